@@ -1,77 +1,846 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/server"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/worker"
 )
 
 type Setting string
 
 const (
-	AllowedRepositoriesSetting             Setting = "AllowedRepositories"
-	AllowOnlyPublicRepositories            Setting = "AllowOnlyPublicRepositories"
-	BotNameSetting                         Setting = "BotName"
-	StreamNameSetting                      Setting = "StreamName"
-	PushSubjectSetting                     Setting = "PushSubject"
-	StatusSubjectSetting                   Setting = "StatusSubject"
-	PullRequestSubjectSetting              Setting = "PullRequestSubject"
-	MessageRetryAttemptsSetting            Setting = "MessageRetryAttempts"
-	MessageRetryWaitSetting                Setting = "MessageRetryWait"
-	RateLimitBucketNameSetting             Setting = "RateLimitBucketName"
-	RateLimitBucketTTLSetting              Setting = "RateLimitBucketTTL"
-	RateLimitIntervalSetting               Setting = "RateLimitInterval"
-	AccessTokensBucketNameSetting          Setting = "AccessTokensBucketName"
-	AccessTokensBucketTTLSetting           Setting = "AccessTokensBucketTTL"
-	ConfigsBucketNameSetting               Setting = "ConfigsBucketName"
-	ConfigsBucketTTLSetting                Setting = "ConfigsBucketTTL"
-	CheckRunsBucketNameSetting             Setting = "CheckRunsBucketName"
-	CheckRunsBucketTTLSetting              Setting = "CheckRunsBucketTTL"
-	DurationBeforeMergeAfterCheckSetting   Setting = "DurationBeforeMergeAfterCheck"
-	DurationToWaitAfterUpdateBranchSetting Setting = "DurationToWaitAfterUpdateBranch"
-	MaxMessageAgeSetting                   Setting = "MaxMessageAge"
-	MessageChannelSizePerSubjectSetting    Setting = "MessageChannelSizePerSubject"
+	AllowedRepositoriesSetting              Setting = "AllowedRepositories"
+	AllowOnlyPublicRepositories             Setting = "AllowOnlyPublicRepositories"
+	BotNameSetting                          Setting = "BotName"
+	NamePrefixSetting                       Setting = "NamePrefix"
+	CheckRunNamePrefixSetting               Setting = "CheckRunNamePrefix"
+	AdminTokenSetting                       Setting = "AdminToken"
+	StreamNameSetting                       Setting = "StreamName"
+	PushSubjectSetting                      Setting = "PushSubject"
+	StatusSubjectSetting                    Setting = "StatusSubject"
+	PullRequestSubjectSetting               Setting = "PullRequestSubject"
+	MaintenanceSubjectSetting               Setting = "MaintenanceSubject"
+	DeadLetterStreamNameSetting             Setting = "DeadLetterStreamName"
+	DeadLetterSubjectSetting                Setting = "DeadLetterSubject"
+	DeadLetterMaxAgeSetting                 Setting = "DeadLetterMaxAge"
+	MessageRetryAttemptsSetting             Setting = "MessageRetryAttempts"
+	MessageRetryWaitSetting                 Setting = "MessageRetryWait"
+	RateLimitBucketNameSetting              Setting = "RateLimitBucketName"
+	RateLimitBucketTTLSetting               Setting = "RateLimitBucketTTL"
+	RateLimitIntervalSetting                Setting = "RateLimitInterval"
+	AccessTokensBucketNameSetting           Setting = "AccessTokensBucketName"
+	AccessTokensBucketTTLSetting            Setting = "AccessTokensBucketTTL"
+	ConfigsBucketNameSetting                Setting = "ConfigsBucketName"
+	ConfigsBucketTTLSetting                 Setting = "ConfigsBucketTTL"
+	CheckRunsBucketNameSetting              Setting = "CheckRunsBucketName"
+	CheckRunsBucketTTLSetting               Setting = "CheckRunsBucketTTL"
+	DurationBeforeMergeAfterCheckSetting    Setting = "DurationBeforeMergeAfterCheck"
+	DurationToWaitAfterUpdateBranchSetting  Setting = "DurationToWaitAfterUpdateBranch"
+	MaxMessageAgeSetting                    Setting = "MaxMessageAge"
+	MessageChannelSizePerSubjectSetting     Setting = "MessageChannelSizePerSubject"
+	MaxWebhookBodyBytesSetting              Setting = "MaxWebhookBodyBytes"
+	WebhookPathSetting                      Setting = "WebhookPath"
+	HTTPMaxRetriesSetting                   Setting = "HTTPMaxRetries"
+	HTTPRetryBaseDelaySetting               Setting = "HTTPRetryBaseDelay"
+	HTTPRetryMaxDelaySetting                Setting = "HTTPRetryMaxDelay"
+	PullRequestActionsSetting               Setting = "PullRequestActions"
+	CreateQueuedCheckRunSetting             Setting = "CreateQueuedCheckRun"
+	AckWaitSetting                          Setting = "AckWait"
+	GitHubHTTPTimeoutSetting                Setting = "GitHubHTTPTimeout"
+	GitHubMaxIdleConnsSetting               Setting = "GitHubMaxIdleConns"
+	GitHubIdleConnTimeoutSetting            Setting = "GitHubIdleConnTimeout"
+	PRDetailsBucketNameSetting              Setting = "PRDetailsBucketName"
+	PRDetailsBucketTTLSetting               Setting = "PRDetailsBucketTTL"
+	PRDetailsCacheInvalidationEventsSetting Setting = "PRDetailsCacheInvalidationEvents"
+	MergeLocksBucketNameSetting             Setting = "MergeLocksBucketName"
+	MergeLocksBucketTTLSetting              Setting = "MergeLocksBucketTTL"
+	ReviewRequestsBucketNameSetting         Setting = "ReviewRequestsBucketName"
+	ReviewRequestsBucketTTLSetting          Setting = "ReviewRequestsBucketTTL"
+	RecentlyMergedBucketNameSetting         Setting = "RecentlyMergedBucketName"
+	RecentlyMergedBucketTTLSetting          Setting = "RecentlyMergedBucketTTL"
+	AllowedGitHubEventsSetting              Setting = "AllowedGitHubEvents"
+	KVReplicasSetting                       Setting = "KVReplicas"
+	RateLimitBucketHistorySetting           Setting = "RateLimitBucketHistory"
+	AccessTokensBucketHistorySetting        Setting = "AccessTokensBucketHistory"
+	ConfigsBucketHistorySetting             Setting = "ConfigsBucketHistory"
+	CheckRunsBucketHistorySetting           Setting = "CheckRunsBucketHistory"
+	PRDetailsBucketHistorySetting           Setting = "PRDetailsBucketHistory"
+	MergeLocksBucketHistorySetting          Setting = "MergeLocksBucketHistory"
+	ReviewRequestsBucketHistorySetting      Setting = "ReviewRequestsBucketHistory"
+	RecentlyMergedBucketHistorySetting      Setting = "RecentlyMergedBucketHistory"
+	AccessTokenExpirySkewSetting            Setting = "AccessTokenExpirySkew"
+	MaxDurationForPushWorkerSetting         Setting = "MaxDurationForPushWorker"
+	MaxDurationForPullRequestWorkerSetting  Setting = "MaxDurationForPullRequestWorker"
+	MaxPullRequestsPerSweepSetting          Setting = "MaxPullRequestsPerSweep"
+	PushMessageRetryAttemptsSetting         Setting = "PushMessageRetryAttempts"
+	StatusMessageRetryAttemptsSetting       Setting = "StatusMessageRetryAttempts"
+	PullRequestMessageRetryAttemptsSetting  Setting = "PullRequestMessageRetryAttempts"
+	MergeGroupCheckRunSetting               Setting = "MergeGroupCheckRun"
+	StreamDuplicatesSetting                 Setting = "StreamDuplicates"
+	NotificationURLSetting                  Setting = "NotificationURL"
+	NotificationTemplateSetting             Setting = "NotificationTemplate"
+	NotificationTimeoutSetting              Setting = "NotificationTimeout"
+	RepositoriesBucketNameSetting           Setting = "RepositoriesBucketName"
+	RepositoriesBucketTTLSetting            Setting = "RepositoriesBucketTTL"
+	RepositoriesBucketHistorySetting        Setting = "RepositoriesBucketHistory"
+	SweepIntervalSetting                    Setting = "SweepInterval"
+	ReconcileOnStartSetting                 Setting = "ReconcileOnStart"
+	ReconcileThrottleSetting                Setting = "ReconcileThrottle"
+	CircuitBreakerBucketNameSetting         Setting = "CircuitBreakerBucketName"
+	CircuitBreakerBucketTTLSetting          Setting = "CircuitBreakerBucketTTL"
+	CircuitBreakerBucketHistorySetting      Setting = "CircuitBreakerBucketHistory"
+	CircuitBreakerThresholdSetting          Setting = "CircuitBreakerThreshold"
+	CircuitBreakerCooldownSetting           Setting = "CircuitBreakerCooldown"
+	MutationThrottleBucketNameSetting       Setting = "MutationThrottleBucketName"
+	MutationThrottleBucketTTLSetting        Setting = "MutationThrottleBucketTTL"
+	MutationThrottleBucketHistorySetting    Setting = "MutationThrottleBucketHistory"
+	MutationThrottleSizeSetting             Setting = "MutationThrottleSize"
+	MutationThrottleRefillIntervalSetting   Setting = "MutationThrottleRefillInterval"
 )
 
 var defaultSettings = map[Setting]any{
-	AllowedRepositoriesSetting:             common.RegexSlice{common.MustNewRegexItem(".*")},
-	AllowOnlyPublicRepositories:            false,
-	BotNameSetting:                         "merge-with-label",
-	StreamNameSetting:                      "mwl_bot_events",
-	PushSubjectSetting:                     "push",
-	StatusSubjectSetting:                   "status",
-	PullRequestSubjectSetting:              "pull_request",
-	MessageRetryAttemptsSetting:            5,                //nolint:gomnd // allow to set defaults
-	MessageRetryWaitSetting:                time.Second * 15, //nolint:gomnd // allow to set defaults
-	RateLimitBucketNameSetting:             "mwl_rate_limit",
-	RateLimitBucketTTLSetting:              time.Hour * 24,   //nolint:gomnd // allow to set defaults
-	RateLimitIntervalSetting:               time.Second * 30, //nolint:gomnd // allow to set defaults
-	AccessTokensBucketNameSetting:          "mwl_access_tokens",
-	AccessTokensBucketTTLSetting:           time.Hour * 24, //nolint:gomnd // allow to set defaults
-	ConfigsBucketNameSetting:               "mwl_configs",
-	ConfigsBucketTTLSetting:                time.Hour * 24, //nolint:gomnd // allow to set defaults
-	CheckRunsBucketNameSetting:             "mwl_check_runs",
-	CheckRunsBucketTTLSetting:              time.Minute * 10, //nolint:gomnd // allow to set defaults
-	DurationBeforeMergeAfterCheckSetting:   time.Second * 10, //nolint:gomnd // allow to set defaults
-	DurationToWaitAfterUpdateBranchSetting: time.Second * 30, //nolint:gomnd // allow to set defaults
-	MaxMessageAgeSetting:                   time.Minute * 10, //nolint:gomnd // allow to set defaults
-	MessageChannelSizePerSubjectSetting:    64,               //nolint:gomnd // allow to set defaults
-}
-
-func GetSetting[T any](name Setting) (t T) {
-	if s := os.Getenv(string(name)); s != "" {
-		return convertValue(s, reflect.TypeOf(t)).Interface().(T)
-	}
-	return defaultSettings[name].(T)
-}
-
-func convertValue(value string, targetType reflect.Type) reflect.Value {
-	if targetType == reflect.TypeOf(common.RegexSlice{}) {
+	AllowedRepositoriesSetting:              common.RegexSlice{common.MustNewRegexItem(".*")},
+	AllowOnlyPublicRepositories:             false,
+	BotNameSetting:                          "merge-with-label",
+	NamePrefixSetting:                       "",
+	CheckRunNamePrefixSetting:               "",
+	AdminTokenSetting:                       "",
+	StreamNameSetting:                       "mwl_bot_events",
+	PushSubjectSetting:                      "push",
+	StatusSubjectSetting:                    "status",
+	PullRequestSubjectSetting:               "pull_request",
+	MaintenanceSubjectSetting:               "maintenance",
+	MessageRetryAttemptsSetting:             5,                //nolint:gomnd // allow to set defaults
+	MessageRetryWaitSetting:                 time.Second * 15, //nolint:gomnd // allow to set defaults
+	RateLimitBucketNameSetting:              "mwl_rate_limit",
+	RateLimitBucketTTLSetting:               time.Hour * 24,   //nolint:gomnd // allow to set defaults
+	RateLimitIntervalSetting:                time.Second * 30, //nolint:gomnd // allow to set defaults
+	AccessTokensBucketNameSetting:           "mwl_access_tokens",
+	AccessTokensBucketTTLSetting:            time.Minute * 70, //nolint:gomnd // allow to set defaults
+	ConfigsBucketNameSetting:                "mwl_configs",
+	ConfigsBucketTTLSetting:                 time.Hour * 24, //nolint:gomnd // allow to set defaults
+	CheckRunsBucketNameSetting:              "mwl_check_runs",
+	CheckRunsBucketTTLSetting:               time.Minute * 10,        //nolint:gomnd // allow to set defaults
+	DurationBeforeMergeAfterCheckSetting:    time.Second * 10,        //nolint:gomnd // allow to set defaults
+	DurationToWaitAfterUpdateBranchSetting:  time.Second * 30,        //nolint:gomnd // allow to set defaults
+	MaxMessageAgeSetting:                    time.Minute * 10,        //nolint:gomnd // allow to set defaults
+	MessageChannelSizePerSubjectSetting:     64,                      //nolint:gomnd // allow to set defaults
+	MaxWebhookBodyBytesSetting:              int64(1024 * 1024 * 16), //nolint:gomnd // allow to set defaults
+	WebhookPathSetting:                      "/",
+	HTTPMaxRetriesSetting:                   3,                //nolint:gomnd // allow to set defaults
+	HTTPRetryBaseDelaySetting:               time.Second,      //nolint:gomnd // allow to set defaults
+	HTTPRetryMaxDelaySetting:                time.Second * 30, //nolint:gomnd // allow to set defaults
+	PullRequestActionsSetting:               server.DefaultPullRequestActions,
+	CreateQueuedCheckRunSetting:             false,
+	AckWaitSetting:                          time.Minute * 5,  //nolint:gomnd // allow to set defaults
+	GitHubHTTPTimeoutSetting:                time.Minute,      //nolint:gomnd // allow to set defaults
+	GitHubMaxIdleConnsSetting:               16,               //nolint:gomnd // allow to set defaults
+	GitHubIdleConnTimeoutSetting:            time.Second * 90, //nolint:gomnd // allow to set defaults
+	PRDetailsBucketNameSetting:              "mwl_pr_details",
+	PRDetailsBucketTTLSetting:               time.Second * 30, //nolint:gomnd // allow to set defaults
+	PRDetailsCacheInvalidationEventsSetting: worker.DefaultPRDetailsCacheInvalidationEvents,
+	MergeLocksBucketNameSetting:             "mwl_merge_locks",
+	MergeLocksBucketTTLSetting:              time.Minute * 2, //nolint:gomnd // allow to set defaults
+	ReviewRequestsBucketNameSetting:         "mwl_review_requests",
+	ReviewRequestsBucketTTLSetting:          time.Hour * 24 * 7, //nolint:gomnd // allow to set defaults
+	RecentlyMergedBucketNameSetting:         "mwl_recently_merged",
+	RecentlyMergedBucketTTLSetting:          time.Minute * 10, //nolint:gomnd // allow to set defaults
+	AllowedGitHubEventsSetting:              server.DefaultGitHubEvents,
+	DeadLetterStreamNameSetting:             "mwl_dead_letters",
+	DeadLetterSubjectSetting:                "dead_letter",
+	DeadLetterMaxAgeSetting:                 time.Hour * 24 * 7, //nolint:gomnd // allow to set defaults
+	KVReplicasSetting:                       1,
+	RateLimitBucketHistorySetting:           1,
+	AccessTokensBucketHistorySetting:        1,
+	ConfigsBucketHistorySetting:             1,
+	CheckRunsBucketHistorySetting:           1,
+	PRDetailsBucketHistorySetting:           1,
+	MergeLocksBucketHistorySetting:          1,
+	ReviewRequestsBucketHistorySetting:      1,
+	RecentlyMergedBucketHistorySetting:      1,
+	AccessTokenExpirySkewSetting:            time.Minute * 5, //nolint:gomnd // allow to set defaults
+	MaxDurationForPushWorkerSetting:         time.Minute,
+	MaxDurationForPullRequestWorkerSetting:  time.Minute,
+	MaxPullRequestsPerSweepSetting:          300, //nolint:gomnd // allow to set defaults
+	// the retry attempts of the subjects default to MessageRetryAttempts, see fallbackSettings
+	PushMessageRetryAttemptsSetting:        0,
+	StatusMessageRetryAttemptsSetting:      0,
+	PullRequestMessageRetryAttemptsSetting: 0,
+	MergeGroupCheckRunSetting:              false,
+	StreamDuplicatesSetting:                time.Minute * 2, //nolint:gomnd // allow to set defaults
+	// notifications are disabled if NotificationURL is empty
+	NotificationURLSetting:      "",
+	NotificationTemplateSetting: "",
+	NotificationTimeoutSetting:  time.Second * 10, //nolint:gomnd // allow to set defaults
+	// a repository is swept until no message was seen for it for RepositoriesBucketTTL
+	RepositoriesBucketNameSetting:    "mwl_repositories",
+	RepositoriesBucketTTLSetting:     time.Hour * 24 * 7, //nolint:gomnd // allow to set defaults
+	RepositoriesBucketHistorySetting: 1,
+	// the sweep is disabled by default
+	SweepIntervalSetting: time.Duration(0),
+	// queue a push message for every repository of every installation on startup
+	ReconcileOnStartSetting:  false,
+	ReconcileThrottleSetting: time.Millisecond * 500, //nolint:gomnd // allow to set defaults
+	// failures of a repository are forgotten after CircuitBreakerBucketTTL without a message
+	CircuitBreakerBucketNameSetting:    "mwl_circuit_breaker",
+	CircuitBreakerBucketTTLSetting:     time.Hour * 24, //nolint:gomnd // allow to set defaults
+	CircuitBreakerBucketHistorySetting: 1,
+	// the circuit breaker is disabled by default
+	CircuitBreakerThresholdSetting: 0,
+	CircuitBreakerCooldownSetting:  time.Minute * 10, //nolint:gomnd // allow to set defaults
+	// an installation may send MutationThrottleSize mutations at once and one more every MutationThrottleRefillInterval
+	MutationThrottleBucketNameSetting:    "mwl_mutation_throttle",
+	MutationThrottleBucketTTLSetting:     time.Hour,
+	MutationThrottleBucketHistorySetting: 1,
+	// the throttle is disabled by default
+	MutationThrottleSizeSetting:           0,
+	MutationThrottleRefillIntervalSetting: time.Second,
+}
+
+// SettingError describes a setting that has an invalid value.
+type SettingError struct {
+	Setting        Setting
+	Value          string
+	ExpectedFormat string
+	Err            error
+}
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("%s=%q is invalid: %s, expected %s", e.Setting, e.Value, e.Err, e.ExpectedFormat)
+}
+
+func (e *SettingError) Unwrap() error {
+	return e.Err
+}
+
+// SettingErrors is a list of invalid settings.
+type SettingErrors []error
+
+func (e SettingErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("%d invalid setting(s):", len(e)))
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// secretSettings are redacted when printing the configuration.
+var secretSettings = map[Setting]struct{}{
+	AdminTokenSetting: {},
+	// webhook urls usually contain a token
+	NotificationURLSetting: {},
+}
+
+// Queue groups of the worker subscriptions, they are also the names of the durable consumers.
+const (
+	PushWorkerQueue        = "push-worker"
+	StatusWorkerQueue      = "status-worker"
+	PullRequestWorkerQueue = "pull-request-worker"
+	MaintenanceWorkerQueue = "maintenance-worker"
+)
+
+// Setting values are looked up in this order: flags, environment, config file, defaults.
+var (
+	flagValues = map[Setting]string{}
+	fileValues = map[Setting]string{}
+)
+
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// Options are the command line options that are not settings.
+type Options struct {
+	PrintConfig bool
+	// Args are the arguments that remain after the flags.
+	Args []string
+}
+
+// ParseFlags parses the command line arguments.
+// Every setting can be passed as a flag (e.g. --BotName=my-bot), additionally --config loads a yaml file
+// whose keys are the setting names and --print-config prints the effective configuration.
+func ParseFlags(name string, args []string) (*Options, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	values := make(map[Setting]*string, len(defaultSettings))
+	for _, setting := range sortedSettings() {
+		values[setting] = fs.String(string(setting), "", fmt.Sprintf("%s (default %s)", expectedFormat(reflect.TypeOf(defaultSettings[setting])), formatValue(defaultSettings[setting])))
+	}
+	configFile := fs.String("config", "", "path to a yaml file, the keys are the setting names")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration on startup")
+	if err := fs.Parse(args); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	flagValues = make(map[Setting]string)
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := values[Setting(f.Name)]; ok {
+			flagValues[Setting(f.Name)] = *v
+		}
+	})
+
+	fileValues = make(map[Setting]string)
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			return nil, errors.Wrapf(err, "unable to load config file %s", *configFile)
+		}
+	}
+	return &Options{PrintConfig: *printConfig, Args: fs.Args()}, nil
+}
+
+func loadConfigFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(buf, &values); err != nil {
+		return errors.Wrap(err, "unable to decode config file")
+	}
+	for key, value := range values {
+		if _, ok := defaultSettings[Setting(key)]; !ok {
+			return errors.Errorf("unknown setting `%s'", key)
+		}
+		if list, ok := value.([]any); ok {
+			items := make([]string, len(list))
+			for i := range list {
+				items[i] = fmt.Sprint(list[i])
+			}
+			fileValues[Setting(key)] = strings.Join(items, ",")
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		fileValues[Setting(key)] = fmt.Sprint(value)
+	}
+	return nil
+}
+
+// lookupSetting returns the raw value of the setting and where it came from.
+// An empty source means the setting was not set and the default value should be used.
+func lookupSetting(name Setting) (value, source string) {
+	if v, ok := flagValues[name]; ok {
+		return v, sourceFlag
+	}
+	if v := os.Getenv(string(name)); v != "" {
+		return v, sourceEnv
+	}
+	if v, ok := fileValues[name]; ok {
+		return v, sourceFile
+	}
+	return "", ""
+}
+
+func sortedSettings() []Setting {
+	names := make([]string, 0, len(defaultSettings))
+	for name := range defaultSettings {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	settings := make([]Setting, len(names))
+	for i := range names {
+		settings[i] = Setting(names[i])
+	}
+	return settings
+}
+
+func formatValue(v any) string {
+	switch value := v.(type) {
+	case common.RegexSlice:
+		return strings.Join(value.Strings(), ",")
+	case []string:
+		return strings.Join(value, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func effectiveSetting(setting Setting) (value, source string) {
+	value, source = lookupSetting(setting)
+	if source == "" {
+		value, source = formatValue(defaultValue(setting)), sourceDefault
+	}
+	if _, ok := secretSettings[setting]; ok && value != "" {
+		value = "[redacted]"
+	}
+	return value, source
+}
+
+// PrintConfig writes the effective configuration to w, secret values are redacted.
+func PrintConfig(w io.Writer) error {
+	for _, setting := range sortedSettings() {
+		value, source := effectiveSetting(setting)
+		if _, err := fmt.Fprintf(w, "%s=%s (%s)\n", setting, value, source); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// EffectiveSettings returns the effective value of every setting, secret values are redacted.
+func EffectiveSettings() map[string]any {
+	settings := make(map[string]any, len(defaultSettings))
+	for _, setting := range sortedSettings() {
+		settings[string(setting)], _ = effectiveSetting(setting)
+	}
+	return settings
+}
+
+// ValidateSettings checks that all settings can be parsed and that their combination makes sense.
+// It returns every problem found, an empty result means the settings are valid.
+func ValidateSettings() SettingErrors {
+	var errs SettingErrors
+	for _, name := range sortedSettings() {
+		s, source := lookupSetting(name)
+		if source == "" {
+			continue
+		}
+		targetType := reflect.TypeOf(defaultSettings[name])
+		if _, err := convertValue(s, targetType); err != nil {
+			errs = append(errs, &SettingError{
+				Setting:        name,
+				Value:          s,
+				ExpectedFormat: expectedFormat(targetType),
+				Err:            err,
+			})
+		}
+	}
+	if len(errs) > 0 {
+		// the rules below need parsable settings
+		return errs
+	}
+	for _, rule := range settingRules {
+		if err := rule(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// namedSettings are the names of the stream, the subjects and the kv buckets.
+// NamePrefix is prepended to their default values, values that are set explicitly are used as they are.
+var namedSettings = map[Setting]struct{}{
+	StreamNameSetting:                 {},
+	PushSubjectSetting:                {},
+	StatusSubjectSetting:              {},
+	PullRequestSubjectSetting:         {},
+	MaintenanceSubjectSetting:         {},
+	DeadLetterStreamNameSetting:       {},
+	DeadLetterSubjectSetting:          {},
+	RateLimitBucketNameSetting:        {},
+	AccessTokensBucketNameSetting:     {},
+	ConfigsBucketNameSetting:          {},
+	CheckRunsBucketNameSetting:        {},
+	PRDetailsBucketNameSetting:        {},
+	MergeLocksBucketNameSetting:       {},
+	ReviewRequestsBucketNameSetting:   {},
+	RecentlyMergedBucketNameSetting:   {},
+	RepositoriesBucketNameSetting:     {},
+	CircuitBreakerBucketNameSetting:   {},
+	MutationThrottleBucketNameSetting: {},
+}
+
+// fallbackSettings default to the effective value of another setting.
+var fallbackSettings = map[Setting]Setting{
+	PushMessageRetryAttemptsSetting:        MessageRetryAttemptsSetting,
+	StatusMessageRetryAttemptsSetting:      MessageRetryAttemptsSetting,
+	PullRequestMessageRetryAttemptsSetting: MessageRetryAttemptsSetting,
+}
+
+// defaultValue returns the default value of the setting, prefixed with NamePrefix for namedSettings.
+// fallbackSettings default to the effective value of the setting they fall back to.
+func defaultValue(setting Setting) any {
+	value := defaultSettings[setting]
+	if fallback, ok := fallbackSettings[setting]; ok {
+		if s, source := lookupSetting(fallback); source != "" {
+			if v, err := convertValue(s, reflect.TypeOf(value)); err == nil {
+				return v.Interface()
+			}
+		}
+		return defaultValue(fallback)
+	}
+	if _, ok := namedSettings[setting]; ok {
+		if prefix, source := lookupSetting(NamePrefixSetting); source != "" {
+			return prefix + value.(string)
+		}
+	}
+	return value
+}
+
+var subjectSettings = []Setting{
+	PushSubjectSetting,
+	StatusSubjectSetting,
+	PullRequestSubjectSetting,
+	MaintenanceSubjectSetting,
+	DeadLetterSubjectSetting,
+}
+
+var settingRules = []func() error{
+	validateRateLimitBucketTTL,
+	validateMaxMessageAge,
+	validateSubjects,
+	validateNamePrefix,
+	validateMaxDurations,
+	validateMaxPullRequestsPerSweep,
+	validateAckWait,
+	validateMergeLocksBucketTTL,
+	validateStreamDuplicates,
+	validateKVReplicas,
+	validateKVHistory,
+	validateAccessTokenExpirySkew,
+	validateNotifications,
+	validateSweepInterval,
+	validateReconcileThrottle,
+	validateCircuitBreaker,
+	validateMutationThrottle,
+}
+
+func validateRateLimitBucketTTL() error {
+	ttl := MustGetSetting[time.Duration](RateLimitBucketTTLSetting)
+	interval := MustGetSetting[time.Duration](RateLimitIntervalSetting)
+	if ttl <= interval {
+		return errors.Errorf("%s (%s) must be greater than %s (%s)",
+			RateLimitBucketTTLSetting, ttl, RateLimitIntervalSetting, interval)
+	}
+	return nil
+}
+
+// retryAttemptsSettings are the settings that limit the deliveries of messages.
+var retryAttemptsSettings = []Setting{
+	MessageRetryAttemptsSetting,
+	PushMessageRetryAttemptsSetting,
+	StatusMessageRetryAttemptsSetting,
+	PullRequestMessageRetryAttemptsSetting,
+}
+
+func validateMaxMessageAge() error {
+	maxAge := MustGetSetting[time.Duration](MaxMessageAgeSetting)
+	retryWait := MustGetSetting[time.Duration](MessageRetryWaitSetting)
+	for _, setting := range retryAttemptsSettings {
+		retryAttempts := MustGetSetting[int](setting)
+		if retryAttempts < 1 {
+			return errors.Errorf("%s (%d) must be at least 1", setting, retryAttempts)
+		}
+		if maxAge <= retryWait*time.Duration(retryAttempts) {
+			return errors.Errorf("%s (%s) must be greater than %s*%s (%s*%d)",
+				MaxMessageAgeSetting, maxAge, MessageRetryWaitSetting, setting, retryWait, retryAttempts)
+		}
+	}
+	return nil
+}
+
+func validateMaxPullRequestsPerSweep() error {
+	if n := MustGetSetting[int](MaxPullRequestsPerSweepSetting); n < 0 {
+		return errors.Errorf("%s (%d) must not be negative", MaxPullRequestsPerSweepSetting, n)
+	}
+	return nil
+}
+
+func validateMaxDurations() error {
+	for _, setting := range []Setting{MaxDurationForPushWorkerSetting, MaxDurationForPullRequestWorkerSetting} {
+		if d := MustGetSetting[time.Duration](setting); d <= 0 {
+			return errors.Errorf("%s (%s) must be greater than 0s", setting, d)
+		}
+	}
+	return nil
+}
+
+func validateAckWait() error {
+	ackWait := MustGetSetting[time.Duration](AckWaitSetting)
+	maxDuration := MustGetSetting[time.Duration](MaxDurationForPushWorkerSetting) +
+		MustGetSetting[time.Duration](MaxDurationForPullRequestWorkerSetting)
+	if ackWait <= maxDuration {
+		return errors.Errorf("%s (%s) must be greater than the maximum processing duration (%s)",
+			AckWaitSetting, ackWait, maxDuration)
+	}
+	return nil
+}
+
+func validateMergeLocksBucketTTL() error {
+	ttl := MustGetSetting[time.Duration](MergeLocksBucketTTLSetting)
+	maxDuration := MustGetSetting[time.Duration](MaxDurationForPullRequestWorkerSetting)
+	if ttl <= maxDuration {
+		return errors.Errorf("%s (%s) must be greater than the maximum duration of the pull request worker (%s)",
+			MergeLocksBucketTTLSetting, ttl, maxDuration)
+	}
+	return nil
+}
+
+// validateStreamDuplicates makes sure messages that are delayed by the rate limit are deduplicated by the stream.
+func validateStreamDuplicates() error {
+	duplicates := MustGetSetting[time.Duration](StreamDuplicatesSetting)
+	interval := MustGetSetting[time.Duration](RateLimitIntervalSetting)
+	if duplicates < interval {
+		return errors.Errorf("%s (%s) must not be less than %s (%s)",
+			StreamDuplicatesSetting, duplicates, RateLimitIntervalSetting, interval)
+	}
+	return nil
+}
+
+// StreamDuplicates returns the duplicate window of the stream, it is capped at MaxMessageAge because the window can
+// not be longer than the age of the messages in the stream.
+func StreamDuplicates() time.Duration {
+	duplicates := MustGetSetting[time.Duration](StreamDuplicatesSetting)
+	if maxAge := MustGetSetting[time.Duration](MaxMessageAgeSetting); duplicates > maxAge {
+		return maxAge
+	}
+	return duplicates
+}
+
+// AccessTokenLifetime is the duration GitHub installation access tokens are valid.
+const AccessTokenLifetime = time.Hour
+
+func validateAccessTokenExpirySkew() error {
+	skew := MustGetSetting[time.Duration](AccessTokenExpirySkewSetting)
+	if skew < 0 || skew >= AccessTokenLifetime {
+		return errors.Errorf("%s (%s) must be between 0s and the lifetime of access tokens (%s)",
+			AccessTokenExpirySkewSetting, skew, AccessTokenLifetime)
+	}
+	return nil
+}
+
+func validateNotifications() error {
+	if timeout := MustGetSetting[time.Duration](NotificationTimeoutSetting); timeout <= 0 {
+		return errors.Errorf("%s (%s) must be greater than 0s", NotificationTimeoutSetting, timeout)
+	}
+	if MustGetSetting[string](NotificationURLSetting) == "" {
+		return nil
+	}
+	if u, err := url.ParseRequestURI(MustGetSetting[string](NotificationURLSetting)); err != nil || u.Host == "" {
+		// the url is not part of the error, it might contain a token
+		return errors.Errorf("%s must be an absolute url", NotificationURLSetting)
+	}
+	if tmpl := MustGetSetting[string](NotificationTemplateSetting); tmpl != "" {
+		if _, err := worker.ParseNotificationTemplate(tmpl); err != nil {
+			return errors.Wrapf(err, "%s is invalid", NotificationTemplateSetting)
+		}
+	}
+	return nil
+}
+
+func validateSweepInterval() error {
+	interval := MustGetSetting[time.Duration](SweepIntervalSetting)
+	if interval < 0 {
+		return errors.Errorf("%s (%s) must not be negative", SweepIntervalSetting, interval)
+	}
+	if rateLimit := MustGetSetting[time.Duration](RateLimitIntervalSetting); interval > 0 && interval < rateLimit {
+		return errors.Errorf("%s (%s) must be 0s or not less than %s (%s)",
+			SweepIntervalSetting, interval, RateLimitIntervalSetting, rateLimit)
+	}
+	return nil
+}
+
+func validateReconcileThrottle() error {
+	if throttle := MustGetSetting[time.Duration](ReconcileThrottleSetting); throttle < 0 {
+		return errors.Errorf("%s (%s) must not be negative", ReconcileThrottleSetting, throttle)
+	}
+	return nil
+}
+
+func validateCircuitBreaker() error {
+	threshold := MustGetSetting[int](CircuitBreakerThresholdSetting)
+	if threshold < 0 {
+		return errors.Errorf("%s (%d) must not be negative", CircuitBreakerThresholdSetting, threshold)
+	}
+	if threshold == 0 {
+		return nil
+	}
+	cooldown := MustGetSetting[time.Duration](CircuitBreakerCooldownSetting)
+	if cooldown <= 0 {
+		return errors.Errorf("%s (%s) must be positive", CircuitBreakerCooldownSetting, cooldown)
+	}
+	if ttl := MustGetSetting[time.Duration](CircuitBreakerBucketTTLSetting); ttl <= cooldown {
+		return errors.Errorf("%s (%s) must be greater than %s (%s)",
+			CircuitBreakerBucketTTLSetting, ttl, CircuitBreakerCooldownSetting, cooldown)
+	}
+	return nil
+}
+
+func validateMutationThrottle() error {
+	size := MustGetSetting[int](MutationThrottleSizeSetting)
+	if size < 0 {
+		return errors.Errorf("%s (%d) must not be negative", MutationThrottleSizeSetting, size)
+	}
+	if size == 0 {
+		return nil
+	}
+	refill := MustGetSetting[time.Duration](MutationThrottleRefillIntervalSetting)
+	if refill <= 0 {
+		return errors.Errorf("%s (%s) must be positive", MutationThrottleRefillIntervalSetting, refill)
+	}
+	// an expired bucket is full, so it must not expire before it was refilled
+	full := refill * time.Duration(size)
+	if ttl := MustGetSetting[time.Duration](MutationThrottleBucketTTLSetting); ttl < full {
+		return errors.Errorf("%s (%s) must not be less than %s * %s (%s)",
+			MutationThrottleBucketTTLSetting, ttl, MutationThrottleSizeSetting, MutationThrottleRefillIntervalSetting, full)
+	}
+	return nil
+}
+
+// maxKVReplicas is the maximum number of replicas jetstream supports.
+const maxKVReplicas = 5
+
+func validateKVReplicas() error {
+	replicas := MustGetSetting[int](KVReplicasSetting)
+	if replicas < 1 || replicas > maxKVReplicas {
+		return errors.Errorf("%s (%d) must be between 1 and %d", KVReplicasSetting, replicas, maxKVReplicas)
+	}
+	return nil
+}
+
+var historySettings = []Setting{
+	RateLimitBucketHistorySetting,
+	AccessTokensBucketHistorySetting,
+	ConfigsBucketHistorySetting,
+	CheckRunsBucketHistorySetting,
+	PRDetailsBucketHistorySetting,
+	MergeLocksBucketHistorySetting,
+	ReviewRequestsBucketHistorySetting,
+	RecentlyMergedBucketHistorySetting,
+	RepositoriesBucketHistorySetting,
+	CircuitBreakerBucketHistorySetting,
+	MutationThrottleBucketHistorySetting,
+}
+
+func validateKVHistory() error {
+	var errs []string
+	for _, setting := range historySettings {
+		history := MustGetSetting[int](setting)
+		if history < 1 || history > nats.KeyValueMaxHistory {
+			errs = append(errs, fmt.Sprintf("%s (%d) must be between 1 and %d", setting, history, nats.KeyValueMaxHistory))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// namePrefixRegex matches the characters that are valid in stream, subject and kv bucket names.
+var namePrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]*$`)
+
+func validateNamePrefix() error {
+	prefix := MustGetSetting[string](NamePrefixSetting)
+	if !namePrefixRegex.MatchString(prefix) {
+		return errors.Errorf("%s=%q must only contain letters, digits, `_' and `-'", NamePrefixSetting, prefix)
+	}
+	return nil
+}
+
+func validateSubjects() error {
+	if validateNamePrefix() != nil {
+		// the prefixed subjects would be invalid as well, validateNamePrefix reports the cause
+		return nil
+	}
+	var errs []string
+	seen := make(map[string]Setting)
+	for _, setting := range subjectSettings {
+		subject := MustGetSetting[string](setting)
+		switch {
+		case subject == "":
+			errs = append(errs, fmt.Sprintf("%s must not be empty", setting))
+		case strings.ContainsAny(subject, ".*> \t"):
+			errs = append(errs, fmt.Sprintf("%s=%q must not contain `.', `*', `>' or whitespace", setting, subject))
+		default:
+			if other, ok := seen[subject]; ok {
+				errs = append(errs, fmt.Sprintf("%s and %s must not use the same subject %q", other, setting, subject))
+			}
+			seen[subject] = setting
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// GetSetting returns the value of the setting from the flags, the environment or the config file,
+// or its default value if it is not set.
+func GetSetting[T any](name Setting) (t T, err error) {
+	if _, ok := defaultSettings[name]; !ok {
+		return t, errors.Errorf("unknown setting `%s'", name)
+	}
+	s, source := lookupSetting(name)
+	if source == "" {
+		defaultValue := defaultValue(name)
+		v, ok := defaultValue.(T)
+		if !ok {
+			return t, errors.Errorf("setting `%s' is of type %T, not %T", name, defaultValue, t)
+		}
+		return v, nil
+	}
+	v, err := convertValue(s, reflect.TypeOf(t))
+	if err != nil {
+		return t, &SettingError{
+			Setting:        name,
+			Value:          s,
+			ExpectedFormat: expectedFormat(reflect.TypeOf(t)),
+			Err:            err,
+		}
+	}
+	return v.Interface().(T), nil
+}
+
+// MustGetSetting is like GetSetting but panics if the setting is invalid,
+// use ValidateSettings on startup to report invalid settings.
+func MustGetSetting[T any](name Setting) T {
+	v, err := GetSetting[T](name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+var (
+	regexSliceType  = reflect.TypeOf(common.RegexSlice{})
+	stringSliceType = reflect.TypeOf([]string{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+)
+
+func expectedFormat(targetType reflect.Type) string {
+	switch targetType {
+	case regexSliceType:
+		return "a comma separated list of regular expressions"
+	case stringSliceType:
+		return "a comma separated list"
+	case durationType:
+		return "a duration (e.g. 30s, 5m or 1h)"
+	}
+	switch targetType.Kind() {
+	case reflect.Bool:
+		return "a boolean (true or false)"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	default:
+		return targetType.String()
+	}
+}
+
+func convertValue(value string, targetType reflect.Type) (reflect.Value, error) {
+	switch targetType {
+	case regexSliceType:
 		s := strings.Split(value, ",")
 		items := make(common.RegexSlice, 0, len(s))
 		for _, item := range s {
@@ -79,30 +848,47 @@ func convertValue(value string, targetType reflect.Type) reflect.Value {
 			if item == "" {
 				continue
 			}
-			items = append(items, common.MustNewRegexItem(item))
+			regexItem, err := common.NewRegexItem(item)
+			if err != nil {
+				return reflect.Value{}, errors.WithStack(err)
+			}
+			items = append(items, regexItem)
 		}
-		return reflect.ValueOf(items)
-	}
-	if targetType == reflect.TypeOf(time.Duration(0)) {
+		return reflect.ValueOf(items), nil
+	case stringSliceType:
+		s := strings.Split(value, ",")
+		items := make([]string, 0, len(s))
+		for _, item := range s {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			items = append(items, item)
+		}
+		return reflect.ValueOf(items), nil
+	case durationType:
 		t, err := time.ParseDuration(value)
 		if err != nil {
-			panic(fmt.Sprintf("unable to parse duration `%s'", value))
+			return reflect.Value{}, errors.Errorf("unable to parse duration `%s'", value)
 		}
-		return reflect.ValueOf(t)
+		return reflect.ValueOf(t), nil
 	}
 	switch targetType.Kind() {
 	case reflect.Bool:
-		if boolValue, err := strconv.ParseBool(value); err == nil {
-			return reflect.ValueOf(boolValue).Convert(targetType)
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return reflect.Value{}, errors.Errorf("unable to parse bool `%s'", value)
 		}
+		return reflect.ValueOf(boolValue).Convert(targetType), nil
 	case reflect.String:
-		return reflect.ValueOf(value)
+		return reflect.ValueOf(value).Convert(targetType), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return reflect.ValueOf(intValue).Convert(targetType)
+		intValue, err := strconv.ParseInt(value, 10, targetType.Bits())
+		if err != nil {
+			return reflect.Value{}, errors.Errorf("unable to parse integer `%s'", value)
 		}
+		return reflect.ValueOf(intValue).Convert(targetType), nil
 	default:
-		panic(fmt.Sprintf("unsupported type: %s", targetType))
+		return reflect.Value{}, errors.Errorf("unsupported type: %s", targetType)
 	}
-	return reflect.Zero(targetType)
 }
@@ -0,0 +1,754 @@
+package cmd
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestGetSetting_MaxWebhookBodyBytes(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv(string(MaxWebhookBodyBytesSetting), "")
+		if got := MustGetSetting[int64](MaxWebhookBodyBytesSetting); got != 1024*1024*16 {
+			t.Errorf("GetSetting() = %d, want %d", got, 1024*1024*16)
+		}
+	})
+	t.Run("from env", func(t *testing.T) {
+		t.Setenv(string(MaxWebhookBodyBytesSetting), "1024")
+		if got := MustGetSetting[int64](MaxWebhookBodyBytesSetting); got != 1024 {
+			t.Errorf("GetSetting() = %d, want %d", got, 1024)
+		}
+	})
+}
+
+func TestGetSetting_PullRequestActions(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv(string(PullRequestActionsSetting), "")
+		want := []string{"created", "opened", "labeled", "reopened", "synchronize", "edited"}
+		if got := MustGetSetting[[]string](PullRequestActionsSetting); !slices.Equal(got, want) {
+			t.Errorf("GetSetting() = %v, want %v", got, want)
+		}
+	})
+	t.Run("from env", func(t *testing.T) {
+		t.Setenv(string(PullRequestActionsSetting), "opened, ready_for_review,,labeled")
+		want := []string{"opened", "ready_for_review", "labeled"}
+		if got := MustGetSetting[[]string](PullRequestActionsSetting); !slices.Equal(got, want) {
+			t.Errorf("GetSetting() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestGetSetting_UnknownSetting(t *testing.T) {
+	if _, err := GetSetting[string]("DoesNotExist"); err == nil {
+		t.Error("GetSetting() expected an error for an unknown setting")
+	}
+}
+
+func TestGetSetting_WrongType(t *testing.T) {
+	t.Setenv(string(BotNameSetting), "")
+	if _, err := GetSetting[int](BotNameSetting); err == nil {
+		t.Error("GetSetting() expected an error for a wrong type")
+	}
+}
+
+func Test_convertValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		typ     any
+		want    any
+		wantErr bool
+	}{
+		{name: "string", value: "merge-with-label", typ: "", want: "merge-with-label"},
+
+		{name: "bool true", value: "true", typ: false, want: true},
+		{name: "bool 1", value: "1", typ: false, want: true},
+		{name: "bool false", value: "false", typ: false, want: false},
+		{name: "bool invalid", value: "yes", typ: false, wantErr: true},
+
+		{name: "int", value: "64", typ: 0, want: 64},
+		{name: "int negative", value: "-1", typ: 0, want: -1},
+		{name: "int invalid", value: "64k", typ: 0, wantErr: true},
+		{name: "int float", value: "1.5", typ: 0, wantErr: true},
+		{name: "int8 overflow", value: "128", typ: int8(0), wantErr: true},
+		{name: "int64", value: "1099511627776", typ: int64(0), want: int64(1099511627776)},
+		{name: "int64 invalid", value: "16MiB", typ: int64(0), wantErr: true},
+
+		{name: "duration", value: "30s", typ: time.Duration(0), want: time.Second * 30},
+		{name: "duration combined", value: "1h30m", typ: time.Duration(0), want: time.Minute * 90},
+		{name: "duration invalid", value: "30sec", typ: time.Duration(0), wantErr: true},
+		{name: "duration without unit", value: "30", typ: time.Duration(0), wantErr: true},
+
+		{name: "string slice", value: "a, b,,c ", typ: []string{}, want: []string{"a", "b", "c"}},
+		{name: "string slice empty items", value: ",", typ: []string{}, want: []string{}},
+
+		{name: "regex slice", value: "Eun/.*, other/repo", typ: common.RegexSlice{}, want: []string{"Eun/.*", "other/repo"}},
+		{name: "regex slice invalid", value: "Eun/(", typ: common.RegexSlice{}, wantErr: true},
+
+		{name: "unsupported type", value: "1.5", typ: 1.5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue(tt.value, reflect.TypeOf(tt.typ))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convertValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			value := got.Interface()
+			if sl, ok := value.(common.RegexSlice); ok {
+				value = sl.Strings()
+			}
+			if !reflect.DeepEqual(value, tt.want) {
+				t.Errorf("convertValue() = %#v, want %#v", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv(string(RateLimitIntervalSetting), "30s")
+		t.Setenv(string(MessageRetryAttemptsSetting), "3")
+		if errs := ValidateSettings(); len(errs) > 0 {
+			t.Errorf("ValidateSettings() errors = %v", errs)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv(string(RateLimitIntervalSetting), "30sec")
+		t.Setenv(string(MessageRetryAttemptsSetting), "three")
+		t.Setenv(string(AllowOnlyPublicRepositories), "yes")
+		t.Setenv(string(AllowedRepositoriesSetting), "Eun/(")
+
+		errs := ValidateSettings()
+		var got []Setting
+		var report []string
+		for _, err := range errs {
+			var settingError *SettingError
+			if !errors.As(err, &settingError) {
+				t.Fatalf("ValidateSettings() error = %v, want SettingError", err)
+			}
+			got = append(got, settingError.Setting)
+			report = append(report, err.Error())
+		}
+		want := []Setting{AllowOnlyPublicRepositories, AllowedRepositoriesSetting, MessageRetryAttemptsSetting, RateLimitIntervalSetting}
+		if !slices.Equal(got, want) {
+			t.Errorf("ValidateSettings() settings = %v, want %v", got, want)
+		}
+		for _, s := range []string{
+			`RateLimitInterval="30sec"`,
+			"a duration",
+			`MessageRetryAttempts="three"`,
+			"an integer",
+			"a boolean",
+			"regular expressions",
+		} {
+			if !strings.Contains(strings.Join(report, "\n"), s) {
+				t.Errorf("report does not contain %q:\n%s", s, report)
+			}
+		}
+		// all invalid settings are reported together
+		if got := errs.Error(); !strings.HasPrefix(got, "4 invalid setting(s):\n  AllowOnlyPublicRepositories=") {
+			t.Errorf("Error() = %q", got)
+		}
+	})
+}
+
+func TestValidateSettings_Rules(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[Setting]string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "rate limit bucket ttl equals interval",
+			env: map[Setting]string{
+				RateLimitBucketTTLSetting: "30s",
+				RateLimitIntervalSetting:  "30s",
+			},
+			wantErr: "RateLimitBucketTTL (30s) must be greater than RateLimitInterval (30s)",
+		},
+		{
+			name: "rate limit bucket ttl greater than interval",
+			env: map[Setting]string{
+				RateLimitBucketTTLSetting: "1m",
+				RateLimitIntervalSetting:  "30s",
+			},
+		},
+		{
+			name: "max message age too short for retries",
+			env: map[Setting]string{
+				MaxMessageAgeSetting:        "1m",
+				MessageRetryWaitSetting:     "15s",
+				MessageRetryAttemptsSetting: "4",
+			},
+			wantErr: "MaxMessageAge (1m0s) must be greater than MessageRetryWait*MessageRetryAttempts (15s*4)",
+		},
+		{
+			name: "max message age too short for push retries",
+			env: map[Setting]string{
+				MaxMessageAgeSetting:            "5m",
+				MessageRetryWaitSetting:         "15s",
+				MessageRetryAttemptsSetting:     "3",
+				PushMessageRetryAttemptsSetting: "20",
+			},
+			wantErr: "MaxMessageAge (5m0s) must be greater than MessageRetryWait*PushMessageRetryAttempts (15s*20)",
+		},
+		{
+			name: "no pull request deliveries",
+			env: map[Setting]string{
+				PullRequestMessageRetryAttemptsSetting: "0",
+			},
+			wantErr: "PullRequestMessageRetryAttempts (0) must be at least 1",
+		},
+		{
+			name: "max message age long enough for retries",
+			env: map[Setting]string{
+				MaxMessageAgeSetting:        "1m",
+				MessageRetryWaitSetting:     "15s",
+				MessageRetryAttemptsSetting: "3",
+			},
+		},
+		{
+			name: "ack wait shorter than processing",
+			env: map[Setting]string{
+				AckWaitSetting: "2m",
+			},
+			wantErr: "AckWait (2m0s) must be greater than the maximum processing duration (2m0s)",
+		},
+		{
+			name: "merge locks expire before the merge is done",
+			env: map[Setting]string{
+				MergeLocksBucketTTLSetting: "1m",
+			},
+			wantErr: "MergeLocksBucketTTL (1m0s) must be greater than the maximum duration of the pull request worker (1m0s)",
+		},
+		{
+			name: "duplicate window shorter than rate limit interval",
+			env: map[Setting]string{
+				StreamDuplicatesSetting: "10s",
+			},
+			wantErr: "StreamDuplicates (10s) must not be less than RateLimitInterval (30s)",
+		},
+		{
+			name: "subject with dot",
+			env: map[Setting]string{
+				PushSubjectSetting: "events.push",
+			},
+			wantErr: "PushSubject=\"events.push\" must not contain",
+		},
+		{
+			name: "subject with wildcard",
+			env: map[Setting]string{
+				StatusSubjectSetting: "status*",
+			},
+			wantErr: "StatusSubject=\"status*\" must not contain",
+		},
+		{
+			name: "duplicate subjects",
+			env: map[Setting]string{
+				MaintenanceSubjectSetting: "push",
+			},
+			wantErr: "PushSubject and MaintenanceSubject must not use the same subject \"push\"",
+		},
+		{
+			name: "access token expiry skew exceeds token lifetime",
+			env: map[Setting]string{
+				AccessTokenExpirySkewSetting: "1h",
+			},
+			wantErr: "AccessTokenExpirySkew (1h0m0s) must be between 0s and the lifetime of access tokens (1h0m0s)",
+		},
+		{
+			name: "pull request worker needs longer than ack wait",
+			env: map[Setting]string{
+				MaxDurationForPullRequestWorkerSetting: "4m",
+				MergeLocksBucketTTLSetting:             "5m",
+			},
+			wantErr: "AckWait (5m0s) must be greater than the maximum processing duration (5m0s)",
+		},
+		{
+			name: "push worker without budget",
+			env: map[Setting]string{
+				MaxDurationForPushWorkerSetting: "0s",
+			},
+			wantErr: "MaxDurationForPushWorker (0s) must be greater than 0s",
+		},
+		{
+			name: "kv replicas",
+			env: map[Setting]string{
+				KVReplicasSetting: "6",
+			},
+			wantErr: "KVReplicas (6) must be between 1 and 5",
+		},
+		{
+			name: "kv history",
+			env: map[Setting]string{
+				ConfigsBucketHistorySetting: "65",
+			},
+			wantErr: "ConfigsBucketHistory (65) must be between 1 and 64",
+		},
+		{
+			name: "name prefix",
+			env: map[Setting]string{
+				NamePrefixSetting: "staging_",
+			},
+		},
+		{
+			name: "name prefix with dot",
+			env: map[Setting]string{
+				NamePrefixSetting: "staging.",
+			},
+			wantErr: "NamePrefix=\"staging.\" must only contain letters, digits",
+		},
+		{
+			name: "name prefix with space",
+			env: map[Setting]string{
+				NamePrefixSetting: "my env",
+			},
+			wantErr: "NamePrefix=\"my env\" must only contain letters, digits",
+		},
+		{
+			name: "notifications",
+			env: map[Setting]string{
+				NotificationURLSetting:      "https://hooks.example.com/services/T0/B0/secret",
+				NotificationTemplateSetting: `{"text":"{{.Repository}}#{{.PullRequest}} {{.Action}}"}`,
+			},
+		},
+		{
+			name: "notification url is relative",
+			env: map[Setting]string{
+				NotificationURLSetting: "/services/T0/B0/secret",
+			},
+			wantErr: "NotificationURL must be an absolute url",
+		},
+		{
+			name: "notification template does not parse",
+			env: map[Setting]string{
+				NotificationURLSetting:      "https://hooks.example.com/services/T0/B0/secret",
+				NotificationTemplateSetting: `{{.Action`,
+			},
+			wantErr: "NotificationTemplate is invalid",
+		},
+		{
+			name: "sweep interval",
+			env: map[Setting]string{
+				SweepIntervalSetting: "15m",
+			},
+		},
+		{
+			name: "sweep interval less than rate limit interval",
+			env: map[Setting]string{
+				SweepIntervalSetting:     "10s",
+				RateLimitIntervalSetting: "30s",
+			},
+			wantErr: "SweepInterval (10s) must be 0s or not less than RateLimitInterval (30s)",
+		},
+		{
+			name: "negative reconcile throttle",
+			env: map[Setting]string{
+				ReconcileOnStartSetting:  "true",
+				ReconcileThrottleSetting: "-1s",
+			},
+			wantErr: "ReconcileThrottle (-1s) must not be negative",
+		},
+		{
+			name: "circuit breaker",
+			env: map[Setting]string{
+				CircuitBreakerThresholdSetting: "5",
+				CircuitBreakerCooldownSetting:  "15m",
+			},
+		},
+		{
+			name: "negative circuit breaker threshold",
+			env: map[Setting]string{
+				CircuitBreakerThresholdSetting: "-1",
+			},
+			wantErr: "CircuitBreakerThreshold (-1) must not be negative",
+		},
+		{
+			name: "circuit breaker without cooldown",
+			env: map[Setting]string{
+				CircuitBreakerThresholdSetting: "5",
+				CircuitBreakerCooldownSetting:  "0s",
+			},
+			wantErr: "CircuitBreakerCooldown (0s) must be positive",
+		},
+		{
+			name: "circuit breaker bucket ttl not greater than the cooldown",
+			env: map[Setting]string{
+				CircuitBreakerThresholdSetting: "5",
+				CircuitBreakerCooldownSetting:  "1h",
+				CircuitBreakerBucketTTLSetting: "1h",
+			},
+			wantErr: "CircuitBreakerBucketTTL (1h0m0s) must be greater than CircuitBreakerCooldown (1h0m0s)",
+		},
+		{
+			name: "mutation throttle",
+			env: map[Setting]string{
+				MutationThrottleSizeSetting:           "30",
+				MutationThrottleRefillIntervalSetting: "2s",
+			},
+		},
+		{
+			name: "negative mutation throttle size",
+			env: map[Setting]string{
+				MutationThrottleSizeSetting: "-1",
+			},
+			wantErr: "MutationThrottleSize (-1) must not be negative",
+		},
+		{
+			name: "mutation throttle without refill interval",
+			env: map[Setting]string{
+				MutationThrottleSizeSetting:           "30",
+				MutationThrottleRefillIntervalSetting: "0s",
+			},
+			wantErr: "MutationThrottleRefillInterval (0s) must be positive",
+		},
+		{
+			name: "mutation throttle bucket expires before it is refilled",
+			env: map[Setting]string{
+				MutationThrottleSizeSetting:           "30",
+				MutationThrottleRefillIntervalSetting: "1m",
+				MutationThrottleBucketTTLSetting:      "10m",
+			},
+			wantErr: "MutationThrottleBucketTTL (10m0s) must not be less than MutationThrottleSize * MutationThrottleRefillInterval (30m0s)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, setting := range sortedSettings() {
+				t.Setenv(string(setting), tt.env[setting])
+			}
+			errs := ValidateSettings()
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("ValidateSettings() errors = %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("ValidateSettings() errors = %v, want exactly one", errs)
+			}
+			if !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("ValidateSettings() error = %q, want %q", errs[0], tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetSetting_RetryAttemptsFallback(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv(string(MessageRetryAttemptsSetting), "")
+		t.Setenv(string(PushMessageRetryAttemptsSetting), "")
+		if got := MustGetSetting[int](PushMessageRetryAttemptsSetting); got != 5 {
+			t.Errorf("GetSetting(PushMessageRetryAttempts) = %d, want 5", got)
+		}
+	})
+	t.Run("falls back to MessageRetryAttempts", func(t *testing.T) {
+		t.Setenv(string(MessageRetryAttemptsSetting), "3")
+		t.Setenv(string(PushMessageRetryAttemptsSetting), "20")
+		t.Setenv(string(StatusMessageRetryAttemptsSetting), "")
+		t.Setenv(string(PullRequestMessageRetryAttemptsSetting), "2")
+
+		tests := []struct {
+			setting Setting
+			want    int
+		}{
+			{setting: PushMessageRetryAttemptsSetting, want: 20},
+			{setting: StatusMessageRetryAttemptsSetting, want: 3},
+			{setting: PullRequestMessageRetryAttemptsSetting, want: 2},
+		}
+		for _, tt := range tests {
+			if got := MustGetSetting[int](tt.setting); got != tt.want {
+				t.Errorf("GetSetting(%s) = %d, want %d", tt.setting, got, tt.want)
+			}
+		}
+		if got := EffectiveSettings()[string(StatusMessageRetryAttemptsSetting)]; got != "3" {
+			t.Errorf("EffectiveSettings()[StatusMessageRetryAttempts] = %v, want 3", got)
+		}
+	})
+}
+
+func TestStreamDuplicates(t *testing.T) {
+	tests := []struct {
+		name          string
+		duplicates    string
+		maxMessageAge string
+		want          time.Duration
+	}{
+		{name: "defaults", want: 2 * time.Minute},
+		{name: "shorter than max message age", duplicates: "5m", maxMessageAge: "10m", want: 5 * time.Minute},
+		{name: "capped at max message age", duplicates: "5m", maxMessageAge: "1m", want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(string(StreamDuplicatesSetting), tt.duplicates)
+			t.Setenv(string(MaxMessageAgeSetting), tt.maxMessageAge)
+			if got := StreamDuplicates(); got != tt.want {
+				t.Errorf("StreamDuplicates() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSetting_NamePrefix(t *testing.T) {
+	t.Setenv(string(NamePrefixSetting), "staging_")
+	t.Setenv(string(StreamNameSetting), "")
+	t.Setenv(string(PushSubjectSetting), "")
+	t.Setenv(string(ConfigsBucketNameSetting), "")
+	t.Setenv(string(PRDetailsBucketNameSetting), "custom_pr_details")
+	t.Setenv(string(BotNameSetting), "")
+
+	tests := []struct {
+		setting Setting
+		want    string
+	}{
+		{setting: StreamNameSetting, want: "staging_mwl_bot_events"},
+		{setting: PushSubjectSetting, want: "staging_push"},
+		{setting: ConfigsBucketNameSetting, want: "staging_mwl_configs"},
+		// explicitly set names are used as they are
+		{setting: PRDetailsBucketNameSetting, want: "custom_pr_details"},
+		// the prefix only applies to names
+		{setting: BotNameSetting, want: "merge-with-label"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.setting), func(t *testing.T) {
+			if got := MustGetSetting[string](tt.setting); got != tt.want {
+				t.Errorf("GetSetting(%s) = %q, want %q", tt.setting, got, tt.want)
+			}
+		})
+	}
+	if got := EffectiveSettings()[string(StreamNameSetting)]; got != "staging_mwl_bot_events" {
+		t.Errorf("EffectiveSettings()[StreamName] = %v, want staging_mwl_bot_events", got)
+	}
+}
+
+func TestEffectiveSettings(t *testing.T) {
+	t.Setenv(string(BotNameSetting), "my-bot")
+	t.Setenv(string(StreamNameSetting), "")
+	secretSettings[StreamNameSetting] = struct{}{}
+	defer delete(secretSettings, StreamNameSetting)
+
+	settings := EffectiveSettings()
+	if len(settings) != len(defaultSettings) {
+		t.Errorf("EffectiveSettings() returned %d settings, want %d", len(settings), len(defaultSettings))
+	}
+	if got := settings[string(BotNameSetting)]; got != "my-bot" {
+		t.Errorf("EffectiveSettings()[BotName] = %v, want my-bot", got)
+	}
+	if got := settings[string(StreamNameSetting)]; got != "[redacted]" {
+		t.Errorf("EffectiveSettings()[StreamName] = %v, want [redacted]", got)
+	}
+}
+
+// TestDefaultSettings makes sure every Setting constant has a default value with a supported type.
+func TestDefaultSettings(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "common.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings []Setting
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			if ident, ok := valueSpec.Type.(*ast.Ident); !ok || ident.Name != "Setting" {
+				continue
+			}
+			for _, value := range valueSpec.Values {
+				lit, ok := value.(*ast.BasicLit)
+				if !ok {
+					continue
+				}
+				settings = append(settings, Setting(strings.Trim(lit.Value, `"`)))
+			}
+		}
+	}
+	if len(settings) != len(defaultSettings) {
+		t.Errorf("found %d settings, but %d default settings", len(settings), len(defaultSettings))
+	}
+	for _, setting := range settings {
+		defaultValue, ok := defaultSettings[setting]
+		if !ok {
+			t.Errorf("setting %s has no default value", setting)
+			continue
+		}
+		if format := expectedFormat(reflect.TypeOf(defaultValue)); format == reflect.TypeOf(defaultValue).String() {
+			t.Errorf("setting %s has an unsupported type %T", setting, defaultValue)
+		}
+	}
+}
+
+func resetSettingLayers(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		flagValues = map[Setting]string{}
+		fileValues = map[Setting]string{}
+	})
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetSetting_Precedence(t *testing.T) {
+	configFile := "BotName: file-bot\n"
+	tests := []struct {
+		name   string
+		args   func(t *testing.T) []string
+		env    string
+		want   string
+		source string
+	}{
+		{
+			name:   "default",
+			args:   func(*testing.T) []string { return nil },
+			want:   "merge-with-label",
+			source: "",
+		},
+		{
+			name: "file over default",
+			args: func(t *testing.T) []string {
+				return []string{"--config", writeConfigFile(t, configFile)}
+			},
+			want:   "file-bot",
+			source: sourceFile,
+		},
+		{
+			name: "env over file",
+			args: func(t *testing.T) []string {
+				return []string{"--config", writeConfigFile(t, configFile)}
+			},
+			env:    "env-bot",
+			want:   "env-bot",
+			source: sourceEnv,
+		},
+		{
+			name: "flag over env",
+			args: func(t *testing.T) []string {
+				return []string{"--config", writeConfigFile(t, configFile), "--BotName", "flag-bot"}
+			},
+			env:    "env-bot",
+			want:   "flag-bot",
+			source: sourceFlag,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSettingLayers(t)
+			t.Setenv(string(BotNameSetting), tt.env)
+			if _, err := ParseFlags("test", tt.args(t)); err != nil {
+				t.Fatal(err)
+			}
+			if got := MustGetSetting[string](BotNameSetting); got != tt.want {
+				t.Errorf("GetSetting() = %q, want %q", got, tt.want)
+			}
+			if _, source := lookupSetting(BotNameSetting); source != tt.source {
+				t.Errorf("lookupSetting() source = %q, want %q", source, tt.source)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	t.Run("config file with lists and typed values", func(t *testing.T) {
+		resetSettingLayers(t)
+		t.Setenv(string(PullRequestActionsSetting), "")
+		t.Setenv(string(MessageRetryAttemptsSetting), "")
+		t.Setenv(string(RateLimitIntervalSetting), "")
+		path := writeConfigFile(t, `
+PullRequestActions:
+  - opened
+  - labeled
+MessageRetryAttempts: 7
+RateLimitInterval: 1m
+`)
+		options, err := ParseFlags("test", []string{"--config", path, "--print-config"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !options.PrintConfig {
+			t.Error("PrintConfig = false, want true")
+		}
+		if got := MustGetSetting[[]string](PullRequestActionsSetting); !slices.Equal(got, []string{"opened", "labeled"}) {
+			t.Errorf("GetSetting() = %v", got)
+		}
+		if got := MustGetSetting[int](MessageRetryAttemptsSetting); got != 7 {
+			t.Errorf("GetSetting() = %d, want 7", got)
+		}
+		if got := MustGetSetting[time.Duration](RateLimitIntervalSetting); got != time.Minute {
+			t.Errorf("GetSetting() = %v, want 1m", got)
+		}
+	})
+	t.Run("unknown key in config file", func(t *testing.T) {
+		resetSettingLayers(t)
+		path := writeConfigFile(t, "DoesNotExist: 1\n")
+		if _, err := ParseFlags("test", []string{"--config", path}); err == nil {
+			t.Error("ParseFlags() expected an error for an unknown key")
+		}
+	})
+	t.Run("invalid flag value is reported by ValidateSettings", func(t *testing.T) {
+		resetSettingLayers(t)
+		if _, err := ParseFlags("test", []string{"--RateLimitInterval", "30sec"}); err != nil {
+			t.Fatal(err)
+		}
+		if errs := ValidateSettings(); len(errs) == 0 {
+			t.Error("ValidateSettings() expected an error")
+		}
+	})
+}
+
+func TestPrintConfig(t *testing.T) {
+	resetSettingLayers(t)
+	t.Setenv(string(BotNameSetting), "")
+	t.Setenv(string(StreamNameSetting), "")
+	secretSettings[StreamNameSetting] = struct{}{}
+	defer delete(secretSettings, StreamNameSetting)
+
+	if _, err := ParseFlags("test", []string{"--StreamName", "secret-stream"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err := PrintConfig(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"BotName=merge-with-label (default)\n",
+		"StreamName=[redacted] (flag)\n",
+		"AllowedRepositories=.* (default)\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("PrintConfig() does not contain %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "secret-stream") {
+		t.Error("PrintConfig() contains the secret value")
+	}
+}
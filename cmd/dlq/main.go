@@ -0,0 +1,239 @@
+// dlq lists, requeues and purges messages the worker moved to the dead letter stream.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/Eun/merge-with-label/cmd"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+const usage = `usage: dlq [settings] <command>
+
+commands:
+  list [--json]              list the dead lettered messages
+  requeue [--force] <id>     publish the message back to its original subject
+  purge                      remove all dead lettered messages
+`
+
+// jetStream is the part of nats.JetStreamContext the commands use.
+type jetStream interface {
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	GetMsg(name string, seq uint64, opts ...nats.JSOpt) (*nats.RawStreamMsg, error)
+	DeleteMsg(name string, seq uint64, opts ...nats.JSOpt) error
+	PurgeStream(name string, opts ...nats.JSOpt) error
+	PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error)
+}
+
+type deadLetter struct {
+	ID          uint64    `json:"id"`
+	Subject     string    `json:"subject"`
+	Repository  string    `json:"repository,omitempty"`
+	PullRequest int64     `json:"pull_request,omitempty"`
+	Reason      string    `json:"reason"`
+	QueuedAt    time.Time `json:"queued_at"`
+	Age         string    `json:"age"`
+
+	data []byte
+}
+
+type app struct {
+	js            jetStream
+	stream        string
+	maxMessageAge time.Duration
+	now           func() time.Time
+	out           io.Writer
+}
+
+func (a *app) run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		asJSON := fs.Bool("json", false, "print as json")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errors.WithStack(err)
+		}
+		return a.list(*asJSON)
+	case "requeue":
+		fs := flag.NewFlagSet("requeue", flag.ContinueOnError)
+		force := fs.Bool("force", false, "requeue messages that are older than MaxMessageAge")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errors.WithStack(err)
+		}
+		if fs.NArg() != 1 {
+			return errors.New("requeue needs exactly one id")
+		}
+		id, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid id %q", fs.Arg(0))
+		}
+		return a.requeue(id, *force)
+	case "purge":
+		if err := a.js.PurgeStream(a.stream); err != nil {
+			return errors.Wrap(err, "unable to purge dead letter stream")
+		}
+		_, _ = fmt.Fprintln(a.out, "purged")
+		return nil
+	default:
+		return errors.Errorf("unknown command %q", args[0])
+	}
+}
+
+// deadLetters returns all messages in the dead letter stream.
+func (a *app) deadLetters() ([]deadLetter, error) {
+	info, err := a.js.StreamInfo(a.stream)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get dead letter stream")
+	}
+	var letters []deadLetter
+	if info.State.Msgs == 0 {
+		return letters, nil
+	}
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		letter, err := a.get(seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *letter)
+	}
+	return letters, nil
+}
+
+func (a *app) get(id uint64) (*deadLetter, error) {
+	msg, err := a.js.GetMsg(a.stream, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get message %d", id)
+	}
+	letter := deadLetter{
+		ID:       msg.Sequence,
+		Subject:  msg.Header.Get(common.DeadLetterSubjectHeader),
+		Reason:   msg.Header.Get(common.DeadLetterReasonHeader),
+		QueuedAt: msg.Time,
+		data:     msg.Data,
+	}
+	if t, err := time.Parse(time.RFC3339, msg.Header.Get(common.DeadLetterQueuedAtHeader)); err == nil {
+		letter.QueuedAt = t
+	}
+	letter.Age = a.now().Sub(letter.QueuedAt).Round(time.Second).String()
+
+	var payload struct {
+		Repository  common.Repository  `json:"repository"`
+		PullRequest common.PullRequest `json:"pull_request"`
+	}
+	if err := json.Unmarshal(msg.Data, &payload); err == nil {
+		letter.Repository = payload.Repository.FullName
+		letter.PullRequest = payload.PullRequest.Number
+	}
+	return &letter, nil
+}
+
+func (a *app) list(asJSON bool) error {
+	letters, err := a.deadLetters()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(a.out)
+		enc.SetIndent("", "  ")
+		return errors.WithStack(enc.Encode(letters))
+	}
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0) //nolint:gomnd // padding between columns
+	_, _ = fmt.Fprintln(w, "ID\tSUBJECT\tREPOSITORY\tPR\tAGE\tREASON")
+	for i := range letters {
+		pr := ""
+		if letters[i].PullRequest != 0 {
+			pr = "#" + strconv.FormatInt(letters[i].PullRequest, 10)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			letters[i].ID, letters[i].Subject, letters[i].Repository, pr, letters[i].Age, letters[i].Reason)
+	}
+	return errors.WithStack(w.Flush())
+}
+
+func (a *app) requeue(id uint64, force bool) error {
+	letter, err := a.get(id)
+	if err != nil {
+		return err
+	}
+	if letter.Subject == "" {
+		return errors.Errorf("message %d has no %s header", id, common.DeadLetterSubjectHeader)
+	}
+	if age := a.now().Sub(letter.QueuedAt); age > a.maxMessageAge && !force {
+		return errors.Errorf("message %d is %s old, which is older than MaxMessageAge (%s), use --force to requeue it anyway",
+			id, letter.Age, a.maxMessageAge)
+	}
+	if _, err := a.js.PublishMsg(&nats.Msg{Subject: letter.Subject, Data: letter.data}); err != nil {
+		return errors.Wrapf(err, "unable to publish message %d to %s", id, letter.Subject)
+	}
+	if err := a.js.DeleteMsg(a.stream, id); err != nil {
+		return errors.Wrapf(err, "message %d was requeued, but could not be removed from the dead letter stream", id)
+	}
+	_, _ = fmt.Fprintf(a.out, "requeued %d to %s\n", id, letter.Subject)
+	return nil
+}
+
+func main() {
+	os.Exit(execute())
+}
+
+func execute() int {
+	options, err := cmd.ParseFlags(os.Args[0], os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			_, _ = fmt.Fprint(os.Stderr, usage)
+			return 0
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2 //nolint:gomnd // exit code for invalid usage
+	}
+	if len(options.Args) == 0 {
+		_, _ = fmt.Fprint(os.Stderr, usage)
+		return 2 //nolint:gomnd // exit code for invalid usage
+	}
+
+	natsURL := os.Getenv("NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "unable to connect to %s: %s\n", natsURL, err)
+		return 1
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "unable to create jetstream context: %s\n", err)
+		return 1
+	}
+
+	a := app{
+		js:            js,
+		stream:        cmd.MustGetSetting[string](cmd.DeadLetterStreamNameSetting),
+		maxMessageAge: cmd.MustGetSetting[time.Duration](cmd.MaxMessageAgeSetting),
+		now:           time.Now,
+		out:           os.Stdout,
+	}
+	if err := a.run(options.Args); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
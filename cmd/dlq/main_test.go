@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+type fakeJetStream struct {
+	msgs      map[uint64]*nats.RawStreamMsg
+	published []*nats.Msg
+}
+
+func (f *fakeJetStream) StreamInfo(string, ...nats.JSOpt) (*nats.StreamInfo, error) {
+	var state nats.StreamState
+	seqs := make([]uint64, 0, len(f.msgs))
+	for seq := range f.msgs {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	if len(seqs) > 0 {
+		state.Msgs = uint64(len(seqs))
+		state.FirstSeq = seqs[0]
+		state.LastSeq = seqs[len(seqs)-1]
+	}
+	return &nats.StreamInfo{State: state}, nil
+}
+
+func (f *fakeJetStream) GetMsg(_ string, seq uint64, _ ...nats.JSOpt) (*nats.RawStreamMsg, error) {
+	if msg, ok := f.msgs[seq]; ok {
+		return msg, nil
+	}
+	return nil, nats.ErrMsgNotFound
+}
+
+func (f *fakeJetStream) DeleteMsg(_ string, seq uint64, _ ...nats.JSOpt) error {
+	if _, ok := f.msgs[seq]; !ok {
+		return nats.ErrMsgNotFound
+	}
+	delete(f.msgs, seq)
+	return nil
+}
+
+func (f *fakeJetStream) PurgeStream(string, ...nats.JSOpt) error {
+	f.msgs = map[uint64]*nats.RawStreamMsg{}
+	return nil
+}
+
+func (f *fakeJetStream) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	f.published = append(f.published, m)
+	return &nats.PubAck{}, nil
+}
+
+var now = time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+func newDeadLetter(seq uint64, subject, payload string, queuedAt time.Time) *nats.RawStreamMsg {
+	header := nats.Header{}
+	header.Set(common.DeadLetterSubjectHeader, subject)
+	header.Set(common.DeadLetterReasonHeader, "unable to merge")
+	header.Set(common.DeadLetterQueuedAtHeader, queuedAt.Format(time.RFC3339))
+	return &nats.RawStreamMsg{
+		Subject:  "dead_letter.x",
+		Sequence: seq,
+		Header:   header,
+		Data:     []byte(payload),
+		Time:     now,
+	}
+}
+
+func newTestApp() (*app, *fakeJetStream, *bytes.Buffer) {
+	js := &fakeJetStream{msgs: map[uint64]*nats.RawStreamMsg{
+		// 2 was requeued already
+		1: newDeadLetter(1, "pull_request.abc",
+			`{"repository":{"full_name":"owner/repo"},"pull_request":{"number":42}}`, now.Add(-5*time.Minute)),
+		3: newDeadLetter(3, "push.def", `{"repository":{"full_name":"owner/other"}}`, now.Add(-time.Hour)),
+	}}
+	var out bytes.Buffer
+	return &app{
+		js:            js,
+		stream:        "mwl_dead_letters",
+		maxMessageAge: 10 * time.Minute,
+		now:           func() time.Time { return now },
+		out:           &out,
+	}, js, &out
+}
+
+func TestList(t *testing.T) {
+	a, _, out := newTestApp()
+	if err := a.run([]string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 messages, got:\n%s", out.String())
+	}
+	for _, want := range []string{"pull_request.abc", "owner/repo", "#42", "5m0s", "unable to merge"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("line %q does not contain %q", lines[1], want)
+		}
+	}
+	if !strings.Contains(lines[2], "owner/other") || !strings.Contains(lines[2], "1h0m0s") {
+		t.Errorf("unexpected line %q", lines[2])
+	}
+}
+
+func TestList_JSON(t *testing.T) {
+	a, _, out := newTestApp()
+	if err := a.run([]string{"list", "--json"}); err != nil {
+		t.Fatal(err)
+	}
+	var letters []deadLetter
+	if err := json.Unmarshal(out.Bytes(), &letters); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, out.String())
+	}
+	if len(letters) != 2 || letters[0].ID != 1 || letters[0].PullRequest != 42 || letters[1].ID != 3 {
+		t.Errorf("unexpected dead letters %+v", letters)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		wantErr       bool
+		wantPublished string
+	}{
+		{name: "recent message", args: []string{"requeue", "1"}, wantPublished: "pull_request.abc"},
+		{name: "old message", args: []string{"requeue", "3"}, wantErr: true},
+		{name: "old message with force", args: []string{"requeue", "--force", "3"}, wantPublished: "push.def"},
+		{name: "unknown message", args: []string{"requeue", "2"}, wantErr: true},
+		{name: "missing id", args: []string{"requeue"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, js, _ := newTestApp()
+			err := a.run(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantPublished == "" {
+				if len(js.published) != 0 || len(js.msgs) != 2 {
+					t.Errorf("expected nothing to change, published %d, %d messages left", len(js.published), len(js.msgs))
+				}
+				return
+			}
+			if len(js.published) != 1 || js.published[0].Subject != tt.wantPublished {
+				t.Fatalf("expected one message published to %s, got %+v", tt.wantPublished, js.published)
+			}
+			if len(js.msgs) != 1 {
+				t.Errorf("requeued message was not removed from the dead letter stream")
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	a, js, _ := newTestApp()
+	if err := a.run([]string{"purge"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.msgs) != 0 {
+		t.Errorf("%d messages left after purge", len(js.msgs))
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	a, _, _ := newTestApp()
+	if err := a.run([]string{"unknown"}); err == nil {
+		t.Error("run() expected an error for an unknown command")
+	}
+}
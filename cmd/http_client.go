@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// GitHubProxyURL returns the proxy from the GITHUB_PROXY_URL environment variable, nil if it is not set.
+func GitHubProxyURL() (*url.URL, error) {
+	s := os.Getenv("GITHUB_PROXY_URL")
+	if s == "" {
+		return nil, nil
+	}
+	return github.ParseProxyURL(s)
+}
+
+// NewGitHubHTTPClient creates the client that is used for GitHub requests, configured by the settings.
+// If proxy is not nil all GitHub requests are sent through it.
+func NewGitHubHTTPClient(proxy *url.URL) *http.Client {
+	return github.NewHTTPClient(github.HTTPClientOptions{
+		Timeout:         MustGetSetting[time.Duration](GitHubHTTPTimeoutSetting),
+		MaxIdleConns:    MustGetSetting[int](GitHubMaxIdleConnsSetting),
+		IdleConnTimeout: MustGetSetting[time.Duration](GitHubIdleConnTimeoutSetting),
+		MaxRetries:      MustGetSetting[int](HTTPMaxRetriesSetting),
+		RetryBaseDelay:  MustGetSetting[time.Duration](HTTPRetryBaseDelaySetting),
+		RetryMaxDelay:   MustGetSetting[time.Duration](HTTPRetryMaxDelaySetting),
+		Proxy:           proxy,
+		MaxBodyBytes:    MustGetSetting[int64](MaxWebhookBodyBytesSetting),
+	})
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// KeyValueJetStream is the part of nats.JetStreamContext CreateOrUpdateKV uses.
+type KeyValueJetStream interface {
+	CreateKeyValue(cfg *nats.KeyValueConfig) (nats.KeyValue, error)
+	KeyValue(bucket string) (nats.KeyValue, error)
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	UpdateStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+}
+
+// KeyValueConfig returns the config of a kv bucket from its name, ttl and history setting
+// and the KVReplicas setting.
+func KeyValueConfig(nameSetting, ttlSetting, historySetting Setting) *nats.KeyValueConfig {
+	return &nats.KeyValueConfig{
+		Bucket:   MustGetSetting[string](nameSetting),
+		TTL:      MustGetSetting[time.Duration](ttlSetting),
+		History:  uint8(MustGetSetting[int](historySetting)),
+		Replicas: MustGetSetting[int](KVReplicasSetting),
+	}
+}
+
+// kvStreamPrefix is the prefix jetstream uses for the streams of kv buckets.
+const kvStreamPrefix = "KV_"
+
+// CreateOrUpdateKV creates the kv bucket.
+// If the bucket already exists with a different ttl, history or number of replicas its stream is updated,
+// if jetstream refuses the update (e.g. because the cluster has not enough nodes for the replicas) the discrepancy
+// is logged and the bucket is used as it is.
+func CreateOrUpdateKV(logger *zerolog.Logger, js KeyValueJetStream, cfg *nats.KeyValueConfig) (nats.KeyValue, error) {
+	kv, err := js.CreateKeyValue(cfg)
+	if err == nil {
+		return kv, nil
+	}
+	if !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		return nil, errors.Wrapf(err, "unable to create kv bucket %s", cfg.Bucket)
+	}
+
+	info, err := js.StreamInfo(kvStreamPrefix + cfg.Bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get stream of kv bucket %s", cfg.Bucket)
+	}
+	if discrepancies := kvDiscrepancies(&info.Config, cfg); len(discrepancies) > 0 {
+		streamConfig := info.Config
+		streamConfig.MaxAge = cfg.TTL
+		streamConfig.MaxMsgsPerSubject = int64(max(cfg.History, 1))
+		streamConfig.Replicas = max(cfg.Replicas, 1)
+		// the duplicate window must not exceed the max age
+		if streamConfig.MaxAge > 0 && streamConfig.Duplicates > streamConfig.MaxAge {
+			streamConfig.Duplicates = streamConfig.MaxAge
+		}
+		if _, err := js.UpdateStream(&streamConfig); err != nil {
+			logger.Warn().
+				Err(err).
+				Str("bucket", cfg.Bucket).
+				Strs("discrepancies", discrepancies).
+				Msg("kv bucket exists with a different config and could not be updated, using it as it is")
+		} else {
+			logger.Info().
+				Str("bucket", cfg.Bucket).
+				Strs("discrepancies", discrepancies).
+				Msg("updated config of existing kv bucket")
+		}
+	}
+
+	kv, err = js.KeyValue(cfg.Bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open kv bucket %s", cfg.Bucket)
+	}
+	return kv, nil
+}
+
+// kvDiscrepancies lists the differences between the stream of an existing kv bucket and the wanted config.
+func kvDiscrepancies(existing *nats.StreamConfig, cfg *nats.KeyValueConfig) []string {
+	var discrepancies []string
+	if existing.MaxAge != cfg.TTL {
+		discrepancies = append(discrepancies, fmt.Sprintf("ttl is %s, want %s", existing.MaxAge, cfg.TTL))
+	}
+	if history := int64(max(cfg.History, 1)); existing.MaxMsgsPerSubject != history {
+		discrepancies = append(discrepancies, fmt.Sprintf("history is %d, want %d", existing.MaxMsgsPerSubject, history))
+	}
+	if replicas := max(cfg.Replicas, 1); existing.Replicas != replicas {
+		discrepancies = append(discrepancies, fmt.Sprintf("replicas is %d, want %d", existing.Replicas, replicas))
+	}
+	return discrepancies
+}
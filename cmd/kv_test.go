@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+type fakeKV struct {
+	nats.KeyValue
+	bucket string
+}
+
+type fakeKeyValueJetStream struct {
+	streams   map[string]*nats.StreamConfig
+	createErr error
+	updateErr error
+	updated   *nats.StreamConfig
+}
+
+func (f *fakeKeyValueJetStream) CreateKeyValue(cfg *nats.KeyValueConfig) (nats.KeyValue, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	if _, ok := f.streams[kvStreamPrefix+cfg.Bucket]; ok {
+		return nil, nats.ErrStreamNameAlreadyInUse
+	}
+	f.streams[kvStreamPrefix+cfg.Bucket] = &nats.StreamConfig{
+		Name:              kvStreamPrefix + cfg.Bucket,
+		MaxAge:            cfg.TTL,
+		MaxMsgsPerSubject: int64(cfg.History),
+		Replicas:          cfg.Replicas,
+	}
+	return &fakeKV{bucket: cfg.Bucket}, nil
+}
+
+func (f *fakeKeyValueJetStream) KeyValue(bucket string) (nats.KeyValue, error) {
+	if _, ok := f.streams[kvStreamPrefix+bucket]; !ok {
+		return nil, nats.ErrBucketNotFound
+	}
+	return &fakeKV{bucket: bucket}, nil
+}
+
+func (f *fakeKeyValueJetStream) StreamInfo(stream string, _ ...nats.JSOpt) (*nats.StreamInfo, error) {
+	cfg, ok := f.streams[stream]
+	if !ok {
+		return nil, nats.ErrStreamNotFound
+	}
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func (f *fakeKeyValueJetStream) UpdateStream(cfg *nats.StreamConfig, _ ...nats.JSOpt) (*nats.StreamInfo, error) {
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	f.updated = cfg
+	f.streams[cfg.Name] = cfg
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func TestKeyValueConfig(t *testing.T) {
+	t.Setenv(string(ConfigsBucketNameSetting), "")
+	t.Setenv(string(ConfigsBucketTTLSetting), "1h")
+	t.Setenv(string(ConfigsBucketHistorySetting), "5")
+	t.Setenv(string(KVReplicasSetting), "3")
+
+	cfg := KeyValueConfig(ConfigsBucketNameSetting, ConfigsBucketTTLSetting, ConfigsBucketHistorySetting)
+	want := nats.KeyValueConfig{Bucket: "mwl_configs", TTL: time.Hour, History: 5, Replicas: 3}
+	if !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("KeyValueConfig() = %+v, want %+v", *cfg, want)
+	}
+}
+
+func TestCreateOrUpdateKV(t *testing.T) {
+	cfg := &nats.KeyValueConfig{Bucket: "mwl_configs", TTL: time.Hour, History: 5, Replicas: 3}
+	existing := &nats.StreamConfig{
+		Name:              "KV_mwl_configs",
+		MaxAge:            24 * time.Hour,
+		MaxMsgsPerSubject: 1,
+		Replicas:          1,
+		Duplicates:        2 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		streams     map[string]*nats.StreamConfig
+		updateErr   error
+		wantUpdated bool
+		wantLog     string
+	}{
+		{
+			name:    "new bucket",
+			streams: map[string]*nats.StreamConfig{},
+		},
+		{
+			name:        "existing bucket is updated",
+			streams:     map[string]*nats.StreamConfig{"KV_mwl_configs": existing},
+			wantUpdated: true,
+			wantLog:     "updated config of existing kv bucket",
+		},
+		{
+			name:      "existing bucket cannot be updated",
+			streams:   map[string]*nats.StreamConfig{"KV_mwl_configs": existing},
+			updateErr: errors.New("insufficient resources"),
+			wantLog:   "kv bucket exists with a different config and could not be updated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := zerolog.New(&buf)
+			js := &fakeKeyValueJetStream{streams: tt.streams, updateErr: tt.updateErr}
+
+			kv, err := CreateOrUpdateKV(&logger, js, cfg)
+			if err != nil {
+				t.Fatalf("CreateOrUpdateKV() error = %v", err)
+			}
+			if kv.(*fakeKV).bucket != "mwl_configs" {
+				t.Errorf("CreateOrUpdateKV() opened bucket %s, want mwl_configs", kv.(*fakeKV).bucket)
+			}
+			if (js.updated != nil) != tt.wantUpdated {
+				t.Fatalf("stream updated = %v, want %v", js.updated != nil, tt.wantUpdated)
+			}
+			if js.updated != nil {
+				if js.updated.MaxAge != time.Hour || js.updated.MaxMsgsPerSubject != 5 || js.updated.Replicas != 3 {
+					t.Errorf("updated stream = %+v, want ttl 1h, history 5 and 3 replicas", js.updated)
+				}
+				if js.updated.Duplicates != 2*time.Minute {
+					t.Errorf("updated duplicate window = %s, want 2m0s", js.updated.Duplicates)
+				}
+			}
+			if !strings.Contains(buf.String(), tt.wantLog) {
+				t.Errorf("log %q does not contain %q", buf.String(), tt.wantLog)
+			}
+			if tt.wantLog != "" && !strings.Contains(buf.String(), "history is 1, want 5") {
+				t.Errorf("log %q does not contain the discrepancy", buf.String())
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateKV_Error(t *testing.T) {
+	logger := zerolog.Nop()
+	js := &fakeKeyValueJetStream{streams: map[string]*nats.StreamConfig{}, createErr: nats.ErrHistoryToLarge}
+	if _, err := CreateOrUpdateKV(&logger, js, &nats.KeyValueConfig{Bucket: "mwl_configs", History: 65}); err == nil {
+		t.Fatal("CreateOrUpdateKV() expected an error")
+	}
+}
@@ -0,0 +1,226 @@
+// schema generates a json schema for the merge-with-label.yml config file.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/worker"
+)
+
+const (
+	schemaVersion = "http://json-schema.org/draft-07/schema#"
+	schemaID      = "https://raw.githubusercontent.com/Eun/merge-with-label/main/schema.json"
+)
+
+var (
+	durationType   = reflect.TypeOf(time.Duration(0))
+	regexSliceType = reflect.TypeOf(common.RegexSlice{})
+	regexItemType  = reflect.TypeOf(common.RegexItem{})
+
+	approvalRequirementType = reflect.TypeOf(worker.ApprovalRequirement{})
+)
+
+// enums contains the possible values of types that are used as enumerations.
+var enums = map[reflect.Type][]any{
+	reflect.TypeOf(worker.MergeStrategy("")): {
+		worker.MergeCommitStrategy,
+		worker.SquashMergeStrategy,
+		worker.RebaseMergeStrategy,
+	},
+	reflect.TypeOf(worker.NotificationType("")): {
+		worker.CheckRunNotification,
+		worker.IssueNotification,
+		worker.NoNotification,
+	},
+	reflect.TypeOf(worker.MergeOrder("")): {
+		worker.OldestFirstOrder,
+		worker.NewestFirstOrder,
+	},
+	reflect.TypeOf(worker.MatchingMode("")): {
+		worker.RegexMatching,
+		worker.AnchoredMatching,
+	},
+}
+
+// fieldEnums contains the possible values of single fields, the key is `Type.Field`.
+var fieldEnums = map[string][]any{
+	"ConfigHeader.Version": {1},
+}
+
+type schema map[string]any
+
+func main() {
+	source := flag.String("source", "pkg/merge-with-label/worker/config.go", "go file that contains the config structs")
+	out := flag.String("out", "schema.json", "file to write the schema to")
+	flag.Parse()
+
+	buf, err := generate(*source)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, buf, 0o600); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// generate creates the schema for worker.ConfigV1, the field descriptions are taken from the comments in source.
+func generate(source string) ([]byte, error) {
+	comments, err := fieldComments(source)
+	if err != nil {
+		return nil, err
+	}
+	s := schemaForStruct(reflect.TypeOf(worker.ConfigV1{}), comments)
+	s["$schema"] = schemaVersion
+	s["$id"] = schemaID
+	s["title"] = "merge-with-label configuration"
+	s["required"] = []string{"version"}
+
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode schema")
+	}
+	return append(buf, '\n'), nil
+}
+
+// fieldComments returns the doc comments of all struct fields in source, the key is `Type.Field`.
+func fieldComments(source string) (map[string]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), source, nil, parser.ParseComments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", source)
+	}
+	comments := make(map[string]string)
+	ast.Inspect(f, func(node ast.Node) bool {
+		spec, ok := node.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		for _, field := range st.Fields.List {
+			if field.Doc == nil {
+				continue
+			}
+			for _, name := range field.Names {
+				comments[spec.Name.Name+"."+name.Name] = strings.Join(strings.Fields(field.Doc.Text()), " ")
+			}
+		}
+		return false
+	})
+	return comments, nil
+}
+
+func schemaForStruct(t reflect.Type, comments map[string]string) schema {
+	properties := make(map[string]schema)
+	addProperties(t, comments, properties)
+	return schema{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// addProperties adds all fields of t to properties, embedded structs are inlined.
+func addProperties(t reflect.Type, comments map[string]string, properties map[string]schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addProperties(field.Type, comments, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		key := t.Name() + "." + field.Name
+		s := schemaForType(field.Type, comments)
+		s["title"] = field.Name
+		if comment, ok := comments[key]; ok {
+			s["description"] = comment
+		}
+		if values, ok := fieldEnums[key]; ok {
+			s["enum"] = values
+		}
+		properties[name] = s
+	}
+}
+
+func schemaForType(t reflect.Type, comments map[string]string) schema {
+	if values, ok := enums[t]; ok {
+		return schema{
+			"type": "string",
+			"enum": values,
+		}
+	}
+
+	switch t {
+	case durationType:
+		return schema{
+			"type":    []string{"string", "integer"},
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	case regexItemType:
+		return schema{
+			"type":   "string",
+			"format": "regex",
+		}
+	case approvalRequirementType:
+		// a requirement can also be written as a single pattern
+		return schema{
+			"oneOf": []schema{
+				{"type": "string", "format": "regex"},
+				schemaForStruct(t, comments),
+			},
+		}
+	case regexSliceType:
+		return schema{
+			"type": "array",
+			"items": schema{
+				"type":   "string",
+				"format": "regex",
+			},
+		}
+	}
+
+	switch t.Kind() { //nolint:exhaustive // only the kinds that are used in the config are supported
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Pointer:
+		return schemaForType(t.Elem(), comments)
+	case reflect.Slice:
+		return schema{
+			"type":  "array",
+			"items": schemaForType(t.Elem(), comments),
+		}
+	case reflect.Struct:
+		return schemaForStruct(t, comments)
+	default:
+		panic(fmt.Sprintf("unsupported type %s", t))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+const configSource = "../../pkg/merge-with-label/worker/config.go"
+
+// TestSchemaIsUpToDate makes sure the committed schema.json matches the config structs.
+func TestSchemaIsUpToDate(t *testing.T) {
+	got, err := generate(configSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// git might check out the file with windows line endings
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if !bytes.Equal(got, want) {
+		t.Error("schema.json is outdated, run `go run ./cmd/schema`")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	buf, err := generate(configSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		Properties map[string]struct {
+			Type        any            `json:"type"`
+			Enum        []any          `json:"enum"`
+			Description string         `json:"description"`
+			Properties  map[string]any `json:"properties"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(buf, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		check func() bool
+	}{
+		{"version is 1", func() bool {
+			return len(s.Properties["version"].Enum) == 1 && s.Properties["version"].Enum[0] == float64(1)
+		}},
+		{"merge is an object", func() bool { return s.Properties["merge"].Type == "object" }},
+		{"merge contains embedded ignore fields", func() bool {
+			_, ok := s.Properties["merge"].Properties["ignoreFromUsers"]
+			return ok
+		}},
+		{"merge.strategy is an enum", func() bool {
+			strategy, _ := s.Properties["merge"].Properties["strategy"].(map[string]any)
+			enum, _ := strategy["enum"].([]any)
+			return len(enum) == 3
+		}},
+		{"labelDeletedNotification is an enum", func() bool { return len(s.Properties["labelDeletedNotification"].Enum) == 3 }},
+		{"extendsURL has a description", func() bool { return s.Properties["extendsURL"].Description != "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.check() {
+				t.Errorf("unexpected schema:\n%s", buf)
+			}
+		})
+	}
+}
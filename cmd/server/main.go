@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -14,13 +17,29 @@ import (
 	"github.com/rs/zerolog/pkgerrors"
 
 	"github.com/Eun/merge-with-label/cmd"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/buildinfo"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/server"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/worker"
 )
 
 func main() {
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
+	options, err := cmd.ParseFlags(os.Args[0], os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2) //nolint:gomnd // exit code for invalid usage
+	}
+
+	if errs := cmd.ValidateSettings(); len(errs) > 0 {
+		_, _ = fmt.Fprintln(os.Stderr, errs)
+		os.Exit(1)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
@@ -34,6 +53,24 @@ func main() {
 		logger.Debug().Msg("trace logging enabled")
 	}
 
+	buildInfo := buildinfo.Get()
+	logger.Info().Str("version", buildInfo.Version).Str("commit", buildInfo.Commit).Str("date", buildInfo.Date).Msg("starting server")
+
+	logger.Info().Interface("settings", cmd.EffectiveSettings()).Msg("effective configuration")
+
+	gitHubProxy, err := cmd.GitHubProxyURL()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid GITHUB_PROXY_URL")
+		return
+	}
+	if gitHubProxy != nil {
+		logger.Info().Str("proxy_host", gitHubProxy.Host).Msg("using proxy for GitHub requests")
+	}
+
+	if options.PrintConfig {
+		_ = cmd.PrintConfig(os.Stdout)
+	}
+
 	address := os.Getenv("ADDRESS")
 	if address == "" {
 		address = ":" + os.Getenv("PORT")
@@ -43,6 +80,22 @@ func main() {
 		address = ":8000"
 	}
 
+	var appID int64
+	if s := os.Getenv("APP_ID"); s != "" {
+		var err error
+		appID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			logger.Error().Err(err).Msg("unable to get APP_ID")
+			return
+		}
+	}
+
+	pullRequestActions := cmd.MustGetSetting[[]string](cmd.PullRequestActionsSetting)
+	if err := server.ValidatePullRequestActions(pullRequestActions); err != nil {
+		logger.Error().Err(err).Msgf("invalid %s", cmd.PullRequestActionsSetting)
+		return
+	}
+
 	natsURL := os.Getenv("NATS_URL")
 	if natsURL == "" {
 		natsURL = nats.DefaultURL
@@ -65,14 +118,16 @@ func main() {
 	}
 
 	currentStreamConfig := &nats.StreamConfig{
-		Name: cmd.GetSetting[string](cmd.StreamNameSetting),
+		Name: cmd.MustGetSetting[string](cmd.StreamNameSetting),
 		Subjects: []string{
-			cmd.GetSetting[string](cmd.PushSubjectSetting) + ".>",
-			cmd.GetSetting[string](cmd.StatusSubjectSetting) + ".>",
-			cmd.GetSetting[string](cmd.PullRequestSubjectSetting) + ".>",
+			cmd.MustGetSetting[string](cmd.PushSubjectSetting) + ".>",
+			cmd.MustGetSetting[string](cmd.StatusSubjectSetting) + ".>",
+			cmd.MustGetSetting[string](cmd.PullRequestSubjectSetting) + ".>",
+			cmd.MustGetSetting[string](cmd.MaintenanceSubjectSetting) + ".>",
 		},
-		Retention: nats.WorkQueuePolicy,
-		MaxAge:    cmd.GetSetting[time.Duration](cmd.MaxMessageAgeSetting),
+		Retention:  nats.WorkQueuePolicy,
+		MaxAge:     cmd.MustGetSetting[time.Duration](cmd.MaxMessageAgeSetting),
+		Duplicates: cmd.StreamDuplicates(),
 	}
 
 	logger.Debug().Msg("getting js info")
@@ -98,10 +153,9 @@ func main() {
 	logger.Debug().Msg("js stream is ready")
 
 	logger.Debug().Msg("creating ratelimit kv")
-	rateLimitKV, err := js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: cmd.GetSetting[string](cmd.RateLimitBucketNameSetting),
-		TTL:    cmd.GetSetting[time.Duration](cmd.RateLimitBucketTTLSetting),
-	})
+	rateLimitKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.RateLimitBucketNameSetting, cmd.RateLimitBucketTTLSetting, cmd.RateLimitBucketHistorySetting,
+	))
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -111,27 +165,115 @@ func main() {
 	}
 	logger.Debug().Msg("configured ratelimit kv")
 
+	var tokenStore server.TokenStore
+	var checkRunsKV nats.KeyValue
+	var checkRunNames server.CheckRunNameStore
+	if cmd.MustGetSetting[bool](cmd.CreateQueuedCheckRunSetting) {
+		logger.Debug().Msg("creating access_token kv")
+		accessTokensKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+			cmd.AccessTokensBucketNameSetting, cmd.AccessTokensBucketTTLSetting, cmd.AccessTokensBucketHistorySetting,
+		))
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("nats_url", natsURL).
+				Msg("unable to create jetstream key value bucket for access-tokens")
+			return
+		}
+		tokenStore = &server.KVTokenStore{
+			KV:          accessTokensKV,
+			MinValidity: cmd.MustGetSetting[time.Duration](cmd.AccessTokenExpirySkewSetting),
+		}
+		logger.Debug().Msg("configured access_token kv")
+
+		logger.Debug().Msg("creating check_runs kv")
+		checkRunsKV, err = cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+			cmd.CheckRunsBucketNameSetting, cmd.CheckRunsBucketTTLSetting, cmd.CheckRunsBucketHistorySetting,
+		))
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("nats_url", natsURL).
+				Msg("unable to create jetstream key value bucket for check runs")
+			return
+		}
+		logger.Debug().Msg("configured check_runs kv")
+
+		logger.Debug().Msg("creating configs kv")
+		configsKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+			cmd.ConfigsBucketNameSetting, cmd.ConfigsBucketTTLSetting, cmd.ConfigsBucketHistorySetting,
+		))
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("nats_url", natsURL).
+				Msg("unable to create jetstream key value bucket for configs")
+			return
+		}
+		// the queued check run must have the same name the worker uses, otherwise it would create a second one
+		checkRunNames = &worker.KVCheckRunNameStore{
+			KV:                 configsKV,
+			CheckRunNamePrefix: cmd.MustGetSetting[string](cmd.CheckRunNamePrefixSetting),
+			BotName:            cmd.MustGetSetting[string](cmd.BotNameSetting),
+		}
+		logger.Debug().Msg("configured configs kv")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/version", buildinfo.Handler())
+	mux.Handle("/", &server.Handler{
+		GetLoggerForContext: func(ctx context.Context) *zerolog.Logger {
+			return &logger
+		},
+		AllowedRepositories:         cmd.MustGetSetting[common.RegexSlice](cmd.AllowedRepositoriesSetting),
+		AllowOnlyPublicRepositories: cmd.MustGetSetting[bool](cmd.AllowOnlyPublicRepositories),
+		MaxBodyBytes:                cmd.MustGetSetting[int64](cmd.MaxWebhookBodyBytesSetting),
+		AppID:                       appID,
+		RedirectURL:                 os.Getenv("LANDING_PAGE_URL"),
+		WebhookPath:                 cmd.MustGetSetting[string](cmd.WebhookPathSetting),
+		PullRequestActions:          pullRequestActions,
+		AllowedGitHubEvents:         cmd.MustGetSetting[[]string](cmd.AllowedGitHubEventsSetting),
+		HandleMergeGroup:            cmd.MustGetSetting[bool](cmd.MergeGroupCheckRunSetting),
+
+		JetStreamContext:   js,
+		PushSubject:        cmd.MustGetSetting[string](cmd.PushSubjectSetting),
+		StatusSubject:      cmd.MustGetSetting[string](cmd.StatusSubjectSetting),
+		PullRequestSubject: cmd.MustGetSetting[string](cmd.PullRequestSubjectSetting),
+		MaintenanceSubject: cmd.MustGetSetting[string](cmd.MaintenanceSubjectSetting),
+
+		RateLimitKV:       rateLimitKV,
+		RateLimitInterval: cmd.MustGetSetting[time.Duration](cmd.RateLimitIntervalSetting),
+
+		ServerCheckRunToken: tokenStore,
+		CheckRunNames:       checkRunNames,
+		CheckRunsKV:         checkRunsKV,
+		HTTPClient:          cmd.NewGitHubHTTPClient(gitHubProxy),
+	})
+
+	if adminToken := cmd.MustGetSetting[string](cmd.AdminTokenSetting); adminToken != "" {
+		mux.Handle("/admin/queue", &server.QueueHandler{
+			GetLoggerForContext: func(ctx context.Context) *zerolog.Logger {
+				return &logger
+			},
+			JetStreamContext: js,
+			StreamName:       cmd.MustGetSetting[string](cmd.StreamNameSetting),
+			ConsumerNames: []string{
+				cmd.PushWorkerQueue,
+				cmd.StatusWorkerQueue,
+				cmd.PullRequestWorkerQueue,
+				cmd.MaintenanceWorkerQueue,
+			},
+			AdminToken: adminToken,
+		})
+	}
+
 	srv := http.Server{
 		Addr:              address,
 		ReadTimeout:       1 * time.Second,
 		WriteTimeout:      1 * time.Second,
 		IdleTimeout:       30 * time.Second, //nolint:gomnd // set IdleTimeout
 		ReadHeaderTimeout: 2 * time.Second,  //nolint:gomnd // set ReadHeaderTimeout
-		Handler: &server.Handler{
-			GetLoggerForContext: func(ctx context.Context) *zerolog.Logger {
-				return &logger
-			},
-			AllowedRepositories:         cmd.GetSetting[common.RegexSlice](cmd.AllowedRepositoriesSetting),
-			AllowOnlyPublicRepositories: cmd.GetSetting[bool](cmd.AllowOnlyPublicRepositories),
-
-			JetStreamContext:   js,
-			PushSubject:        cmd.GetSetting[string](cmd.PushSubjectSetting),
-			StatusSubject:      cmd.GetSetting[string](cmd.StatusSubjectSetting),
-			PullRequestSubject: cmd.GetSetting[string](cmd.PullRequestSubjectSetting),
-
-			RateLimitKV:       rateLimitKV,
-			RateLimitInterval: cmd.GetSetting[time.Duration](cmd.RateLimitIntervalSetting),
-		},
+		Handler:           mux,
 		BaseContext: func(listener net.Listener) context.Context {
 			return ctx
 		},
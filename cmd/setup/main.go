@@ -0,0 +1,122 @@
+// setup prints a GitHub App manifest that can be used to register the app.
+// See https://docs.github.com/en/apps/sharing-github-apps/registering-a-github-app-from-a-manifest
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+type hookAttributes struct {
+	URL    string `json:"url"`
+	Active bool   `json:"active"`
+}
+
+type manifest struct {
+	Name               string            `json:"name"`
+	URL                string            `json:"url"`
+	Description        string            `json:"description,omitempty"`
+	HookAttributes     *hookAttributes   `json:"hook_attributes,omitempty"`
+	Public             bool              `json:"public"`
+	DefaultEvents      []string          `json:"default_events"`
+	DefaultPermissions map[string]string `json:"default_permissions"`
+}
+
+// defaultPermissions are the repository permissions the bot needs.
+var defaultPermissions = map[string]string{
+	"actions":       "read",
+	"checks":        "write",
+	"contents":      "write",
+	"issues":        "write",
+	"merge_queues":  "read",
+	"metadata":      "read",
+	"pull_requests": "write",
+	"statuses":      "read",
+	"workflows":     "write",
+}
+
+// defaultEvents are the events the server handles.
+var defaultEvents = []string{
+	"check_run",
+	"check_suite",
+	"label",
+	"merge_group",
+	"pull_request",
+	"pull_request_review",
+	"push",
+	"status",
+	"workflow_run",
+}
+
+type options struct {
+	Name       string
+	URL        string
+	WebhookURL string
+	Public     bool
+}
+
+func newManifest(opts *options) (*manifest, error) {
+	if opts.Name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+	if err := validateURL(opts.URL); err != nil {
+		return nil, errors.Wrap(err, "invalid url")
+	}
+	m := manifest{
+		Name:               opts.Name,
+		URL:                opts.URL,
+		Description:        "A github bot for merging & updating pull requests with a label.",
+		Public:             opts.Public,
+		DefaultEvents:      defaultEvents,
+		DefaultPermissions: defaultPermissions,
+	}
+	if opts.WebhookURL != "" {
+		if err := validateURL(opts.WebhookURL); err != nil {
+			return nil, errors.Wrap(err, "invalid webhook url")
+		}
+		m.HookAttributes = &hookAttributes{
+			URL:    opts.WebhookURL,
+			Active: true,
+		}
+	}
+	return &m, nil
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("`%s' is not an absolute http(s) url", s)
+	}
+	return nil
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.Name, "name", "merge-with-label", "name of the app")
+	flag.StringVar(&opts.URL, "url", "https://github.com/Eun/merge-with-label", "homepage of the app")
+	flag.StringVar(&opts.WebhookURL, "webhook-url", "", "url the webhooks are sent to, e.g. https://example.com/")
+	flag.BoolVar(&opts.Public, "public", false, "allow the app to be installed on any account")
+	flag.Parse()
+
+	m, err := newManifest(&opts)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2) //nolint:gomnd // exit code for invalid usage
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(m); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// manifestSchema mirrors the manifest parameters that GitHub accepts, unknown fields are rejected when decoding.
+type manifestSchema struct {
+	Name           *string `json:"name"`
+	URL            *string `json:"url"`
+	Description    string  `json:"description"`
+	HookAttributes *struct {
+		URL    *string `json:"url"`
+		Active bool    `json:"active"`
+	} `json:"hook_attributes"`
+	RedirectURL        string            `json:"redirect_url"`
+	CallbackURLs       []string          `json:"callback_urls"`
+	SetupURL           string            `json:"setup_url"`
+	Public             bool              `json:"public"`
+	DefaultEvents      []string          `json:"default_events"`
+	DefaultPermissions map[string]string `json:"default_permissions"`
+}
+
+func validateManifest(t *testing.T, buf []byte) *manifestSchema {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	var m manifestSchema
+	if err := dec.Decode(&m); err != nil {
+		t.Fatalf("manifest does not match the schema: %v", err)
+	}
+	if m.Name == nil || *m.Name == "" {
+		t.Error("name is required")
+	}
+	if m.URL == nil || *m.URL == "" {
+		t.Error("url is required")
+	}
+	if m.HookAttributes != nil && (m.HookAttributes.URL == nil || *m.HookAttributes.URL == "") {
+		t.Error("hook_attributes.url is required")
+	}
+	for permission, level := range m.DefaultPermissions {
+		if level != "read" && level != "write" {
+			t.Errorf("permission %s has an invalid level %q", permission, level)
+		}
+	}
+	return &m
+}
+
+func TestNewManifest(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        options
+		wantErr     bool
+		wantWebhook string
+	}{
+		{
+			name: "without webhook",
+			opts: options{Name: "merge-with-label", URL: "https://github.com/Eun/merge-with-label"},
+		},
+		{
+			name:        "with webhook",
+			opts:        options{Name: "merge-with-label", URL: "https://github.com/Eun/merge-with-label", WebhookURL: "https://example.com/hook"},
+			wantWebhook: "https://example.com/hook",
+		},
+		{
+			name:    "invalid webhook",
+			opts:    options{Name: "merge-with-label", URL: "https://github.com/Eun/merge-with-label", WebhookURL: "example.com/hook"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid url",
+			opts:    options{Name: "merge-with-label", URL: "github.com"},
+			wantErr: true,
+		},
+		{
+			name:    "empty name",
+			opts:    options{URL: "https://github.com/Eun/merge-with-label"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newManifest(&tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			buf, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := validateManifest(t, buf)
+
+			if tt.wantWebhook == "" && got.HookAttributes != nil {
+				t.Errorf("hook_attributes = %+v, want none", got.HookAttributes)
+			}
+			if tt.wantWebhook != "" && (got.HookAttributes == nil || *got.HookAttributes.URL != tt.wantWebhook || !got.HookAttributes.Active) {
+				t.Errorf("hook_attributes = %+v, want active %s", got.HookAttributes, tt.wantWebhook)
+			}
+			for _, event := range []string{"merge_group", "workflow_run"} {
+				if !slices.Contains(got.DefaultEvents, event) {
+					t.Errorf("default_events = %v, want %s", got.DefaultEvents, event)
+				}
+			}
+			for _, permission := range []string{"pull_requests", "contents", "checks"} {
+				if got.DefaultPermissions[permission] != "write" {
+					t.Errorf("permission %s = %q, want write", permission, got.DefaultPermissions[permission])
+				}
+			}
+			for _, event := range []string{"pull_request", "push", "check_run", "status"} {
+				if !slices.Contains(got.DefaultEvents, event) {
+					t.Errorf("event %s is missing", event)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// DeadLetterStreamConfig returns the config of the stream dead lettered messages are kept in.
+// Unlike the event stream it is not a work queue, messages stay until they are requeued, purged or expire.
+func DeadLetterStreamConfig() *nats.StreamConfig {
+	return &nats.StreamConfig{
+		Name:      MustGetSetting[string](DeadLetterStreamNameSetting),
+		Subjects:  []string{MustGetSetting[string](DeadLetterSubjectSetting) + ".>"},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    MustGetSetting[time.Duration](DeadLetterMaxAgeSetting),
+	}
+}
+
+// EnsureStream creates the stream or updates its config if it already exists.
+func EnsureStream(js nats.JetStreamManager, cfg *nats.StreamConfig) error {
+	_, err := js.StreamInfo(cfg.Name)
+	if err != nil && !errors.Is(err, nats.ErrStreamNotFound) {
+		return errors.Wrap(err, "unable to get stream")
+	}
+	if err == nil {
+		if _, err := js.UpdateStream(cfg); err != nil {
+			return errors.Wrap(err, "unable to update stream")
+		}
+		return nil
+	}
+	if _, err := js.AddStream(cfg); err != nil {
+		return errors.Wrap(err, "unable to add stream")
+	}
+	return nil
+}
@@ -2,23 +2,47 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/pkgerrors"
 
 	"github.com/Eun/merge-with-label/cmd"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/buildinfo"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/metrics"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/worker"
 )
 
+// notificationShutdownTimeout is the time pending notifications have to be sent when the worker shuts down.
+const notificationShutdownTimeout = 30 * time.Second
+
 func main() {
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+
+	options, err := cmd.ParseFlags(os.Args[0], os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2) //nolint:gomnd // exit code for invalid usage
+	}
+
+	if errs := cmd.ValidateSettings(); len(errs) > 0 {
+		_, _ = fmt.Fprintln(os.Stderr, errs)
+		os.Exit(1)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
@@ -32,6 +56,24 @@ func main() {
 		logger.Debug().Msg("trace logging enabled")
 	}
 
+	buildInfo := buildinfo.Get()
+	logger.Info().Str("version", buildInfo.Version).Str("commit", buildInfo.Commit).Str("date", buildInfo.Date).Msg("starting worker")
+
+	logger.Info().Interface("settings", cmd.EffectiveSettings()).Msg("effective configuration")
+
+	gitHubProxy, err := cmd.GitHubProxyURL()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid GITHUB_PROXY_URL")
+		return
+	}
+	if gitHubProxy != nil {
+		logger.Info().Str("proxy_host", gitHubProxy.Host).Msg("using proxy for GitHub requests")
+	}
+
+	if options.PrintConfig {
+		_ = cmd.PrintConfig(os.Stdout)
+	}
+
 	if os.Getenv("APP_ID") == "" {
 		logger.Error().Msg("APP_ID is not set")
 		return
@@ -79,10 +121,9 @@ func main() {
 	}
 
 	logger.Debug().Msg("creating access_token kv")
-	accessTokensKV, err := js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: cmd.GetSetting[string](cmd.AccessTokensBucketNameSetting),
-		TTL:    cmd.GetSetting[time.Duration](cmd.AccessTokensBucketTTLSetting),
-	})
+	accessTokensKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.AccessTokensBucketNameSetting, cmd.AccessTokensBucketTTLSetting, cmd.AccessTokensBucketHistorySetting,
+	))
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -93,10 +134,9 @@ func main() {
 	logger.Debug().Msg("configured access_token kv")
 
 	logger.Debug().Msg("creating configs kv")
-	configsKV, err := js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: cmd.GetSetting[string](cmd.ConfigsBucketNameSetting),
-		TTL:    cmd.GetSetting[time.Duration](cmd.ConfigsBucketTTLSetting),
-	})
+	configsKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.ConfigsBucketNameSetting, cmd.ConfigsBucketTTLSetting, cmd.ConfigsBucketHistorySetting,
+	))
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -106,11 +146,115 @@ func main() {
 	}
 	logger.Debug().Msg("configured configs kv")
 
+	logger.Debug().Msg("creating pr_details kv")
+	prDetailsKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.PRDetailsBucketNameSetting, cmd.PRDetailsBucketTTLSetting, cmd.PRDetailsBucketHistorySetting,
+	))
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("nats_url", os.Getenv("NATS_URL")).
+			Msg("unable to create jetstream key value bucket for pull request details")
+		return
+	}
+	logger.Debug().Msg("configured pr_details kv")
+
+	logger.Debug().Msg("creating merge_locks kv")
+	mergeLocksKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.MergeLocksBucketNameSetting, cmd.MergeLocksBucketTTLSetting, cmd.MergeLocksBucketHistorySetting,
+	))
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("nats_url", os.Getenv("NATS_URL")).
+			Msg("unable to create jetstream key value bucket for merge locks")
+		return
+	}
+	logger.Debug().Msg("configured merge_locks kv")
+
+	logger.Debug().Msg("creating review_requests kv")
+	reviewRequestsKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.ReviewRequestsBucketNameSetting, cmd.ReviewRequestsBucketTTLSetting, cmd.ReviewRequestsBucketHistorySetting,
+	))
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("nats_url", os.Getenv("NATS_URL")).
+			Msg("unable to create jetstream key value bucket for review requests")
+		return
+	}
+	logger.Debug().Msg("configured review_requests kv")
+
+	logger.Debug().Msg("creating recently_merged kv")
+	recentlyMergedKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.RecentlyMergedBucketNameSetting, cmd.RecentlyMergedBucketTTLSetting, cmd.RecentlyMergedBucketHistorySetting,
+	))
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("nats_url", os.Getenv("NATS_URL")).
+			Msg("unable to create jetstream key value bucket for recently merged pull requests")
+		return
+	}
+	logger.Debug().Msg("configured recently_merged kv")
+
+	var repositoriesKV nats.KeyValue
+	if cmd.MustGetSetting[time.Duration](cmd.SweepIntervalSetting) > 0 {
+		logger.Debug().Msg("creating repositories kv")
+		repositoriesKV, err = cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+			cmd.RepositoriesBucketNameSetting, cmd.RepositoriesBucketTTLSetting, cmd.RepositoriesBucketHistorySetting,
+		))
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("nats_url", os.Getenv("NATS_URL")).
+				Msg("unable to create jetstream key value bucket for repositories")
+			return
+		}
+		logger.Debug().Msg("configured repositories kv")
+	}
+
+	var circuitBreakerKV nats.KeyValue
+	if cmd.MustGetSetting[int](cmd.CircuitBreakerThresholdSetting) > 0 {
+		logger.Debug().Msg("creating circuit_breaker kv")
+		circuitBreakerKV, err = cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+			cmd.CircuitBreakerBucketNameSetting, cmd.CircuitBreakerBucketTTLSetting, cmd.CircuitBreakerBucketHistorySetting,
+		))
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("nats_url", os.Getenv("NATS_URL")).
+				Msg("unable to create jetstream key value bucket for the circuit breaker")
+			return
+		}
+		logger.Debug().Msg("configured circuit_breaker kv")
+	}
+
+	var mutationThrottle *common.TokenBucket
+	if size := cmd.MustGetSetting[int](cmd.MutationThrottleSizeSetting); size > 0 {
+		logger.Debug().Msg("creating mutation_throttle kv")
+		mutationThrottleKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+			cmd.MutationThrottleBucketNameSetting, cmd.MutationThrottleBucketTTLSetting, cmd.MutationThrottleBucketHistorySetting,
+		))
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("nats_url", os.Getenv("NATS_URL")).
+				Msg("unable to create jetstream key value bucket for the mutation throttle")
+			return
+		}
+		mutationThrottle = &common.TokenBucket{
+			KV:             mutationThrottleKV,
+			Size:           size,
+			RefillInterval: cmd.MustGetSetting[time.Duration](cmd.MutationThrottleRefillIntervalSetting),
+		}
+		logger.Debug().Msg("configured mutation_throttle kv")
+	}
+
 	logger.Debug().Msg("creating check_runs kv")
-	checkRunsKV, err := js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: cmd.GetSetting[string](cmd.CheckRunsBucketNameSetting),
-		TTL:    cmd.GetSetting[time.Duration](cmd.CheckRunsBucketTTLSetting),
-	})
+	checkRunsKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.CheckRunsBucketNameSetting, cmd.CheckRunsBucketTTLSetting, cmd.CheckRunsBucketHistorySetting,
+	))
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -121,10 +265,9 @@ func main() {
 	logger.Debug().Msg("configured check_runs kv")
 
 	logger.Debug().Msg("creating ratelimit kv")
-	rateLimitKV, err := js.CreateKeyValue(&nats.KeyValueConfig{
-		Bucket: cmd.GetSetting[string](cmd.RateLimitBucketNameSetting),
-		TTL:    cmd.GetSetting[time.Duration](cmd.RateLimitBucketTTLSetting),
-	})
+	rateLimitKV, err := cmd.CreateOrUpdateKV(&logger, js, cmd.KeyValueConfig(
+		cmd.RateLimitBucketNameSetting, cmd.RateLimitBucketTTLSetting, cmd.RateLimitBucketHistorySetting,
+	))
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -134,12 +277,23 @@ func main() {
 	}
 	logger.Debug().Msg("configured ratelimit kv")
 
+	logger.Debug().Msg("creating dead letter stream")
+	if err := cmd.EnsureStream(js, cmd.DeadLetterStreamConfig()); err != nil {
+		logger.Error().
+			Err(err).
+			Str("nats_url", os.Getenv("NATS_URL")).
+			Msg("unable to create dead letter stream")
+		return
+	}
+	logger.Debug().Msg("dead letter stream is ready")
+
 	logger.Debug().Msg("subscribing to push subject")
 	pushSubscription, err := js.QueueSubscribeSync(
-		cmd.GetSetting[string](cmd.PushSubjectSetting)+".>",
-		"push-worker",
+		cmd.MustGetSetting[string](cmd.PushSubjectSetting)+".>",
+		cmd.PushWorkerQueue,
 		nats.AckExplicit(),
-		nats.MaxDeliver(cmd.GetSetting[int](cmd.MessageRetryAttemptsSetting)),
+		nats.AckWait(cmd.MustGetSetting[time.Duration](cmd.AckWaitSetting)),
+		nats.MaxDeliver(cmd.MustGetSetting[int](cmd.PushMessageRetryAttemptsSetting)),
 	)
 	if err != nil {
 		logger.Error().
@@ -156,10 +310,11 @@ func main() {
 
 	logger.Debug().Msg("subscribing to status subject")
 	statusSubscription, err := js.QueueSubscribeSync(
-		cmd.GetSetting[string](cmd.StatusSubjectSetting)+".>",
-		"status-worker",
+		cmd.MustGetSetting[string](cmd.StatusSubjectSetting)+".>",
+		cmd.StatusWorkerQueue,
 		nats.AckExplicit(),
-		nats.MaxDeliver(cmd.GetSetting[int](cmd.MessageRetryAttemptsSetting)),
+		nats.AckWait(cmd.MustGetSetting[time.Duration](cmd.AckWaitSetting)),
+		nats.MaxDeliver(cmd.MustGetSetting[int](cmd.StatusMessageRetryAttemptsSetting)),
 	)
 	if err != nil {
 		logger.Error().
@@ -176,10 +331,11 @@ func main() {
 
 	logger.Debug().Msg("subscribing to pull_request subject")
 	pullRequestSubscription, err := js.QueueSubscribeSync(
-		cmd.GetSetting[string](cmd.PullRequestSubjectSetting)+".>",
-		"pull-request-worker",
+		cmd.MustGetSetting[string](cmd.PullRequestSubjectSetting)+".>",
+		cmd.PullRequestWorkerQueue,
 		nats.AckExplicit(),
-		nats.MaxDeliver(cmd.GetSetting[int](cmd.MessageRetryAttemptsSetting)),
+		nats.AckWait(cmd.MustGetSetting[time.Duration](cmd.AckWaitSetting)),
+		nats.MaxDeliver(cmd.MustGetSetting[int](cmd.PullRequestMessageRetryAttemptsSetting)),
 	)
 	if err != nil {
 		logger.Error().
@@ -194,39 +350,111 @@ func main() {
 		}
 	}()
 
+	logger.Debug().Msg("subscribing to maintenance subject")
+	maintenanceSubscription, err := js.QueueSubscribeSync(
+		cmd.MustGetSetting[string](cmd.MaintenanceSubjectSetting)+".>",
+		cmd.MaintenanceWorkerQueue,
+		nats.AckExplicit(),
+		nats.AckWait(cmd.MustGetSetting[time.Duration](cmd.AckWaitSetting)),
+		nats.MaxDeliver(cmd.MustGetSetting[int](cmd.MessageRetryAttemptsSetting)),
+	)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("nats_url", os.Getenv("NATS_URL")).
+			Msg("unable to create jetstream subscriber for maintenance queue")
+		return
+	}
+	defer func() {
+		if err := maintenanceSubscription.Unsubscribe(); err != nil {
+			logger.Error().Err(err).Msg("unable to unsubscribe from maintenance queue")
+		}
+	}()
+
+	var notifier *worker.Notifier
+	if notificationURL := cmd.MustGetSetting[string](cmd.NotificationURLSetting); notificationURL != "" {
+		notifier, err = worker.NewNotifier(
+			&logger,
+			&http.Client{},
+			notificationURL,
+			cmd.MustGetSetting[string](cmd.NotificationTemplateSetting),
+			cmd.MustGetSetting[time.Duration](cmd.NotificationTimeoutSetting),
+		)
+		if err != nil {
+			logger.Error().Err(err).Msg("unable to create notifier")
+			return
+		}
+	}
+
 	w := worker.Worker{
 		Logger:  &logger,
-		BotName: cmd.GetSetting[string](cmd.BotNameSetting),
+		BotName: cmd.MustGetSetting[string](cmd.BotNameSetting),
+		ID:      uuid.NewString(),
 
-		AllowedRepositories:         cmd.GetSetting[common.RegexSlice](cmd.AllowedRepositoriesSetting),
-		AllowOnlyPublicRepositories: cmd.GetSetting[bool](cmd.AllowOnlyPublicRepositories),
+		CheckRunNamePrefix: cmd.MustGetSetting[string](cmd.CheckRunNamePrefixSetting),
+
+		AllowedRepositories:         cmd.MustGetSetting[common.RegexSlice](cmd.AllowedRepositoriesSetting),
+		AllowOnlyPublicRepositories: cmd.MustGetSetting[bool](cmd.AllowOnlyPublicRepositories),
 
 		PushSubscription:        pushSubscription,
 		StatusSubscription:      statusSubscription,
 		PullRequestSubscription: pullRequestSubscription,
+		MaintenanceSubscription: maintenanceSubscription,
 
 		AccessTokensKV: accessTokensKV,
 		ConfigsKV:      configsKV,
 		CheckRunsKV:    checkRunsKV,
+		PRDetailsKV:    prDetailsKV,
+		MergeLocksKV:   mergeLocksKV,
+
+		AccessTokenExpirySkew: cmd.MustGetSetting[time.Duration](cmd.AccessTokenExpirySkewSetting),
+
+		ReviewRequestsKV: reviewRequestsKV,
+		RecentlyMergedKV: recentlyMergedKV,
+		RepositoriesKV:   repositoriesKV,
+		SweepInterval:    cmd.MustGetSetting[time.Duration](cmd.SweepIntervalSetting),
+
+		ReconcileThrottle: cmd.MustGetSetting[time.Duration](cmd.ReconcileThrottleSetting),
+
+		CircuitBreakerKV:        circuitBreakerKV,
+		CircuitBreakerThreshold: cmd.MustGetSetting[int](cmd.CircuitBreakerThresholdSetting),
+		CircuitBreakerCooldown:  cmd.MustGetSetting[time.Duration](cmd.CircuitBreakerCooldownSetting),
+
+		MutationThrottle: mutationThrottle,
+
+		PRDetailsCacheInvalidationEvents: cmd.MustGetSetting[[]string](cmd.PRDetailsCacheInvalidationEventsSetting),
 
 		JetStreamContext:   js,
-		PullRequestSubject: cmd.GetSetting[string](cmd.PullRequestSubjectSetting),
-		RetryWait:          cmd.GetSetting[time.Duration](cmd.MessageRetryWaitSetting),
+		PullRequestSubject: cmd.MustGetSetting[string](cmd.PullRequestSubjectSetting),
+		PushSubject:        cmd.MustGetSetting[string](cmd.PushSubjectSetting),
+		RetryWait:          cmd.MustGetSetting[time.Duration](cmd.MessageRetryWaitSetting),
+
+		MaxDurationForPushWorker:        cmd.MustGetSetting[time.Duration](cmd.MaxDurationForPushWorkerSetting),
+		MaxDurationForPullRequestWorker: cmd.MustGetSetting[time.Duration](cmd.MaxDurationForPullRequestWorkerSetting),
 
-		MaxDurationForPushWorker:        time.Minute,
-		MaxDurationForPullRequestWorker: time.Minute,
+		MaxPullRequestsPerSweep: cmd.MustGetSetting[int](cmd.MaxPullRequestsPerSweepSetting),
 
 		RateLimitKV:       rateLimitKV,
-		RateLimitInterval: cmd.GetSetting[time.Duration](cmd.RateLimitIntervalSetting),
+		RateLimitInterval: cmd.MustGetSetting[time.Duration](cmd.RateLimitIntervalSetting),
+
+		DurationBeforeMergeAfterCheck:       cmd.MustGetSetting[time.Duration](cmd.DurationBeforeMergeAfterCheckSetting),
+		DurationToWaitAfterUpdateBranch:     cmd.MustGetSetting[time.Duration](cmd.DurationToWaitAfterUpdateBranchSetting),
+		MessageChannelSizePerSubjectSetting: cmd.MustGetSetting[int](cmd.MessageChannelSizePerSubjectSetting),
 
-		DurationBeforeMergeAfterCheck:       cmd.GetSetting[time.Duration](cmd.DurationBeforeMergeAfterCheckSetting),
-		DurationToWaitAfterUpdateBranch:     cmd.GetSetting[time.Duration](cmd.DurationToWaitAfterUpdateBranchSetting),
-		MessageChannelSizePerSubjectSetting: cmd.GetSetting[int](cmd.MessageChannelSizePerSubjectSetting),
+		MaxDeliver:        cmd.MustGetSetting[int](cmd.MessageRetryAttemptsSetting),
+		DeadLetterSubject: cmd.MustGetSetting[string](cmd.DeadLetterSubjectSetting),
+		MaxDeliverBySubject: map[string]int{
+			cmd.MustGetSetting[string](cmd.PushSubjectSetting):        cmd.MustGetSetting[int](cmd.PushMessageRetryAttemptsSetting),
+			cmd.MustGetSetting[string](cmd.StatusSubjectSetting):      cmd.MustGetSetting[int](cmd.StatusMessageRetryAttemptsSetting),
+			cmd.MustGetSetting[string](cmd.PullRequestSubjectSetting): cmd.MustGetSetting[int](cmd.PullRequestMessageRetryAttemptsSetting),
+		},
 
-		HTTPClient: http.DefaultClient,
+		HTTPClient: cmd.NewGitHubHTTPClient(gitHubProxy),
 
 		AppID:      appID,
 		PrivateKey: privateKeyBytes,
+
+		Notifications: notifier,
 	}
 
 	errChan := make(chan error)
@@ -235,13 +463,51 @@ func main() {
 		errChan <- w.Consume()
 	}()
 
+	if cmd.MustGetSetting[bool](cmd.ReconcileOnStartSetting) {
+		go func() {
+			if err := w.Reconcile(ctx); err != nil {
+				logger.Error().Err(err).Msg("unable to reconcile installations")
+			}
+		}()
+	}
+
+	var metricsServer *http.Server
+	if metricsAddress := os.Getenv("METRICS_ADDRESS"); metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/version", buildinfo.Handler())
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:              metricsAddress,
+			ReadTimeout:       1 * time.Second,
+			WriteTimeout:      1 * time.Second,
+			IdleTimeout:       30 * time.Second, //nolint:gomnd // set IdleTimeout
+			ReadHeaderTimeout: 2 * time.Second,  //nolint:gomnd // set ReadHeaderTimeout
+			Handler:           mux,
+		}
+		go func() {
+			logger.Info().Msgf("metrics listening on %s", metricsAddress)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error().Err(err).Msgf("unable to listen on address %s", metricsAddress)
+			}
+		}()
+	}
+
 	select {
 	case <-ctx.Done():
 		logger.Info().Msg("shutting down")
-		_ = w.Shutdown(context.Background())
+		// give pending notifications a chance to be sent
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), notificationShutdownTimeout)
+		if err := w.Shutdown(shutdownCtx); err != nil {
+			logger.Error().Err(err).Msg("unable to shutdown worker")
+		}
+		cancel()
 	case err := <-errChan:
 		if err != nil {
 			logger.Error().Err(err).Msg("unable to consume")
 		}
 	}
+
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(context.Background())
+	}
 }
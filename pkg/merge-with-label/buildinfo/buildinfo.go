@@ -0,0 +1,100 @@
+// Package buildinfo provides the version information of the running binary.
+//
+// The values can be set during build time using ldflags:
+//
+//	go build -ldflags "-X github.com/Eun/merge-with-label/pkg/merge-with-label/buildinfo.Version=v1.0.0 \
+//	  -X github.com/Eun/merge-with-label/pkg/merge-with-label/buildinfo.Commit=abcdef \
+//	  -X github.com/Eun/merge-with-label/pkg/merge-with-label/buildinfo.Date=2023-01-01T00:00:00Z"
+//
+// If they are not set the information embedded by the go toolchain is used.
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+const unknown = "unknown"
+
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+func (i Info) String() string {
+	return i.Version + " (commit " + i.Commit + ", built " + i.Date + ")"
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return get(Version, Commit, Date, debug.ReadBuildInfo)
+}
+
+func get(version, commit, date string, readBuildInfo func() (*debug.BuildInfo, bool)) Info {
+	info := Info{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+	}
+	if info.Version == "" || info.Commit == "" || info.Date == "" {
+		fillFromBuildInfo(&info, readBuildInfo)
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if !strings.HasPrefix(info.Version, "v") && info.Version != "dev" {
+		info.Version = "v" + info.Version
+	}
+	if info.Commit == "" {
+		info.Commit = unknown
+	}
+	if info.Date == "" {
+		info.Date = unknown
+	}
+	return info
+}
+
+func fillFromBuildInfo(info *Info, readBuildInfo func() (*debug.BuildInfo, bool)) {
+	bi, ok := readBuildInfo()
+	if !ok || bi == nil {
+		return
+	}
+	if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = setting.Value
+			}
+		}
+	}
+}
+
+// Handler returns a http.Handler that responds with the build information encoded as json.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get())
+	})
+}
@@ -0,0 +1,89 @@
+package buildinfo
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func Test_get(t *testing.T) {
+	buildInfo := func(version string, settings ...debug.BuildSetting) func() (*debug.BuildInfo, bool) {
+		return func() (*debug.BuildInfo, bool) {
+			return &debug.BuildInfo{
+				Main:     debug.Module{Version: version},
+				Settings: settings,
+			}, true
+		}
+	}
+	noBuildInfo := func() (*debug.BuildInfo, bool) {
+		return nil, false
+	}
+
+	tests := []struct {
+		name          string
+		version       string
+		commit        string
+		date          string
+		readBuildInfo func() (*debug.BuildInfo, bool)
+		want          Info
+	}{
+		{
+			name:          "use ldflags when all are set",
+			version:       "v1.2.3",
+			commit:        "abcdef",
+			date:          "2023-01-01T00:00:00Z",
+			readBuildInfo: buildInfo("v0.0.1", debug.BuildSetting{Key: "vcs.revision", Value: "123456"}),
+			want:          Info{Version: "v1.2.3", Commit: "abcdef", Date: "2023-01-01T00:00:00Z"},
+		},
+		{
+			name:    "fallback to build info when ldflags are absent",
+			version: "",
+			commit:  "",
+			date:    "",
+			readBuildInfo: buildInfo(
+				"v0.0.1",
+				debug.BuildSetting{Key: "vcs.revision", Value: "123456"},
+				debug.BuildSetting{Key: "vcs.time", Value: "2023-02-01T00:00:00Z"},
+			),
+			want: Info{Version: "v0.0.1", Commit: "123456", Date: "2023-02-01T00:00:00Z"},
+		},
+		{
+			name:          "fallback only for missing ldflags",
+			version:       "v1.2.3",
+			commit:        "",
+			date:          "",
+			readBuildInfo: buildInfo("v0.0.1", debug.BuildSetting{Key: "vcs.revision", Value: "123456"}),
+			want:          Info{Version: "v1.2.3", Commit: "123456", Date: "unknown"},
+		},
+		{
+			name:          "devel version is reported as dev",
+			version:       "",
+			commit:        "",
+			date:          "",
+			readBuildInfo: buildInfo("(devel)"),
+			want:          Info{Version: "dev", Commit: "unknown", Date: "unknown"},
+		},
+		{
+			name:          "add v prefix to version",
+			version:       "1.2.3",
+			commit:        "abcdef",
+			date:          "2023-01-01T00:00:00Z",
+			readBuildInfo: noBuildInfo,
+			want:          Info{Version: "v1.2.3", Commit: "abcdef", Date: "2023-01-01T00:00:00Z"},
+		},
+		{
+			name:          "no build info available",
+			version:       "",
+			commit:        "",
+			date:          "",
+			readBuildInfo: noBuildInfo,
+			want:          Info{Version: "dev", Commit: "unknown", Date: "unknown"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := get(tt.version, tt.commit, tt.date, tt.readBuildInfo); got != tt.want {
+				t.Errorf("get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
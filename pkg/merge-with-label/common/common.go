@@ -1,5 +1,10 @@
 package common
 
+import (
+	"strings"
+	"time"
+)
+
 const (
 	DelayUntilHeader = "DelayUntil"
 )
@@ -10,9 +15,34 @@ type Repository struct {
 	NodeID    string `json:"node_id"`
 	OwnerName string `json:"owner_name"`
 	Private   bool   `json:"private"`
+	// DefaultBranch is the default branch of the repository, it is empty for messages queued by older servers.
+	DefaultBranch string `json:"default_branch,omitempty"`
+}
+
+// Equal reports whether r and other refer to the same repository.
+// FullName is compared case-insensitively, if one of the repositories has no FullName the NodeID is compared instead.
+func (r Repository) Equal(other Repository) bool {
+	if r.FullName != "" && other.FullName != "" {
+		return strings.EqualFold(r.FullName, other.FullName)
+	}
+	return r.NodeID != "" && r.NodeID == other.NodeID
+}
+
+// CacheKey returns a key that identifies the repository, it can be used as a map or kv key.
+// The NodeID is used if it is set, otherwise the lowercased FullName is used because GitHub treats it case-insensitively.
+func (r Repository) CacheKey() string {
+	if r.NodeID != "" {
+		return r.NodeID
+	}
+	return strings.ToLower(r.FullName)
 }
+
 type PullRequest struct {
 	Number int64 `json:"number"`
+	// State is the state of the pull request when the event was sent, empty if the event did not carry it.
+	State string `json:"state,omitempty"`
+	// MergedAt is the time the pull request was merged, nil if it is not merged or the event did not carry it.
+	MergedAt *time.Time `json:"merged_at,omitempty"`
 }
 
 type Message interface {
@@ -20,9 +50,18 @@ type Message interface {
 	GetRepository() Repository
 }
 
+// Sender is the user that triggered an event.
+type Sender struct {
+	Login string `json:"login"`
+	// Type is the account type of the user, e.g. User or Bot.
+	Type string `json:"type"`
+}
+
 type BaseMessage struct {
 	InstallationID int64      `json:"installation_id"`
 	Repository     Repository `json:"repository"`
+	// Sender triggered the event, it is empty for messages that were not created by a webhook.
+	Sender Sender `json:"sender"`
 }
 
 func (m BaseMessage) GetRepository() Repository {
@@ -35,12 +74,30 @@ func (m BaseMessage) GetInstallationID() int64 {
 type QueuePullRequestMessage struct {
 	BaseMessage
 	PullRequest PullRequest `json:"pull_request"`
+	// Closed is set when the pull request was closed without merging, only cleanup is done for these messages.
+	Closed bool `json:"closed,omitempty"`
+	// Action is the action of the pull_request event, empty if the message was not created by a pull_request event.
+	Action string `json:"action,omitempty"`
+	// Label is the label that was added or removed, only set for labeled and unlabeled actions.
+	Label string `json:"label,omitempty"`
+	// HeadSHA is the sha of the head commit when the event was sent, if known.
+	HeadSHA string `json:"head_sha,omitempty"`
 }
 
 type QueuePushMessage struct {
 	BaseMessage
+	// Branch is the pushed branch, only pull requests based on it are looked at.
+	// It is empty for messages queued by older servers, all pull requests are looked at for them.
+	Branch string `json:"branch,omitempty"`
 }
 
 type QueueStatusMessage struct {
 	BaseMessage
 }
+
+type QueueMaintenanceMessage struct {
+	BaseMessage
+	DeletedLabel string `json:"deleted_label"`
+	// MergeGroupHeadSHA is the head sha of a merge group that requested checks.
+	MergeGroupHeadSHA string `json:"merge_group_head_sha,omitempty"`
+}
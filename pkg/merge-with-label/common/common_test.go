@@ -0,0 +1,49 @@
+package common
+
+import "testing"
+
+func TestRepository_Equal(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     Repository
+		b     Repository
+		equal bool
+	}{
+		{name: "same full name", a: Repository{FullName: "org/repo"}, b: Repository{FullName: "org/repo"}, equal: true},
+		{name: "full name differs in case", a: Repository{FullName: "Org/Repo"}, b: Repository{FullName: "org/repo"}, equal: true},
+		{name: "only one node id", a: Repository{NodeID: "R_1", FullName: "org/repo"}, b: Repository{FullName: "org/repo"}, equal: true},
+		{name: "different full name", a: Repository{NodeID: "R_1", FullName: "org/repo"}, b: Repository{NodeID: "R_1", FullName: "org/other"}},
+		{name: "missing full name, same node id", a: Repository{NodeID: "R_1"}, b: Repository{NodeID: "R_1", FullName: "org/repo"}, equal: true},
+		{name: "missing full name, different node id", a: Repository{NodeID: "R_1"}, b: Repository{NodeID: "R_2", FullName: "org/repo"}},
+		{name: "empty", a: Repository{}, b: Repository{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Equal(tt.b); got != tt.equal {
+				t.Errorf("Equal() = %v, want %v", got, tt.equal)
+			}
+			if got := tt.b.Equal(tt.a); got != tt.equal {
+				t.Errorf("Equal() is not symmetric, got %v, want %v", got, tt.equal)
+			}
+		})
+	}
+}
+
+func TestRepository_CacheKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository Repository
+		want       string
+	}{
+		{name: "node id", repository: Repository{NodeID: "R_1", FullName: "org/repo"}, want: "R_1"},
+		{name: "full name", repository: Repository{FullName: "Org/Repo"}, want: "org/repo"},
+		{name: "empty", repository: Repository{}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.repository.CacheKey(); got != tt.want {
+				t.Errorf("CacheKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package common
+
+// Headers of messages in the dead letter stream.
+const (
+	// DeadLetterSubjectHeader is the subject the message was originally published to.
+	DeadLetterSubjectHeader = "Mwl-Original-Subject"
+	// DeadLetterReasonHeader is the error of the last delivery.
+	DeadLetterReasonHeader = "Mwl-Dead-Letter-Reason"
+	// DeadLetterQueuedAtHeader is the time the message was originally published, formatted as RFC3339.
+	DeadLetterQueuedAtHeader = "Mwl-Queued-At"
+)
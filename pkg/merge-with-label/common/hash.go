@@ -0,0 +1,98 @@
+package common
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"strconv"
+)
+
+// HashForKV returns a hash of name that can be used as a kv key.
+func HashForKV(name string) string {
+	h := sha512.Sum512([]byte(name))
+	return hex.EncodeToString(h[:])
+}
+
+// CheckRunKVKey returns the key the id of a check run is stored with in the check runs kv bucket.
+func CheckRunKVKey(name, pullRequestNodeID, sha string) string {
+	return HashForKV(name + pullRequestNodeID + sha)
+}
+
+// AccessTokenKVKey returns the key the access token of a repository is stored with in the access tokens kv bucket.
+// The key includes the installation, so tokens are never shared between installations.
+func AccessTokenKVKey(installationID int64, repository *Repository) string {
+	return HashForKV(strconv.FormatInt(installationID, 10) + ":" + repository.CacheKey())
+}
+
+// PullRequestDetailsKVKey returns the key the details of a pull request are stored with in the pull request details kv bucket.
+func PullRequestDetailsKVKey(repository *Repository, number int64) string {
+	return HashForKV(repository.CacheKey() + "#" + strconv.FormatInt(number, 10))
+}
+
+// BlockedSinceKVKey returns the key the time a pull request got blocked from merging is stored with
+// in the check runs kv bucket.
+func BlockedSinceKVKey(pullRequestNodeID string) string {
+	return HashForKV(pullRequestNodeID + "blocked_since")
+}
+
+// BlockedLabelKVKey returns the key that marks that the blocked label was added to a pull request
+// in the check runs kv bucket.
+func BlockedLabelKVKey(pullRequestNodeID string) string {
+	return HashForKV(pullRequestNodeID + "blocked_label")
+}
+
+// MergeLockKVKey returns the key that is used to lock the merge of a pull request in the merge locks kv bucket.
+func MergeLockKVKey(pullRequestNodeID string) string {
+	return HashForKV(pullRequestNodeID + "merge")
+}
+
+// RepositoryMergeLockKVKey returns the key that is used to lock the merges of a repository in the merge locks kv bucket.
+func RepositoryMergeLockKVKey(repository *Repository) string {
+	return HashForKV(repository.CacheKey() + "merge")
+}
+
+// ReviewRequestKVKey returns the key that marks that reviewers were requested for a pull request
+// in the review requests kv bucket.
+func ReviewRequestKVKey(pullRequestNodeID string) string {
+	return HashForKV(pullRequestNodeID + "review_request")
+}
+
+// RecentlyMergedKVKey returns the key that marks a pull request as merged in the recently merged kv bucket.
+func RecentlyMergedKVKey(repository *Repository, number int64) string {
+	return HashForKV(repository.CacheKey() + "#" + strconv.FormatInt(number, 10) + "merged")
+}
+
+// ImmediateMergeRetryKVKey returns the key that marks that a commit of a pull request with an immediate merge label
+// was already retried after a delay, it is stored in the check runs kv bucket.
+func ImmediateMergeRetryKVKey(pullRequestNodeID, sha string) string {
+	return HashForKV(pullRequestNodeID + sha + "immediate")
+}
+
+// RecheckKVKey returns the key the amount of rechecks of pending checks for a commit of a pull request is stored with
+// in the check runs kv bucket.
+func RecheckKVKey(pullRequestNodeID, sha string) string {
+	return HashForKV(pullRequestNodeID + sha + "recheck")
+}
+
+// LabelCheckKVKey returns the key that marks that the configured labels of a repository were checked
+// in the configs kv bucket.
+func LabelCheckKVKey(repository *Repository) string {
+	return HashForKV(repository.CacheKey() + "label_check")
+}
+
+// RecentRepositoryKVKey returns the key a repository the worker saw recently is stored with in the repositories
+// kv bucket.
+func RecentRepositoryKVKey(installationID int64, repository *Repository) string {
+	return HashForKV(strconv.FormatInt(installationID, 10) + ":" + repository.CacheKey() + "seen")
+}
+
+// CircuitBreakerKVKey returns the key the circuit breaker state of a repository is stored with in the circuit
+// breaker kv bucket.
+func CircuitBreakerKVKey(repository *Repository) string {
+	return HashForKV(repository.CacheKey() + "circuit")
+}
+
+// MutationThrottleKVKey returns the key the token bucket for the mutations of an installation is stored with in the
+// mutation throttle kv bucket.
+func MutationThrottleKVKey(installationID int64) string {
+	return HashForKV(strconv.FormatInt(installationID, 10) + "mutations")
+}
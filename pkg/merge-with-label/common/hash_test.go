@@ -0,0 +1,16 @@
+package common
+
+import "testing"
+
+func TestAccessTokenKVKey(t *testing.T) {
+	repository := &Repository{NodeID: "R_1", FullName: "org/repo-a"}
+	if AccessTokenKVKey(1, repository) != AccessTokenKVKey(1, &Repository{NodeID: "R_1", FullName: "org/repo-a"}) {
+		t.Error("AccessTokenKVKey() is not stable")
+	}
+	if AccessTokenKVKey(1, repository) == AccessTokenKVKey(2, repository) {
+		t.Error("AccessTokenKVKey() is the same for different installations")
+	}
+	if AccessTokenKVKey(1, repository) == AccessTokenKVKey(1, &Repository{NodeID: "R_2", FullName: "org/repo-b"}) {
+		t.Error("AccessTokenKVKey() is the same for different repositories")
+	}
+}
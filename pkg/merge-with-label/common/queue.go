@@ -12,13 +12,35 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// publishAckTimeout is the time QueueMessage waits for the stream to acknowledge a message.
+const publishAckTimeout = 5 * time.Second
+
+// QueueUnavailableError is returned by QueueMessage if the message could not be queued because the stream or the
+// rate limit kv bucket is not available or the stream rejected the message, the message can be queued again later.
+type QueueUnavailableError struct {
+	Err error
+}
+
+func (e *QueueUnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QueueUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// QueueMessage publishes the message to the subject.
+// Messages with the same msgID are rate limited by interval, the message is delayed and deduplicated by the stream if
+// the same msgID was queued in the interval. Otherwise the message is deduplicated by deliveryID (if set), so a
+// redelivered webhook does not queue the same message again.
 func QueueMessage(
 	logger *zerolog.Logger,
 	js nats.JetStreamContext,
 	kv nats.KeyValue,
 	interval time.Duration,
 	subject,
-	msgID string,
+	msgID,
+	deliveryID string,
 	msg any,
 ) error {
 	const bufSize = 8 // 64 bit
@@ -27,7 +49,7 @@ func QueueMessage(
 	msgIDHash := hex.EncodeToString(h[:])
 	entry, err := kv.Get(msgIDHash)
 	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
-		return errors.Wrap(err, "unable to get rate limit from kv bucket")
+		return errors.Wrap(&QueueUnavailableError{Err: err}, "unable to get rate limit from kv bucket")
 	}
 	if errors.Is(err, nats.ErrKeyNotFound) {
 		entry = nil
@@ -45,20 +67,25 @@ func QueueMessage(
 		// and add a header to delay the message until the interval was hit
 		header.Set(nats.MsgIdHdr, msgIDHash)
 		header.Set(DelayUntilHeader, time.Now().Add(diff).Format(time.RFC3339))
+	} else if deliveryID != "" {
+		// one delivery can queue multiple messages, so the msg id is part of the hash
+		//nolint:gosec // allow weak cryptographic, md5 is just used for creating a unique message id
+		h := md5.Sum([]byte(deliveryID + "." + msgID))
+		header.Set(nats.MsgIdHdr, hex.EncodeToString(h[:]))
 	}
 	buf, err := json.Marshal(msg)
 	if err != nil {
 		return errors.Wrap(err, "unable to encode message")
 	}
 
-	_, err = js.PublishMsgAsync(&nats.Msg{
+	// wait for the ack, a message the stream rejected must not be reported as queued
+	_, err = js.PublishMsg(&nats.Msg{
 		Subject: subject,
 		Header:  header,
 		Data:    buf,
-	})
-
+	}, nats.AckWait(publishAckTimeout))
 	if err != nil {
-		return errors.Wrap(err, "unable to publish message to queue")
+		return errors.Wrap(&QueueUnavailableError{Err: err}, "unable to publish message to queue")
 	}
 	logger.
 		Debug().
@@ -68,7 +95,7 @@ func QueueMessage(
 	binary.LittleEndian.PutUint64(b, uint64(time.Now().UTC().Unix()))
 	_, err = kv.Put(msgIDHash, b)
 	if err != nil {
-		return errors.Wrap(err, "unable to store last message time in kv bucket")
+		return errors.Wrap(&QueueUnavailableError{Err: err}, "unable to store last message time in kv bucket")
 	}
 	return nil
 }
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// errCodeStreamStoreFailed is the error code nats-server responds with if a message exceeds the limits of a stream.
+const errCodeStreamStoreFailed nats.ErrorCode = 10077
+
+// fakeStream rejects messages once it holds maxMsgs messages, like a stream with the DiscardNew policy.
+// Messages with a msg id that was already published are dropped, like in the duplicate window of a stream.
+type fakeStream struct {
+	nats.JetStreamContext
+	maxMsgs  int
+	messages []*nats.Msg
+}
+
+func (js *fakeStream) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	if id := m.Header.Get(nats.MsgIdHdr); id != "" {
+		for _, published := range js.messages {
+			if published.Header.Get(nats.MsgIdHdr) == id {
+				return &nats.PubAck{Stream: "stream", Duplicate: true}, nil
+			}
+		}
+	}
+	if len(js.messages) >= js.maxMsgs {
+		return nil, &nats.APIError{Code: 503, ErrorCode: errCodeStreamStoreFailed, Description: "maximum messages exceeded"}
+	}
+	js.messages = append(js.messages, m)
+	return &nats.PubAck{Stream: "stream", Sequence: uint64(len(js.messages))}, nil
+}
+
+type fakeRateLimitKV struct {
+	nats.KeyValue
+	entries map[string][]byte
+}
+
+func (kv *fakeRateLimitKV) Get(key string) (nats.KeyValueEntry, error) {
+	if _, ok := kv.entries[key]; !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return nil, nil
+}
+
+func (kv *fakeRateLimitKV) Put(key string, value []byte) (uint64, error) {
+	kv.entries[key] = value
+	return uint64(len(kv.entries)), nil
+}
+
+func TestQueueMessage_FullStream(t *testing.T) {
+	logger := zerolog.Nop()
+	js := &fakeStream{maxMsgs: 1}
+	kv := &fakeRateLimitKV{entries: make(map[string][]byte)}
+
+	if err := QueueMessage(&logger, js, kv, time.Second, "push.1", "push.a", "", &QueuePushMessage{}); err != nil {
+		t.Fatalf("QueueMessage() error = %v", err)
+	}
+	if len(kv.entries) != 1 {
+		t.Fatalf("rate limit was not stored for the queued message")
+	}
+
+	err := QueueMessage(&logger, js, kv, time.Second, "push.2", "push.b", "", &QueuePushMessage{})
+	var unavailableErr *QueueUnavailableError
+	if !errors.As(err, &unavailableErr) {
+		t.Fatalf("QueueMessage() error = %v, want a QueueUnavailableError", err)
+	}
+	var apiErr *nats.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != errCodeStreamStoreFailed {
+		t.Errorf("QueueMessage() error = %v, want the error of the stream", err)
+	}
+	if len(kv.entries) != 1 {
+		t.Errorf("rate limit was stored for a rejected message")
+	}
+}
+
+func TestQueueMessage_Redelivery(t *testing.T) {
+	logger := zerolog.Nop()
+	js := &fakeStream{maxMsgs: 10}
+	for i := 0; i < 2; i++ {
+		// a fresh kv, the redelivery arrives after the rate limit interval
+		kv := &fakeRateLimitKV{entries: make(map[string][]byte)}
+		if err := QueueMessage(&logger, js, kv, time.Second, "push.1", "push.a", "delivery-1", &QueuePushMessage{}); err != nil {
+			t.Fatalf("QueueMessage() error = %v", err)
+		}
+	}
+	if len(js.messages) != 1 {
+		t.Errorf("got %d messages, want 1", len(js.messages))
+	}
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// maxTokenBucketAttempts is how often Take tries to store the bucket if other workers changed it in the meantime.
+// Every conflict means another worker changed the bucket in the meantime, the limit only guards against a bucket
+// that is contended without end.
+const maxTokenBucketAttempts = 32
+
+// TokenBucket limits actions with a token bucket per key that is stored in a kv bucket, so it is shared by all
+// workers. Every bucket holds up to Size tokens and gets a new token every RefillInterval.
+// The ttl of the kv bucket must not be less than the time to refill an empty bucket, expired buckets are full.
+type TokenBucket struct {
+	KV             nats.KeyValue
+	Size           int
+	RefillInterval time.Duration
+	// Now returns the current time, defaults to time.Now.
+	Now func() time.Time
+}
+
+type tokenBucketState struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+func (b *TokenBucket) timeNow() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+// Take takes a token from the bucket of key. If the bucket is empty nothing is taken and the time until the next
+// token is available is returned.
+func (b *TokenBucket) Take(key string) (wait time.Duration, err error) {
+	for attempt := 0; attempt < maxTokenBucketAttempts; attempt++ {
+		now := b.timeNow()
+		state, revision, err := b.get(key, now)
+		if err != nil {
+			return 0, err
+		}
+		if state.Tokens < 1 {
+			return time.Duration((1 - state.Tokens) * float64(b.RefillInterval)), nil
+		}
+		state.Tokens--
+		state.Updated = now
+
+		buf, err := json.Marshal(state)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to encode token bucket")
+		}
+		if revision == 0 {
+			_, err = b.KV.Create(key, buf)
+		} else {
+			_, err = b.KV.Update(key, buf, revision)
+		}
+		if err == nil {
+			return 0, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return 0, errors.Wrap(err, "unable to store token bucket in kv bucket")
+		}
+		// another worker took a token in the meantime
+	}
+	return 0, errors.New("unable to take a token, the bucket was changed too often")
+}
+
+// get returns the refilled bucket of key and the revision it is stored with, the revision is 0 if no bucket is
+// stored.
+func (b *TokenBucket) get(key string, now time.Time) (tokenBucketState, uint64, error) {
+	state := tokenBucketState{Tokens: float64(b.Size), Updated: now}
+	entry, err := b.KV.Get(key)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return state, 0, nil
+		}
+		return state, 0, errors.Wrap(err, "unable to get token bucket from kv bucket")
+	}
+	if err := json.Unmarshal(entry.Value(), &state); err != nil {
+		return state, 0, errors.Wrap(err, "unable to decode token bucket")
+	}
+	if elapsed := now.Sub(state.Updated); elapsed > 0 && b.RefillInterval > 0 {
+		state.Tokens = math.Min(float64(b.Size), state.Tokens+float64(elapsed)/float64(b.RefillInterval))
+		state.Updated = now
+	}
+	return state, entry.Revision(), nil
+}
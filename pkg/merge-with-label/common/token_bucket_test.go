@@ -0,0 +1,203 @@
+package common
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// fakeRevisionKV is an in memory kv bucket that rejects outdated revisions like a jetstream kv bucket.
+type fakeRevisionKV struct {
+	nats.KeyValue
+	mu        sync.Mutex
+	values    map[string][]byte
+	revisions map[string]uint64
+	revision  uint64
+	conflicts int
+	// beforeUpdate is called before every Update and Create, e.g. to let another worker change the value.
+	beforeUpdate func()
+}
+
+func newFakeRevisionKV() *fakeRevisionKV {
+	return &fakeRevisionKV{values: make(map[string][]byte), revisions: make(map[string]uint64)}
+}
+
+type fakeRevisionEntry struct {
+	nats.KeyValueEntry
+	value    []byte
+	revision uint64
+}
+
+func (e *fakeRevisionEntry) Value() []byte    { return e.value }
+func (e *fakeRevisionEntry) Revision() uint64 { return e.revision }
+
+func (kv *fakeRevisionKV) Get(key string) (nats.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.values[key]
+	if !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return &fakeRevisionEntry{value: value, revision: kv.revisions[key]}, nil
+}
+
+func (kv *fakeRevisionKV) Create(key string, value []byte) (uint64, error) {
+	return kv.Update(key, value, 0)
+}
+
+func (kv *fakeRevisionKV) Update(key string, value []byte, last uint64) (uint64, error) {
+	if kv.beforeUpdate != nil {
+		kv.beforeUpdate()
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.revisions[key] != last {
+		kv.conflicts++
+		return 0, nats.ErrKeyExists
+	}
+	kv.revision++
+	kv.values[key] = value
+	kv.revisions[key] = kv.revision
+	return kv.revision, nil
+}
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	bucket := &TokenBucket{
+		KV:             newFakeRevisionKV(),
+		Size:           2,
+		RefillInterval: 10 * time.Second,
+		Now:            func() time.Time { return now },
+	}
+	take := func() time.Duration {
+		t.Helper()
+		wait, err := bucket.Take("installation")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait
+	}
+
+	// a new bucket is full
+	for i := 0; i < 2; i++ {
+		if wait := take(); wait != 0 {
+			t.Fatalf("take %d: wait = %s, want 0", i, wait)
+		}
+	}
+	if wait := take(); wait != 10*time.Second {
+		t.Errorf("wait = %s, want 10s", wait)
+	}
+	// other keys have their own bucket
+	if wait, err := bucket.Take("other"); err != nil || wait != 0 {
+		t.Errorf("other: wait = %s, err = %v", wait, err)
+	}
+
+	// partially refilled
+	now = now.Add(4 * time.Second)
+	if wait := take(); wait != 6*time.Second {
+		t.Errorf("wait = %s, want 6s", wait)
+	}
+	now = now.Add(6 * time.Second)
+	if wait := take(); wait != 0 {
+		t.Errorf("wait = %s, want 0 after the refill", wait)
+	}
+
+	// the bucket does not hold more than Size tokens
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if wait := take(); wait != 0 {
+			t.Fatalf("take %d: wait = %s, want 0", i, wait)
+		}
+	}
+	if wait := take(); wait == 0 {
+		t.Error("took more than Size tokens")
+	}
+}
+
+func TestTokenBucket_Take_Concurrent(t *testing.T) {
+	const (
+		size    = 10
+		workers = 50
+	)
+	kv := newFakeRevisionKV()
+	// give the other workers a chance to read the bucket before it is stored
+	kv.beforeUpdate = func() { time.Sleep(time.Millisecond) }
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	bucket := &TokenBucket{
+		KV:             kv,
+		Size:           size,
+		RefillInterval: time.Minute,
+		Now:            func() time.Time { return now },
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		taken   int
+		waiting int
+	)
+	start := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			wait, err := bucket.Take("installation")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				t.Errorf("Take() error = %v", err)
+			case wait == 0:
+				taken++
+			default:
+				waiting++
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if taken != size {
+		t.Errorf("took %d tokens, want %d", taken, size)
+	}
+	if waiting != workers-size {
+		t.Errorf("%d workers have to wait, want %d", waiting, workers-size)
+	}
+	t.Logf("%d conflicting updates", kv.conflicts)
+}
+
+func TestTokenBucket_Take_Conflict(t *testing.T) {
+	kv := newFakeRevisionKV()
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	bucket := &TokenBucket{
+		KV:             kv,
+		Size:           2,
+		RefillInterval: time.Minute,
+		Now:            func() time.Time { return now },
+	}
+
+	// another worker takes a token between reading and storing the bucket
+	var otherWait time.Duration
+	var otherErr error
+	kv.beforeUpdate = func() {
+		kv.beforeUpdate = nil
+		otherWait, otherErr = bucket.Take("installation")
+	}
+	wait, err := bucket.Take("installation")
+	if err != nil || wait != 0 {
+		t.Fatalf("Take() = %s, %v", wait, err)
+	}
+	if otherErr != nil || otherWait != 0 {
+		t.Fatalf("Take() of the other worker = %s, %v", otherWait, otherErr)
+	}
+	if kv.conflicts != 1 {
+		t.Errorf("conflicts = %d, want 1", kv.conflicts)
+	}
+	// both tokens were taken
+	if wait, err := bucket.Take("installation"); err != nil || wait != time.Minute {
+		t.Errorf("Take() = %s, %v, want to wait 1m", wait, err)
+	}
+}
@@ -8,19 +8,78 @@ import (
 	"github.com/pkg/errors"
 )
 
+// LiteralPrefix marks a RegexItem as literal, e.g. `= bob` only matches `bob`.
+const LiteralPrefix = "= "
+
+// NegationPrefix marks a RegexItem as negative, e.g. `!nightly` excludes everything that matches `nightly`.
+// Use `\!` to match a literal exclamation mark at the start.
+const NegationPrefix = "!"
+
+// RegexItem matches strings against a pattern.
+// Patterns are case-insensitive, both the comparison with the text and the regex.
+// A single pattern can opt out with the `(?-i)` flag, all patterns of an item with CaseSensitive.
 type RegexItem struct {
 	Text  string
 	Regex *regexp.Regexp
+
+	anchored      bool
+	caseSensitive bool
 }
 
 func (sl *RegexItem) createRegex() (err error) {
-	sl.Regex, err = regexp.Compile(sl.Text)
+	var expr string
+	if literal, ok := sl.Literal(); ok {
+		expr = "^" + regexp.QuoteMeta(literal) + "$"
+	} else if sl.anchored {
+		expr = "^(?:" + sl.pattern() + ")$"
+	} else {
+		expr = sl.pattern()
+	}
+	if !sl.caseSensitive {
+		expr = "(?i)" + expr
+	}
+	sl.Regex, err = regexp.Compile(expr)
 	if err != nil {
 		return errors.Wrapf(err, "`%s' is not a valid regex", sl.Text)
 	}
 	return nil
 }
 
+// Negated reports whether the item is prefixed with the NegationPrefix.
+func (sl *RegexItem) Negated() bool {
+	return strings.HasPrefix(sl.Text, NegationPrefix)
+}
+
+// pattern returns the text without the NegationPrefix.
+func (sl *RegexItem) pattern() string {
+	return strings.TrimPrefix(sl.Text, NegationPrefix)
+}
+
+// Literal returns the text without the LiteralPrefix, ok is false if the item is not literal.
+func (sl *RegexItem) Literal() (literal string, ok bool) {
+	return strings.CutPrefix(sl.pattern(), LiteralPrefix)
+}
+
+// Anchored returns a copy of the item whose regex must match the whole string.
+func (sl *RegexItem) Anchored() (RegexItem, error) {
+	item := *sl
+	item.anchored = true
+	if err := item.createRegex(); err != nil {
+		return RegexItem{}, err
+	}
+	return item, nil
+}
+
+// CaseSensitive returns a copy of the item that matches case-sensitive.
+func (sl *RegexItem) CaseSensitive() (RegexItem, error) {
+	item := *sl
+	item.caseSensitive = true
+	if err := item.createRegex(); err != nil {
+		return RegexItem{}, err
+	}
+	return item, nil
+}
+
 func (sl *RegexItem) MarshalJSON() ([]byte, error) {
 	return json.Marshal(sl.Text)
 }
@@ -32,7 +91,7 @@ func (sl *RegexItem) UnmarshalJSON(data []byte) error {
 	return sl.createRegex()
 }
 
-func (sl *RegexItem) MarshalYAML() (interface{}, error) {
+func (sl RegexItem) MarshalYAML() (interface{}, error) {
 	return sl.Text, nil
 }
 
@@ -43,14 +102,17 @@ func (sl *RegexItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return sl.createRegex()
 }
 
+// Equal reports whether s is matched by the item, for negated items the result is inverted.
 func (sl *RegexItem) Equal(s string) bool {
-	if strings.EqualFold(s, sl.Text) {
-		return true
-	}
-	if sl.Regex.MatchString(s) {
-		return true
+	return sl.matches(s) != sl.Negated()
+}
+
+// matches reports whether s is matched by the item, ignoring the NegationPrefix.
+func (sl *RegexItem) matches(s string) bool {
+	if sl.caseSensitive {
+		return s == sl.pattern() || sl.Regex.MatchString(s)
 	}
-	return false
+	return strings.EqualFold(s, sl.pattern()) || sl.Regex.MatchString(s)
 }
 
 type RegexSlice []RegexItem
@@ -66,20 +128,79 @@ func (sl RegexSlice) Strings() []string {
 	return s
 }
 
+// Anchored returns a copy of the slice with all items anchored, see RegexItem.Anchored.
+func (sl RegexSlice) Anchored() (RegexSlice, error) {
+	return sl.mapItems((*RegexItem).Anchored)
+}
+
+// CaseSensitive returns a copy of the slice with all items case-sensitive, see RegexItem.CaseSensitive.
+func (sl RegexSlice) CaseSensitive() (RegexSlice, error) {
+	return sl.mapItems((*RegexItem).CaseSensitive)
+}
+
+func (sl RegexSlice) mapItems(fn func(*RegexItem) (RegexItem, error)) (RegexSlice, error) {
+	if sl == nil {
+		return nil, nil
+	}
+	result := make(RegexSlice, len(sl))
+	for i := range sl {
+		item, err := fn(&sl[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = item
+	}
+	return result, nil
+}
+
+// ContainsOneOf returns the text of the first positive item that matches one of the items.
+// Items that are matched by a negated item are never matched.
 func (sl RegexSlice) ContainsOneOf(items ...string) string {
 	for _, item := range items {
-		for _, re := range sl {
-			if re.Equal(item) {
-				return re.Text
+		if sl.Excludes(item) {
+			continue
+		}
+		for i := range sl {
+			if !sl[i].Negated() && sl[i].matches(item) {
+				return sl[i].Text
 			}
 		}
 	}
 	return ""
 }
 
-func MustNewRegexItem(text string) (i RegexItem) {
+// Positives returns all items that are not negated.
+func (sl RegexSlice) Positives() RegexSlice {
+	var positives RegexSlice
+	for i := range sl {
+		if !sl[i].Negated() {
+			positives = append(positives, sl[i])
+		}
+	}
+	return positives
+}
+
+// Excludes reports whether s is matched by one of the negated items.
+func (sl RegexSlice) Excludes(s string) bool {
+	for i := range sl {
+		if sl[i].Negated() && sl[i].matches(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewRegexItem(text string) (i RegexItem, err error) {
 	i.Text = text
 	if err := i.createRegex(); err != nil {
+		return RegexItem{}, err
+	}
+	return i, nil
+}
+
+func MustNewRegexItem(text string) RegexItem {
+	i, err := NewRegexItem(text)
+	if err != nil {
 		panic(err)
 	}
 	return i
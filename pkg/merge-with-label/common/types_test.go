@@ -0,0 +1,259 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRegexItem_Equal(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		anchored bool
+		value    string
+		want     bool
+	}{
+		// regex patterns match anywhere in the value
+		{pattern: "test", value: "test", want: true},
+		{pattern: "test", value: "TEST", want: true},
+		{pattern: "test", value: "integration-test-flaky", want: true},
+		{pattern: "bob", value: "bobby-tables", want: true},
+		{pattern: "^bob$", value: "bobby-tables", want: false},
+		{pattern: ".*", value: "anything", want: true},
+		{pattern: "chore:.+", value: "chore: update deps", want: true},
+		{pattern: "chore:.+", value: "feat: chore: x", want: true},
+
+		// anchored patterns must match the whole value
+		{pattern: "test", anchored: true, value: "test", want: true},
+		{pattern: "test", anchored: true, value: "TEST", want: true},
+		{pattern: "test", anchored: true, value: "integration-test-flaky", want: false},
+		{pattern: "bob", anchored: true, value: "bobby-tables", want: false},
+		{pattern: "^bob$", anchored: true, value: "bob", want: true},
+		{pattern: ".*", anchored: true, value: "anything", want: true},
+		{pattern: "chore:.+", anchored: true, value: "chore: update deps", want: true},
+		{pattern: "chore:.+", anchored: true, value: "feat: chore: x", want: false},
+		{pattern: "a|b", anchored: true, value: "ab", want: false},
+		{pattern: "a|b", anchored: true, value: "b", want: true},
+
+		// literal patterns match the value exactly (case-insensitive)
+		{pattern: "= test", value: "test", want: true},
+		{pattern: "= test", value: "Test", want: true},
+		{pattern: "= test", value: "integration-test-flaky", want: false},
+		{pattern: "= bob", value: "bobby-tables", want: false},
+		{pattern: "= a.b", value: "a.b", want: true},
+		{pattern: "= a.b", value: "axb", want: false},
+		{pattern: "= ci (linux)", value: "ci (linux)", want: true},
+		{pattern: "= test", anchored: true, value: "test", want: true},
+		{pattern: "= a.b", anchored: true, value: "axb", want: false},
+
+		// negated patterns
+		{pattern: "!nightly", value: "nightly-job", want: false},
+		{pattern: "!nightly", value: "build", want: true},
+		{pattern: "!nightly", anchored: true, value: "nightly-job", want: true},
+		{pattern: "!nightly", anchored: true, value: "nightly", want: false},
+		{pattern: "!= nightly", value: "nightly-job", want: true},
+		{pattern: `\!nightly`, value: "!nightly", want: true},
+
+		// patterns are case-insensitive, unless they opt out
+		{pattern: "no-merge-.+", value: "NO-MERGE-YET", want: true},
+		{pattern: "(?-i)no-merge-.+", value: "NO-MERGE-YET", want: false},
+		{pattern: "(?-i)no-merge-.+", value: "no-merge-yet", want: true},
+		{pattern: "(?-i)no-merge-.+", anchored: true, value: "NO-MERGE-YET", want: false},
+		{pattern: "no-merge-.+", anchored: true, value: "NO-MERGE-YET", want: true},
+
+		// only `= ` is the literal prefix
+		{pattern: "=test", value: "x=test", want: true},
+	}
+	for _, tt := range tests {
+		item := MustNewRegexItem(tt.pattern)
+		if tt.anchored {
+			var err error
+			item, err = item.Anchored()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := item.Equal(tt.value); got != tt.want {
+			t.Errorf("%q (anchored=%v).Equal(%q) = %v, want %v", tt.pattern, tt.anchored, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRegexSlice_Unmarshal(t *testing.T) {
+	var fromYAML struct {
+		Items RegexSlice `yaml:"items"`
+	}
+	if err := yaml.Unmarshal([]byte(`items: ["= bob", "test"]`), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := json.Marshal(fromYAML.Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != `["= bob","test"]` {
+		t.Errorf("json = %s", buf)
+	}
+	var fromJSON RegexSlice
+	if err := json.Unmarshal(buf, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := yaml.Marshal(fromJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "- = bob\n- test\n" {
+		t.Errorf("yaml = %q", out)
+	}
+
+	for _, sl := range []RegexSlice{fromYAML.Items, fromJSON} {
+		if got := sl.ContainsOneOf("bobby-tables"); got != "" {
+			t.Errorf("ContainsOneOf(bobby-tables) = %q, want none", got)
+		}
+		if got := sl.ContainsOneOf("bob"); got != "= bob" {
+			t.Errorf("ContainsOneOf(bob) = %q, want `= bob'", got)
+		}
+		if got := sl.ContainsOneOf("integration-test-flaky"); got != "test" {
+			t.Errorf("ContainsOneOf(integration-test-flaky) = %q, want test", got)
+		}
+	}
+}
+
+func TestRegexSlice_Anchored(t *testing.T) {
+	sl := RegexSlice{MustNewRegexItem("test"), MustNewRegexItem("= bob")}
+	anchored, err := sl.Anchored()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := anchored.ContainsOneOf("integration-test-flaky"); got != "" {
+		t.Errorf("anchored ContainsOneOf() = %q, want none", got)
+	}
+	if got := sl.ContainsOneOf("integration-test-flaky"); got != "test" {
+		t.Errorf("original slice was modified, ContainsOneOf() = %q", got)
+	}
+	if got := anchored.Strings(); got[0] != "test" || got[1] != "= bob" {
+		t.Errorf("Strings() = %v, the text should not change", got)
+	}
+	if nilSlice, err := RegexSlice(nil).Anchored(); err != nil || nilSlice != nil {
+		t.Errorf("Anchored() of nil = %v, %v", nilSlice, err)
+	}
+}
+
+func TestRegexSlice_NegativePatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		value    string
+		want     string
+	}{
+		// without negatives nothing changes
+		{patterns: []string{".*"}, value: "nightly", want: ".*"},
+		{patterns: []string{"test", "lint"}, value: "lint", want: "lint"},
+
+		// negatives exclude values that a positive pattern matches
+		{patterns: []string{".*", "!nightly"}, value: "build", want: ".*"},
+		{patterns: []string{".*", "!nightly"}, value: "nightly", want: ""},
+		{patterns: []string{".*", "!nightly"}, value: "ci/nightly-job", want: ""},
+		{patterns: []string{"!nightly", ".*"}, value: "nightly", want: ""},
+		{patterns: []string{"ci/.*", "!= ci/nightly"}, value: "ci/nightly-job", want: "ci/.*"},
+		{patterns: []string{"ci/.*", "!= ci/nightly"}, value: "CI/Nightly", want: ""},
+		{patterns: []string{"ci/.*", "!^ci/(nightly|weekly)$"}, value: "ci/weekly", want: ""},
+
+		// only negatives never match
+		{patterns: []string{"!nightly"}, value: "build", want: ""},
+		{patterns: []string{"!nightly"}, value: "nightly", want: ""},
+
+		// escaped exclamation marks are matched literally
+		{patterns: []string{`\!important`}, value: "!important", want: `\!important`},
+		{patterns: []string{`\!important`}, value: "important", want: ""},
+		{patterns: []string{".*", `\!important`}, value: "!important", want: ".*"},
+	}
+	for _, tt := range tests {
+		var sl RegexSlice
+		for _, p := range tt.patterns {
+			sl = append(sl, MustNewRegexItem(p))
+		}
+		if got := sl.ContainsOneOf(tt.value); got != tt.want {
+			t.Errorf("%v.ContainsOneOf(%q) = %q, want %q", tt.patterns, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRegexSlice_NegativePatterns_Rendering(t *testing.T) {
+	var sl RegexSlice
+	if err := yaml.Unmarshal([]byte(`[".*", "!nightly", '\!important']`), &sl); err != nil {
+		t.Fatal(err)
+	}
+	if got := sl.String(); got != `.*, !nightly, \!important` {
+		t.Errorf("String() = %q", got)
+	}
+	if got := sl.Positives().Strings(); len(got) != 2 || got[0] != ".*" || got[1] != `\!important` {
+		t.Errorf("Positives() = %v", got)
+	}
+	buf, err := json.Marshal(sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON RegexSlice
+	if err := json.Unmarshal(buf, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !fromJSON[1].Negated() || fromJSON[2].Negated() {
+		t.Errorf("negation was not restored from json: %s", buf)
+	}
+	if !fromJSON.Excludes("nightly") {
+		t.Error("Excludes(nightly) = false, want true")
+	}
+}
+
+func TestRegexItem_Equal_Negated(t *testing.T) {
+	item := MustNewRegexItem("!^main$")
+	if item.Equal("main") {
+		t.Error(`"!^main$".Equal("main") = true, want false`)
+	}
+	if !item.Equal("feature") {
+		t.Error(`"!^main$".Equal("feature") = false, want true`)
+	}
+}
+
+func TestRegexItem_CaseSensitive(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{pattern: "no-merge", value: "no-merge", want: true},
+		{pattern: "no-merge", value: "NO-MERGE", want: false},
+		{pattern: "no-merge-.+", value: "NO-MERGE-YET", want: false},
+		{pattern: "no-merge-.+", value: "no-merge-yet", want: true},
+		{pattern: "(?i)no-merge-.+", value: "NO-MERGE-YET", want: true},
+		{pattern: "= bob", value: "Bob", want: false},
+		{pattern: "= bob", value: "bob", want: true},
+		{pattern: "!nightly", value: "NIGHTLY", want: true},
+	}
+	for _, tt := range tests {
+		item := MustNewRegexItem(tt.pattern)
+		caseSensitive, err := item.CaseSensitive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := caseSensitive.Equal(tt.value); got != tt.want {
+			t.Errorf("%q (case-sensitive).Equal(%q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+
+	// the options can be combined in any order
+	item := MustNewRegexItem("no-merge")
+	anchored, err := item.Anchored()
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, err := anchored.CaseSensitive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if both.Equal("NO-MERGE") || both.Equal("no-merge-yet") || !both.Equal("no-merge") {
+		t.Errorf("anchored and case-sensitive item matches wrong values")
+	}
+}
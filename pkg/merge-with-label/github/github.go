@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -19,9 +21,26 @@ import (
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 )
 
-const maxBodyBytes = 1024 * 1024 * 16
+// DefaultMaxBodyBytes is used if HTTPClientOptions.MaxBodyBytes is not set.
+const DefaultMaxBodyBytes = 1024 * 1024 * 16
 const bearerHeaderName = "Bearer"
 
+// ErrBodyTooLarge is returned when a response body exceeds HTTPClientOptions.MaxBodyBytes.
+var ErrBodyTooLarge = errors.New("body too large")
+
+func copyBody(dst *bytes.Buffer, r io.Reader) error {
+	_, err := io.Copy(dst, r)
+	return err
+}
+
+func readBody(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := copyBody(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 var _ zerolog.LogObjectMarshaler = &ResponseError{}
 
 type ResponseError struct {
@@ -125,8 +144,8 @@ func doGraphQLRequest(ctx context.Context, client *http.Client, token, query str
 		return nil, errors.Wrap(err, "unable to execute request")
 	}
 	body.Reset()
-	_, err = io.Copy(&body, io.LimitReader(resp.Body, maxBodyBytes))
-	if err != nil {
+	if err := copyBody(&body, resp.Body); err != nil {
+		_ = resp.Body.Close()
 		return nil, errors.Wrap(err, "unable to copy body")
 	}
 	if err := resp.Body.Close(); err != nil {
@@ -245,8 +264,7 @@ func GetAccessToken(
 
 	body.Reset()
 
-	_, err = io.Copy(&body, io.LimitReader(resp.Body, maxBodyBytes))
-	if err != nil {
+	if err := copyBody(&body, resp.Body); err != nil {
 		return nil, errors.Wrap(err, "unable to copy body")
 	}
 
@@ -282,6 +300,9 @@ func MergePullRequest(
 	mergeStrategy,
 	commitHeadline string,
 ) error {
+	if err := throttleMutation(ctx); err != nil {
+		return err
+	}
 	_, err := doGraphQLRequest(ctx, client, token, `
 mutation MergePullRequest(
   $pullRequestId: ID!,
@@ -311,6 +332,9 @@ mutation MergePullRequest(
 }
 
 func DeleteRef(ctx context.Context, client *http.Client, token, refNodeID string) error {
+	if err := throttleMutation(ctx); err != nil {
+		return err
+	}
 	_, err := doGraphQLRequest(ctx, client, token, `
 mutation DeleteRef($refId: ID!){ 
   deleteRef(input: {
@@ -328,6 +352,111 @@ mutation DeleteRef($refId: ID!){
 	return nil
 }
 
+func ClosePullRequest(ctx context.Context, client *http.Client, token, pullRequestID string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation ClosePullRequest($pullRequestId: ID!){
+  closePullRequest(input: {
+    pullRequestId: $pullRequestId,
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"pullRequestId": pullRequestID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to close pull request")
+	}
+	return nil
+}
+
+// RequestReviews requests reviews for the pull request from the users and teams with the node ids.
+func RequestReviews(ctx context.Context, client *http.Client, token, pullRequestID string, userIDs, teamIDs []string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation RequestReviews($pullRequestId: ID!, $userIds: [ID!], $teamIds: [ID!]){
+  requestReviews(input: {
+    pullRequestId: $pullRequestId,
+    userIds: $userIds,
+    teamIds: $teamIds,
+    union: true,
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"pullRequestId": pullRequestID,
+		"userIds":       userIDs,
+		"teamIds":       teamIDs,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to request reviews")
+	}
+	return nil
+}
+
+// RemoveLabels removes the labels with the node ids from the issue or pull request with the node id.
+func RemoveLabels(ctx context.Context, client *http.Client, token, labelableID string, labelIDs []string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation RemoveLabels($labelableId: ID!, $labelIds: [ID!]!){
+  removeLabelsFromLabelable(input: {
+    labelableId: $labelableId,
+    labelIds: $labelIds,
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"labelableId": labelableID,
+		"labelIds":    labelIDs,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to remove labels")
+	}
+	return nil
+}
+
+// AddLabels adds the labels with the node ids to the issue or pull request with the node id.
+func AddLabels(ctx context.Context, client *http.Client, token, labelableID string, labelIDs []string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation AddLabels($labelableId: ID!, $labelIds: [ID!]!){
+  addLabelsToLabelable(input: {
+    labelableId: $labelableId,
+    labelIds: $labelIds,
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"labelableId": labelableID,
+		"labelIds":    labelIDs,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to add labels")
+	}
+	return nil
+}
+
+// PostIssueComment adds a comment to the issue or pull request with the node id.
+func PostIssueComment(ctx context.Context, client *http.Client, token, subjectID, body string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation AddComment($subjectId: ID!, $body: String!){
+  addComment(input: {
+    subjectId: $subjectId,
+    body: $body,
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"subjectId": subjectID,
+		"body":      body,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to post comment")
+	}
+	return nil
+}
+
 func UpdatePullRequest(
 	ctx context.Context,
 	client *http.Client,
@@ -335,6 +464,9 @@ func UpdatePullRequest(
 	pullRequestID,
 	expectedHeadSha string,
 ) error {
+	if err := throttleMutation(ctx); err != nil {
+		return err
+	}
 	_, err := doGraphQLRequest(ctx, client, token, `
 mutation UpdatePullRequestBranch($pullRequestId: ID!, $expectedHeadOid: GitObjectID!){ 
   updatePullRequestBranch(input: {
@@ -354,54 +486,172 @@ mutation UpdatePullRequestBranch($pullRequestId: ID!, $expectedHeadOid: GitObjec
 	return nil
 }
 
-func GetPullRequestsThatAreOpenAndHaveOneOfTheseLabels(
+// OpenPullRequest is an open pull request, its base branch and its labels.
+type OpenPullRequest struct {
+	Number      int64
+	BaseRefName string
+	Labels      []string
+}
+
+// maxPullRequestsPerPage is the maximum page size GitHub allows.
+const maxPullRequestsPerPage = 100
+
+// GetOpenPullRequests returns the open pull requests of the repository with their labels,
+// if baseRefName is not empty only the ones based on it.
+// Labels are not filtered by GitHub, its search does not support regular expressions,
+// callers match the labels themselves with keep (nil keeps all pull requests).
+// At most maxResults kept pull requests are returned (0 returns all), truncated reports whether there were more.
+func GetOpenPullRequests(
 	ctx context.Context,
 	client *http.Client,
 	token string,
 	repository *common.Repository,
-	labels []string,
-) ([]common.PullRequest, error) {
+	baseRefName string,
+	maxResults int,
+	keep func(pr *OpenPullRequest) bool,
+) (pullRequests []OpenPullRequest, truncated bool, err error) {
 	var after string
-	var pullRequests []common.PullRequest
 	for {
+		first := maxPullRequestsPerPage
+		// with keep it is unknown how many pull requests of a page are kept
+		if remaining := maxResults - len(pullRequests); keep == nil && maxResults > 0 && remaining < first {
+			first = remaining
+		}
 		var response struct {
-			Search struct {
-				Nodes []struct {
-					Number int64 `json:"number"`
-				} `json:"nodes"`
-				PageInfo struct {
-					EndCursor   string `json:"endCursor"`
-					HasNextPage bool   `json:"hasNextPage"`
-				} `json:"pageInfo"`
-			} `json:"search"`
+			Repository struct {
+				PullRequests struct {
+					Nodes []struct {
+						Number      int64  `json:"number"`
+						BaseRefName string `json:"baseRefName"`
+						Labels      struct {
+							Nodes []struct {
+								Name string `json:"name"`
+							} `json:"nodes"`
+						} `json:"labels"`
+					} `json:"nodes"`
+					PageInfo struct {
+						EndCursor   string `json:"endCursor"`
+						HasNextPage bool   `json:"hasNextPage"`
+					} `json:"pageInfo"`
+				} `json:"pullRequests"`
+			} `json:"repository"`
 		}
 
 		query := `
-query GetPullRequests($query: String!, $after: String){
-  search(query: $query, type:ISSUE, first: 100, after: $after){
-    nodes{
-      ... on PullRequest {
-        id
+query GetOpenPullRequests($owner: String!, $name: String!, $first: Int!, $after: String, $baseRefName: String){
+  repository(owner: $owner, name: $name){
+    pullRequests(states: OPEN, baseRefName: $baseRefName, first: $first, after: $after){
+      nodes{
         number
-        state
+        baseRefName
+        labels(first: 100){
+          nodes{
+            name
+          }
+        }
+      }
+      pageInfo{
+        endCursor
+        hasNextPage
       }
     }
-    pageInfo{
-      endCursor
-      hasNextPage
+  }
+}`
+
+		buf, err := doGraphQLRequest(ctx, client, token, query, struct {
+			After       string `json:"after,omitempty"`
+			BaseRefName string `json:"baseRefName,omitempty"`
+			First       int    `json:"first"`
+			Owner       string `json:"owner"`
+			Name        string `json:"name"`
+		}{
+			After:       after,
+			BaseRefName: baseRefName,
+			First:       first,
+			Owner:       repository.OwnerName,
+			Name:        repository.Name,
+		})
+		if err != nil {
+			return nil, false, errors.Wrap(err, "unable to get pull requests")
+		}
+
+		if err := json.Unmarshal(buf, &response); err != nil {
+			return nil, false, errors.WithStack(&ResponseError{
+				Message:            "unable to decode body",
+				ExpectedStatusCode: http.StatusOK,
+				Body:               string(buf),
+				NextError:          err,
+			})
+		}
+
+		for _, node := range response.Repository.PullRequests.Nodes {
+			pr := OpenPullRequest{Number: node.Number, BaseRefName: node.BaseRefName}
+			for _, label := range node.Labels.Nodes {
+				pr.Labels = append(pr.Labels, label.Name)
+			}
+			if keep != nil && !keep(&pr) {
+				continue
+			}
+			if maxResults > 0 && len(pullRequests) >= maxResults {
+				return pullRequests, true, nil
+			}
+			pullRequests = append(pullRequests, pr)
+		}
+		if !response.Repository.PullRequests.PageInfo.HasNextPage {
+			return pullRequests, false, nil
+		}
+		if maxResults > 0 && len(pullRequests) >= maxResults {
+			return pullRequests, true, nil
+		}
+		after = response.Repository.PullRequests.PageInfo.EndCursor
+	}
+}
+
+// GetRepositoryLabels returns the names of all labels of the repository.
+func GetRepositoryLabels(ctx context.Context, client *http.Client, token string, repository *common.Repository) ([]string, error) {
+	var labels []string
+	var after string
+	for {
+		var response struct {
+			Repository struct {
+				Labels struct {
+					Nodes []struct {
+						Name string `json:"name"`
+					} `json:"nodes"`
+					PageInfo struct {
+						EndCursor   string `json:"endCursor"`
+						HasNextPage bool   `json:"hasNextPage"`
+					} `json:"pageInfo"`
+				} `json:"labels"`
+			} `json:"repository"`
+		}
+
+		query := `
+query GetRepositoryLabels($owner: String!, $name: String!, $after: String){
+  repository(owner: $owner, name: $name){
+    labels(first: 100, after: $after){
+      nodes{
+        name
+      }
+      pageInfo{
+        endCursor
+        hasNextPage
+      }
     }
   }
 }`
 
 		buf, err := doGraphQLRequest(ctx, client, token, query, struct {
 			After string `json:"after,omitempty"`
-			Query string `json:"query"`
+			Owner string `json:"owner"`
+			Name  string `json:"name"`
 		}{
 			After: after,
-			Query: fmt.Sprintf("repo:%s is:pr state:open label:%s", repository.FullName, strings.Join(labels, ",")),
+			Owner: repository.OwnerName,
+			Name:  repository.Name,
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to get pull requests")
+			return nil, errors.Wrap(err, "unable to get labels")
 		}
 
 		if err := json.Unmarshal(buf, &response); err != nil {
@@ -413,35 +663,104 @@ query GetPullRequests($query: String!, $after: String){
 			})
 		}
 
-		for i := range response.Search.Nodes {
-			pullRequests = append(pullRequests, response.Search.Nodes[i])
+		for _, node := range response.Repository.Labels.Nodes {
+			labels = append(labels, node.Name)
 		}
-		if !response.Search.PageInfo.HasNextPage {
-			break
+		if !response.Repository.Labels.PageInfo.HasNextPage {
+			return labels, nil
 		}
-		after = response.Search.PageInfo.EndCursor
+		after = response.Repository.Labels.PageInfo.EndCursor
+	}
+}
+
+// CreateLabel creates a label in the repository with the node id, color is a hex color without the leading #.
+func CreateLabel(ctx context.Context, client *http.Client, token, repositoryID, name, color, description string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation CreateLabel($repositoryId: ID!, $name: String!, $color: String!, $description: String){
+  createLabel(input: {
+    repositoryId: $repositoryId,
+    name: $name,
+    color: $color,
+    description: $description,
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"repositoryId": repositoryID,
+		"name":         name,
+		"color":        color,
+		"description":  description,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create label")
 	}
+	return nil
+}
+
+// CheckState sources.
+const (
+	CheckStateSourceStatus     = "status"
+	CheckStateSourceCheckSuite = "check_suite"
+	CheckStateSourceCheckRun   = "check_run"
+)
+
+// CheckState is the state of a commit status, check suite or check run of the last commit.
+type CheckState struct {
+	Name  string
+	State string
+	// URL is the details page of the check, it is empty if there is none.
+	URL string
+	// Source is one of CheckStateSourceStatus, CheckStateSourceCheckSuite or CheckStateSourceCheckRun.
+	Source string
+	// App is the name of the GitHub App of check suites and check runs, it is empty for statuses.
+	App string
+}
 
-	return pullRequests, nil
+// AutoMerge describes GitHub's auto-merge that was enabled on a pull request, it is nil in PullRequestDetails
+// if auto-merge is not enabled.
+type AutoMerge struct {
+	// EnabledBy is the login of the user that enabled auto-merge.
+	EnabledBy string
+	// MergeMethod is the method auto-merge merges with, e.g. SQUASH.
+	MergeMethod string
 }
 
 type PullRequestDetails struct {
-	AheadBy          int
-	ApprovedBy       []string
-	Author           string
-	BaseRefName      string
-	CheckStates      map[string]string
-	HasConflicts     bool
-	HeadRefID        string
-	HeadRefName      string
-	ID               string
-	IsMergeable      bool
-	MergeStateStatus string
-	Labels           []string
-	LastCommitSha    string
-	LastCommitTime   time.Time
-	State            string
-	Title            string
+	AheadBy            int
+	ApprovedBy         []string
+	Author             string
+	AutoMerge          *AutoMerge
+	BaseRefName        string
+	Body               string
+	CheckStates        map[string]CheckState
+	CommitCount        int
+	HasConflicts       bool
+	HeadRefID          string
+	HeadRefName        string
+	ID                 string
+	IsLastCommitSigned bool
+	IsMergeable        bool
+	MergeStateStatus   string
+	PendingReviewers   []string
+	ReviewDecision     string
+	Labels             []string
+	LabelIDs           map[string]string
+	LastCommitSha      string
+	LastCommitTime     time.Time
+	State              string
+	StatusCheckRollup  string
+	URL                string
+	Title              string
+}
+
+// actorLogin returns the login of an actor the way it is shown in the ui.
+// graphql returns the login of apps without the [bot] suffix.
+func actorLogin(login, typename string) string {
+	if typename == "Bot" {
+		return login + "[bot]"
+	}
+	return login
 }
 
 func getPullRequestBaseName(ctx context.Context, client *http.Client, token string, repo *common.Repository, number int64) (string, error) {
@@ -483,6 +802,8 @@ func getPullRequestBaseName(ctx context.Context, client *http.Client, token stri
 	return response.Data.Repository.PullRequest.BaseRef.Name, nil
 }
 
+// GetPullRequestDetails returns the details of the pull request. The checks of the last commit are only
+// summarized in StatusCheckRollup, CheckStates are filled by GetPullRequestCheckStates.
 func GetPullRequestDetails(
 	ctx context.Context,
 	client *http.Client,
@@ -499,34 +820,28 @@ func GetPullRequestDetails(
 			Repository struct {
 				PullRequest struct {
 					Author struct {
-						Login string `json:"login"`
+						Login    string `json:"login"`
+						Typename string `json:"__typename"`
 					} `json:"author"`
+					AutoMergeRequest *struct {
+						EnabledBy struct {
+							Login string `json:"login"`
+						} `json:"enabledBy"`
+						MergeMethod string `json:"mergeMethod"`
+					} `json:"autoMergeRequest"`
+					Body    string `json:"body"`
 					Commits struct {
-						Nodes []struct {
+						TotalCount int `json:"totalCount"`
+						Nodes      []struct {
 							Commit struct {
-								CheckSuites struct {
-									Nodes []struct {
-										App struct {
-											Name string `json:"name"`
-										} `json:"app"`
-										CheckRuns struct {
-											Nodes []struct {
-												Conclusion string `json:"conclusion"`
-												Name       string `json:"name"`
-												Status     string `json:"status"`
-											} `json:"nodes"`
-										} `json:"checkRuns" graphql:"checkRuns(last:100)"`
-										Conclusion string `json:"conclusion"`
-									} `json:"nodes"`
-								} `json:"checkSuites" graphql:"checkSuites(last:100)"`
 								CommittedDate string `json:"committedDate"`
 								Oid           string `json:"oid"`
-								Status        struct {
-									Contexts []struct {
-										Context string `json:"context"`
-										State   string `json:"state"`
-									} `json:"contexts"`
-								} `json:"status"`
+								Signature     *struct {
+									IsValid bool `json:"isValid"`
+								} `json:"signature"`
+								StatusCheckRollup *struct {
+									State string `json:"state"`
+								} `json:"statusCheckRollup"`
 							} `json:"commit"`
 						} `json:"nodes"`
 					} `json:"commits" graphql:"commits(last:1)"`
@@ -540,17 +855,43 @@ func GetPullRequestDetails(
 					ID     string `json:"id"`
 					Labels struct {
 						Nodes []struct {
+							ID   string `json:"id"`
 							Name string `json:"name"`
 						} `json:"nodes"`
 					} `json:"labels" graphql:"labels(last: 100)"`
 					MergeStateStatus string `json:"mergeStateStatus"`
 					Mergeable        string `json:"mergeable"`
-					State            string `json:"state"`
-					Title            string `json:"title"`
-					Reviews          struct {
+					ReviewRequests   struct {
+						Nodes []struct {
+							RequestedReviewer struct {
+								Typename string `json:"__typename"`
+								// the fragments are only used to build the query, their fields are decoded below
+								User struct {
+									Login string `json:"login"`
+								} `json:"-" graphql:"... on User"`
+								Team struct {
+									Slug         string `json:"slug"`
+									Organization struct {
+										Login string `json:"login"`
+									} `json:"organization"`
+								} `json:"-" graphql:"... on Team"`
+								Login        string `json:"login" graphql:"-"`
+								Slug         string `json:"slug" graphql:"-"`
+								Organization struct {
+									Login string `json:"login"`
+								} `json:"organization" graphql:"-"`
+							} `json:"requestedReviewer"`
+						} `json:"nodes"`
+					} `json:"reviewRequests" graphql:"reviewRequests(first: 100)"`
+					ReviewDecision string `json:"reviewDecision"`
+					State          string `json:"state"`
+					Title          string `json:"title"`
+					URL            string `json:"url"`
+					Reviews        struct {
 						Nodes []struct {
 							Author struct {
-								Login string `json:"login"`
+								Login    string `json:"login"`
+								Typename string `json:"__typename"`
 							} `json:"author"`
 						} `json:"nodes"`
 					} `json:"reviews" graphql:"reviews(states: APPROVED, last: 100)"`
@@ -571,19 +912,7 @@ func GetPullRequestDetails(
 		"branch": baseName,
 	})
 	if err != nil {
-		var graphQLErrors GraphQLErrors
-		if !errors.As(err, &graphQLErrors) {
-			return nil, errors.Wrap(err, "unable to get latest pull request details")
-		}
-
-		ignoredErrorPaths := []string{"repository.pullRequest.commits.nodes.0.commit.checkSuites.nodes"}
-		for _, e := range graphQLErrors {
-			for _, path := range ignoredErrorPaths {
-				if !strings.HasPrefix(e.Path, path) {
-					return nil, errors.Wrap(err, "unable to get latest pull request details")
-				}
-			}
-		}
+		return nil, errors.Wrap(err, "unable to get latest pull request details")
 	}
 
 	if err := json.Unmarshal(buf, &response.Data); err != nil {
@@ -595,90 +924,148 @@ func GetPullRequestDetails(
 		})
 	}
 
+	author := &response.Data.Repository.PullRequest.Author
 	details := &PullRequestDetails{
 		AheadBy:          response.Data.Repository.PullRequest.HeadRef.Compare.AheadBy,
 		ApprovedBy:       make([]string, len(response.Data.Repository.PullRequest.Reviews.Nodes)),
-		Author:           response.Data.Repository.PullRequest.Author.Login,
+		Author:           actorLogin(author.Login, author.Typename),
 		BaseRefName:      baseName,
+		Body:             response.Data.Repository.PullRequest.Body,
+		CommitCount:      response.Data.Repository.PullRequest.Commits.TotalCount,
 		HasConflicts:     response.Data.Repository.PullRequest.Mergeable == "CONFLICTING",
 		HeadRefID:        response.Data.Repository.PullRequest.HeadRef.ID,
 		HeadRefName:      response.Data.Repository.PullRequest.HeadRef.Name,
 		ID:               response.Data.Repository.PullRequest.ID,
 		IsMergeable:      response.Data.Repository.PullRequest.Mergeable == "MERGEABLE",
 		MergeStateStatus: response.Data.Repository.PullRequest.MergeStateStatus,
+		ReviewDecision:   response.Data.Repository.PullRequest.ReviewDecision,
 		Labels:           make([]string, len(response.Data.Repository.PullRequest.Labels.Nodes)),
+		LabelIDs:         make(map[string]string, len(response.Data.Repository.PullRequest.Labels.Nodes)),
 		State:            response.Data.Repository.PullRequest.State,
 		Title:            response.Data.Repository.PullRequest.Title,
+		URL:              response.Data.Repository.PullRequest.URL,
+	}
+
+	if autoMerge := response.Data.Repository.PullRequest.AutoMergeRequest; autoMerge != nil {
+		details.AutoMerge = &AutoMerge{
+			EnabledBy:   autoMerge.EnabledBy.Login,
+			MergeMethod: autoMerge.MergeMethod,
+		}
 	}
 
 	for i := range response.Data.Repository.PullRequest.Reviews.Nodes {
-		details.ApprovedBy[i] = response.Data.Repository.PullRequest.Reviews.Nodes[i].Author.Login
+		author := &response.Data.Repository.PullRequest.Reviews.Nodes[i].Author
+		details.ApprovedBy[i] = actorLogin(author.Login, author.Typename)
+	}
+
+	for i := range response.Data.Repository.PullRequest.ReviewRequests.Nodes {
+		reviewer := &response.Data.Repository.PullRequest.ReviewRequests.Nodes[i].RequestedReviewer
+		switch reviewer.Typename {
+		case "User":
+			details.PendingReviewers = append(details.PendingReviewers, reviewer.Login)
+		case "Team":
+			// same notation as merge.requestReviewersOnMissingApproval
+			details.PendingReviewers = append(details.PendingReviewers, "team:"+reviewer.Organization.Login+"/"+reviewer.Slug)
+		}
 	}
 
 	for i := range response.Data.Repository.PullRequest.Labels.Nodes {
 		details.Labels[i] = response.Data.Repository.PullRequest.Labels.Nodes[i].Name
+		details.LabelIDs[details.Labels[i]] = response.Data.Repository.PullRequest.Labels.Nodes[i].ID
 	}
 
 	if len(response.Data.Repository.PullRequest.Commits.Nodes) != 0 {
 		commit := &response.Data.Repository.PullRequest.Commits.Nodes[0].Commit
 		details.LastCommitSha = commit.Oid
+		details.IsLastCommitSigned = commit.Signature != nil && commit.Signature.IsValid
 		details.LastCommitTime, err = time.Parse(time.RFC3339, commit.CommittedDate)
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to parse date")
 		}
 
-		details.CheckStates = make(map[string]string)
-
-		for _, c := range commit.Status.Contexts {
-			details.CheckStates[c.Context] = c.State
-		}
-
-		for _, node := range commit.CheckSuites.Nodes {
-			if node.App.Name == "" {
-				continue
-			}
-			details.CheckStates[node.App.Name] = node.Conclusion
-			for _, run := range node.CheckRuns.Nodes {
-				if run.Status == "COMPLETED" {
-					details.CheckStates[node.App.Name+"/"+run.Name] = run.Conclusion
-				} else {
-					details.CheckStates[node.App.Name+"/"+run.Name] = "PENDING"
-				}
-			}
+		if commit.StatusCheckRollup != nil {
+			details.StatusCheckRollup = commit.StatusCheckRollup.State
 		}
 	}
 
 	return details, nil
 }
 
-func GetLatestBaseCommitSha(ctx context.Context, client *http.Client, token string, repo *common.Repository) (string, error) {
+// GetPullRequestCheckStates returns the statuses, check suites and check runs of the last commit of the pull
+// request, keyed by their name.
+func GetPullRequestCheckStates(
+	ctx context.Context,
+	client *http.Client,
+	token string,
+	repo *common.Repository,
+	number int64,
+) (map[string]CheckState, error) {
 	var response struct {
 		Data struct {
 			Repository struct {
-				DefaultBranchRef struct {
-					Target struct {
-						Oid string `json:"oid"`
-					} `json:"target"`
-				} `json:"defaultBranchRef"`
+				PullRequest struct {
+					Commits struct {
+						Nodes []struct {
+							Commit struct {
+								CheckSuites struct {
+									Nodes []struct {
+										App struct {
+											Name string `json:"name"`
+										} `json:"app"`
+										CheckRuns struct {
+											Nodes []struct {
+												Conclusion string `json:"conclusion"`
+												DetailsURL string `json:"detailsUrl"`
+												Name       string `json:"name"`
+												Status     string `json:"status"`
+											} `json:"nodes"`
+										} `json:"checkRuns" graphql:"checkRuns(last:100)"`
+										Conclusion string `json:"conclusion"`
+									} `json:"nodes"`
+								} `json:"checkSuites" graphql:"checkSuites(last:100)"`
+								Status struct {
+									Contexts []struct {
+										Context   string `json:"context"`
+										State     string `json:"state"`
+										TargetURL string `json:"targetUrl"`
+									} `json:"contexts"`
+								} `json:"status"`
+							} `json:"commit"`
+						} `json:"nodes"`
+					} `json:"commits" graphql:"commits(last:1)"`
+				} `json:"pullRequest" graphql:"pullRequest(number: $number)"`
 			} `json:"repository" graphql:"repository(owner: $owner, name: $name)"`
-		} `graphql:"query GetLatestBaseCommitSha($owner: String!, $name: String!)"`
+		} `graphql:"query GetPullRequestCheckStates($owner: String!, $name: String!, $number: Int!)"`
 	}
 
 	query, err := gengraphql.Generate(&response, nil)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to build query")
+		return nil, errors.Wrap(err, "unable to build query")
 	}
 
 	buf, err := doGraphQLRequest(ctx, client, token, query, map[string]any{
-		"owner": repo.OwnerName,
-		"name":  repo.Name,
+		"owner":  repo.OwnerName,
+		"name":   repo.Name,
+		"number": number,
 	})
 	if err != nil {
-		return "", errors.Wrap(err, "unable to get latest commit sha for default branch")
+		var graphQLErrors GraphQLErrors
+		if !errors.As(err, &graphQLErrors) {
+			return nil, errors.Wrap(err, "unable to get check states")
+		}
+
+		ignoredErrorPaths := []string{"repository.pullRequest.commits.nodes.0.commit.checkSuites.nodes"}
+		for _, e := range graphQLErrors {
+			for _, path := range ignoredErrorPaths {
+				if !strings.HasPrefix(e.Path, path) {
+					return nil, errors.Wrap(err, "unable to get check states")
+				}
+			}
+		}
 	}
 
 	if err := json.Unmarshal(buf, &response.Data); err != nil {
-		return "", errors.WithStack(&ResponseError{
+		return nil, errors.WithStack(&ResponseError{
 			Message:            "unable to decode body",
 			ExpectedStatusCode: http.StatusOK,
 			Body:               string(buf),
@@ -686,20 +1073,257 @@ func GetLatestBaseCommitSha(ctx context.Context, client *http.Client, token stri
 		})
 	}
 
-	return response.Data.Repository.DefaultBranchRef.Target.Oid, nil
+	checkStates := make(map[string]CheckState)
+	if len(response.Data.Repository.PullRequest.Commits.Nodes) == 0 {
+		return checkStates, nil
+	}
+	commit := &response.Data.Repository.PullRequest.Commits.Nodes[0].Commit
+
+	for _, c := range commit.Status.Contexts {
+		checkStates[c.Context] = CheckState{
+			Name:   c.Context,
+			State:  c.State,
+			URL:    c.TargetURL,
+			Source: CheckStateSourceStatus,
+		}
+	}
+
+	for _, node := range commit.CheckSuites.Nodes {
+		if node.App.Name == "" {
+			continue
+		}
+		checkStates[node.App.Name] = CheckState{
+			Name:   node.App.Name,
+			State:  node.Conclusion,
+			Source: CheckStateSourceCheckSuite,
+			App:    node.App.Name,
+		}
+		for _, run := range node.CheckRuns.Nodes {
+			state := run.Conclusion
+			if run.Status != "COMPLETED" {
+				state = "PENDING"
+			}
+			name := node.App.Name + "/" + run.Name
+			checkStates[name] = CheckState{
+				Name:   name,
+				State:  state,
+				URL:    run.DetailsURL,
+				Source: CheckStateSourceCheckRun,
+				App:    node.App.Name,
+			}
+		}
+	}
+	return checkStates, nil
 }
 
-func GetConfig(
-	ctx context.Context,
-	client *http.Client,
+// GetLatestBaseCommitSha returns the latest commit sha of the default branch.
+func GetLatestBaseCommitSha(ctx context.Context, client *http.Client, token string, repo *common.Repository) (string, error) {
+	_, sha, err := GetDefaultBranch(ctx, client, token, repo)
+	return sha, err
+}
+
+// GetDefaultBranch returns the name and the latest commit sha of the default branch.
+func GetDefaultBranch(ctx context.Context, client *http.Client, token string, repo *common.Repository) (name, sha string, err error) {
+	var response struct {
+		Data struct {
+			Repository struct {
+				DefaultBranchRef struct {
+					Name   string `json:"name"`
+					Target struct {
+						Oid string `json:"oid"`
+					} `json:"target"`
+				} `json:"defaultBranchRef"`
+			} `json:"repository" graphql:"repository(owner: $owner, name: $name)"`
+		} `graphql:"query GetLatestBaseCommitSha($owner: String!, $name: String!)"`
+	}
+
+	query, err := gengraphql.Generate(&response, nil)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to build query")
+	}
+
+	buf, err := doGraphQLRequest(ctx, client, token, query, map[string]any{
+		"owner": repo.OwnerName,
+		"name":  repo.Name,
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to get latest commit sha for default branch")
+	}
+
+	if err := json.Unmarshal(buf, &response.Data); err != nil {
+		return "", "", errors.WithStack(&ResponseError{
+			Message:            "unable to decode body",
+			ExpectedStatusCode: http.StatusOK,
+			Body:               string(buf),
+			NextError:          err,
+		})
+	}
+
+	return response.Data.Repository.DefaultBranchRef.Name, response.Data.Repository.DefaultBranchRef.Target.Oid, nil
+}
+
+// GetUserID returns the node id of the user with the login.
+func GetUserID(ctx context.Context, client *http.Client, token, login string) (string, error) {
+	var response struct {
+		Data struct {
+			User *struct {
+				ID string `json:"id"`
+			} `json:"user" graphql:"user(login: $login)"`
+		} `graphql:"query GetUserID($login: String!)"`
+	}
+
+	query, err := gengraphql.Generate(&response, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to build query")
+	}
+
+	buf, err := doGraphQLRequest(ctx, client, token, query, map[string]any{
+		"login": login,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get id of user %s", login)
+	}
+
+	if err := json.Unmarshal(buf, &response.Data); err != nil {
+		return "", errors.WithStack(&ResponseError{
+			Message:            "unable to decode body",
+			ExpectedStatusCode: http.StatusOK,
+			Body:               string(buf),
+			NextError:          err,
+		})
+	}
+	if response.Data.User == nil || response.Data.User.ID == "" {
+		return "", errors.Errorf("user %s not found", login)
+	}
+	return response.Data.User.ID, nil
+}
+
+// GetTeamID returns the node id of the team with the slug in the organization.
+func GetTeamID(ctx context.Context, client *http.Client, token, organization, slug string) (string, error) {
+	var response struct {
+		Data struct {
+			Organization *struct {
+				Team *struct {
+					ID string `json:"id"`
+				} `json:"team" graphql:"team(slug: $slug)"`
+			} `json:"organization" graphql:"organization(login: $organization)"`
+		} `graphql:"query GetTeamID($organization: String!, $slug: String!)"`
+	}
+
+	query, err := gengraphql.Generate(&response, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to build query")
+	}
+
+	buf, err := doGraphQLRequest(ctx, client, token, query, map[string]any{
+		"organization": organization,
+		"slug":         slug,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get id of team %s/%s", organization, slug)
+	}
+
+	if err := json.Unmarshal(buf, &response.Data); err != nil {
+		return "", errors.WithStack(&ResponseError{
+			Message:            "unable to decode body",
+			ExpectedStatusCode: http.StatusOK,
+			Body:               string(buf),
+			NextError:          err,
+		})
+	}
+	if response.Data.Organization == nil || response.Data.Organization.Team == nil ||
+		response.Data.Organization.Team.ID == "" {
+		return "", errors.Errorf("team %s/%s not found", organization, slug)
+	}
+	return response.Data.Organization.Team.ID, nil
+}
+
+// GetLabelID returns the node id of the label with the name in the repository, it is empty if there is no such label.
+func GetLabelID(ctx context.Context, client *http.Client, token string, repository *common.Repository, name string) (string, error) {
+	var response struct {
+		Data struct {
+			Repository struct {
+				Label *struct {
+					ID string `json:"id"`
+				} `json:"label" graphql:"label(name: $label)"`
+			} `json:"repository" graphql:"repository(owner: $owner, name: $name)"`
+		} `graphql:"query GetLabelID($owner: String!, $name: String!, $label: String!)"`
+	}
+
+	query, err := gengraphql.Generate(&response, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to build query")
+	}
+
+	buf, err := doGraphQLRequest(ctx, client, token, query, map[string]any{
+		"owner": repository.OwnerName,
+		"name":  repository.Name,
+		"label": name,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get id of label %s", name)
+	}
+
+	if err := json.Unmarshal(buf, &response.Data); err != nil {
+		return "", errors.WithStack(&ResponseError{
+			Message:            "unable to decode body",
+			ExpectedStatusCode: http.StatusOK,
+			Body:               string(buf),
+			NextError:          err,
+		})
+	}
+	if response.Data.Repository.Label == nil {
+		return "", nil
+	}
+	return response.Data.Repository.Label.ID, nil
+}
+
+const ConfigFilePath = ".github/merge-with-label.yml"
+
+func GetConfig(
+	ctx context.Context,
+	client *http.Client,
 	token string,
 	repository *common.Repository,
 	sha string,
 ) ([]byte, error) {
+	return GetFile(ctx, client, token, repository, sha, ConfigFilePath)
+}
+
+// GetFile returns the contents of the file at path in the repository at the specific sha.
+// It returns nil if the file does not exist.
+func GetFile(
+	ctx context.Context,
+	client *http.Client,
+	token string,
+	repository *common.Repository,
+	sha,
+	path string,
+) ([]byte, error) {
+	return GetURL(
+		ctx,
+		client,
+		token,
+		fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", repository.FullName, sha, strings.TrimPrefix(path, "/")),
+	)
+}
+
+// GetURL returns the contents of the url, the token is only sent to github hosts.
+// It returns nil if the url does not exist.
+func GetURL(
+	ctx context.Context,
+	client *http.Client,
+	token,
+	rawURL string,
+) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse url")
+	}
 	r, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodGet,
-		fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/.github/merge-with-label.yml", repository.FullName, sha),
+		u.String(),
 		http.NoBody,
 	)
 	if err != nil {
@@ -708,7 +1332,9 @@ func GetConfig(
 
 	r.Header.Add("Accept", "application/vnd.github.raw")
 	r.Header.Add("X-GitHub-Api-Version", "2022-11-28")
-	r.Header.Set("Authorization", bearerHeaderName+" "+token)
+	if IsGitHubHost(u.Host) {
+		r.Header.Set("Authorization", bearerHeaderName+" "+token)
+	}
 
 	resp, err := client.Do(r)
 	if err != nil {
@@ -716,7 +1342,7 @@ func GetConfig(
 	}
 	defer resp.Body.Close()
 
-	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	buf, err := readBody(resp.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to copy body")
 	}
@@ -727,7 +1353,7 @@ func GetConfig(
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.WithStack(&ResponseError{
-			Message:            "error when getting config",
+			Message:            "error when getting file",
 			ActualStatusCode:   resp.StatusCode,
 			ExpectedStatusCode: http.StatusOK,
 			Body:               string(buf),
@@ -736,6 +1362,37 @@ func GetConfig(
 	return buf, nil
 }
 
+// IsGitHubHost reports whether host serves GitHub content and may receive the installation token.
+func IsGitHubHost(host string) bool {
+	switch strings.ToLower(host) {
+	case "raw.githubusercontent.com", "api.github.com":
+		return true
+	}
+	return false
+}
+
+type CheckRunAnnotationLevel string
+
+const (
+	CheckRunAnnotationLevelFailure CheckRunAnnotationLevel = "FAILURE"
+	CheckRunAnnotationLevelNotice  CheckRunAnnotationLevel = "NOTICE"
+	CheckRunAnnotationLevelWarning CheckRunAnnotationLevel = "WARNING"
+)
+
+type CheckRunAnnotationRange struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+// CheckRunAnnotation annotates a specific file (and line range) in the check run.
+type CheckRunAnnotation struct {
+	Path            string                  `json:"path"`
+	Location        CheckRunAnnotationRange `json:"location"`
+	AnnotationLevel CheckRunAnnotationLevel `json:"annotationLevel"`
+	Title           string                  `json:"title,omitempty"`
+	Message         string                  `json:"message"`
+}
+
 func CreateCheckRun(
 	ctx context.Context,
 	client *http.Client,
@@ -747,6 +1404,24 @@ func CreateCheckRun(
 	title,
 	summary string,
 ) (string, error) {
+	return CreateCheckRunWithAnnotations(ctx, client, token, repo, sha, status, name, title, summary, nil)
+}
+
+func CreateCheckRunWithAnnotations(
+	ctx context.Context,
+	client *http.Client,
+	token string,
+	repo *common.Repository,
+	sha,
+	status,
+	name,
+	title,
+	summary string,
+	annotations []CheckRunAnnotation,
+) (string, error) {
+	if err := throttleMutation(ctx); err != nil {
+		return "", err
+	}
 	buf, err := doGraphQLRequest(ctx, client, token, `
 mutation CreateCheckRun(
   $repositoryId: ID!,
@@ -754,7 +1429,8 @@ mutation CreateCheckRun(
   $status: RequestableCheckStatusState!,
   $name: String!,
   $title: String!,
-  $summary: String!
+  $summary: String!,
+  $annotations: [CheckAnnotationData!]
 ){
   createCheckRun(input: {
     repositoryId: $repositoryId,
@@ -765,9 +1441,12 @@ mutation CreateCheckRun(
     output: {
       title: $title
       summary: $summary
+      annotations: $annotations
     }
   }) {
-    clientMutationId
+    checkRun {
+      id
+    }
   }
 }
 `, map[string]any{
@@ -777,13 +1456,82 @@ mutation CreateCheckRun(
 		"name":         name,
 		"title":        title,
 		"summary":      summary,
+		"annotations":  annotations,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create check run")
+	}
+
+	var response struct {
+		CreateCheckRun struct {
+			CheckRun struct {
+				ID string `json:"id"`
+			} `json:"checkRun"`
+		} `json:"createCheckRun"`
+	}
+	if err := json.Unmarshal(buf, &response); err != nil {
+		return "", errors.WithStack(&ResponseError{
+			Message:   "unable to decode body",
+			Body:      string(buf),
+			NextError: err,
+		})
+	}
+
+	return response.CreateCheckRun.CheckRun.ID, nil
+}
+
+// CreateCheckRunImmediate creates a check run with the status QUEUED and returns its id.
+// It is used to show the check run before the worker picks up the pull request.
+func CreateCheckRunImmediate(
+	ctx context.Context,
+	client *http.Client,
+	token string,
+	repo *common.Repository,
+	sha,
+	name,
+	title string,
+) (string, error) {
+	if err := throttleMutation(ctx); err != nil {
+		return "", err
+	}
+	buf, err := doGraphQLRequest(ctx, client, token, `
+mutation CreateCheckRunImmediate(
+  $repositoryId: ID!,
+  $sha: GitObjectID!,
+  $name: String!,
+  $title: String!
+){
+  createCheckRun(input: {
+    repositoryId: $repositoryId,
+    headSha: $sha,
+    status: QUEUED,
+    name: $name,
+    output: {
+      title: $title
+      summary: ""
+    }
+  }) {
+    checkRun {
+      id
+    }
+  }
+}
+`, map[string]any{
+		"repositoryId": repo.NodeID,
+		"sha":          sha,
+		"name":         name,
+		"title":        title,
 	})
 	if err != nil {
 		return "", errors.Wrap(err, "unable to create check run")
 	}
 
 	var response struct {
-		ClientMutationID string `json:"clientMutationId"`
+		CreateCheckRun struct {
+			CheckRun struct {
+				ID string `json:"id"`
+			} `json:"checkRun"`
+		} `json:"createCheckRun"`
 	}
 	if err := json.Unmarshal(buf, &response); err != nil {
 		return "", errors.WithStack(&ResponseError{
@@ -793,7 +1541,56 @@ mutation CreateCheckRun(
 		})
 	}
 
-	return response.ClientMutationID, nil
+	return response.CreateCheckRun.CheckRun.ID, nil
+}
+
+// CreateSucceededCheckRun creates a COMPLETED check run with the conclusion SUCCESS.
+// It is used for merge groups, where the check run must not block the merge queue.
+func CreateSucceededCheckRun(
+	ctx context.Context,
+	client *http.Client,
+	token string,
+	repo *common.Repository,
+	sha,
+	name,
+	title string,
+) error {
+	if err := throttleMutation(ctx); err != nil {
+		return err
+	}
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation CreateSucceededCheckRun(
+  $repositoryId: ID!,
+  $sha: GitObjectID!,
+  $name: String!,
+  $title: String!
+){
+  createCheckRun(input: {
+    repositoryId: $repositoryId,
+    headSha: $sha,
+    status: COMPLETED,
+    conclusion: SUCCESS,
+    name: $name,
+    output: {
+      title: $title
+      summary: ""
+    }
+  }) {
+    checkRun {
+      id
+    }
+  }
+}
+`, map[string]any{
+		"repositoryId": repo.NodeID,
+		"sha":          sha,
+		"name":         name,
+		"title":        title,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create check run")
+	}
+	return nil
 }
 
 func UpdateCheckRun(
@@ -807,6 +1604,24 @@ func UpdateCheckRun(
 	title,
 	summary string,
 ) (string, error) {
+	return UpdateCheckRunWithAnnotations(ctx, client, token, repo, checkRunID, status, name, title, summary, nil)
+}
+
+func UpdateCheckRunWithAnnotations(
+	ctx context.Context,
+	client *http.Client,
+	token string,
+	repo *common.Repository,
+	checkRunID,
+	status,
+	name,
+	title,
+	summary string,
+	annotations []CheckRunAnnotation,
+) (string, error) {
+	if err := throttleMutation(ctx); err != nil {
+		return "", err
+	}
 	buf, err := doGraphQLRequest(ctx, client, token, `
 mutation UpdateCheckRun(
   $checkRunId: ID!,
@@ -814,7 +1629,8 @@ mutation UpdateCheckRun(
   $status: RequestableCheckStatusState!,
   $name: String!,
   $title: String!,
-  $summary: String!
+  $summary: String!,
+  $annotations: [CheckAnnotationData!]
 ){
   updateCheckRun(input: {
     checkRunId: $checkRunId,
@@ -825,9 +1641,12 @@ mutation UpdateCheckRun(
     output: {
       title: $title
       summary: $summary
+      annotations: $annotations
     }
   }) {
-    clientMutationId
+    checkRun {
+      id
+    }
   }
 }
 `, map[string]any{
@@ -837,13 +1656,18 @@ mutation UpdateCheckRun(
 		"name":         name,
 		"title":        title,
 		"summary":      summary,
+		"annotations":  annotations,
 	})
 	if err != nil {
 		return "", errors.Wrap(err, "unable to update check run")
 	}
 
 	var response struct {
-		ClientMutationID string `json:"clientMutationId"`
+		UpdateCheckRun struct {
+			CheckRun struct {
+				ID string `json:"id"`
+			} `json:"checkRun"`
+		} `json:"updateCheckRun"`
 	}
 	if err := json.Unmarshal(buf, &response); err != nil {
 		return "", errors.WithStack(&ResponseError{
@@ -853,20 +1677,77 @@ mutation UpdateCheckRun(
 		})
 	}
 
-	return response.ClientMutationID, nil
+	return response.UpdateCheckRun.CheckRun.ID, nil
 }
 
+func CreateIssue(ctx context.Context, client *http.Client, token string, repo *common.Repository, title, body string) error {
+	_, err := doGraphQLRequest(ctx, client, token, `
+mutation CreateIssue($repositoryId: ID!, $title: String!, $body: String!){
+  createIssue(input: {
+    repositoryId: $repositoryId,
+    title: $title,
+    body: $body
+  }) {
+    clientMutationId
+  }
+}
+`, map[string]any{
+		"repositoryId": repo.NodeID,
+		"title":        title,
+		"body":         body,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create issue")
+	}
+	return nil
+}
+
+// GetInstallationIDs returns the ids of all installations of the app.
 func GetInstallationIDs(
 	ctx context.Context,
 	client *http.Client,
 	appID int64,
 	privateKey []byte,
 ) ([]int64, error) {
+	authorizationKey, err := getAuthorizationKey(appID, privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get authorization key")
+	}
+
+	var ids []int64
+	err = getAllPages(ctx, client, authorizationKey, "https://api.github.com/app/installations?per_page=100",
+		func(buf []byte) error {
+			var response []struct {
+				ID int64 `json:"id"`
+			}
+			if err := json.Unmarshal(buf, &response); err != nil {
+				return err
+			}
+			for i := range response {
+				ids = append(ids, response[i].ID)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get installations")
+	}
+	return ids, nil
+}
+
+// GetInstallationAccessToken returns an access token for all repositories of the installation that is only allowed
+// to read their metadata.
+func GetInstallationAccessToken(
+	ctx context.Context,
+	client *http.Client,
+	appID int64,
+	privateKey []byte,
+	installationID int64,
+) (*AccessToken, error) {
 	r, err := http.NewRequestWithContext(
 		ctx,
-		http.MethodGet,
-		"https://api.github.com/app/installations",
-		http.NoBody,
+		http.MethodPost,
+		fmt.Sprintf("https://api.github.com/app/installations/%d/access_tokens", installationID),
+		strings.NewReader(`{"permissions":{"metadata":"read"}}`),
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create request")
@@ -887,35 +1768,132 @@ func GetInstallationIDs(
 	}
 	defer resp.Body.Close()
 
-	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	buf, err := readBody(resp.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to copy body")
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusCreated {
 		return nil, errors.WithStack(&ResponseError{
-			Message:            "error when installations",
+			Message:            "error when getting access token",
 			ActualStatusCode:   resp.StatusCode,
-			ExpectedStatusCode: http.StatusOK,
+			ExpectedStatusCode: http.StatusCreated,
 			Body:               string(buf),
 		})
 	}
-	var response []struct {
-		ID int64 `json:"id"`
-	}
-	if err := json.Unmarshal(buf, &response); err != nil {
+
+	var token AccessToken
+	if err := json.Unmarshal(buf, &token); err != nil {
 		return nil, errors.WithStack(&ResponseError{
-			Message:   "unable to decode body",
-			Body:      string(buf),
-			NextError: err,
+			Message:            "unable to decode body",
+			ActualStatusCode:   resp.StatusCode,
+			ExpectedStatusCode: http.StatusCreated,
+			Body:               string(buf),
+			NextError:          err,
 		})
 	}
+	return &token, nil
+}
+
+// InstallationRepository is a repository the app is installed on.
+type InstallationRepository struct {
+	common.Repository
+	Archived bool
+}
 
-	ids := make([]int64, len(response))
-	for i := range response {
-		ids[i] = response[i].ID
+// GetInstallationRepositories returns all repositories the installation of the token has access to,
+// token must be an installation access token (see GetInstallationAccessToken).
+func GetInstallationRepositories(ctx context.Context, client *http.Client, token string) ([]InstallationRepository, error) {
+	var repositories []InstallationRepository
+	err := getAllPages(ctx, client, bearerHeaderName+" "+token, "https://api.github.com/installation/repositories?per_page=100",
+		func(buf []byte) error {
+			var response struct {
+				Repositories []struct {
+					FullName      string `json:"full_name"`
+					Name          string `json:"name"`
+					NodeID        string `json:"node_id"`
+					Private       bool   `json:"private"`
+					Archived      bool   `json:"archived"`
+					DefaultBranch string `json:"default_branch"`
+					Owner         struct {
+						Login string `json:"login"`
+					} `json:"owner"`
+				} `json:"repositories"`
+			}
+			if err := json.Unmarshal(buf, &response); err != nil {
+				return err
+			}
+			for _, repo := range response.Repositories {
+				repositories = append(repositories, InstallationRepository{
+					Repository: common.Repository{
+						FullName:      repo.FullName,
+						Name:          repo.Name,
+						NodeID:        repo.NodeID,
+						OwnerName:     repo.Owner.Login,
+						Private:       repo.Private,
+						DefaultBranch: repo.DefaultBranch,
+					},
+					Archived: repo.Archived,
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get installation repositories")
 	}
-	return ids, nil
+	return repositories, nil
+}
+
+// getAllPages requests pageURL and every next page of its Link header, fn is called with the body of every page.
+func getAllPages(ctx context.Context, client *http.Client, authorization, pageURL string, fn func(buf []byte) error) error {
+	for pageURL != "" {
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, http.NoBody)
+		if err != nil {
+			return errors.Wrap(err, "unable to create request")
+		}
+		r.Header.Set("Authorization", authorization)
+		r.Header.Add("Accept", "application/vnd.github+json")
+		r.Header.Add("X-GitHub-Api-Version", "2022-11-28")
+
+		resp, err := client.Do(r)
+		if err != nil {
+			return errors.Wrap(err, "unable to execute request")
+		}
+		buf, err := readBody(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrap(err, "unable to copy body")
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.WithStack(&ResponseError{
+				Message:            "error when getting " + r.URL.Path,
+				ActualStatusCode:   resp.StatusCode,
+				ExpectedStatusCode: http.StatusOK,
+				Body:               string(buf),
+			})
+		}
+		if err := fn(buf); err != nil {
+			return errors.WithStack(&ResponseError{
+				Message:   "unable to decode body",
+				Body:      string(buf),
+				NextError: err,
+			})
+		}
+		pageURL = nextPageURL(resp.Header.Get("Link"))
+	}
+	return nil
+}
+
+// linkNextRegex matches the next page in a Link header, e.g. `<https://api.github.com/...&page=2>; rel="next"`.
+var linkNextRegex = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextPageURL returns the url of the next page of the Link header, it is empty on the last page.
+func nextPageURL(link string) string {
+	if m := linkNextRegex.FindStringSubmatch(link); m != nil {
+		return m[1]
+	}
+	return ""
 }
 
 func getAuthorizationKey(appID int64, privateKey []byte) (string, error) {
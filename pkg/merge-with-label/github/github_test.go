@@ -0,0 +1,640 @@
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func Test_maxBodyTransport(t *testing.T) {
+	get := func(body string) ([]byte, error) {
+		transport := &maxBodyTransport{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+			}),
+			MaxBodyBytes: 4,
+		}
+		resp, err := (&http.Client{Transport: transport}).Get("https://api.github.com/")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return readBody(resp.Body)
+	}
+
+	if buf, err := get("1234"); err != nil || string(buf) != "1234" {
+		t.Errorf("readBody() = %q, %v, want %q, nil", buf, err, "1234")
+	}
+
+	if _, err := get("12345"); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("readBody() error = %v, want %v", err, ErrBodyTooLarge)
+	}
+}
+
+func TestGetPullRequestDetails_Signature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{name: "valid signature", signature: `{"isValid":true}`, want: true},
+		{name: "invalid signature", signature: `{"isValid":false}`, want: false},
+		{name: "no signature", signature: `null`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					body, err := io.ReadAll(r.Body)
+					if err != nil {
+						return nil, err
+					}
+					response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+					if strings.Contains(string(body), "GetPullRequestDetails") {
+						response = `{"data":{"repository":{"pullRequest":{"commits":{"totalCount":1,"nodes":[{"commit":{` +
+							`"oid":"abc","committedDate":"2023-01-01T00:00:00Z","signature":` + tt.signature + `}}]}}}}}`
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(response)),
+						Request:    r,
+					}, nil
+				}),
+			}
+
+			details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.LastCommitSha != "abc" {
+				t.Errorf("LastCommitSha = %q, want abc", details.LastCommitSha)
+			}
+			if details.IsLastCommitSigned != tt.want {
+				t.Errorf("IsLastCommitSigned = %v, want %v", details.IsLastCommitSigned, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPullRequestDetails_ApprovedBy(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+			if strings.Contains(string(body), "GetPullRequestDetails") {
+				response = `{"data":{"repository":{"pullRequest":{"reviews":{"nodes":[` +
+					`{"author":{"login":"user","__typename":"User"}},` +
+					`{"author":{"login":"github-actions","__typename":"Bot"}}]}}}}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(response)),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(details.ApprovedBy, ","); got != "user,github-actions[bot]" {
+		t.Errorf("ApprovedBy = %q, want %q", got, "user,github-actions[bot]")
+	}
+}
+
+func TestGetPullRequestDetails_Author(t *testing.T) {
+	tests := []struct {
+		name   string
+		author string
+		want   string
+	}{
+		{name: "user", author: `{"login":"octocat","__typename":"User"}`, want: "octocat"},
+		{name: "bot", author: `{"login":"renovate","__typename":"Bot"}`, want: "renovate[bot]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					body, err := io.ReadAll(r.Body)
+					if err != nil {
+						return nil, err
+					}
+					response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+					if strings.Contains(string(body), "GetPullRequestDetails") {
+						response = `{"data":{"repository":{"pullRequest":{"author":` + tt.author + `}}}}`
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(response)),
+						Request:    r,
+					}, nil
+				}),
+			}
+
+			details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the same notation as ApprovedBy, so patterns like renovate\[bot\] match
+			if details.Author != tt.want {
+				t.Errorf("Author = %q, want %q", details.Author, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPullRequestDetails_AutoMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     *AutoMerge
+	}{
+		{
+			name:     "enabled",
+			response: `{"data":{"repository":{"pullRequest":{"autoMergeRequest":{"enabledBy":{"login":"user"},"mergeMethod":"SQUASH"}}}}}`,
+			want:     &AutoMerge{EnabledBy: "user", MergeMethod: "SQUASH"},
+		},
+		{
+			name:     "disabled",
+			response: `{"data":{"repository":{"pullRequest":{"autoMergeRequest":null}}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			client := &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					body, err := io.ReadAll(r.Body)
+					if err != nil {
+						return nil, err
+					}
+					response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+					if strings.Contains(string(body), "GetPullRequestDetails") {
+						query = string(body)
+						response = tt.response
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(response)),
+						Request:    r,
+					}, nil
+				}),
+			}
+
+			details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(query, "autoMergeRequest") {
+				t.Errorf("query does not fetch autoMergeRequest: %s", query)
+			}
+			if !reflect.DeepEqual(details.AutoMerge, tt.want) {
+				t.Errorf("AutoMerge = %+v, want %+v", details.AutoMerge, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPullRequestDetails_PendingReviewers(t *testing.T) {
+	var query string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+			if strings.Contains(string(body), "GetPullRequestDetails") {
+				query = string(body)
+				response = `{"data":{"repository":{"pullRequest":{"reviewRequests":{"nodes":[` +
+					`{"requestedReviewer":{"__typename":"User","login":"octocat"}},` +
+					`{"requestedReviewer":{"__typename":"Team","slug":"reviewers","organization":{"login":"org"}}},` +
+					`{"requestedReviewer":{"__typename":"Mannequin"}}]}}}}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(response)),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"reviewRequests(first: 100)", "... on User", "... on Team"} {
+		if !strings.Contains(query, s) {
+			t.Errorf("query does not contain %q: %s", s, query)
+		}
+	}
+	if want := []string{"octocat", "team:org/reviewers"}; !reflect.DeepEqual(details.PendingReviewers, want) {
+		t.Errorf("PendingReviewers = %v, want %v", details.PendingReviewers, want)
+	}
+}
+
+func TestGetPullRequestDetails_ReviewDecision(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+			if strings.Contains(string(body), "GetPullRequestDetails") {
+				if !strings.Contains(string(body), "reviewDecision") {
+					t.Errorf("query does not contain reviewDecision: %s", body)
+				}
+				response = `{"data":{"repository":{"pullRequest":{"reviewDecision":"CHANGES_REQUESTED"}}}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(response)),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.ReviewDecision != "CHANGES_REQUESTED" {
+		t.Errorf("ReviewDecision = %q, want CHANGES_REQUESTED", details.ReviewDecision)
+	}
+}
+
+func TestGetPullRequestDetails_StatusCheckRollup(t *testing.T) {
+	var bodies []string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, string(body))
+			response := `{"data":{"repository":{"pullRequest":{"baseRef":{"name":"main"}}}}}`
+			if strings.Contains(string(body), "GetPullRequestDetails") {
+				response = `{"data":{"repository":{"pullRequest":{"commits":{"totalCount":1,"nodes":[{"commit":{` +
+					`"oid":"abc","committedDate":"2023-01-01T00:00:00Z","statusCheckRollup":{"state":"FAILURE"}}}]}}}}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(response)),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	details, err := GetPullRequestDetails(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.StatusCheckRollup != "FAILURE" {
+		t.Errorf("StatusCheckRollup = %q, want FAILURE", details.StatusCheckRollup)
+	}
+	if details.CheckStates != nil {
+		t.Errorf("CheckStates = %+v, want them to be fetched separately", details.CheckStates)
+	}
+	// the base name and the details, the checks are not part of the query
+	if len(bodies) != 2 {
+		t.Fatalf("made %d requests, want 2", len(bodies))
+	}
+	if strings.Contains(bodies[1], "checkSuites") {
+		t.Errorf("query contains the check suites: %s", bodies[1])
+	}
+}
+
+func TestGetPullRequestCheckStates(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.Contains(string(body), "GetPullRequestCheckStates") {
+				t.Errorf("unexpected request %s", body)
+			}
+			response := `{"data":{"repository":{"pullRequest":{"commits":{"nodes":[{"commit":{` +
+				`"status":{"contexts":[{"context":"ci/jenkins","state":"FAILURE","targetUrl":"https://ci.example.com/1"}]},` +
+				`"checkSuites":{"nodes":[{"app":{"name":"GitHub Actions"},"conclusion":"SUCCESS","checkRuns":{"nodes":[` +
+				`{"name":"test","status":"COMPLETED","conclusion":"SUCCESS","detailsUrl":"https://github.com/owner/repo/runs/1"},` +
+				`{"name":"lint","status":"IN_PROGRESS","conclusion":""}]}}]}}}]}}}}}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(response)),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	checkStates, err := GetPullRequestCheckStates(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]CheckState{
+		"ci/jenkins":     {Name: "ci/jenkins", State: "FAILURE", URL: "https://ci.example.com/1", Source: CheckStateSourceStatus},
+		"GitHub Actions": {Name: "GitHub Actions", State: "SUCCESS", Source: CheckStateSourceCheckSuite, App: "GitHub Actions"},
+		"GitHub Actions/test": {
+			Name:   "GitHub Actions/test",
+			State:  "SUCCESS",
+			URL:    "https://github.com/owner/repo/runs/1",
+			Source: CheckStateSourceCheckRun,
+			App:    "GitHub Actions",
+		},
+		"GitHub Actions/lint": {Name: "GitHub Actions/lint", State: "PENDING", Source: CheckStateSourceCheckRun, App: "GitHub Actions"},
+	}
+	if !reflect.DeepEqual(checkStates, want) {
+		t.Errorf("GetPullRequestCheckStates() = %+v, want %+v", checkStates, want)
+	}
+}
+
+func TestGetOpenPullRequests(t *testing.T) {
+	responses := []string{
+		`{"data":{"repository":{"pullRequests":{"nodes":[` +
+			`{"number":1,"labels":{"nodes":[{"name":"merge-when-green"}]}},` +
+			`{"number":2,"labels":{"nodes":[]}}],` +
+			`"pageInfo":{"endCursor":"c1","hasNextPage":true}}}}}`,
+		`{"data":{"repository":{"pullRequests":{"nodes":[` +
+			`{"number":3,"labels":{"nodes":[{"name":"bug"},{"name":"update"}]}}],` +
+			`"pageInfo":{"endCursor":"c2","hasNextPage":false}}}}}`,
+	}
+	var bodies []string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, string(body))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responses[len(bodies)-1])),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	pullRequests, truncated, err := GetOpenPullRequests(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated {
+		t.Error("GetOpenPullRequests() truncated the result without a limit")
+	}
+	want := []OpenPullRequest{
+		{Number: 1, Labels: []string{"merge-when-green"}},
+		{Number: 2},
+		{Number: 3, Labels: []string{"bug", "update"}},
+	}
+	if !reflect.DeepEqual(pullRequests, want) {
+		t.Errorf("GetOpenPullRequests() = %+v, want %+v", pullRequests, want)
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[1], `"after":"c1"`) {
+		t.Errorf("requests = %v, want the second page to start after c1", bodies)
+	}
+	if strings.Contains(bodies[0], "label:") || strings.Contains(bodies[0], `"baseRefName":`) {
+		t.Errorf("request %s must not filter by label or base branch", bodies[0])
+	}
+}
+
+func TestGetOpenPullRequests_MaxResults(t *testing.T) {
+	var bodies []string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, string(body))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(strings.NewReader(`{"data":{"repository":{"pullRequests":{"nodes":[` +
+					`{"number":1},{"number":2}],"pageInfo":{"endCursor":"c","hasNextPage":true}}}}}`)),
+				Request: r,
+			}, nil
+		}),
+	}
+
+	pullRequests, truncated, err := GetOpenPullRequests(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, "", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pullRequests) != 2 || !truncated {
+		t.Errorf("GetOpenPullRequests() = %d pull requests, truncated %v, want 2, true", len(pullRequests), truncated)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("GetOpenPullRequests() made %d requests, want 1", len(bodies))
+	}
+	if !strings.Contains(bodies[0], `"first":2`) {
+		t.Errorf("request %s does not limit the page size to 2", bodies[0])
+	}
+}
+
+func TestGetOpenPullRequests_Keep(t *testing.T) {
+	responses := []string{
+		`{"data":{"repository":{"pullRequests":{"nodes":[` +
+			`{"number":1,"labels":{"nodes":[{"name":"bug"}]}},` +
+			`{"number":2,"labels":{"nodes":[{"name":"merge"}]}}],` +
+			`"pageInfo":{"endCursor":"c1","hasNextPage":true}}}}}`,
+		`{"data":{"repository":{"pullRequests":{"nodes":[` +
+			`{"number":3,"labels":{"nodes":[{"name":"bug"}]}},` +
+			`{"number":4,"labels":{"nodes":[{"name":"merge"}]}},` +
+			`{"number":5,"labels":{"nodes":[{"name":"merge"}]}}],` +
+			`"pageInfo":{"endCursor":"c2","hasNextPage":false}}}}}`,
+	}
+	var bodies []string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, string(body))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responses[len(bodies)-1])),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	keep := func(pr *OpenPullRequest) bool {
+		return len(pr.Labels) == 1 && pr.Labels[0] == "merge"
+	}
+	pullRequests, truncated, err := GetOpenPullRequests(
+		context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"}, "main", 2, keep,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pull requests that are not kept do not count towards maxResults
+	want := []OpenPullRequest{
+		{Number: 2, Labels: []string{"merge"}},
+		{Number: 4, Labels: []string{"merge"}},
+	}
+	if !reflect.DeepEqual(pullRequests, want) || !truncated {
+		t.Errorf("GetOpenPullRequests() = %+v, truncated %v, want %+v, true", pullRequests, truncated, want)
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[0], `"baseRefName":"main"`) || !strings.Contains(bodies[0], `"first":100`) {
+		t.Errorf("requests = %v, want full pages of pull requests based on main", bodies)
+	}
+}
+
+func TestGetDefaultBranch(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(strings.NewReader(
+					`{"data":{"repository":{"defaultBranchRef":{"name":"main","target":{"oid":"abc"}}}}}`)),
+				Request: r,
+			}, nil
+		}),
+	}
+
+	name, sha, err := GetDefaultBranch(context.Background(), client, "token", &common.Repository{OwnerName: "owner", Name: "repo"})
+	if err != nil {
+		t.Fatalf("GetDefaultBranch() error = %v", err)
+	}
+	if name != "main" || sha != "abc" {
+		t.Errorf("GetDefaultBranch() = %q, %q, want %q, %q", name, sha, "main", "abc")
+	}
+}
+
+func newTestPrivateKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// pagedResponses answers the requests with the bodies of pages, every page but the last links to the next one.
+func pagedResponses(t *testing.T, pages []string, requests *[]*http.Request) *http.Client {
+	t.Helper()
+	return &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			*requests = append(*requests, r)
+			page := len(*requests)
+			header := make(http.Header)
+			if page < len(pages) {
+				next := *r.URL
+				q := next.Query()
+				q.Set("page", strconv.Itoa(page+1))
+				next.RawQuery = q.Encode()
+				header.Set("Link", `<`+next.String()+`>; rel="next", <https://api.github.com/last>; rel="last"`)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     header,
+				Body:       io.NopCloser(strings.NewReader(pages[page-1])),
+				Request:    r,
+			}, nil
+		}),
+	}
+}
+
+func TestGetInstallationIDs(t *testing.T) {
+	var requests []*http.Request
+	client := pagedResponses(t, []string{`[{"id":1},{"id":2}]`, `[{"id":3}]`}, &requests)
+
+	ids, err := GetInstallationIDs(context.Background(), client, 42, newTestPrivateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Errorf("GetInstallationIDs() = %v, want [1 2 3]", ids)
+	}
+	if len(requests) != 2 || requests[1].URL.Query().Get("page") != "2" {
+		t.Fatalf("requests = %v, want the second page to be requested", requests)
+	}
+	for _, r := range requests {
+		if r.URL.Path != "/app/installations" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ey") {
+			t.Errorf("request = %s %s, want the installations requested with a jwt", r.URL, r.Header.Get("Authorization"))
+		}
+	}
+}
+
+func TestGetInstallationRepositories(t *testing.T) {
+	var requests []*http.Request
+	client := pagedResponses(t, []string{
+		`{"total_count":2,"repositories":[{"full_name":"owner/repo","name":"repo","node_id":"R_1",` +
+			`"default_branch":"main","owner":{"login":"owner"}}]}`,
+		`{"total_count":2,"repositories":[{"full_name":"owner/old","name":"old","node_id":"R_2","private":true,` +
+			`"archived":true,"default_branch":"master","owner":{"login":"owner"}}]}`,
+	}, &requests)
+
+	repositories, err := GetInstallationRepositories(context.Background(), client, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InstallationRepository{
+		{Repository: common.Repository{FullName: "owner/repo", Name: "repo", NodeID: "R_1", OwnerName: "owner", DefaultBranch: "main"}},
+		{
+			Repository: common.Repository{
+				FullName: "owner/old", Name: "old", NodeID: "R_2", OwnerName: "owner", Private: true, DefaultBranch: "master",
+			},
+			Archived: true,
+		},
+	}
+	if !reflect.DeepEqual(repositories, want) {
+		t.Errorf("GetInstallationRepositories() = %+v, want %+v", repositories, want)
+	}
+	if len(requests) != 2 || requests[0].Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("requests = %v, want two pages requested with the installation token", requests)
+	}
+}
+
+func TestGetInstallationRepositories_Error(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(strings.NewReader(`{"message":"Bad credentials"}`)),
+				Request:    r,
+			}, nil
+		}),
+	}
+	_, err := GetInstallationRepositories(context.Background(), client, "token")
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) || responseError.ActualStatusCode != http.StatusUnauthorized {
+		t.Errorf("GetInstallationRepositories() error = %v, want a ResponseError", err)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{link: "", want: ""},
+		{
+			link: `<https://api.github.com/installation/repositories?page=2>; rel="next", <https://api.github.com/installation/repositories?page=5>; rel="last"`,
+			want: "https://api.github.com/installation/repositories?page=2",
+		},
+		{link: `<https://api.github.com/installation/repositories?page=1>; rel="prev"`, want: ""},
+	}
+	for _, tt := range tests {
+		if got := nextPageURL(tt.link); got != tt.want {
+			t.Errorf("nextPageURL(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
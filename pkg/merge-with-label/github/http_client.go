@@ -0,0 +1,130 @@
+package github
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPClientOptions configures the client returned by NewHTTPClient.
+type HTTPClientOptions struct {
+	// Timeout limits the whole request including retries, zero means no timeout.
+	// It is a safety net, the context of the request should still be used to limit requests.
+	Timeout time.Duration
+	// MaxIdleConns is the number of idle connections that are kept, all of them may be used for the same host.
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// Proxy is used for requests to GitHub, if nil the proxy from the environment is used.
+	Proxy *url.URL
+
+	// MaxBodyBytes limits the size of response bodies, defaults to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+// NewHTTPClient creates a dedicated client for GitHub requests.
+func NewHTTPClient(opts HTTPClientOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.MaxIdleConns
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConns
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	if opts.Proxy != nil {
+		transport.Proxy = gitHubProxy(opts.Proxy)
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if opts.MaxRetries > 0 {
+		roundTripper = &RetryTransport{
+			Transport:  transport,
+			MaxRetries: opts.MaxRetries,
+			BaseDelay:  opts.RetryBaseDelay,
+			MaxDelay:   opts.RetryMaxDelay,
+		}
+	}
+
+	maxBodyBytes := opts.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &maxBodyTransport{
+			Transport:    roundTripper,
+			MaxBodyBytes: maxBodyBytes,
+		},
+	}
+}
+
+// maxBodyTransport fails reading response bodies that exceed MaxBodyBytes with ErrBodyTooLarge.
+type maxBodyTransport struct {
+	Transport    http.RoundTripper
+	MaxBodyBytes int64
+}
+
+func (t *maxBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, limit: t.MaxBodyBytes, remaining: t.MaxBodyBytes}
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errors.Wrapf(ErrBodyTooLarge, "body exceeds %d bytes", b.limit)
+	}
+	// read one byte more than allowed to notice bodies that are too large
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = -1
+		return n, errors.Wrapf(ErrBodyTooLarge, "body exceeds %d bytes", b.limit)
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// ParseProxyURL parses and validates a proxy url, supported are http, https and socks5 urls.
+func ParseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy url")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme `%s', expected http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy url has no host")
+	}
+	return u, nil
+}
+
+// gitHubProxy returns a proxy function that only uses the proxy for GitHub hosts.
+func gitHubProxy(proxy *url.URL) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if !IsGitHubHost(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy, nil
+	}
+}
@@ -0,0 +1,88 @@
+package github
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ http.RoundTripper = &RetryTransport{}
+
+// RetryTransport retries requests that failed with 429, 503 or 504 using an exponential backoff.
+type RetryTransport struct {
+	// Transport is the transport that is used for the requests, defaults to http.DefaultTransport.
+	Transport  http.RoundTripper
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 {
+			r = req.Clone(req.Context())
+			if req.Body != nil && req.Body != http.NoBody {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, errors.Wrap(err, "unable to get body for retry")
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := transport.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		if attempt >= t.MaxRetries || !isRetryableStatusCode(resp.StatusCode) {
+			return resp, nil
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			// body cannot be replayed
+			return resp, nil
+		}
+
+		delay := t.delay(attempt, resp)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, errors.WithStack(req.Context().Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the time to wait before the next attempt,
+// the Retry-After header is used if present.
+func (t *RetryTransport) delay(attempt int, resp *http.Response) time.Duration {
+	delay := t.BaseDelay << attempt
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+	if delay < 0 || (t.MaxDelay > 0 && delay > t.MaxDelay) {
+		delay = t.MaxDelay
+	}
+	return delay
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,124 @@
+package github
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRetryTransport_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRetries   int
+		statusCodes  []int
+		wantStatus   int
+		wantAttempts int
+	}{
+		{
+			name:         "success is not retried",
+			maxRetries:   3,
+			statusCodes:  []int{http.StatusOK},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retry on 429, 503 and 504",
+			maxRetries:   3,
+			statusCodes:  []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusOK},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 4,
+		},
+		{
+			name:         "other errors are not retried",
+			maxRetries:   3,
+			statusCodes:  []int{http.StatusInternalServerError},
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+		{
+			name:         "stop after max retries",
+			maxRetries:   2,
+			statusCodes:  []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			transport := &RetryTransport{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					body, err := io.ReadAll(r.Body)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(body) != "body" {
+						t.Errorf("attempt %d: body = %q, want %q", attempts, body, "body")
+					}
+					statusCode := tt.statusCodes[attempts]
+					attempts++
+					return &http.Response{
+						StatusCode: statusCode,
+						Header:     make(http.Header),
+						Body:       io.NopCloser(strings.NewReader("")),
+						Request:    r,
+					}, nil
+				}),
+				MaxRetries: tt.maxRetries,
+				BaseDelay:  time.Millisecond,
+				MaxDelay:   time.Millisecond * 5,
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "https://api.github.com/graphql", strings.NewReader("body"))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader("body")), nil
+			}
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("RoundTrip() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("RoundTrip() attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_delay(t *testing.T) {
+	transport := &RetryTransport{BaseDelay: time.Second, MaxDelay: time.Second * 10}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{name: "first attempt", attempt: 0, want: time.Second},
+		{name: "exponential backoff", attempt: 2, want: time.Second * 4},
+		{name: "capped by max delay", attempt: 5, want: time.Second * 10},
+		{name: "retry after header", attempt: 0, retryAfter: "3", want: time.Second * 3},
+		{name: "retry after header is capped", attempt: 0, retryAfter: "60", want: time.Second * 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: make(http.Header)}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			if got := transport.delay(tt.attempt, resp); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrThrottled is returned by mutations if the Throttle of the context has no token left, nothing was sent to
+// github.
+type ErrThrottled struct {
+	// Wait is the time until the next token is available.
+	Wait time.Duration
+}
+
+func (e *ErrThrottled) Error() string {
+	return fmt.Sprintf("mutations are throttled, next one is possible in %s", e.Wait)
+}
+
+// Throttle limits the mutations that are sent to github, so bursts do not trigger the secondary rate limits.
+type Throttle interface {
+	// Take takes a token for a mutation, if none is left it returns the time until the next token is available.
+	Take() (wait time.Duration, err error)
+}
+
+type throttleContextKey struct{}
+
+// WithThrottle returns a copy of ctx whose mutations (merges, updates, branch deletions and check runs) take a
+// token of throttle before they are sent.
+func WithThrottle(ctx context.Context, throttle Throttle) context.Context {
+	return context.WithValue(ctx, throttleContextKey{}, throttle)
+}
+
+// throttleMutation takes a token of the Throttle of ctx, mutations are not limited if ctx has no Throttle.
+func throttleMutation(ctx context.Context) error {
+	throttle, ok := ctx.Value(throttleContextKey{}).(Throttle)
+	if !ok {
+		return nil
+	}
+	wait, err := throttle.Take()
+	if err != nil {
+		return errors.Wrap(err, "unable to take a token for the mutation")
+	}
+	if wait > 0 {
+		return errors.WithStack(&ErrThrottled{Wait: wait})
+	}
+	return nil
+}
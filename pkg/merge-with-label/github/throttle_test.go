@@ -0,0 +1,63 @@
+package github
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type throttleFunc func() (time.Duration, error)
+
+func (f throttleFunc) Take() (time.Duration, error) {
+	return f()
+}
+
+func TestThrottleMutation(t *testing.T) {
+	requests := 0
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"data":{}}`)),
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	// without a throttle mutations are not limited
+	if err := DeleteRef(context.Background(), client, "token", "ref"); err != nil {
+		t.Fatalf("DeleteRef() error = %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("made %d requests, want 1", requests)
+	}
+
+	wait := time.Duration(0)
+	ctx := WithThrottle(context.Background(), throttleFunc(func() (time.Duration, error) { return wait, nil }))
+	if err := MergePullRequest(ctx, client, "token", "PR_1", "abc", "SQUASH", ""); err != nil {
+		t.Fatalf("MergePullRequest() error = %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("made %d requests, want 2", requests)
+	}
+
+	// no token left: nothing is sent
+	wait = 3 * time.Second
+	err := MergePullRequest(ctx, client, "token", "PR_1", "abc", "SQUASH", "")
+	var throttledErr *ErrThrottled
+	if !errors.As(err, &throttledErr) {
+		t.Fatalf("MergePullRequest() error = %v, want ErrThrottled", err)
+	}
+	if throttledErr.Wait != wait {
+		t.Errorf("Wait = %s, want %s", throttledErr.Wait, wait)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests while throttled, want 2", requests)
+	}
+}
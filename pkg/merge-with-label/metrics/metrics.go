@@ -0,0 +1,87 @@
+// Package metrics provides counters that are exposed in the prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// KVCacheHits counts the lookups in a kv bucket that returned a usable entry, by bucket.
+	KVCacheHits = newCounterVec("kv_cache_hits_total", "Lookups in a kv bucket that returned a usable entry.", "bucket")
+	// KVCacheMisses counts the lookups in a kv bucket that returned no or an outdated entry, by bucket.
+	KVCacheMisses = newCounterVec("kv_cache_misses_total", "Lookups in a kv bucket that returned no or an outdated entry.", "bucket")
+	// CircuitBreakerDrops counts the messages that were dropped because the circuit of their repository was open,
+	// by repository.
+	CircuitBreakerDrops = newCounterVec("circuit_breaker_dropped_messages_total",
+		"Messages that were dropped because the circuit of their repository was open.", "repository")
+)
+
+var registry = []*CounterVec{KVCacheHits, KVCacheMisses, CircuitBreakerDrops}
+
+// CounterVec is a set of counters that are partitioned by the value of one label.
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: make(map[string]uint64),
+	}
+}
+
+// Inc increments the counter for the label value by one.
+func (c *CounterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue]++
+}
+
+// Get returns the current value of the counter for the label value.
+func (c *CounterVec) Get(labelValue string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *CounterVec) write(sb *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _ = fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	labelValues := make([]string, 0, len(c.values))
+	for labelValue := range c.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	for _, labelValue := range labelValues {
+		_, _ = fmt.Fprintf(sb, "%s{%s=%q} %d\n", c.name, c.label, labelValue, c.values[labelValue])
+	}
+}
+
+// Handler returns a http.Handler that responds with all counters in the prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var sb strings.Builder
+		for _, c := range registry {
+			c.write(&sb)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(sb.String()))
+	})
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	KVCacheHits.Inc("test_bucket_b")
+	KVCacheHits.Inc("test_bucket_a")
+	KVCacheHits.Inc("test_bucket_a")
+	KVCacheMisses.Inc("test_bucket_a")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE kv_cache_hits_total counter\n",
+		"kv_cache_hits_total{bucket=\"test_bucket_a\"} 2\nkv_cache_hits_total{bucket=\"test_bucket_b\"} 1\n",
+		"kv_cache_misses_total{bucket=\"test_bucket_a\"} 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", http.NoBody))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
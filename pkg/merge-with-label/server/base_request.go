@@ -2,6 +2,8 @@ package server
 
 import (
 	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 )
 
 type BaseRequest struct {
@@ -19,6 +21,27 @@ type BaseRequest struct {
 		Private       bool   `json:"private"`
 		DefaultBranch string `json:"default_branch"`
 	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+		Type  string `json:"type"`
+	} `json:"sender"`
+}
+
+// sender returns the user that triggered the event.
+func (req *BaseRequest) sender() common.Sender {
+	return common.Sender{Login: req.Sender.Login, Type: req.Sender.Type}
+}
+
+// repository returns the repository the event belongs to.
+func (req *BaseRequest) repository() common.Repository {
+	return common.Repository{
+		NodeID:        req.Repository.NodeID,
+		FullName:      req.Repository.FullName,
+		Name:          req.Repository.Name,
+		OwnerName:     req.Repository.Owner.Login,
+		Private:       req.Repository.Private,
+		DefaultBranch: req.Repository.DefaultBranch,
+	}
 }
 
 func (req *BaseRequest) IsValid(logger *zerolog.Logger) bool {
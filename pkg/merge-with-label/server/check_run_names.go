@@ -0,0 +1,12 @@
+package server
+
+import (
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// CheckRunNameStore provides the names of the check runs the worker reports its state in.
+type CheckRunNameStore interface {
+	// CheckRunName returns the name of the check run for the repository, an empty string is returned if the name
+	// is not known.
+	CheckRunName(repository *common.Repository) (string, error)
+}
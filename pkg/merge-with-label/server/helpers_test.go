@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// fakeJetStream records the published messages, messages with a msg id that was already published are dropped
+// like in the duplicate window of a stream.
+type fakeJetStream struct {
+	nats.JetStreamContext
+	mu       sync.Mutex
+	messages []*nats.Msg
+	// err is returned by PublishMsg if set.
+	err error
+}
+
+func (js *fakeJetStream) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.err != nil {
+		return nil, js.err
+	}
+	if id := m.Header.Get(nats.MsgIdHdr); id != "" {
+		for _, published := range js.messages {
+			if published.Header.Get(nats.MsgIdHdr) == id {
+				return &nats.PubAck{Duplicate: true}, nil
+			}
+		}
+	}
+	js.messages = append(js.messages, m)
+	return &nats.PubAck{}, nil
+}
+
+func (js *fakeJetStream) Messages() []*nats.Msg {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return append([]*nats.Msg(nil), js.messages...)
+}
+
+// fakeKV is an in memory nats.KeyValue, only the methods used by common.QueueMessage are implemented.
+type fakeKV struct {
+	nats.KeyValue
+	mu      sync.Mutex
+	entries map[string][]byte
+	// err is returned by Get if set.
+	err error
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{entries: make(map[string][]byte)}
+}
+
+type fakeKVEntry struct {
+	nats.KeyValueEntry
+	value []byte
+}
+
+func (e *fakeKVEntry) Value() []byte {
+	return e.value
+}
+
+func (kv *fakeKV) Get(key string) (nats.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.err != nil {
+		return nil, kv.err
+	}
+	value, ok := kv.entries[key]
+	if !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return &fakeKVEntry{value: value}, nil
+}
+
+func (kv *fakeKV) Put(key string, value []byte) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.entries[key] = value
+	return uint64(len(kv.entries)), nil
+}
+
+func (kv *fakeKV) Create(key string, value []byte) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if _, ok := kv.entries[key]; ok {
+		return 0, nats.ErrKeyExists
+	}
+	kv.entries[key] = value
+	return uint64(len(kv.entries)), nil
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
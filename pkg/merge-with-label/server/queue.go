@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// JetStreamInfo is the part of nats.JetStreamContext the QueueHandler uses.
+type JetStreamInfo interface {
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	ConsumerInfo(stream, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+}
+
+// QueueHandler serves the depth of the stream and the lag of its consumers as json.
+// Requests must carry the AdminToken as bearer token, the handler answers 404 Not Found if no AdminToken is set.
+type QueueHandler struct {
+	GetLoggerForContext GetLoggerForContext
+	JetStreamContext    JetStreamInfo
+	StreamName          string
+	ConsumerNames       []string
+	AdminToken          string
+	// now returns the current time, defaults to time.Now.
+	now func() time.Time
+}
+
+// QueueInfo is the response of the QueueHandler.
+type QueueInfo struct {
+	Stream    string         `json:"stream"`
+	Messages  uint64         `json:"messages"`
+	Bytes     uint64         `json:"bytes"`
+	FirstSeq  uint64         `json:"first_seq"`
+	LastSeq   uint64         `json:"last_seq"`
+	Consumers []ConsumerInfo `json:"consumers"`
+	// OldestPendingAge is the age of the oldest message in the stream, messages are removed once acknowledged,
+	// so every message in the stream is pending.
+	OldestPendingAge string `json:"oldest_pending_age,omitempty"`
+}
+
+// ConsumerInfo describes the lag of a consumer.
+type ConsumerInfo struct {
+	Name string `json:"name"`
+	// Missing is set if the consumer does not exist, e.g. because no worker was started yet.
+	Missing        bool   `json:"missing,omitempty"`
+	NumPending     uint64 `json:"num_pending"`
+	NumAckPending  int    `json:"num_ack_pending"`
+	NumRedelivered int    `json:"num_redelivered"`
+	NumWaiting     int    `json:"num_waiting"`
+	AckFloorStream uint64 `json:"ack_floor_stream_seq"`
+	AckFloorLast   string `json:"ack_floor_last_active,omitempty"`
+}
+
+func (h *QueueHandler) timeNow() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+func (h *QueueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.AdminToken == "" {
+		respond(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		respond(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+		respond(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	info, err := h.queueInfo()
+	if err != nil {
+		h.GetLoggerForContext(r.Context()).Error().Err(err).Msg("unable to get queue info")
+		respond(w, http.StatusInternalServerError, "error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+const bearerPrefix = "Bearer "
+
+func (h *QueueHandler) queueInfo() (*QueueInfo, error) {
+	stream, err := h.JetStreamContext.StreamInfo(h.StreamName)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get stream info")
+	}
+	info := &QueueInfo{
+		Stream:    h.StreamName,
+		Messages:  stream.State.Msgs,
+		Bytes:     stream.State.Bytes,
+		FirstSeq:  stream.State.FirstSeq,
+		LastSeq:   stream.State.LastSeq,
+		Consumers: make([]ConsumerInfo, 0, len(h.ConsumerNames)),
+	}
+	if stream.State.Msgs > 0 && !stream.State.FirstTime.IsZero() {
+		info.OldestPendingAge = h.timeNow().Sub(stream.State.FirstTime).Round(time.Second).String()
+	}
+
+	for _, name := range h.ConsumerNames {
+		consumer, err := h.JetStreamContext.ConsumerInfo(h.StreamName, name)
+		if err != nil {
+			if errors.Is(err, nats.ErrConsumerNotFound) {
+				info.Consumers = append(info.Consumers, ConsumerInfo{Name: name, Missing: true})
+				continue
+			}
+			return nil, errors.Wrapf(err, "unable to get consumer info of %s", name)
+		}
+		c := ConsumerInfo{
+			Name:           name,
+			NumPending:     consumer.NumPending,
+			NumAckPending:  consumer.NumAckPending,
+			NumRedelivered: consumer.NumRedelivered,
+			NumWaiting:     consumer.NumWaiting,
+			AckFloorStream: consumer.AckFloor.Stream,
+		}
+		if consumer.AckFloor.Last != nil {
+			c.AckFloorLast = consumer.AckFloor.Last.UTC().Format(time.RFC3339)
+		}
+		info.Consumers = append(info.Consumers, c)
+	}
+	return info, nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+type fakeJetStreamInfo struct {
+	stream    *nats.StreamInfo
+	consumers map[string]*nats.ConsumerInfo
+}
+
+func (f *fakeJetStreamInfo) StreamInfo(string, ...nats.JSOpt) (*nats.StreamInfo, error) {
+	return f.stream, nil
+}
+
+func (f *fakeJetStreamInfo) ConsumerInfo(_, name string, _ ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	if c, ok := f.consumers[name]; ok {
+		return c, nil
+	}
+	return nil, nats.ErrConsumerNotFound
+}
+
+func newTestQueueHandler() *QueueHandler {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	lastAck := now.Add(-time.Minute)
+	logger := zerolog.Nop()
+	return &QueueHandler{
+		GetLoggerForContext: func(context.Context) *zerolog.Logger {
+			return &logger
+		},
+		JetStreamContext: &fakeJetStreamInfo{
+			stream: &nats.StreamInfo{State: nats.StreamState{
+				Msgs:      3,
+				FirstSeq:  10,
+				LastSeq:   12,
+				FirstTime: now.Add(-5 * time.Minute),
+			}},
+			consumers: map[string]*nats.ConsumerInfo{
+				"pull-request-worker": {
+					NumPending:     1,
+					NumAckPending:  2,
+					NumRedelivered: 1,
+					AckFloor:       nats.SequenceInfo{Stream: 9, Last: &lastAck},
+				},
+			},
+		},
+		StreamName:    "mwl_bot_events",
+		ConsumerNames: []string{"pull-request-worker", "push-worker"},
+		AdminToken:    "secret",
+		now:           func() time.Time { return now },
+	}
+}
+
+func TestQueueHandler_ServeHTTP(t *testing.T) {
+	h := newTestQueueHandler()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/queue", http.NoBody)
+	req.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var info QueueInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Messages != 3 || info.OldestPendingAge != "5m0s" {
+		t.Errorf("stream = %d messages, oldest %q, want 3 messages, oldest 5m0s", info.Messages, info.OldestPendingAge)
+	}
+	if len(info.Consumers) != 2 {
+		t.Fatalf("got %d consumers, want 2", len(info.Consumers))
+	}
+	want := ConsumerInfo{
+		Name:           "pull-request-worker",
+		NumPending:     1,
+		NumAckPending:  2,
+		NumRedelivered: 1,
+		AckFloorStream: 9,
+		AckFloorLast:   "2024-01-02T11:59:00Z",
+	}
+	if info.Consumers[0] != want {
+		t.Errorf("consumer = %+v, want %+v", info.Consumers[0], want)
+	}
+	if c := info.Consumers[1]; c.Name != "push-worker" || !c.Missing {
+		t.Errorf("consumer = %+v, want push-worker to be missing", c)
+	}
+}
+
+func TestQueueHandler_ServeHTTP_Unauthorized(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "no token configured", adminToken: "", authorization: "Bearer ", wantStatus: http.StatusNotFound},
+		{name: "missing header", adminToken: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", adminToken: "secret", authorization: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", adminToken: "secret", authorization: "secret", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestQueueHandler()
+			h.AdminToken = tt.adminToken
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/admin/queue", http.NoBody)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
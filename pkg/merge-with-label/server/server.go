@@ -6,55 +6,204 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"golang.org/x/exp/slices"
 
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
 )
 
-const maxBodyBytes = 1024 * 1024 * 16
+const DefaultMaxBodyBytes = 1024 * 1024 * 16
+
+// DefaultPullRequestActions are the pull_request actions that are handled if Handler.PullRequestActions is empty.
+var DefaultPullRequestActions = []string{"created", "opened", "labeled", "reopened", "synchronize", "edited"}
+
+// DefaultGitHubEvents are the events the handler processes, it is the default of the AllowedGitHubEvents setting.
+var DefaultGitHubEvents = []string{
+	"check_run",
+	"check_suite",
+	"label",
+	"merge_group",
+	"pull_request",
+	"pull_request_review",
+	"push",
+	"status",
+	"workflow_run",
+}
+
+// PullRequestReviewActions are the pull_request_review actions that are handled.
+// dismissed and edited are handled as well, so a dismissed approval is noticed before the pull request gets merged.
+var PullRequestReviewActions = []string{"submitted", "dismissed", "edited"}
+
+// KnownPullRequestActions are the actions github sends for the pull_request event.
+var KnownPullRequestActions = []string{
+	"assigned",
+	"auto_merge_disabled",
+	"auto_merge_enabled",
+	"closed",
+	"converted_to_draft",
+	"created", // not sent by github, kept for backwards compatibility
+	"demilestoned",
+	"dequeued",
+	"edited",
+	"enqueued",
+	"labeled",
+	"locked",
+	"milestoned",
+	"opened",
+	"ready_for_review",
+	"reopened",
+	"review_request_removed",
+	"review_requested",
+	"synchronize",
+	"unassigned",
+	"unlabeled",
+	"unlocked",
+}
+
+// ValidatePullRequestActions returns an error if one of the actions is not a known pull_request action.
+func ValidatePullRequestActions(actions []string) error {
+	var unknown []string
+	for _, action := range actions {
+		if slices.Index(KnownPullRequestActions, action) == -1 {
+			unknown = append(unknown, action)
+		}
+	}
+	if len(unknown) > 0 {
+		return errors.Errorf("unknown pull_request action(s) %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
 
 var _ http.Handler = &Handler{}
 
 type GetLoggerForContext func(ctx context.Context) *zerolog.Logger
 
+// RepositoryDeniedHandler is called when an event of a repository is dropped because the repository is not allowed.
+type RepositoryDeniedHandler func(logger *zerolog.Logger, repo string, reason string)
+
+// Reasons passed to the RepositoryDeniedHandler.
+const (
+	RepositoryDeniedPrivate    = "repository is private"
+	RepositoryDeniedNotAllowed = "repository does not match AllowedRepositories"
+)
+
+// DefaultRepositoryDeniedHandler logs the denied repository as a warning.
+func DefaultRepositoryDeniedHandler(logger *zerolog.Logger, repo, reason string) {
+	logger.Warn().Str("repo", repo).Str("reason", reason).Msg("repository is not allowed")
+}
+
 type Handler struct {
 	GetLoggerForContext         GetLoggerForContext
 	AllowedRepositories         common.RegexSlice
 	AllowOnlyPublicRepositories bool
+	// RepositoryDeniedHandler is called for events of repositories that are not allowed,
+	// defaults to DefaultRepositoryDeniedHandler.
+	RepositoryDeniedHandler RepositoryDeniedHandler
+	MaxBodyBytes            int64
+	// AppID is the id of the github app, if set only events that target this app are accepted.
+	AppID int64
+	// WebhookPath is the path the webhooks are received on, defaults to /.
+	WebhookPath string
+	// PullRequestActions are the pull_request actions that are handled, defaults to DefaultPullRequestActions.
+	PullRequestActions []string
+	// AllowedGitHubEvents are the X-GitHub-Event values that are handled, other events are dropped before
+	// their body is read. If empty or `*` is included all events are handled, ping events are always handled.
+	AllowedGitHubEvents []string
+	// HandleMergeGroup queues merge_group events, so the worker reports its check run on the merge group.
+	HandleMergeGroup bool
+	// RedirectURL is the url non POST requests are redirected to,
+	// if empty non POST requests are answered with 405 Method Not Allowed.
+	RedirectURL string
 
 	JetStreamContext   nats.JetStreamContext
 	PushSubject        string
 	StatusSubject      string
 	PullRequestSubject string
+	MaintenanceSubject string
 
 	RateLimitKV       nats.KeyValue
 	RateLimitInterval time.Duration
+
+	// ServerCheckRunToken provides the tokens to create a QUEUED check run right after a pull_request message
+	// was queued, so the pull request shows the check run before the worker picks up the message.
+	// If nil no check run is created by the server.
+	ServerCheckRunToken TokenStore
+	// CheckRunNames provides the name of the QUEUED check run, which must be the name the worker uses for the
+	// repository. No check run is created if the name is not known.
+	CheckRunNames CheckRunNameStore
+	// CheckRunsKV stores the id of the QUEUED check run, so the worker updates the same check run.
+	CheckRunsKV nats.KeyValue
+	HTTPClient  *http.Client
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.RequestURI != "/" && r.RequestURI != "" {
+	if !h.isWebhookPath(r.URL.Path) {
 		h.respond(w, http.StatusNotFound, "not found")
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Redirect(w, r, "https://github.com/Eun/merge-with-label", http.StatusTemporaryRedirect)
+		if h.RedirectURL != "" {
+			http.Redirect(w, r, h.RedirectURL, http.StatusTemporaryRedirect)
+			return
+		}
+		w.Header().Set("Allow", http.MethodPost)
+		h.respond(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	defer r.Body.Close()
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if !h.isTargetApp(r) {
+		h.respond(w, http.StatusBadRequest, "bad request")
+		return
+	}
+
+	if !h.isAllowedEvent(r.Header.Get("X-GitHub-Event")) {
+		h.GetLoggerForContext(r.Context()).Trace().
+			Str("event", r.Header.Get("X-GitHub-Event")).
+			Msg("event is not allowed")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	maxBodyBytes := h.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	// read one extra byte to detect if the body exceeds the limit
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
 	if err != nil {
 		h.GetLoggerForContext(r.Context()).Error().Err(err).Msg("unable to read body")
 		h.respond(w, http.StatusBadRequest, "bad request")
 		return
 	}
+	if int64(len(body)) > maxBodyBytes {
+		// the body is never truncated, but log the announced size to see how far the limit was exceeded
+		h.GetLoggerForContext(r.Context()).Warn().
+			Int64("max_body_bytes", maxBodyBytes).
+			Int64("content_length", r.ContentLength).
+			Str("event", r.Header.Get("X-GitHub-Event")).
+			Msg("request body too large")
+		h.respondWithBody(w, http.StatusRequestEntityTooLarge, struct {
+			Status        string `json:"status"`
+			ContentLength int64  `json:"content_length"`
+			MaxBodyBytes  int64  `json:"max_body_bytes"`
+		}{
+			Status:        "request body too large",
+			ContentLength: r.ContentLength,
+			MaxBodyBytes:  maxBodyBytes,
+		})
+		return
+	}
 
 	githubEvent := r.Header.Get("X-GitHub-Event")
 	githubID := r.Header.Get("X-GitHub-Delivery")
@@ -70,6 +219,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		logger.Debug().Msg("got event")
 	}
 
+	if githubEvent == "ping" {
+		h.handlePing(&logger, body, w)
+		return
+	}
+
 	baseRequest := h.unmarshalAndValidateRequest(&logger, body, w)
 	if baseRequest == nil {
 		return
@@ -79,6 +233,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "check_run":
 		h.handleCheckRun(&logger, githubID, body, w)
 		return
+	case "check_suite":
+		h.handleCheckSuite(&logger, githubID, body, w)
+		return
+	case "label":
+		h.handleLabel(&logger, githubID, body, w)
+		return
+	case "merge_group":
+		h.handleMergeGroup(&logger, githubID, body, w)
+		return
 	case "pull_request":
 		h.handlePullRequest(&logger, githubID, body, w)
 		return
@@ -91,10 +254,71 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "status":
 		h.handleStatus(&logger, githubID, baseRequest, w)
 		return
+	case "workflow_run":
+		h.handleWorkflowRun(&logger, githubID, body, w)
+		return
 	}
 	h.respond(w, http.StatusOK, "ok")
 }
 
+// isTargetApp reports whether the event was meant for the configured app.
+// Events without the X-GitHub-Hook-Installation-Target-ID header (older GHES) are accepted.
+func (h *Handler) isTargetApp(r *http.Request) bool {
+	if h.AppID == 0 {
+		return true
+	}
+	targetIDValue := r.Header.Get("X-GitHub-Hook-Installation-Target-ID")
+	if targetIDValue == "" {
+		return true
+	}
+	targetID, err := strconv.ParseInt(targetIDValue, 10, 64)
+	if err == nil && targetID == h.AppID {
+		return true
+	}
+	h.GetLoggerForContext(r.Context()).Warn().
+		Int64("app_id", h.AppID).
+		Str("target_id", targetIDValue).
+		Str("event", r.Header.Get("X-GitHub-Event")).
+		Msgf("event is meant for app %s, but this is app %d", targetIDValue, h.AppID)
+	return false
+}
+
+func (h *Handler) isRepositoryAllowed(logger *zerolog.Logger, repo string, private bool) bool {
+	reason := ""
+	switch {
+	case h.AllowOnlyPublicRepositories && private:
+		reason = RepositoryDeniedPrivate
+	case h.AllowedRepositories.ContainsOneOf(repo) == "":
+		reason = RepositoryDeniedNotAllowed
+	default:
+		return true
+	}
+	denied := h.RepositoryDeniedHandler
+	if denied == nil {
+		denied = DefaultRepositoryDeniedHandler
+	}
+	denied(logger, repo, reason)
+	return false
+}
+
+func (h *Handler) isAllowedEvent(event string) bool {
+	if len(h.AllowedGitHubEvents) == 0 || event == "ping" {
+		return true
+	}
+	return slices.Index(h.AllowedGitHubEvents, event) != -1 || slices.Index(h.AllowedGitHubEvents, "*") != -1
+}
+
+func (h *Handler) isWebhookPath(path string) bool {
+	webhookPath := h.WebhookPath
+	if webhookPath == "" {
+		webhookPath = "/"
+	}
+	if path == "" {
+		path = "/"
+	}
+	return strings.TrimSuffix(path, "/") == strings.TrimSuffix(webhookPath, "/")
+}
+
 func (h *Handler) unmarshalAndValidateRequest(rootLogger *zerolog.Logger, body []byte, w http.ResponseWriter) *BaseRequest {
 	var req BaseRequest
 	if err := json.Unmarshal(body, &req); err != nil {
@@ -108,31 +332,45 @@ func (h *Handler) unmarshalAndValidateRequest(rootLogger *zerolog.Logger, body [
 		return nil
 	}
 
-	if h.AllowOnlyPublicRepositories && req.Repository.Private {
-		rootLogger.Warn().Str("repo", req.Repository.FullName).Msg("repository is not allowed (it is private)")
+	if !h.isRepositoryAllowed(rootLogger, req.Repository.FullName, req.Repository.Private) {
 		h.respond(w, http.StatusOK, "ok")
 		return nil
 	}
+	return &req
+}
 
-	if h.AllowedRepositories.ContainsOneOf(req.Repository.FullName) == "" {
-		rootLogger.Warn().Str("repo", req.Repository.FullName).Msg("repository is not allowed")
-		h.respond(w, http.StatusOK, "ok")
-		return nil
+func (h *Handler) handlePing(logger *zerolog.Logger, body []byte, w http.ResponseWriter) {
+	var req struct {
+		Zen    string `json:"zen"`
+		HookID int64  `json:"hook_id"`
 	}
-	return &req
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Error().Err(err).Msg("unable to decode request")
+		h.respond(w, http.StatusBadRequest, "bad request")
+		return
+	}
+	logger.Info().Int64("hook_id", req.HookID).Msg("ping received, webhook configured correctly")
+	h.respondWithBody(w, http.StatusOK, struct {
+		Status string `json:"status"`
+		Zen    string `json:"zen"`
+	}{
+		Status: "ok",
+		Zen:    req.Zen,
+	})
+}
+
+// pullRequestReference is a pull request referenced by a check_run or check_suite event.
+type pullRequestReference struct {
+	Number int64 `json:"number"`
 }
 
 func (h *Handler) handleCheckRun(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
 	var req struct {
 		BaseRequest
 		CheckRun struct {
-			PullRequests []struct {
-				Number int64 `json:"number"`
-			} `json:"pull_requests"`
-			CheckSuite struct {
-				PullRequests []struct {
-					Number int64 `json:"number"`
-				} `json:"pull_requests"`
+			PullRequests []pullRequestReference `json:"pull_requests"`
+			CheckSuite   struct {
+				PullRequests []pullRequestReference `json:"pull_requests"`
 			} `json:"check_suite"`
 		} `json:"check_run"`
 	}
@@ -148,45 +386,237 @@ func (h *Handler) handleCheckRun(logger *zerolog.Logger, eventID string, body []
 		return
 	}
 
+	h.queueReferencedPullRequests(
+		logger,
+		eventID,
+		&req.BaseRequest,
+		append(req.CheckRun.PullRequests, req.CheckRun.CheckSuite.PullRequests...),
+		w,
+	)
+}
+
+// handleCheckSuite handles check_suite events, some ci integrations only send those and no check_run events.
+func (h *Handler) handleCheckSuite(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
+	var req struct {
+		BaseRequest
+		CheckSuite struct {
+			PullRequests []pullRequestReference `json:"pull_requests"`
+		} `json:"check_suite"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Error().Err(err).Msg("unable to decode request")
+		h.respond(w, http.StatusBadRequest, "bad request")
+		return
+	}
+
+	if req.Action != "completed" {
+		logger.Debug().Msg("action is not completed")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	h.queueReferencedPullRequests(logger, eventID, &req.BaseRequest, req.CheckSuite.PullRequests, w)
+}
+
+// handleWorkflowRun handles workflow_run events, for apps that receive them instead of check_run events.
+// Repositories that send both event types queue the same pull_request messages, those are deduplicated by
+// their message id and the rate limit.
+func (h *Handler) handleWorkflowRun(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
+	var req struct {
+		BaseRequest
+		WorkflowRun struct {
+			HeadSHA      string                 `json:"head_sha"`
+			PullRequests []pullRequestReference `json:"pull_requests"`
+		} `json:"workflow_run"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Error().Err(err).Msg("unable to decode request")
+		h.respond(w, http.StatusBadRequest, "bad request")
+		return
+	}
+
+	if req.Action != "completed" {
+		logger.Debug().Msg("action is not completed")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	if len(req.WorkflowRun.PullRequests) == 0 {
+		// github leaves pull_requests empty for pull requests from forks,
+		// handle it like a status event, so the pull requests of head_sha are found by the worker
+		logger.Debug().Str("head_sha", req.WorkflowRun.HeadSHA).Msg("workflow run has no pull requests")
+		h.handleStatus(logger, eventID, &req.BaseRequest, w)
+		return
+	}
+
+	h.queueReferencedPullRequests(logger, eventID, &req.BaseRequest, req.WorkflowRun.PullRequests, w)
+}
+
+// queueReferencedPullRequests queues a pull_request message for every distinct pull request in references.
+func (h *Handler) queueReferencedPullRequests(
+	logger *zerolog.Logger,
+	eventID string,
+	req *BaseRequest,
+	references []pullRequestReference,
+	w http.ResponseWriter,
+) {
 	// remove duplicates
 	pullRequests := make(map[int64]struct{})
-	for _, request := range append(req.CheckRun.PullRequests, req.CheckRun.CheckSuite.PullRequests...) {
-		if request.Number == 0 {
+	for _, reference := range references {
+		if reference.Number == 0 {
 			continue
 		}
-		pullRequests[request.Number] = struct{}{}
+		pullRequests[reference.Number] = struct{}{}
 	}
 
+	repository := req.repository()
 	for number := range pullRequests {
 		err := h.queuePullRequestMessage(
 			logger,
 			eventID,
-			&common.Repository{
-				NodeID:    req.Repository.NodeID,
-				FullName:  req.Repository.FullName,
-				Name:      req.Repository.Name,
-				OwnerName: req.Repository.Owner.Login,
-				Private:   req.Repository.Private,
-			},
+			&repository,
 			req.Installation.ID,
+			req.sender(),
 			&common.PullRequest{
 				Number: number,
-			})
+			},
+			false,
+			"",
+			"",
+			"")
 		if err != nil {
-			logger.Error().Err(err).Msg("unable to queue message")
-			h.respond(w, http.StatusInternalServerError, "error")
+			h.respondQueueError(logger, eventID, h.PullRequestSubject, err, "unable to queue message", w)
 			return
 		}
 	}
 	h.respond(w, http.StatusOK, "ok")
 }
+
+func (h *Handler) handleLabel(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
+	var req struct {
+		BaseRequest
+		Label struct {
+			Name string `json:"name"`
+		} `json:"label"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Error().Err(err).Msg("unable to decode request")
+		h.respond(w, http.StatusBadRequest, "bad request")
+		return
+	}
+
+	if req.Action != "deleted" {
+		logger.Debug().Msg("action is not deleted")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	if req.Label.Name == "" {
+		logger.Debug().Msg("no label.name present in request")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	err := common.QueueMessage(
+		logger,
+		h.JetStreamContext,
+		h.RateLimitKV,
+		h.RateLimitInterval,
+		h.MaintenanceSubject+"."+eventID,
+		fmt.Sprintf("label.%d.%s.%s", req.Installation.ID, req.Repository.NodeID, req.Label.Name),
+		eventID,
+		&common.QueueMaintenanceMessage{
+			BaseMessage: common.BaseMessage{
+				InstallationID: req.Installation.ID,
+				Repository:     req.repository(),
+				Sender:         req.sender(),
+			},
+			DeletedLabel: req.Label.Name,
+		})
+	if err != nil {
+		h.respondQueueError(logger, eventID, h.MaintenanceSubject, err, "unable to queue maintenance message", w)
+		return
+	}
+	h.respond(w, http.StatusOK, "ok")
+}
+
+// handleMergeGroup queues a maintenance message for merge groups that request checks,
+// the worker creates a successful check run on their head sha, so merge queues that require it are not blocked.
+func (h *Handler) handleMergeGroup(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
+	if !h.HandleMergeGroup {
+		logger.Debug().Msg("merge_group events are not handled")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	var req struct {
+		BaseRequest
+		MergeGroup struct {
+			HeadSHA string `json:"head_sha"`
+		} `json:"merge_group"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger.Error().Err(err).Msg("unable to decode request")
+		h.respond(w, http.StatusBadRequest, "bad request")
+		return
+	}
+
+	if req.Action != "checks_requested" {
+		logger.Debug().Msg("action is not checks_requested")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	if req.MergeGroup.HeadSHA == "" {
+		logger.Debug().Msg("no merge_group.head_sha present in request")
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	if !h.isRepositoryAllowed(logger, req.Repository.FullName, req.Repository.Private) {
+		h.respond(w, http.StatusOK, "ok")
+		return
+	}
+
+	err := common.QueueMessage(
+		logger,
+		h.JetStreamContext,
+		h.RateLimitKV,
+		h.RateLimitInterval,
+		h.MaintenanceSubject+"."+eventID,
+		fmt.Sprintf("merge_group.%d.%s.%s", req.Installation.ID, req.Repository.NodeID, req.MergeGroup.HeadSHA),
+		eventID,
+		&common.QueueMaintenanceMessage{
+			BaseMessage: common.BaseMessage{
+				InstallationID: req.Installation.ID,
+				Repository:     req.repository(),
+				Sender:         req.sender(),
+			},
+			MergeGroupHeadSHA: req.MergeGroup.HeadSHA,
+		})
+	if err != nil {
+		h.respondQueueError(logger, eventID, h.MaintenanceSubject, err, "unable to queue maintenance message", w)
+		return
+	}
+	h.respond(w, http.StatusOK, "ok")
+}
+
 func (h *Handler) handlePullRequest(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
 	var req struct {
 		BaseRequest
 		PullRequest struct {
-			Number int64  `json:"number"`
-			State  string `json:"state"`
+			Number   int64      `json:"number"`
+			NodeID   string     `json:"node_id"`
+			State    string     `json:"state"`
+			Merged   bool       `json:"merged"`
+			MergedAt *time.Time `json:"merged_at"`
+			Head     struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
 		} `json:"pull_request"`
+		Label struct {
+			Name string `json:"name"`
+		} `json:"label"`
 	}
 
 	if err := json.Unmarshal(body, &req); err != nil {
@@ -206,53 +636,117 @@ func (h *Handler) handlePullRequest(logger *zerolog.Logger, eventID string, body
 		return
 	}
 
-	if req.PullRequest.State != "open" {
+	// pull requests that were closed without merging are forwarded to clean up
+	closed := req.Action == "closed" && req.PullRequest.State == "closed" && !req.PullRequest.Merged
+
+	if req.PullRequest.State != "open" && !closed {
 		logger.Debug().Msg("pull_request.state is not `open'")
 		h.respond(w, http.StatusOK, "ok")
 		return
 	}
 
-	handleActions := []string{"created", "opened", "labeled", "reopened", "synchronize", "edited"}
-	if slices.Index(handleActions, req.Action) == -1 {
-		logger.Debug().Msgf("action is not one of %s", strings.Join(handleActions, ", "))
-		h.respond(w, http.StatusOK, "ok")
-		return
+	handleActions := h.PullRequestActions
+	if len(handleActions) == 0 {
+		handleActions = DefaultPullRequestActions
 	}
-
-	if h.AllowOnlyPublicRepositories && req.Repository.Private {
-		logger.Warn().Str("repo", req.Repository.FullName).Msg("repository is not allowed (it is private)")
+	if !closed && slices.Index(handleActions, req.Action) == -1 {
+		logger.Debug().Msgf("action is not one of %s", strings.Join(handleActions, ", "))
 		h.respond(w, http.StatusOK, "ok")
 		return
 	}
 
-	if h.AllowedRepositories.ContainsOneOf(req.Repository.FullName) == "" {
-		logger.Warn().Str("repo", req.Repository.FullName).Msg("repository is not allowed")
+	if !h.isRepositoryAllowed(logger, req.Repository.FullName, req.Repository.Private) {
 		h.respond(w, http.StatusOK, "ok")
 		return
 	}
 
+	repository := req.repository()
 	err := h.queuePullRequestMessage(
 		logger,
 		eventID,
-		&common.Repository{
-			NodeID:    req.Repository.NodeID,
-			FullName:  req.Repository.FullName,
-			Name:      req.Repository.Name,
-			OwnerName: req.Repository.Owner.Login,
-			Private:   req.Repository.Private,
-		},
+		&repository,
 		req.Installation.ID,
+		req.sender(),
 		&common.PullRequest{
-			Number: req.PullRequest.Number,
-		})
+			Number:   req.PullRequest.Number,
+			State:    req.PullRequest.State,
+			MergedAt: req.PullRequest.MergedAt,
+		},
+		closed,
+		req.Action,
+		req.Label.Name,
+		req.PullRequest.Head.SHA)
 	if err != nil {
-		logger.Error().Err(err).Msg("unable to queue pull_request message")
-		h.respond(w, http.StatusInternalServerError, "error")
+		h.respondQueueError(logger, eventID, h.PullRequestSubject, err, "unable to queue pull_request message", w)
 		return
 	}
+	if !closed && h.ServerCheckRunToken != nil {
+		go h.createQueuedCheckRun(logger, req.Installation.ID, &repository, req.PullRequest.NodeID, req.PullRequest.Head.SHA)
+	}
 	h.respond(w, http.StatusOK, "ok")
 }
 
+// createQueuedCheckRun creates a QUEUED check run for the pull request,
+// nothing is done if there is no token or if there is already a check run for the sha.
+func (h *Handler) createQueuedCheckRun(
+	logger *zerolog.Logger,
+	installationID int64,
+	repository *common.Repository,
+	pullRequestNodeID,
+	sha string,
+) {
+	const timeout = 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if pullRequestNodeID == "" || sha == "" {
+		return
+	}
+
+	token, err := h.ServerCheckRunToken.Token(installationID, repository)
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to get token for queued check run")
+		return
+	}
+	if token == "" {
+		logger.Debug().Msg("no token for queued check run available")
+		return
+	}
+
+	checkRunName, err := h.CheckRunNames.CheckRunName(repository)
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to get name for queued check run")
+		return
+	}
+	if checkRunName == "" {
+		logger.Debug().Msg("no name for queued check run available")
+		return
+	}
+
+	key := common.CheckRunKVKey(checkRunName, pullRequestNodeID, sha)
+	if _, err := h.CheckRunsKV.Get(key); err == nil {
+		logger.Debug().Msg("check run already exists")
+		return
+	} else if !errors.Is(err, nats.ErrKeyNotFound) {
+		logger.Error().Err(err).Msg("unable to get check_run_id from kv bucket")
+		return
+	}
+
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	checkRunID, err := github.CreateCheckRunImmediate(ctx, client, token, repository, sha, checkRunName, "queued")
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to create queued check run")
+		return
+	}
+	// use Create, the worker might have created a check run in the meantime
+	if _, err := h.CheckRunsKV.Create(key, []byte(checkRunID)); err != nil && !errors.Is(err, nats.ErrKeyExists) {
+		logger.Error().Err(err).Msg("unable to store check_run_id in kv bucket")
+	}
+}
+
 func (h *Handler) handlePullRequestReview(logger *zerolog.Logger, eventID string, body []byte, w http.ResponseWriter) {
 	var req struct {
 		BaseRequest
@@ -285,41 +779,34 @@ func (h *Handler) handlePullRequestReview(logger *zerolog.Logger, eventID string
 		return
 	}
 
-	if req.Action != "submitted" {
-		logger.Debug().Msg("action is not submitted")
-		h.respond(w, http.StatusOK, "ok")
-		return
-	}
-
-	if h.AllowOnlyPublicRepositories && req.Repository.Private {
-		logger.Warn().Str("repo", req.Repository.FullName).Msg("repository is not allowed (it is private)")
+	if slices.Index(PullRequestReviewActions, req.Action) == -1 {
+		logger.Debug().Msgf("action is not one of %s", strings.Join(PullRequestReviewActions, ", "))
 		h.respond(w, http.StatusOK, "ok")
 		return
 	}
 
-	if h.AllowedRepositories.ContainsOneOf(req.Repository.FullName) == "" {
-		logger.Warn().Str("repo", req.Repository.FullName).Msg("repository is not allowed")
+	if !h.isRepositoryAllowed(logger, req.Repository.FullName, req.Repository.Private) {
 		h.respond(w, http.StatusOK, "ok")
 		return
 	}
 
+	repository := req.repository()
 	err := h.queuePullRequestMessage(
 		logger,
 		eventID,
-		&common.Repository{
-			NodeID:    req.Repository.NodeID,
-			FullName:  req.Repository.FullName,
-			Name:      req.Repository.Name,
-			OwnerName: req.Repository.Owner.Login,
-			Private:   req.Repository.Private,
-		},
+		&repository,
 		req.Installation.ID,
+		req.sender(),
 		&common.PullRequest{
 			Number: req.PullRequest.Number,
-		})
+			State:  req.PullRequest.State,
+		},
+		false,
+		"",
+		"",
+		"")
 	if err != nil {
-		logger.Error().Err(err).Msg("unable to queue pull_request message")
-		h.respond(w, http.StatusInternalServerError, "error")
+		h.respondQueueError(logger, eventID, h.PullRequestSubject, err, "unable to queue pull_request message", w)
 		return
 	}
 	h.respond(w, http.StatusOK, "ok")
@@ -344,36 +831,40 @@ func (h *Handler) handlePush(logger *zerolog.Logger, eventID string, body []byte
 		return
 	}
 
-	if req.Ref != "refs/heads/"+req.Repository.DefaultBranch {
-		// no need to handle pushes that are not targeted to the master branch.
-		// pushes to branches will only be handled when there is a pull request open
-		// and if a pull request is open, github will call the pull_request synchronize event.
+	branch, ok := strings.CutPrefix(req.Ref, "refs/heads/")
+	if !ok {
+		// no need to handle pushes of tags
 		h.respond(w, http.StatusOK, "ok")
 		return
 	}
 
+	// pushes to other branches than the default branch are queued as well, pull requests can be based on them
+	// (e.g. release/1.x). The worker only looks at the pull requests that are based on the pushed branch, the pull
+	// requests of a pushed head branch are handled by the pull_request synchronize event.
+	msgID := fmt.Sprintf("push.%d.%s", req.Installation.ID, req.Repository.NodeID)
+	if branch != req.Repository.DefaultBranch {
+		// do not rate limit pushes to the default branch with pushes to other branches
+		msgID += "." + branch
+	}
+
 	err := common.QueueMessage(
 		logger,
 		h.JetStreamContext,
 		h.RateLimitKV,
 		h.RateLimitInterval,
 		h.PushSubject+"."+eventID,
-		fmt.Sprintf("push.%d.%s", req.Installation.ID, req.Repository.NodeID),
+		msgID,
+		eventID,
 		&common.QueuePushMessage{
 			BaseMessage: common.BaseMessage{
 				InstallationID: req.Installation.ID,
-				Repository: common.Repository{
-					NodeID:    req.Repository.NodeID,
-					FullName:  req.Repository.FullName,
-					Name:      req.Repository.Name,
-					OwnerName: req.Repository.Owner.Login,
-					Private:   req.Repository.Private,
-				},
+				Repository:     req.repository(),
+				Sender:         req.sender(),
 			},
+			Branch: branch,
 		})
 	if err != nil {
-		logger.Error().Err(err).Msg("unable to queue push message")
-		h.respond(w, http.StatusInternalServerError, "error")
+		h.respondQueueError(logger, eventID, h.PushSubject, err, "unable to queue push message", w)
 		return
 	}
 	h.respond(w, http.StatusOK, "ok")
@@ -387,21 +878,16 @@ func (h *Handler) handleStatus(logger *zerolog.Logger, eventID string, baseReque
 		h.RateLimitInterval,
 		h.StatusSubject+"."+eventID,
 		fmt.Sprintf("status.%d.%s", baseRequest.Installation.ID, baseRequest.Repository.NodeID),
+		eventID,
 		&common.QueueStatusMessage{
 			BaseMessage: common.BaseMessage{
 				InstallationID: baseRequest.Installation.ID,
-				Repository: common.Repository{
-					NodeID:    baseRequest.Repository.NodeID,
-					FullName:  baseRequest.Repository.FullName,
-					Name:      baseRequest.Repository.Name,
-					OwnerName: baseRequest.Repository.Owner.Login,
-					Private:   baseRequest.Repository.Private,
-				},
+				Repository:     baseRequest.repository(),
+				Sender:         baseRequest.sender(),
 			},
 		})
 	if err != nil {
-		logger.Error().Err(err).Msg("unable to queue status message")
-		h.respond(w, http.StatusInternalServerError, "error")
+		h.respondQueueError(logger, eventID, h.StatusSubject, err, "unable to queue status message", w)
 		return
 	}
 	h.respond(w, http.StatusOK, "ok")
@@ -412,28 +898,82 @@ func (h *Handler) queuePullRequestMessage(
 	eventID string,
 	repository *common.Repository,
 	installationID int64,
+	sender common.Sender,
 	pullRequest *common.PullRequest,
+	closed bool,
+	action,
+	label,
+	headSHA string,
 ) error {
+	msgID := fmt.Sprintf("pull_request.%d.%s.%d", installationID, repository.NodeID, pullRequest.Number)
+	if closed {
+		// use a different id, so the message does not get deduplicated with previous messages
+		msgID = fmt.Sprintf("pull_request_closed.%d.%s.%d", installationID, repository.NodeID, pullRequest.Number)
+	}
 	return common.QueueMessage(
 		logger,
 		h.JetStreamContext,
 		h.RateLimitKV,
 		h.RateLimitInterval,
 		h.PullRequestSubject+"."+eventID,
-		fmt.Sprintf("pull_request.%d.%s.%d", installationID, repository.NodeID, pullRequest.Number),
+		msgID,
+		eventID,
 		&common.QueuePullRequestMessage{
 			BaseMessage: common.BaseMessage{
 				InstallationID: installationID,
 				Repository:     *repository,
+				Sender:         sender,
 			},
 			PullRequest: *pullRequest,
+			Closed:      closed,
+			Action:      action,
+			Label:       label,
+			HeadSHA:     headSHA,
 		})
 }
 
+// queueRetryAfter is the Retry-After value of responses for events that could not be queued.
+const queueRetryAfter = 30 * time.Second
+
+// respondQueueError responds to an event that could not be queued.
+// If the queue is unavailable 503 is responded, so the delivery can be retried, other errors are responded with 500.
+func (h *Handler) respondQueueError(
+	logger *zerolog.Logger,
+	eventID,
+	subject string,
+	err error,
+	msg string,
+	w http.ResponseWriter,
+) {
+	logger.Error().Err(err).Str("delivery_id", eventID).Str("subject", subject+"."+eventID).Msg(msg)
+	var unavailableErr *common.QueueUnavailableError
+	if !errors.As(err, &unavailableErr) {
+		h.respond(w, http.StatusInternalServerError, "error")
+		return
+	}
+	if w != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(queueRetryAfter.Seconds())))
+	}
+	h.respond(w, http.StatusServiceUnavailable, "unavailable")
+}
+
+func (h *Handler) respondWithBody(w http.ResponseWriter, statusCode int, body any) {
+	if w == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
 func (h *Handler) respond(w http.ResponseWriter, statusCode int, status string) {
 	if w == nil {
 		return
 	}
+	respond(w, statusCode, status)
+}
+
+func respond(w http.ResponseWriter, statusCode int, status string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	_, _ = fmt.Fprintf(w, `{"status": %q}`, status)
@@ -0,0 +1,1209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func newTestHandler() *Handler {
+	logger := zerolog.Nop()
+	return &Handler{
+		GetLoggerForContext: func(context.Context) *zerolog.Logger {
+			return &logger
+		},
+	}
+}
+
+func TestHandler_ServeHTTP_BodyTooLarge(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxBodyBytes int64
+		body         string
+		wantStatus   int
+	}{
+		{
+			name:         "body exceeds the limit",
+			maxBodyBytes: 8,
+			body:         `{"action":"opened"}`,
+			wantStatus:   http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "body is exactly the limit",
+			maxBodyBytes: 2,
+			body:         `{}`,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "default limit is used when not set",
+			maxBodyBytes: 0,
+			body:         `{}`,
+			wantStatus:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.MaxBodyBytes = tt.maxBodyBytes
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("ServeHTTP() content-type = %q, want application/json", ct)
+			}
+			if tt.wantStatus != http.StatusRequestEntityTooLarge {
+				return
+			}
+			var resp struct {
+				ContentLength int64 `json:"content_length"`
+				MaxBodyBytes  int64 `json:"max_body_bytes"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.ContentLength != int64(len(tt.body)) || resp.MaxBodyBytes != tt.maxBodyBytes {
+				t.Errorf("ServeHTTP() body = %s, want content_length %d and max_body_bytes %d",
+					rec.Body.String(), len(tt.body), tt.maxBodyBytes)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_Methods(t *testing.T) {
+	methods := []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+		http.MethodOptions,
+		http.MethodConnect,
+		http.MethodTrace,
+	}
+	for _, method := range methods {
+		t.Run(method+" without redirect url", func(t *testing.T) {
+			h := newTestHandler()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, "/", http.NoBody))
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+			}
+			if allow := rec.Header().Get("Allow"); allow != http.MethodPost {
+				t.Errorf("ServeHTTP() Allow = %q, want %q", allow, http.MethodPost)
+			}
+		})
+		t.Run(method+" with redirect url", func(t *testing.T) {
+			h := newTestHandler()
+			h.RedirectURL = "https://example.com"
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, "/", http.NoBody))
+			if rec.Code != http.StatusTemporaryRedirect {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusTemporaryRedirect)
+			}
+			if location := rec.Header().Get("Location"); location != h.RedirectURL {
+				t.Errorf("ServeHTTP() Location = %q, want %q", location, h.RedirectURL)
+			}
+		})
+	}
+
+	t.Run("POST is handled", func(t *testing.T) {
+		h := newTestHandler()
+		h.RedirectURL = "https://example.com"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`)))
+		if rec.Code != http.StatusOK {
+			t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestHandler_ServeHTTP_WebhookPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		webhookPath string
+		target      string
+		wantStatus  int
+	}{
+		{name: "default path", webhookPath: "", target: "/", wantStatus: http.StatusOK},
+		{name: "default path with query string", webhookPath: "", target: "/?foo=bar", wantStatus: http.StatusOK},
+		{name: "default path with other path", webhookPath: "", target: "/foo", wantStatus: http.StatusNotFound},
+		{name: "prefixed path", webhookPath: "/hooks/github", target: "/hooks/github", wantStatus: http.StatusOK},
+		{name: "prefixed path with trailing slash", webhookPath: "/hooks/github", target: "/hooks/github/", wantStatus: http.StatusOK},
+		{
+			name:        "prefixed path configured with trailing slash",
+			webhookPath: "/hooks/github/",
+			target:      "/hooks/github",
+			wantStatus:  http.StatusOK,
+		},
+		{name: "prefixed path with query string", webhookPath: "/hooks/github", target: "/hooks/github?foo=bar", wantStatus: http.StatusOK},
+		{name: "prefixed path with root", webhookPath: "/hooks/github", target: "/", wantStatus: http.StatusNotFound},
+		{name: "prefixed path with sub path", webhookPath: "/hooks/github", target: "/hooks/github/foo", wantStatus: http.StatusNotFound},
+		{name: "prefixed path with parent path", webhookPath: "/hooks/github", target: "/hooks", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.WebhookPath = tt.webhookPath
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(`{}`)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_TargetID(t *testing.T) {
+	tests := []struct {
+		name       string
+		appID      int64
+		targetID   string
+		wantStatus int
+	}{
+		{name: "target id matches", appID: 1234, targetID: "1234", wantStatus: http.StatusOK},
+		{name: "target id does not match", appID: 1234, targetID: "5678", wantStatus: http.StatusBadRequest},
+		{name: "target id is invalid", appID: 1234, targetID: "abc", wantStatus: http.StatusBadRequest},
+		{name: "target id is missing", appID: 1234, targetID: "", wantStatus: http.StatusOK},
+		{name: "app id is not configured", appID: 0, targetID: "5678", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.AppID = tt.appID
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+			if tt.targetID != "" {
+				req.Header.Set("X-GitHub-Hook-Installation-Target-ID", tt.targetID)
+			}
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_Ping(t *testing.T) {
+	body, err := os.ReadFile("testdata/ping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	req.Header.Set("X-GitHub-Event", "ping")
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp struct {
+		Status string `json:"status"`
+		Zen    string `json:"zen"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "ok" || resp.Zen != "Design for failure." {
+		t.Errorf("ServeHTTP() body = %+v", resp)
+	}
+}
+
+func TestHandler_ServeHTTP_Label(t *testing.T) {
+	body, err := os.ReadFile("testdata/label_deleted.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		body         string
+		wantMessages int
+	}{
+		{
+			name:         "deleted label is queued",
+			body:         string(body),
+			wantMessages: 1,
+		},
+		{
+			name:         "created label is ignored",
+			body:         strings.Replace(string(body), `"deleted"`, `"created"`, 1),
+			wantMessages: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.MaintenanceSubject = "maintenance"
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("X-GitHub-Event", "label")
+			req.Header.Set("X-GitHub-Delivery", "1")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			messages := js.Messages()
+			if len(messages) != tt.wantMessages {
+				t.Fatalf("got %d messages, want %d", len(messages), tt.wantMessages)
+			}
+			if tt.wantMessages == 0 {
+				return
+			}
+			if messages[0].Subject != "maintenance.1" {
+				t.Errorf("subject = %q, want %q", messages[0].Subject, "maintenance.1")
+			}
+			var msg common.QueueMaintenanceMessage
+			if err := json.Unmarshal(messages[0].Data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.DeletedLabel != "merge" || msg.InstallationID != 42 || msg.Repository.FullName != "owner/repo" {
+				t.Errorf("unexpected message %+v", msg)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_CheckSuite(t *testing.T) {
+	body, err := os.ReadFile("testdata/check_suite_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		body        string
+		wantNumbers []int64
+	}{
+		{
+			name:        "completed check suite queues every pull request once",
+			body:        string(body),
+			wantNumbers: []int64{2, 3},
+		},
+		{
+			name: "requested check suite is ignored",
+			body: strings.Replace(string(body), `"action": "completed"`, `"action": "requested"`, 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.PullRequestSubject = "pull_request"
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("X-GitHub-Event", "check_suite")
+			req.Header.Set("X-GitHub-Delivery", "1")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			var numbers []int64
+			for _, m := range js.Messages() {
+				var msg common.QueuePullRequestMessage
+				if err := json.Unmarshal(m.Data, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.InstallationID != 42 || msg.Repository.FullName != "owner/repo" {
+					t.Errorf("unexpected message %+v", msg)
+				}
+				numbers = append(numbers, msg.PullRequest.Number)
+			}
+			slices.Sort(numbers)
+			if !slices.Equal(numbers, tt.wantNumbers) {
+				t.Errorf("queued pull requests = %v, want %v", numbers, tt.wantNumbers)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_WorkflowRun(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		action       string
+		wantSubjects []string
+	}{
+		{
+			name:         "pull requests of the workflow run are queued",
+			file:         "testdata/workflow_run_completed.json",
+			action:       "completed",
+			wantSubjects: []string{"pull_request.1"},
+		},
+		{
+			name:         "workflow run without pull requests queues a status message",
+			file:         "testdata/workflow_run_completed_fork.json",
+			action:       "completed",
+			wantSubjects: []string{"status.1"},
+		},
+		{
+			name:   "requested workflow run is ignored",
+			file:   "testdata/workflow_run_completed.json",
+			action: "requested",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.PullRequestSubject = "pull_request"
+			h.StatusSubject = "status"
+
+			rec := httptest.NewRecorder()
+			payload := strings.Replace(string(body), `"action": "completed"`, fmt.Sprintf("%q: %q", "action", tt.action), 1)
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload))
+			req.Header.Set("X-GitHub-Event", "workflow_run")
+			req.Header.Set("X-GitHub-Delivery", "1")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			var subjects []string
+			for _, m := range js.Messages() {
+				subjects = append(subjects, m.Subject)
+				var msg common.BaseMessage
+				if err := json.Unmarshal(m.Data, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.InstallationID != 42 || msg.Repository.FullName != "owner/repo" {
+					t.Errorf("unexpected message %+v", msg)
+				}
+			}
+			if !slices.Equal(subjects, tt.wantSubjects) {
+				t.Errorf("subjects = %v, want %v", subjects, tt.wantSubjects)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_MergeGroup(t *testing.T) {
+	body, err := os.ReadFile("testdata/merge_group_checks_requested.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name             string
+		body             string
+		handleMergeGroup bool
+		wantMessages     int
+	}{
+		{
+			name:             "checks requested is queued",
+			body:             string(body),
+			handleMergeGroup: true,
+			wantMessages:     1,
+		},
+		{
+			name:         "merge groups are not handled by default",
+			body:         string(body),
+			wantMessages: 0,
+		},
+		{
+			name:             "destroyed merge group is ignored",
+			body:             strings.Replace(string(body), `"checks_requested"`, `"destroyed"`, 1),
+			handleMergeGroup: true,
+			wantMessages:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.MaintenanceSubject = "maintenance"
+			h.HandleMergeGroup = tt.handleMergeGroup
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("X-GitHub-Event", "merge_group")
+			req.Header.Set("X-GitHub-Delivery", "1")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			messages := js.Messages()
+			if len(messages) != tt.wantMessages {
+				t.Fatalf("got %d messages, want %d", len(messages), tt.wantMessages)
+			}
+			if tt.wantMessages == 0 {
+				return
+			}
+			var msg common.QueueMaintenanceMessage
+			if err := json.Unmarshal(messages[0].Data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.MergeGroupHeadSHA != "8e5a1cc0f4b8f4d2a6c2a7a6e2f1d3b4c5a69788" || msg.DeletedLabel != "" {
+				t.Errorf("unexpected message %+v", msg)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_QueueErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		publishErr     error
+		kvErr          error
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:       "queued",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:           "publish fails",
+			publishErr:     nats.ErrNoStreamResponse,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "30",
+		},
+		{
+			name:           "rate limit kv fails",
+			kvErr:          nats.ErrTimeout,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "30",
+		},
+		{
+			name:       "invalid payload",
+			body:       `{"action": `,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = &fakeJetStream{err: tt.publishErr}
+			kv := newFakeKV()
+			kv.err = tt.kvErr
+			h.RateLimitKV = kv
+			h.PullRequestSubject = "pull_request"
+
+			body := tt.body
+			if body == "" {
+				body = pullRequestEventBody(t, "opened", "open", false)
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			req.Header.Set("X-GitHub-Event", "pull_request")
+			req.Header.Set("X-GitHub-Delivery", "1")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	t.Run("unexpected error", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		logger := zerolog.Nop()
+		newTestHandler().respondQueueError(&logger, "1", "pull_request", io.ErrUnexpectedEOF, "unable to queue message", rec)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "" {
+			t.Errorf("Retry-After = %q, want none", got)
+		}
+	})
+}
+
+func TestHandler_ServeHTTP_Redelivery(t *testing.T) {
+	tests := []struct {
+		name         string
+		deliveries   []string
+		wantMessages int
+	}{
+		{name: "redelivery is deduplicated", deliveries: []string{"1", "1"}, wantMessages: 1},
+		{name: "different deliveries are queued", deliveries: []string{"1", "2"}, wantMessages: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.PullRequestSubject = "pull_request"
+
+			for _, delivery := range tt.deliveries {
+				// reset the rate limit, so only the delivery id deduplicates
+				h.RateLimitKV = newFakeKV()
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pullRequestEventBody(t, "opened", "open", false)))
+				req.Header.Set("X-GitHub-Event", "pull_request")
+				req.Header.Set("X-GitHub-Delivery", delivery)
+				h.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+				}
+			}
+			if got := len(js.Messages()); got != tt.wantMessages {
+				t.Errorf("got %d messages, want %d", got, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_Sender(t *testing.T) {
+	js := &fakeJetStream{}
+	h := newTestHandler()
+	h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+	h.JetStreamContext = js
+	h.RateLimitKV = newFakeKV()
+	h.PullRequestSubject = "pull_request"
+
+	body := strings.Replace(pullRequestEventBody(t, "labeled", "open", false),
+		`{"action"`, `{"sender":{"login":"octocat","type":"User"},"label":{"name":"merge"},"action"`, 1)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	h.ServeHTTP(rec, req)
+
+	messages := js.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	var msg common.QueuePullRequestMessage
+	if err := json.Unmarshal(messages[0].Data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := (common.Sender{Login: "octocat", Type: "User"}); msg.Sender != want {
+		t.Errorf("sender = %+v, want %+v", msg.Sender, want)
+	}
+	if msg.Label != "merge" {
+		t.Errorf("label = %q, want %q", msg.Label, "merge")
+	}
+	if msg.Repository.DefaultBranch != "main" {
+		t.Errorf("default branch = %q, want %q", msg.Repository.DefaultBranch, "main")
+	}
+}
+
+func pushEventBody(t *testing.T, ref string) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"ref":          ref,
+		"installation": map[string]any{"id": 42},
+		"repository": map[string]any{
+			"node_id":        "R_1",
+			"name":           "repo",
+			"full_name":      "owner/repo",
+			"owner":          map[string]any{"login": "owner"},
+			"default_branch": "main",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func newTestPushHandler(js *fakeJetStream) *Handler {
+	h := newTestHandler()
+	h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+	h.JetStreamContext = js
+	h.RateLimitKV = newFakeKV()
+	h.RateLimitInterval = time.Minute
+	h.PushSubject = "push"
+	return h
+}
+
+func TestHandler_ServeHTTP_Push(t *testing.T) {
+	tests := []struct {
+		name         string
+		ref          string
+		wantMessages int
+		wantBranch   string
+	}{
+		{name: "push to the default branch", ref: "refs/heads/main", wantMessages: 1, wantBranch: "main"},
+		{name: "push to another branch", ref: "refs/heads/release/1.x", wantMessages: 1, wantBranch: "release/1.x"},
+		{name: "push of a tag", ref: "refs/tags/v1.0.0", wantMessages: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestPushHandler(js)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pushEventBody(t, tt.ref)))
+			req.Header.Set("X-GitHub-Event", "push")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			messages := js.Messages()
+			if len(messages) != tt.wantMessages {
+				t.Fatalf("got %d messages, want %d", len(messages), tt.wantMessages)
+			}
+			if tt.wantMessages == 0 {
+				return
+			}
+			var msg common.QueuePushMessage
+			if err := json.Unmarshal(messages[0].Data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Repository.DefaultBranch != "main" || msg.Branch != tt.wantBranch {
+				t.Errorf("default branch = %q, branch = %q, want %q, %q", msg.Repository.DefaultBranch, msg.Branch, "main", tt.wantBranch)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_Push_RateLimitedPerBranch(t *testing.T) {
+	js := &fakeJetStream{}
+	h := newTestPushHandler(js)
+
+	for i, ref := range []string{"refs/heads/main", "refs/heads/release/1.x", "refs/heads/main"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pushEventBody(t, ref)))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("delivery-%d", i))
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+
+	messages := js.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+	// the push to release/1.x is not delayed by the push to main, the second push to main is
+	for i, wantDelayed := range []bool{false, false, true} {
+		if delayed := messages[i].Header.Get(common.DelayUntilHeader) != ""; delayed != wantDelayed {
+			t.Errorf("message %d delayed = %v, want %v", i, delayed, wantDelayed)
+		}
+	}
+}
+
+func pullRequestEventBody(t *testing.T, action, state string, merged bool) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"action":       action,
+		"installation": map[string]any{"id": 42},
+		"repository": map[string]any{
+			"node_id":        "R_1",
+			"name":           "repo",
+			"full_name":      "owner/repo",
+			"owner":          map[string]any{"login": "owner"},
+			"default_branch": "main",
+		},
+		"pull_request": map[string]any{
+			"number": 1,
+			"state":  state,
+			"merged": merged,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandler_ServeHTTP_PullRequestClosed(t *testing.T) {
+	tests := []struct {
+		name         string
+		merged       bool
+		wantMessages int
+	}{
+		{
+			name:         "closed pull request is queued for cleanup",
+			merged:       false,
+			wantMessages: 1,
+		},
+		{
+			name:         "merged pull request is ignored",
+			merged:       true,
+			wantMessages: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.PullRequestSubject = "pull_request"
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pullRequestEventBody(t, "closed", "closed", tt.merged)))
+			req.Header.Set("X-GitHub-Event", "pull_request")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			messages := js.Messages()
+			if len(messages) != tt.wantMessages {
+				t.Fatalf("got %d messages, want %d", len(messages), tt.wantMessages)
+			}
+			if tt.wantMessages == 0 {
+				return
+			}
+			var msg common.QueuePullRequestMessage
+			if err := json.Unmarshal(messages[0].Data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if !msg.Closed || msg.PullRequest.Number != 1 {
+				t.Errorf("unexpected message %+v", msg)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_PullRequestActions(t *testing.T) {
+	tests := []struct {
+		name         string
+		actions      []string
+		action       string
+		wantMessages int
+	}{
+		{
+			name:         "default actions handle edited",
+			actions:      nil,
+			action:       "edited",
+			wantMessages: 1,
+		},
+		{
+			name:         "default actions ignore ready_for_review",
+			actions:      nil,
+			action:       "ready_for_review",
+			wantMessages: 0,
+		},
+		{
+			name:         "custom actions handle ready_for_review",
+			actions:      []string{"opened", "ready_for_review"},
+			action:       "ready_for_review",
+			wantMessages: 1,
+		},
+		{
+			name:         "custom actions ignore edited",
+			actions:      []string{"opened", "ready_for_review"},
+			action:       "edited",
+			wantMessages: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.PullRequestSubject = "pull_request"
+			h.PullRequestActions = tt.actions
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pullRequestEventBody(t, tt.action, "open", false)))
+			req.Header.Set("X-GitHub-Event", "pull_request")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := len(js.Messages()); got != tt.wantMessages {
+				t.Errorf("got %d messages, want %d", got, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func pullRequestReviewEventBody(t *testing.T, action, reviewState string) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"action":       action,
+		"installation": map[string]any{"id": 42},
+		"repository": map[string]any{
+			"node_id":   "R_1",
+			"name":      "repo",
+			"full_name": "owner/repo",
+			"owner":     map[string]any{"login": "owner"},
+		},
+		"review": map[string]any{
+			"state": reviewState,
+			"user":  map[string]any{"login": "alice"},
+		},
+		"pull_request": map[string]any{
+			"number": 1,
+			"state":  "open",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandler_ServeHTTP_PullRequestReview(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       string
+		reviewState  string
+		wantMessages int
+	}{
+		{name: "submitted", action: "submitted", reviewState: "approved", wantMessages: 1},
+		{name: "dismissed", action: "dismissed", reviewState: "dismissed", wantMessages: 1},
+		{name: "edited", action: "edited", reviewState: "approved", wantMessages: 1},
+		{name: "unknown action", action: "deleted", reviewState: "approved", wantMessages: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.PullRequestSubject = "pull_request"
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pullRequestReviewEventBody(t, tt.action, tt.reviewState)))
+			req.Header.Set("X-GitHub-Event", "pull_request_review")
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			msgs := js.Messages()
+			if got := len(msgs); got != tt.wantMessages {
+				t.Fatalf("got %d messages, want %d", got, tt.wantMessages)
+			}
+			if tt.wantMessages == 0 {
+				return
+			}
+			var msg common.QueuePullRequestMessage
+			if err := json.Unmarshal(msgs[0].Data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.PullRequest.Number != 1 || msg.HeadSHA != "" {
+				t.Errorf("message = %+v, want pull request 1 without head sha", msg)
+			}
+		})
+	}
+}
+
+func TestHandler_RepositoryDeniedHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     string
+		onlyPublic  bool
+		private     bool
+		wantAllowed bool
+		wantReason  string
+	}{
+		{
+			name:        "allowed",
+			allowed:     "owner/.*",
+			wantAllowed: true,
+		},
+		{
+			name:       "not matching allowed repositories",
+			allowed:    "other/.*",
+			wantReason: RepositoryDeniedNotAllowed,
+		},
+		{
+			name:       "private repository",
+			allowed:    ".*",
+			onlyPublic: true,
+			private:    true,
+			wantReason: RepositoryDeniedPrivate,
+		},
+		{
+			name:        "private repository is allowed",
+			allowed:     ".*",
+			private:     true,
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(tt.allowed)}
+			h.AllowOnlyPublicRepositories = tt.onlyPublic
+			var calls int
+			var gotRepo, gotReason string
+			h.RepositoryDeniedHandler = func(_ *zerolog.Logger, repo, reason string) {
+				calls++
+				gotRepo, gotReason = repo, reason
+			}
+
+			logger := zerolog.Nop()
+			if got := h.isRepositoryAllowed(&logger, "owner/repo", tt.private); got != tt.wantAllowed {
+				t.Errorf("isRepositoryAllowed() = %v, want %v", got, tt.wantAllowed)
+			}
+			if tt.wantAllowed {
+				if calls != 0 {
+					t.Errorf("handler was called %d times, want 0", calls)
+				}
+				return
+			}
+			if calls != 1 || gotRepo != "owner/repo" || gotReason != tt.wantReason {
+				t.Errorf("handler called %d times with (%q, %q), want once with (%q, %q)",
+					calls, gotRepo, gotReason, "owner/repo", tt.wantReason)
+			}
+		})
+	}
+
+	t.Run("pull request event of a denied repository", func(t *testing.T) {
+		js := &fakeJetStream{}
+		h := newTestHandler()
+		h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem("other/.*")}
+		h.JetStreamContext = js
+		h.RateLimitKV = newFakeKV()
+		h.PullRequestSubject = "pull_request"
+		var denied []string
+		h.RepositoryDeniedHandler = func(_ *zerolog.Logger, repo, reason string) {
+			denied = append(denied, repo+": "+reason)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pullRequestEventBody(t, "opened", "open", false)))
+		req.Header.Set("X-GitHub-Event", "pull_request")
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got := len(js.Messages()); got != 0 {
+			t.Errorf("got %d messages, want 0", got)
+		}
+		if want := "owner/repo: " + RepositoryDeniedNotAllowed; len(denied) != 1 || denied[0] != want {
+			t.Errorf("denied = %v, want [%s]", denied, want)
+		}
+	})
+}
+
+func TestHandler_ServeHTTP_AllowedGitHubEvents(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowed      []string
+		event        string
+		wantMessages int
+	}{
+		{
+			name:         "all events are handled by default",
+			allowed:      nil,
+			event:        "pull_request",
+			wantMessages: 1,
+		},
+		{
+			name:         "allowed event is handled",
+			allowed:      DefaultGitHubEvents,
+			event:        "pull_request",
+			wantMessages: 1,
+		},
+		{
+			name:         "other events are dropped",
+			allowed:      []string{"push", "status"},
+			event:        "pull_request",
+			wantMessages: 0,
+		},
+		{
+			name:         "wildcard allows all events",
+			allowed:      []string{"push", "*"},
+			event:        "pull_request",
+			wantMessages: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			h := newTestHandler()
+			h.AllowedRepositories = common.RegexSlice{common.MustNewRegexItem(".*")}
+			h.AllowedGitHubEvents = tt.allowed
+			h.JetStreamContext = js
+			h.RateLimitKV = newFakeKV()
+			h.PullRequestSubject = "pull_request"
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pullRequestEventBody(t, "opened", "open", false)))
+			req.Header.Set("X-GitHub-Event", tt.event)
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := len(js.Messages()); got != tt.wantMessages {
+				t.Errorf("got %d messages, want %d", got, tt.wantMessages)
+			}
+		})
+	}
+
+	t.Run("ping is always handled", func(t *testing.T) {
+		h := newTestHandler()
+		h.AllowedGitHubEvents = []string{"push"}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"zen":"Keep it logically awesome."}`))
+		req.Header.Set("X-GitHub-Event", "ping")
+		h.ServeHTTP(rec, req)
+		if !strings.Contains(rec.Body.String(), "Keep it logically awesome.") {
+			t.Errorf("ServeHTTP() body = %s, want the zen", rec.Body.String())
+		}
+	})
+}
+
+func TestValidatePullRequestActions(t *testing.T) {
+	if err := ValidatePullRequestActions(DefaultPullRequestActions); err != nil {
+		t.Errorf("ValidatePullRequestActions() error = %v, want nil", err)
+	}
+	if err := ValidatePullRequestActions([]string{"opened", "ready_for_review", "auto_merge_enabled"}); err != nil {
+		t.Errorf("ValidatePullRequestActions() error = %v, want nil", err)
+	}
+	if err := ValidatePullRequestActions([]string{"opened", "merged"}); err == nil {
+		t.Error("ValidatePullRequestActions() expected an error for unknown action")
+	}
+}
+
+type staticTokenStore string
+
+func (s staticTokenStore) Token(int64, *common.Repository) (string, error) {
+	return string(s), nil
+}
+
+type staticCheckRunNameStore string
+
+func (s staticCheckRunNameStore) CheckRunName(*common.Repository) (string, error) {
+	return string(s), nil
+}
+
+func TestHandler_createQueuedCheckRun(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		checkRunName string
+		existingID   string
+		wantRequests int
+		wantCheckRun string
+	}{
+		{
+			name:         "check run is created and stored",
+			token:        "token",
+			checkRunName: "merge-with-label",
+			wantRequests: 1,
+			wantCheckRun: "CR_1",
+		},
+		{
+			name:         "check run name of the repository",
+			token:        "token",
+			checkRunName: "deploy",
+			wantRequests: 1,
+			wantCheckRun: "CR_1",
+		},
+		{
+			name:         "no token available",
+			token:        "",
+			checkRunName: "merge-with-label",
+			wantRequests: 0,
+		},
+		{
+			name:         "check run name not known",
+			token:        "token",
+			checkRunName: "",
+			wantRequests: 0,
+		},
+		{
+			name:         "check run already exists",
+			token:        "token",
+			checkRunName: "merge-with-label",
+			existingID:   "CR_0",
+			wantRequests: 0,
+			wantCheckRun: "CR_0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			h := newTestHandler()
+			h.ServerCheckRunToken = staticTokenStore(tt.token)
+			h.CheckRunNames = staticCheckRunNameStore(tt.checkRunName)
+			kv := newFakeKV()
+			h.CheckRunsKV = kv
+			h.HTTPClient = &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					requests++
+					var body struct {
+						Query     string         `json:"query"`
+						Variables map[string]any `json:"variables"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						t.Fatal(err)
+					}
+					if !strings.Contains(body.Query, "status: QUEUED") {
+						t.Errorf("query does not create a queued check run: %s", body.Query)
+					}
+					if body.Variables["name"] != tt.checkRunName {
+						t.Errorf("check run name = %v, want %q", body.Variables["name"], tt.checkRunName)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(`{"data":{"createCheckRun":{"checkRun":{"id":"CR_1"}}}}`)),
+						Request:    r,
+					}, nil
+				}),
+			}
+
+			key := common.CheckRunKVKey(tt.checkRunName, "PR_1", "abc")
+			if tt.existingID != "" {
+				if _, err := kv.Put(key, []byte(tt.existingID)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := zerolog.Nop()
+			h.createQueuedCheckRun(&logger, 1, &common.Repository{NodeID: "R_1", FullName: "owner/repo"}, "PR_1", "abc")
+
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, tt.wantRequests)
+			}
+			var got string
+			if entry, err := kv.Get(key); err == nil {
+				got = string(entry.Value())
+			}
+			if got != tt.wantCheckRun {
+				t.Errorf("stored check run id = %q, want %q", got, tt.wantCheckRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// TokenStore provides installation tokens for repositories.
+type TokenStore interface {
+	// Token returns the token for the repository of the installation, an empty string is returned if no token is
+	// available.
+	Token(installationID int64, repository *common.Repository) (string, error)
+}
+
+// KVTokenStore reads the installation tokens the worker cached in the access tokens kv bucket.
+// The server never creates tokens, so no token is available until the worker worked on the repository.
+type KVTokenStore struct {
+	KV nats.KeyValue
+	// MinValidity is the minimum duration a token must still be valid, defaults to one minute.
+	MinValidity time.Duration
+}
+
+func (s *KVTokenStore) Token(installationID int64, repository *common.Repository) (string, error) {
+	entry, err := s.KV.Get(common.AccessTokenKVKey(installationID, repository))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "unable to get access token from kv bucket")
+	}
+	if len(entry.Value()) == 0 {
+		return "", nil
+	}
+
+	var token github.AccessToken
+	if err := json.Unmarshal(entry.Value(), &token); err != nil {
+		return "", errors.Wrap(err, "unable to decode access token from kv bucket")
+	}
+
+	minValidity := s.MinValidity
+	if minValidity <= 0 {
+		minValidity = time.Minute
+	}
+	if token.ExpiresAt.Before(time.Now().Add(minValidity)) {
+		return "", nil
+	}
+	return token.Token, nil
+}
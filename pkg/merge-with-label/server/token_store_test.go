@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestKVTokenStore_Token(t *testing.T) {
+	repository := &common.Repository{NodeID: "R_1", FullName: "owner/repo"}
+	tests := []struct {
+		name           string
+		token          *github.AccessToken
+		installationID int64
+		want           string
+	}{
+		{
+			name:  "no token cached",
+			token: nil,
+			want:  "",
+		},
+		{
+			name:  "valid token",
+			token: &github.AccessToken{Token: "token", ExpiresAt: time.Now().Add(time.Hour)},
+			want:  "token",
+		},
+		{
+			name:  "token expires soon",
+			token: &github.AccessToken{Token: "token", ExpiresAt: time.Now().Add(time.Second * 10)},
+			want:  "",
+		},
+		{
+			name:           "token of another installation",
+			token:          &github.AccessToken{Token: "token", ExpiresAt: time.Now().Add(time.Hour)},
+			installationID: 2,
+			want:           "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newFakeKV()
+			if tt.token != nil {
+				buf, err := json.Marshal(tt.token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := kv.Put(common.AccessTokenKVKey(1, repository), buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := &KVTokenStore{KV: kv}
+			installationID := tt.installationID
+			if installationID == 0 {
+				installationID = 1
+			}
+			got, err := store.Token(installationID, repository)
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Token() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,6 @@ package worker
 import (
 	"context"
 	"encoding/json"
-	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
@@ -19,7 +18,7 @@ func (worker *Worker) getAccessToken(
 	repository *common.Repository,
 	installationID int64,
 ) (string, error) {
-	key := hashForKV(repository.FullName)
+	key := common.AccessTokenKVKey(installationID, repository)
 
 	logger := rootLogger.With().
 		Str("hash_key", key).
@@ -30,6 +29,7 @@ func (worker *Worker) getAccessToken(
 		return "", errors.Wrap(err, "unable to get access token from kv bucket")
 	}
 	if entry == nil || len(entry.Value()) == 0 || errors.Is(err, nats.ErrKeyNotFound) {
+		countKVLookup(worker.AccessTokensKV, false)
 		logger.Debug().
 			Str("reason", "not in cache").
 			Msg("creating a new access token")
@@ -47,7 +47,8 @@ func (worker *Worker) getAccessToken(
 		return "", errors.Wrap(err, "unable to decode access token from kv bucket")
 	}
 
-	if cachedToken.ExpiresAt.Before(time.Now()) {
+	if worker.isAccessTokenExpired(&cachedToken) {
+		countKVLookup(worker.AccessTokensKV, false)
 		logger.Debug().
 			Str("reason", "expired").
 			Msg("creating a new access token")
@@ -60,11 +61,17 @@ func (worker *Worker) getAccessToken(
 		)
 	}
 
+	countKVLookup(worker.AccessTokensKV, true)
 	logger.Debug().
 		Msg("got access token from cache")
 	return cachedToken.Token, nil
 }
 
+// isAccessTokenExpired reports whether the token expires within AccessTokenExpirySkew.
+func (worker *Worker) isAccessTokenExpired(token *github.AccessToken) bool {
+	return token.ExpiresAt.Before(worker.timeNow().Add(worker.AccessTokenExpirySkew))
+}
+
 func (worker *Worker) createNewAccessToken(
 	ctx context.Context,
 	rootLogger *zerolog.Logger,
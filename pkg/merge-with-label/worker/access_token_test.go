@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestWorker_getAccessToken_ExpirySkew(t *testing.T) {
+	privateKey := newTestPrivateKey(t)
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	repository := &common.Repository{FullName: "owner/repo"}
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		skew      time.Duration
+		wantToken string
+	}{
+		{name: "valid beyond the skew", expiresIn: 10 * time.Minute, skew: 5 * time.Minute, wantToken: "cached"},
+		{name: "expires within the skew", expiresIn: 3 * time.Minute, skew: 5 * time.Minute, wantToken: "new"},
+		{name: "expired", expiresIn: -time.Minute, wantToken: "new"},
+		{name: "no skew", expiresIn: time.Minute, wantToken: "cached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			worker := &Worker{
+				AccessTokensKV:        newFakeKV(),
+				AccessTokenExpirySkew: tt.skew,
+				AppID:                 1,
+				PrivateKey:            privateKey,
+				HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					requests++
+					return &http.Response{
+						StatusCode: http.StatusCreated,
+						Body:       io.NopCloser(strings.NewReader(`{"token":"new","expires_at":"2024-01-02T13:00:00Z"}`)),
+						Request:    r,
+					}, nil
+				})},
+				now: func() time.Time { return now },
+			}
+			cached, err := json.Marshal(github.AccessToken{Token: "cached", ExpiresAt: now.Add(tt.expiresIn)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worker.AccessTokensKV.Put(common.AccessTokenKVKey(1, repository), cached); err != nil {
+				t.Fatal(err)
+			}
+
+			logger := log.Logger
+			token, err := worker.getAccessToken(context.Background(), &logger, repository, 1)
+			if err != nil {
+				t.Fatalf("getAccessToken() error = %v", err)
+			}
+			if token != tt.wantToken {
+				t.Errorf("getAccessToken() = %q, want %q", token, tt.wantToken)
+			}
+			if wantRequests := map[string]int{"cached": 0, "new": 1}[tt.wantToken]; requests != wantRequests {
+				t.Errorf("GitHub was asked %d times for a token, want %d", requests, wantRequests)
+			}
+		})
+	}
+}
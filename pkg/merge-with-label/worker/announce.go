@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// announceActions are the pull_request actions checkRun.announce creates a check run for.
+var announceActions = []string{"opened", "synchronize"}
+
+// announce creates an informational check run for pull requests that have none of the merge or update labels,
+// so authors learn about the merge label and what currently blocks the merge.
+// The conditions are only evaluated, nothing is pushed back or rechecked.
+// It reports whether the check run was created, once a label is added the normal flow takes over the check run.
+func (worker *pullRequestWorker) announce(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	action string,
+	details *github.PullRequestDetails,
+) (bool, error) {
+	if !sess.Config.CheckRun.Announce || slices.Index(announceActions, action) == -1 {
+		return false, nil
+	}
+	labels := sess.Config.Merge.Labels.Positives()
+	if len(labels) == 0 ||
+		sess.Config.Merge.Labels.ContainsOneOf(details.Labels...) != "" ||
+		sess.Config.Merge.ImmediateLabels.ContainsOneOf(details.Labels...) != "" ||
+		sess.Config.Update.Labels.ContainsOneOf(details.Labels...) != "" {
+		return false, nil
+	}
+
+	cfg, _ := sess.Config.forAuthor(details.Author)
+	evaluationCfg := *cfg
+	evaluationCfg.Merge.RecheckInterval = 0
+	result, err := evaluateAllConditions(ctx, logger, details, worker.mergeConditions(&evaluationCfg, sess.Repository.DefaultBranch), "")
+	if err != nil && !errors.As(err, new(pushBackError)) {
+		return false, errors.WithStack(err)
+	}
+
+	summary := "no blockers, the pull request can be merged right away"
+	if result.Title != "" {
+		summary = "current blockers: " + result.Title
+		if result.Summary != "" {
+			summary += "\n\n" + result.Summary
+		}
+	}
+	logger.Debug().Msg("announcing merge label")
+	if err := worker.CreateOrUpdateCheckRun(
+		ctx,
+		logger,
+		sess,
+		details.ID,
+		details.LastCommitSha,
+		"COMPLETED",
+		fmt.Sprintf("add the `%s` label to merge automatically", labels[0].Text),
+		summary,
+	); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
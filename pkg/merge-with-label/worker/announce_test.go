@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestPullRequestWorker_announce(t *testing.T) {
+	tests := []struct {
+		name         string
+		announce     bool
+		action       string
+		labels       []string
+		approvals    int
+		wantAnnounce bool
+		wantSummary  string
+	}{
+		{name: "disabled", action: "opened"},
+		{name: "opened with blockers", announce: true, action: "opened", approvals: 2, wantAnnounce: true, wantSummary: "current blockers: missing required approvals"},
+		{name: "synchronize without blockers", announce: true, action: "synchronize", wantAnnounce: true, wantSummary: "no blockers"},
+		{name: "other action", announce: true, action: "edited"},
+		{name: "merge label", announce: true, action: "opened", labels: []string{"merge"}},
+		{name: "update label", announce: true, action: "synchronize", labels: []string{"update"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			sess.Config.CheckRun.Announce = tt.announce
+			sess.Config.Update.Labels = common.RegexSlice{common.MustNewRegexItem("update")}
+			sess.Config.Merge.RequiredApprovals = tt.approvals
+			// rechecks are not used when announcing
+			sess.Config.Merge.RecheckInterval = 1
+			details := newTestPullRequestDetails()
+			details.Labels = tt.labels
+
+			logger := log.Logger
+			announced, err := worker.announce(context.Background(), &logger, sess, tt.action, details)
+			if err != nil {
+				t.Fatalf("announce() error = %v", err)
+			}
+			if announced != tt.wantAnnounce {
+				t.Fatalf("announce() = %v, want %v", announced, tt.wantAnnounce)
+			}
+			if !tt.wantAnnounce {
+				if countOperations(gh, "CreateCheckRun") != 0 {
+					t.Error("CreateCheckRun should not be called")
+				}
+				return
+			}
+			body := gh.LastRequestBody("CreateCheckRun")
+			for _, want := range []string{"add the `merge` label to merge automatically", tt.wantSummary} {
+				if !strings.Contains(body, want) {
+					t.Errorf("CreateCheckRun body %s does not contain %q", body, want)
+				}
+			}
+			if countOperations(gh, "MergePullRequest") != 0 {
+				t.Error("MergePullRequest should not be called when announcing")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// withoutBlockedLabel removes merge.blockedLabel from the labels of the pull request, so the label the bot adds
+// itself is never matched by merge.labels, update.labels or ignoreWithLabels. LabelIDs still contains it.
+func withoutBlockedLabel(cfg *ConfigV1, details *github.PullRequestDetails) {
+	if cfg.Merge.BlockedLabel == "" {
+		return
+	}
+	labels := details.Labels[:0:0]
+	for _, label := range details.Labels {
+		if !strings.EqualFold(label, cfg.Merge.BlockedLabel) {
+			labels = append(labels, label)
+		}
+	}
+	details.Labels = labels
+}
+
+// blockedLabelID returns the node id of merge.blockedLabel if the pull request has it.
+func blockedLabelID(cfg *ConfigV1, details *github.PullRequestDetails) (string, bool) {
+	for name, id := range details.LabelIDs {
+		if strings.EqualFold(name, cfg.Merge.BlockedLabel) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// addBlockedLabel adds merge.blockedLabel to the pull request.
+// That the label was added is tracked in the check runs kv bucket, so it is only added once.
+func (worker *Worker) addBlockedLabel(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+) error {
+	name := sess.Config.Merge.BlockedLabel
+	if name == "" {
+		return nil
+	}
+	if _, ok := blockedLabelID(sess.Config, details); ok {
+		return nil
+	}
+
+	key := common.BlockedLabelKVKey(details.ID)
+	if _, err := worker.CheckRunsKV.Create(key, []byte(name)); err != nil {
+		if errors.Is(err, nats.ErrKeyExists) {
+			logger.Debug().Msg("blocked label was already added")
+			return nil
+		}
+		return errors.Wrap(err, "unable to create blocked label in kv bucket")
+	}
+
+	err := worker.doAddBlockedLabel(ctx, logger, sess, details, name)
+	if err != nil {
+		// allow the next evaluation to try again
+		if err := worker.CheckRunsKV.Delete(key); err != nil {
+			logger.Error().Err(err).Msg("unable to delete blocked label from kv bucket")
+		}
+	}
+	return err
+}
+
+func (worker *Worker) doAddBlockedLabel(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+	name string,
+) error {
+	id, err := github.GetLabelID(ctx, worker.HTTPClient, sess.AccessToken, sess.Repository, name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if id == "" {
+		// reported by the label check, createMissingLabels creates it
+		logger.Warn().Str("label", name).Msg("blocked label does not exist")
+		return nil
+	}
+	logger.Info().Str("label", name).Msg("adding blocked label")
+	if err := github.AddLabels(ctx, worker.HTTPClient, sess.AccessToken, details.ID, []string{id}); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// removeBlockedLabel removes merge.blockedLabel from the pull request.
+func (worker *Worker) removeBlockedLabel(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+) error {
+	if sess.Config.Merge.BlockedLabel == "" {
+		return nil
+	}
+	key := common.BlockedLabelKVKey(details.ID)
+	id, ok := blockedLabelID(sess.Config, details)
+	if !ok {
+		if _, err := worker.CheckRunsKV.Get(key); err != nil {
+			if errors.Is(err, nats.ErrKeyNotFound) {
+				return nil
+			}
+			return errors.Wrap(err, "unable to get blocked label from kv bucket")
+		}
+	} else {
+		logger.Info().Str("label", sess.Config.Merge.BlockedLabel).Msg("removing blocked label")
+		if err := github.RemoveLabels(ctx, worker.HTTPClient, sess.AccessToken, details.ID, []string{id}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := worker.CheckRunsKV.Delete(key); err != nil {
+		return errors.Wrap(err, "unable to delete blocked label from kv bucket")
+	}
+	return nil
+}
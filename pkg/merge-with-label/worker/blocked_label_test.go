@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestWithoutBlockedLabel(t *testing.T) {
+	cfg := &ConfigV1{
+		Merge: MergeConfigV1{
+			BlockedLabel: "merge-blocked",
+			IgnoreConfig: IgnoreConfig{ignoreWithLabels: common.RegexSlice{common.MustNewRegexItem("blocked")}},
+		},
+	}
+	details := newTestPullRequestDetails()
+	details.Labels = []string{"merge", "Merge-Blocked"}
+	details.LabelIDs = map[string]string{"merge": "L_1", "Merge-Blocked": "L_2"}
+
+	withoutBlockedLabel(cfg, details)
+	if !slices.Equal(details.Labels, []string{"merge"}) {
+		t.Errorf("Labels = %v, want [merge]", details.Labels)
+	}
+	if id, ok := blockedLabelID(cfg, details); !ok || id != "L_2" {
+		t.Errorf("blockedLabelID() = %q, %v, want L_2, true", id, ok)
+	}
+
+	worker := Worker{}
+	result, err := worker.shouldSkipBecauseOfLabel(&cfg.Merge.IgnoreConfig)(context.Background(), &log.Logger, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SkipAction {
+		t.Errorf("the blocked label must not be treated as an ignore label, got %+v", result)
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_BlockedLabel(t *testing.T) {
+	t.Run("label is added once when the merge is blocked", func(t *testing.T) {
+		gh := newFakeGitHub()
+		gh.responses["GetLabelID"] = `{"data":{"repository":{"label":{"id":"L_2"}}}}`
+		worker := newTestPullRequestWorker(gh)
+		sess := newTestSession()
+		sess.Config.Merge.BlockedLabel = "merge-blocked"
+		sess.Config.Merge.RequirePRBodyNotEmpty = true
+
+		logger := log.Logger
+		for i := 0; i < 2; i++ {
+			stop, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, newTestPullRequestDetails())
+			if err != nil {
+				t.Fatalf("mergePullRequest() error = %v", err)
+			}
+			if !stop || didMerge {
+				t.Fatalf("mergePullRequest() = %v, %v, want the merge to be skipped", stop, didMerge)
+			}
+		}
+		if got := countOperations(gh, "AddLabels"); got != 1 {
+			t.Errorf("AddLabels called %d times, want 1", got)
+		}
+		body := gh.LastRequestBody("AddLabels")
+		if !strings.Contains(body, `"labelableId":"PR_1"`) || !strings.Contains(body, `"L_2"`) {
+			t.Errorf("AddLabels body = %s", body)
+		}
+	})
+
+	t.Run("label is removed when the pull request can be merged", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		sess := newTestSession()
+		sess.Config.Merge.BlockedLabel = "merge-blocked"
+		details := newTestPullRequestDetails()
+		details.LabelIDs = map[string]string{"merge": "L_1", "merge-blocked": "L_2"}
+		if _, err := worker.CheckRunsKV.Create(common.BlockedLabelKVKey(details.ID), []byte("merge-blocked")); err != nil {
+			t.Fatal(err)
+		}
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+		if err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+		if !didMerge {
+			t.Fatal("expected pull request to be merged")
+		}
+		ops := gh.Operations()
+		if removeAt, mergeAt := slices.Index(ops, "RemoveLabels"), slices.Index(ops, "MergePullRequest"); removeAt == -1 || removeAt > mergeAt {
+			t.Errorf("operations = %v, want RemoveLabels before MergePullRequest", ops)
+		}
+		if body := gh.LastRequestBody("RemoveLabels"); !strings.Contains(body, `"L_2"`) {
+			t.Errorf("RemoveLabels body = %s", body)
+		}
+		if _, err := worker.CheckRunsKV.Get(common.BlockedLabelKVKey(details.ID)); !errors.Is(err, nats.ErrKeyNotFound) {
+			t.Errorf("blocked label flag should be deleted, got %v", err)
+		}
+	})
+
+	t.Run("label is removed when the merge label is removed", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		sess := newTestSession()
+		sess.Config.Merge.BlockedLabel = "merge-blocked"
+		details := newTestPullRequestDetails()
+		details.Labels = nil
+		details.LabelIDs = map[string]string{"merge-blocked": "L_2"}
+		if _, err := worker.CheckRunsKV.Create(common.BlockedLabelKVKey(details.ID), []byte("merge-blocked")); err != nil {
+			t.Fatal(err)
+		}
+
+		logger := log.Logger
+		stop, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+		if err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+		if stop || didMerge {
+			t.Fatalf("mergePullRequest() = %v, %v, want nothing to happen", stop, didMerge)
+		}
+		if body := gh.LastRequestBody("RemoveLabels"); !strings.Contains(body, `"L_2"`) {
+			t.Errorf("RemoveLabels body = %s", body)
+		}
+		if _, err := worker.CheckRunsKV.Get(common.BlockedLabelKVKey(details.ID)); !errors.Is(err, nats.ErrKeyNotFound) {
+			t.Errorf("blocked label flag should be deleted, got %v", err)
+		}
+	})
+
+	t.Run("label is removed when the pull request is closed", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		sess := newTestSession()
+		sess.Config.Merge.BlockedLabel = "merge-blocked"
+		details := newTestPullRequestDetails()
+		details.State = "CLOSED"
+		details.LabelIDs = map[string]string{"merge": "L_1", "merge-blocked": "L_2"}
+		if _, err := worker.CheckRunsKV.Create(common.BlockedLabelKVKey(details.ID), []byte("merge-blocked")); err != nil {
+			t.Fatal(err)
+		}
+
+		logger := log.Logger
+		if err := worker.cleanupClosedPullRequest(context.Background(), &logger, sess, details); err != nil {
+			t.Fatalf("cleanupClosedPullRequest() error = %v", err)
+		}
+		if body := gh.LastRequestBody("RemoveLabels"); !strings.Contains(body, `"L_2"`) {
+			t.Errorf("RemoveLabels body = %s", body)
+		}
+		if _, err := worker.CheckRunsKV.Get(common.BlockedLabelKVKey(details.ID)); !errors.Is(err, nats.ErrKeyNotFound) {
+			t.Errorf("blocked label flag should be deleted, got %v", err)
+		}
+	})
+
+	t.Run("nothing happens without the setting", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		sess := newTestSession()
+		sess.Config.Merge.RequirePRBodyNotEmpty = true
+
+		logger := log.Logger
+		if _, _, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, newTestPullRequestDetails()); err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+		if ops := gh.Operations(); slices.Contains(ops, "GetLabelID") || slices.Contains(ops, "AddLabels") {
+			t.Errorf("operations = %v, want no label mutations", ops)
+		}
+	})
+}
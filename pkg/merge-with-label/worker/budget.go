@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// budgetWarningRatio is the share of its budget after which a handler logs a warning.
+const budgetWarningRatio = 0.8
+
+// withBudget returns a context that is canceled after budget and a function that must be called once the handler
+// is done. It logs a warning if the handler used more than budgetWarningRatio of its budget, so the budget can be
+// raised before messages start to fail.
+func (worker *Worker) withBudget(logger *zerolog.Logger, budget time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	start := worker.timeNow()
+	return ctx, func() {
+		cancel()
+		if elapsed := worker.timeNow().Sub(start); elapsed > time.Duration(float64(budget)*budgetWarningRatio) {
+			logger.Warn().
+				Dur("elapsed", elapsed).
+				Dur("budget", budget).
+				Msg("handler used most of its budget, consider raising it")
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWorker_withBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		wantWarn bool
+	}{
+		{name: "well within the budget", elapsed: 30 * time.Second},
+		{name: "exactly 80% of the budget", elapsed: 48 * time.Second},
+		{name: "more than 80% of the budget", elapsed: 50 * time.Second, wantWarn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+			worker := &Worker{now: func() time.Time { return now }}
+			var buf bytes.Buffer
+			logger := zerolog.New(&buf)
+
+			ctx, done := worker.withBudget(&logger, time.Minute)
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+				t.Errorf("context deadline = %v, want one minute from now", deadline)
+			}
+			now = now.Add(tt.elapsed)
+			done()
+
+			if ctx.Err() == nil {
+				t.Error("context was not canceled")
+			}
+			if got := strings.Contains(buf.String(), "handler used most of its budget"); got != tt.wantWarn {
+				t.Errorf("warning logged = %v, want %v: %s", got, tt.wantWarn, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package worker
+
+import (
+	"github.com/nats-io/nats.go"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/metrics"
+)
+
+// countKVLookup records whether a lookup in the kv bucket returned a usable entry.
+func countKVLookup(kv nats.KeyValue, hit bool) {
+	if hit {
+		metrics.KVCacheHits.Inc(kv.Bucket())
+		return
+	}
+	metrics.KVCacheMisses.Inc(kv.Bucket())
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/metrics"
+)
+
+func TestCreateOrUpdateCheckRun_CountsKVLookups(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["CreateCheckRun"] = `{"data":{"createCheckRun":{"checkRun":{"id":"CR_1"}}}}`
+	worker := newTestPullRequestWorker(gh)
+	sess := newTestSession()
+	logger := log.Logger
+
+	hits, misses := metrics.KVCacheHits.Get("fake"), metrics.KVCacheMisses.Get("fake")
+	for i := 0; i < 2; i++ {
+		if err := worker.CreateOrUpdateCheckRun(
+			context.Background(), &logger, sess, "PR_1", "abc", "COMPLETED", "title", "",
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first call creates the check run, the second one finds it in the bucket
+	if got := metrics.KVCacheMisses.Get("fake") - misses; got != 1 {
+		t.Errorf("misses = %d, want 1", got)
+	}
+	if got := metrics.KVCacheHits.Get("fake") - hits; got != 1 {
+		t.Errorf("hits = %d, want 1", got)
+	}
+}
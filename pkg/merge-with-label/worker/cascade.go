@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// queueSweepAfterMerge queues a push message for the base branch of the merged pull request,
+// so the remaining labeled pull requests are evaluated right away instead of waiting for their next event.
+// The message is rate limited like every other message, so bursts of merges result in a single sweep.
+// Queueing is disabled if PushSubject is empty.
+func (worker *Worker) queueSweepAfterMerge(
+	logger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+) error {
+	if worker.PushSubject == "" {
+		return nil
+	}
+	err := common.QueueMessage(
+		logger,
+		worker.JetStreamContext,
+		worker.RateLimitKV,
+		worker.RateLimitInterval,
+		worker.PushSubject+"."+uuid.NewString(),
+		fmt.Sprintf("merged.%d.%s.%s", sess.InstallationID, sess.Repository.NodeID, details.BaseRefName),
+		"",
+		&common.QueuePushMessage{
+			BaseMessage: common.BaseMessage{
+				InstallationID: sess.InstallationID,
+				Repository:     *sess.Repository,
+			},
+			Branch: details.BaseRefName,
+		})
+	if err != nil {
+		return errors.Wrap(err, "unable to publish push message to queue")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+func TestPullRequestWorker_mergePullRequest_Cascade(t *testing.T) {
+	tests := []struct {
+		name    string
+		cascade *bool
+		merges  int
+		want    int
+	}{
+		{name: "default", merges: 1, want: 1},
+		{name: "enabled", cascade: boolPtr(true), merges: 1, want: 1},
+		{name: "disabled", cascade: boolPtr(false), merges: 1, want: 0},
+		// the second sweep is within the rate limit interval, nats drops it by its message id
+		{name: "burst of merges", merges: 2, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			js := &fakePublisher{}
+			worker.JetStreamContext = js
+			worker.RateLimitKV = newFakeKV()
+			worker.RateLimitInterval = time.Minute
+			worker.PushSubject = "push"
+			sess := newTestSession()
+			sess.Config.Merge.Cascade = tt.cascade
+
+			logger := log.Logger
+			for i := 0; i < tt.merges; i++ {
+				if _, _, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, newTestPullRequestDetails()); err != nil {
+					t.Fatalf("mergePullRequest() error = %v", err)
+				}
+			}
+
+			var sweeps int
+			for _, msg := range js.published {
+				if !strings.HasPrefix(msg.Subject, "push.") {
+					t.Errorf("published to %s, want the push subject", msg.Subject)
+				}
+				if msg.Header.Get(nats.MsgIdHdr) == "" {
+					sweeps++
+				}
+			}
+			if sweeps != tt.want {
+				t.Errorf("got %d sweeps, want %d", sweeps, tt.want)
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// KVCheckRunNameStore reads the check run names from the configs the worker cached in the configs kv bucket, so
+// the name respects merge.checkRunName of the repository.
+// The name is not known until the worker loaded the config of the repository.
+type KVCheckRunNameStore struct {
+	KV                 nats.KeyValue
+	CheckRunNamePrefix string
+	BotName            string
+}
+
+func (s *KVCheckRunNameStore) CheckRunName(repository *common.Repository) (string, error) {
+	entry, err := s.KV.Get(hashForKV(repository))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "unable to get config from kv bucket")
+	}
+	if len(entry.Value()) == 0 {
+		return "", nil
+	}
+
+	var config cachedConfig
+	if err := json.Unmarshal(entry.Value(), &config); err != nil {
+		return "", errors.Wrap(err, "unable to decode config from kv bucket")
+	}
+	if config.ConfigV1 == nil {
+		return "", nil
+	}
+	worker := Worker{CheckRunNamePrefix: s.CheckRunNamePrefix, BotName: s.BotName}
+	return worker.checkRunNameOf(&config.Merge), nil
+}
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestKVCheckRunNameStore_CheckRunName(t *testing.T) {
+	kv := newFakeKV()
+	store := &KVCheckRunNameStore{KV: kv, CheckRunNamePrefix: "ci/", BotName: "merge-with-label"}
+	repository := &common.Repository{NodeID: "R_1", FullName: "owner/repo"}
+
+	// the worker did not load the config yet
+	if got, err := store.CheckRunName(repository); err != nil || got != "" {
+		t.Fatalf("CheckRunName() = %q, %v, want no name", got, err)
+	}
+
+	put := func(config *ConfigV1) {
+		t.Helper()
+		buf, err := json.Marshal(cachedConfig{ConfigV1: config, SHA: "abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kv.Put(hashForKV(repository), buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put(&ConfigV1{})
+	if got, err := store.CheckRunName(repository); err != nil || got != "ci/merge-with-label" {
+		t.Errorf("CheckRunName() = %q, %v, want ci/merge-with-label", got, err)
+	}
+
+	put(&ConfigV1{Merge: MergeConfigV1{CheckRunName: "deploy"}})
+	if got, err := store.CheckRunName(repository); err != nil || got != "ci/deploy" {
+		t.Errorf("CheckRunName() = %q, %v, want ci/deploy", got, err)
+	}
+}
@@ -2,14 +2,70 @@ package worker
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/buildinfo"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
 )
 
+// checkRunSummaryWithFooter appends the version and the notes to the summary.
+func checkRunSummaryWithFooter(summary string, notes ...string) string {
+	footer := "<sub>merge-with-label " + buildinfo.Get().Version
+	for _, note := range notes {
+		footer += " · " + note
+	}
+	footer += "</sub>"
+	if strings.TrimSpace(summary) == "" {
+		return footer
+	}
+	return summary + "\n\n---\n" + footer
+}
+
+// renderConfigProblems lists the invalid fields of the config that were ignored.
+func renderConfigProblems(problems []ConfigProblem) string {
+	var sb strings.Builder
+	sb.WriteString("**The config contains errors, these fields are ignored:**\n")
+	for _, problem := range problems {
+		sb.WriteString("- " + problem.String() + "\n")
+	}
+	return sb.String()
+}
+
+// configProblemsTitle returns the check run title for the problems of the config.
+func configProblemsTitle(problems []ConfigProblem) string {
+	if len(problems) == 1 {
+		return "config error: " + problems[0].String()
+	}
+	return fmt.Sprintf("config error: %d invalid fields", len(problems))
+}
+
+// checkRunName returns the name of the check run, prefixed with CheckRunNamePrefix.
+func (worker *Worker) checkRunName(sess *session) string {
+	if sess.Config != nil {
+		return worker.checkRunNameOf(&sess.Config.Merge)
+	}
+	return worker.CheckRunNamePrefix + worker.BotName
+}
+
+// checkRunNameOf returns the name of the check run for the merge config cfg, prefixed with CheckRunNamePrefix.
+func (worker *Worker) checkRunNameOf(cfg *MergeConfigV1) string {
+	if cfg.CheckRunName != "" {
+		return worker.CheckRunNamePrefix + cfg.CheckRunName
+	}
+	return worker.CheckRunNamePrefix + worker.BotName
+}
+
+// isOwnCheckRun reports whether the check is the check run the bot reports its own state in.
+func (worker *Worker) isOwnCheckRun(cfg *MergeConfigV1, check *github.CheckState) bool {
+	return check.Source == github.CheckStateSourceCheckRun && check.Name == check.App+"/"+worker.checkRunNameOf(cfg)
+}
+
 func (worker *Worker) CreateOrUpdateCheckRun(
 	ctx context.Context,
 	rootLogger *zerolog.Logger,
@@ -19,33 +75,62 @@ func (worker *Worker) CreateOrUpdateCheckRun(
 	status,
 	title,
 	summary string,
+	annotations ...github.CheckRunAnnotation,
 ) error {
 	if sha == "" {
 		return nil
 	}
 
+	name := worker.checkRunName(sess)
 	logger := rootLogger.With().
 		Str("sha", sha).
+		Str("check_run_name", name).
 		Logger()
 
-	key := hashForKV(pullRequestNodeID + sha)
+	if len(sess.ConfigProblems) != 0 {
+		problems := renderConfigProblems(sess.ConfigProblems)
+		if strings.TrimSpace(summary) != "" {
+			problems += "\n"
+		}
+		summary = problems + summary
+	}
+
+	if sess.Config != nil && sess.Config.CheckRun.ShowConfig {
+		details, err := renderConfigDetails(sess.Config, sess.ConfigSource)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if strings.TrimSpace(summary) != "" {
+			summary += "\n\n"
+		}
+		summary += details
+	}
+	var notes []string
+	if sess.MergeOverride != "" {
+		notes = append(notes, "applied "+sess.MergeOverride)
+	}
+	summary = checkRunSummaryWithFooter(summary, notes...)
+
+	key := checkRunKey(name, pullRequestNodeID, sha)
 	entry, err := worker.CheckRunsKV.Get(key)
 	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
 		return errors.Wrap(err, "unable to get check_run_id from kv bucket")
 	}
 	if entry == nil || len(entry.Value()) == 0 || errors.Is(err, nats.ErrKeyNotFound) {
+		countKVLookup(worker.CheckRunsKV, false)
 		logger.Debug().
 			Msg("creating a new check run")
-		checkRunID, err := github.CreateCheckRun(
+		checkRunID, err := github.CreateCheckRunWithAnnotations(
 			ctx,
 			worker.HTTPClient,
 			sess.AccessToken,
 			sess.Repository,
 			sha,
 			status,
-			worker.BotName,
+			name,
 			title,
 			summary,
+			annotations,
 		)
 		if err != nil {
 			return errors.Wrap(err, "error creating check run")
@@ -55,17 +140,19 @@ func (worker *Worker) CreateOrUpdateCheckRun(
 		}
 		return nil
 	}
+	countKVLookup(worker.CheckRunsKV, true)
 
-	checkRunID, err := github.UpdateCheckRun(
+	checkRunID, err := github.UpdateCheckRunWithAnnotations(
 		ctx,
 		worker.HTTPClient,
 		sess.AccessToken,
 		sess.Repository,
 		string(entry.Value()),
 		status,
-		worker.BotName,
+		name,
 		title,
 		summary,
+		annotations,
 	)
 	if err != nil {
 		return errors.Wrap(err, "error updating check run")
@@ -75,3 +162,62 @@ func (worker *Worker) CreateOrUpdateCheckRun(
 	}
 	return nil
 }
+
+func checkRunKey(name, pullRequestNodeID, sha string) string {
+	return common.CheckRunKVKey(name, pullRequestNodeID, sha)
+}
+
+// CloseCheckRun completes the check run of the pull request with the given title and removes it from the kv bucket.
+// Nothing is done if no check run was created for the sha.
+func (worker *Worker) CloseCheckRun(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	pullRequestNodeID,
+	sha,
+	title string,
+) error {
+	if sha == "" {
+		return nil
+	}
+
+	name := worker.checkRunName(sess)
+	logger := rootLogger.With().
+		Str("sha", sha).
+		Str("check_run_name", name).
+		Logger()
+
+	key := checkRunKey(name, pullRequestNodeID, sha)
+	entry, err := worker.CheckRunsKV.Get(key)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			countKVLookup(worker.CheckRunsKV, false)
+			logger.Debug().Msg("no check run to close")
+			return nil
+		}
+		return errors.Wrap(err, "unable to get check_run_id from kv bucket")
+	}
+
+	countKVLookup(worker.CheckRunsKV, len(entry.Value()) != 0)
+	if len(entry.Value()) != 0 {
+		logger.Debug().Msg("closing check run")
+		if _, err := github.UpdateCheckRun(
+			ctx,
+			worker.HTTPClient,
+			sess.AccessToken,
+			sess.Repository,
+			string(entry.Value()),
+			"COMPLETED",
+			name,
+			title,
+			checkRunSummaryWithFooter(""),
+		); err != nil {
+			return errors.Wrap(err, "error updating check run")
+		}
+	}
+
+	if err := worker.CheckRunsKV.Delete(key); err != nil {
+		return errors.Wrap(err, "unable to delete check_run_id from kv bucket")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestCreateOrUpdateCheckRun_NamePrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		checkRunName string
+		want         string
+	}{
+		{name: "bot name", want: "team-a/merge-with-label"},
+		{name: "configured name", checkRunName: "merge", want: "team-a/merge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["CreateCheckRun"] = `{"data":{"createCheckRun":{"checkRun":{"id":"CR_1"}}}}`
+			gh.responses["UpdateCheckRun"] = `{"data":{"updateCheckRun":{"checkRun":{"id":"CR_1"}}}}`
+			worker := newTestPullRequestWorker(gh)
+			worker.CheckRunNamePrefix = "team-a/"
+			sess := newTestSession()
+			sess.Config.Merge.CheckRunName = tt.checkRunName
+			logger := log.Logger
+
+			for i := 0; i < 2; i++ {
+				if err := worker.CreateOrUpdateCheckRun(
+					context.Background(), &logger, sess, "PR_1", "abc", "COMPLETED", "title", "",
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, operation := range []string{"CreateCheckRun", "UpdateCheckRun"} {
+				if body := gh.LastRequestBody(operation); !strings.Contains(body, `"name":"`+tt.want+`"`) {
+					t.Errorf("%s body %s does not contain the name %q", operation, body, tt.want)
+				}
+			}
+			if _, err := worker.CheckRunsKV.Get(checkRunKey(tt.want, "PR_1", "abc")); err != nil {
+				t.Errorf("check run id is not stored with the prefixed name: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateCheckRun_Annotations(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["CreateCheckRun"] = `{"data":{"createCheckRun":{"checkRun":{"id":"CR_1"}}}}`
+	gh.responses["UpdateCheckRun"] = `{"data":{"updateCheckRun":{"checkRun":{"id":"CR_1"}}}}`
+	worker := newTestPullRequestWorker(gh)
+	sess := newTestSession()
+	logger := log.Logger
+	annotation := github.CheckRunAnnotation{
+		Path:            "go.mod",
+		Location:        github.CheckRunAnnotationRange{StartLine: 1, EndLine: 1},
+		AnnotationLevel: github.CheckRunAnnotationLevelFailure,
+		Message:         "changes to go.mod are not allowed",
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := worker.CreateOrUpdateCheckRun(
+			context.Background(), &logger, sess, "PR_1", "abc", "COMPLETED", "title", "", annotation,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, operation := range []string{"CreateCheckRun", "UpdateCheckRun"} {
+		body := gh.LastRequestBody(operation)
+		if !strings.Contains(body, `"path":"go.mod"`) || !strings.Contains(body, `"annotationLevel":"FAILURE"`) {
+			t.Errorf("%s body %s does not contain the annotation", operation, body)
+		}
+	}
+}
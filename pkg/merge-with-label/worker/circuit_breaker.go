@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// circuitState is stored in CircuitBreakerKV for repositories whose last messages failed.
+type circuitState struct {
+	// Failures is the number of consecutive hard failures.
+	Failures int `json:"failures"`
+	// OpenUntil is the time until messages for the repository are dropped, the circuit is closed if it is zero.
+	OpenUntil time.Time `json:"open_until"`
+}
+
+func (worker *Worker) circuitBreakerEnabled() bool {
+	return worker.CircuitBreakerKV != nil && worker.CircuitBreakerThreshold > 0
+}
+
+// getCircuitState returns the state of the circuit and the revision it is stored with, the revision is 0 if no
+// state is stored.
+func (worker *Worker) getCircuitState(key string) (circuitState, uint64, error) {
+	var state circuitState
+	entry, err := worker.CircuitBreakerKV.Get(key)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return state, 0, nil
+		}
+		return state, 0, errors.Wrap(err, "unable to get circuit state from kv bucket")
+	}
+	if err := json.Unmarshal(entry.Value(), &state); err != nil {
+		return state, 0, errors.Wrap(err, "unable to decode circuit state")
+	}
+	return state, entry.Revision(), nil
+}
+
+// maxCircuitStateAttempts is how often a failure is stored if other workers changed the circuit state in the
+// meantime.
+const maxCircuitStateAttempts = 32
+
+// circuitAllows reports whether a message for the repository should be processed.
+// While the circuit is open messages are dropped. Once the cooldown passed the circuit is half-open: one message
+// is let through as trial and the circuit stays open for the other messages until the result of the trial is
+// recorded, or the cooldown passed again.
+// recorded reports whether a circuit state might be stored for the repository, it is passed to
+// recordCircuitResult so a success does not need to look the state up again.
+func (worker *Worker) circuitAllows(logger *zerolog.Logger, repository *common.Repository) (allowed, recorded bool) {
+	if !worker.circuitBreakerEnabled() {
+		return true, false
+	}
+	key := common.CircuitBreakerKVKey(repository)
+	state, revision, err := worker.getCircuitState(key)
+	if err != nil {
+		// do not stop working because of the circuit breaker
+		logger.Error().Err(err).Msg("unable to get circuit state")
+		return true, true
+	}
+	if revision == 0 {
+		return true, false
+	}
+	if state.OpenUntil.IsZero() {
+		return true, true
+	}
+	now := worker.timeNow()
+	if now.Before(state.OpenUntil) {
+		return false, true
+	}
+
+	state.OpenUntil = now.Add(worker.CircuitBreakerCooldown)
+	buf, err := json.Marshal(state)
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to encode circuit state")
+		return false, true
+	}
+	if _, err := worker.CircuitBreakerKV.Update(key, buf, revision); err != nil {
+		// another worker claimed the trial
+		logger.Debug().Err(err).Msg("unable to claim the trial of the half-open circuit")
+		return false, true
+	}
+	logger.Info().Int("failures", state.Failures).Msg("circuit is half-open, processing a trial message")
+	return true, true
+}
+
+// recordCircuitResult closes the circuit of the repository after a success and counts a hard failure otherwise.
+// The circuit is opened for CircuitBreakerCooldown once CircuitBreakerThreshold consecutive failures were counted.
+// recorded is the result of circuitAllows, a success is not looked up if no circuit state was stored.
+func (worker *Worker) recordCircuitResult(logger *zerolog.Logger, repository *common.Repository, failed, recorded bool) {
+	if !worker.circuitBreakerEnabled() {
+		return
+	}
+	key := common.CircuitBreakerKVKey(repository)
+	if !failed {
+		if recorded {
+			worker.closeCircuit(logger, key)
+		}
+		return
+	}
+	if err := worker.countCircuitFailure(logger, key); err != nil {
+		logger.Error().Err(err).Msg("unable to count circuit failure")
+	}
+}
+
+func (worker *Worker) closeCircuit(logger *zerolog.Logger, key string) {
+	state, revision, err := worker.getCircuitState(key)
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to get circuit state")
+		return
+	}
+	if revision == 0 {
+		return
+	}
+	if err := worker.CircuitBreakerKV.Delete(key); err != nil {
+		logger.Error().Err(err).Msg("unable to delete circuit state from kv bucket")
+		return
+	}
+	if !state.OpenUntil.IsZero() {
+		logger.Info().Msg("circuit closed")
+	}
+}
+
+// countCircuitFailure increases the failures of the circuit, it uses the revision of the state so failures of
+// other workers are not lost.
+func (worker *Worker) countCircuitFailure(logger *zerolog.Logger, key string) error {
+	for attempt := 0; attempt < maxCircuitStateAttempts; attempt++ {
+		state, revision, err := worker.getCircuitState(key)
+		if err != nil {
+			return err
+		}
+		state.Failures++
+		if state.Failures >= worker.CircuitBreakerThreshold {
+			state.OpenUntil = worker.timeNow().Add(worker.CircuitBreakerCooldown)
+		}
+		buf, err := json.Marshal(state)
+		if err != nil {
+			return errors.Wrap(err, "unable to encode circuit state")
+		}
+		if revision == 0 {
+			_, err = worker.CircuitBreakerKV.Create(key, buf)
+		} else {
+			_, err = worker.CircuitBreakerKV.Update(key, buf, revision)
+		}
+		if err != nil {
+			if !errors.Is(err, nats.ErrKeyExists) {
+				return errors.Wrap(err, "unable to store circuit state in kv bucket")
+			}
+			// another worker changed the circuit in the meantime
+			continue
+		}
+		if !state.OpenUntil.IsZero() {
+			logger.Warn().
+				Int("failures", state.Failures).
+				Time("open_until", state.OpenUntil).
+				Msg("circuit opened, messages for the repository are dropped")
+		}
+		return nil
+	}
+	return errors.New("unable to count the failure, the circuit state was changed too often")
+}
@@ -0,0 +1,222 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/metrics"
+)
+
+func newTestCircuitBreakerWorker(now *time.Time) *Worker {
+	return &Worker{
+		AllowedRepositories:     common.RegexSlice{common.MustNewRegexItem(".*")},
+		CircuitBreakerKV:        newFakeKV(),
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  10 * time.Minute,
+		now:                     func() time.Time { return *now },
+	}
+}
+
+// deliverCircuitBreakerMessage handles a message for the repository and reports whether fn was called.
+func deliverCircuitBreakerMessage(worker *Worker, repo string, err error) bool {
+	logger := zerolog.Nop()
+	msg := &nats.Msg{
+		Sub:     &nats.Subscription{},
+		Subject: "pull_request.1",
+		Reply:   "$JS.ACK.stream.consumer.1.10.10.1690000000000000000.0",
+		Data:    []byte(`{"repository":{"full_name":"` + repo + `"}}`),
+	}
+	called := false
+	handleMessage[common.QueuePullRequestMessage](worker, &logger, msg,
+		func(*zerolog.Logger, *common.QueuePullRequestMessage) error {
+			called = true
+			return err
+		})
+	return called
+}
+
+func TestHandleMessage_CircuitBreaker(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	worker := newTestCircuitBreakerWorker(&now)
+	boom := errors.New("boom")
+	const repo = "owner/circuit"
+	drops := metrics.CircuitBreakerDrops.Get(repo)
+
+	// closed: failures below the threshold are processed
+	if !deliverCircuitBreakerMessage(worker, repo, boom) {
+		t.Fatal("message was not processed while the circuit is closed")
+	}
+	// the second failure opens the circuit
+	if !deliverCircuitBreakerMessage(worker, repo, boom) {
+		t.Fatal("message was not processed while the circuit is closed")
+	}
+
+	// open: messages are dropped, other repositories are not affected
+	now = now.Add(5 * time.Minute)
+	if deliverCircuitBreakerMessage(worker, repo, nil) {
+		t.Error("message was processed while the circuit is open")
+	}
+	if got := metrics.CircuitBreakerDrops.Get(repo) - drops; got != 1 {
+		t.Errorf("dropped messages = %d, want 1", got)
+	}
+	if !deliverCircuitBreakerMessage(worker, "owner/other", nil) {
+		t.Error("message of another repository was not processed")
+	}
+
+	// half-open: the failed trial opens the circuit again
+	now = now.Add(5 * time.Minute)
+	if !deliverCircuitBreakerMessage(worker, repo, boom) {
+		t.Fatal("trial message was not processed after the cooldown")
+	}
+	now = now.Add(5 * time.Minute)
+	if deliverCircuitBreakerMessage(worker, repo, nil) {
+		t.Error("message was processed after the trial failed")
+	}
+
+	// half-open: the successful trial closes the circuit
+	now = now.Add(5 * time.Minute)
+	if !deliverCircuitBreakerMessage(worker, repo, nil) {
+		t.Fatal("trial message was not processed after the cooldown")
+	}
+	if !deliverCircuitBreakerMessage(worker, repo, boom) {
+		t.Error("message was not processed after the circuit was closed")
+	}
+	if !deliverCircuitBreakerMessage(worker, repo, nil) {
+		t.Error("the failures were not reset by the successful trial")
+	}
+}
+
+func TestHandleMessage_CircuitBreaker_Success(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	worker := newTestCircuitBreakerWorker(&now)
+	boom := errors.New("boom")
+	const repo = "owner/repo"
+
+	// a success resets the consecutive failures
+	deliverCircuitBreakerMessage(worker, repo, boom)
+	deliverCircuitBreakerMessage(worker, repo, nil)
+	deliverCircuitBreakerMessage(worker, repo, boom)
+	if !deliverCircuitBreakerMessage(worker, repo, nil) {
+		t.Error("circuit opened without consecutive failures")
+	}
+
+	// push backs are no hard failures
+	for i := 0; i < 3; i++ {
+		deliverCircuitBreakerMessage(worker, repo, pushBackError{delay: time.Second})
+	}
+	if !deliverCircuitBreakerMessage(worker, repo, nil) {
+		t.Error("circuit opened because of push backs")
+	}
+}
+
+func TestWorker_circuitAllows_SingleTrial(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	worker := newTestCircuitBreakerWorker(&now)
+	logger := zerolog.Nop()
+	repository := &common.Repository{FullName: "owner/repo"}
+
+	worker.recordCircuitResult(&logger, repository, true, false)
+	worker.recordCircuitResult(&logger, repository, true, true)
+	now = now.Add(10 * time.Minute)
+
+	if allowed, _ := worker.circuitAllows(&logger, repository); !allowed {
+		t.Fatal("trial message was not allowed after the cooldown")
+	}
+	// the trial is still running, e.g. on another worker
+	if allowed, _ := worker.circuitAllows(&logger, repository); allowed {
+		t.Error("a second message was allowed while the circuit is half-open")
+	}
+	// the trial never reported back, the next trial is allowed after another cooldown
+	now = now.Add(10 * time.Minute)
+	if allowed, _ := worker.circuitAllows(&logger, repository); !allowed {
+		t.Error("trial message was not allowed after the cooldown of the lost trial")
+	}
+}
+
+// concurrentKV calls beforeWrite right before the first write, e.g. to simulate another worker.
+type concurrentKV struct {
+	*fakeKV
+	gets        int
+	beforeWrite func()
+}
+
+func (kv *concurrentKV) Get(key string) (nats.KeyValueEntry, error) {
+	kv.gets++
+	return kv.fakeKV.Get(key)
+}
+
+func (kv *concurrentKV) write() {
+	if beforeWrite := kv.beforeWrite; beforeWrite != nil {
+		kv.beforeWrite = nil
+		beforeWrite()
+	}
+}
+
+func (kv *concurrentKV) Create(key string, value []byte) (uint64, error) {
+	kv.write()
+	return kv.fakeKV.Create(key, value)
+}
+
+func (kv *concurrentKV) Update(key string, value []byte, last uint64) (uint64, error) {
+	kv.write()
+	return kv.fakeKV.Update(key, value, last)
+}
+
+func TestWorker_recordCircuitResult(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	worker := newTestCircuitBreakerWorker(&now)
+	worker.CircuitBreakerThreshold = 3
+	kv := &concurrentKV{fakeKV: newFakeKV()}
+	worker.CircuitBreakerKV = kv
+	logger := zerolog.Nop()
+	repository := &common.Repository{FullName: "owner/repo"}
+	key := common.CircuitBreakerKVKey(repository)
+
+	// a success without a recorded failure does not look up the state
+	worker.recordCircuitResult(&logger, repository, false, false)
+	if kv.gets != 0 {
+		t.Errorf("looked up the circuit state %d times, want 0", kv.gets)
+	}
+
+	// failures of other workers are not lost
+	for i := 0; i < 2; i++ {
+		kv.beforeWrite = func() {
+			if err := worker.countCircuitFailure(&logger, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		worker.recordCircuitResult(&logger, repository, true, true)
+	}
+	state, _, err := worker.getCircuitState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Failures != 4 || state.OpenUntil.IsZero() {
+		t.Errorf("state = %+v, want 4 failures and an open circuit", state)
+	}
+
+	// a success closes the circuit
+	worker.recordCircuitResult(&logger, repository, false, true)
+	if _, revision, _ := worker.getCircuitState(key); revision != 0 {
+		t.Error("circuit state was not deleted")
+	}
+}
+
+func TestWorker_circuitAllows_Disabled(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	worker := newTestCircuitBreakerWorker(&now)
+	worker.CircuitBreakerThreshold = 0
+	for i := 0; i < 5; i++ {
+		if !deliverCircuitBreakerMessage(worker, "owner/repo", errors.New("boom")) {
+			t.Fatal("message was not processed with a disabled circuit breaker")
+		}
+	}
+	if keys, _ := worker.CircuitBreakerKV.Keys(); len(keys) != 0 {
+		t.Errorf("stored %d circuit states, want 0", len(keys))
+	}
+}
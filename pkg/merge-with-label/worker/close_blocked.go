@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// closeWhenBlockedReasons are the skip reasons that count as blocked for closeWhenBlocked.
+// Pull requests that are ignored, drafts or from forbidden authors are never closed, neither are pull requests
+// with missing checks, every pull request misses its checks right after a push.
+var closeWhenBlockedReasons = []SkipReason{
+	SkipReasonConflicts,
+	SkipReasonNotMergeable,
+	SkipReasonLinearHistory,
+	SkipReasonTooManyCommits,
+	SkipReasonMissingApprovals,
+	SkipReasonFailedChecks,
+	SkipReasonUnsignedCommit,
+	SkipReasonEmptyBody,
+}
+
+// defaultCloseWhenBlockedAfterHours is used if closeWhenBlocked is enabled and no closeWhenBlockedAfterHours is set.
+const defaultCloseWhenBlockedAfterHours = 72
+
+// closeIfBlockedTooLong closes the pull request if merging was blocked for more than closeWhenBlockedAfterHours.
+// The time the block started is stored in the check runs kv bucket, it is refreshed with every evaluation so it
+// does not expire while the pull request is still blocked.
+func (worker *Worker) closeIfBlockedTooLong(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+	reason SkipReason,
+) (closed bool, err error) {
+	if !sess.Config.Merge.CloseWhenBlocked || slices.Index(closeWhenBlockedReasons, reason) == -1 {
+		return false, nil
+	}
+
+	now := worker.timeNow()
+	since := now
+	key := common.BlockedSinceKVKey(details.ID)
+	entry, err := worker.CheckRunsKV.Get(key)
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return false, errors.Wrap(err, "unable to get blocked since from kv bucket")
+	}
+	if err == nil && entry != nil {
+		if t, err := time.Parse(time.RFC3339, string(entry.Value())); err == nil {
+			since = t
+		}
+	}
+
+	logger := rootLogger.With().Time("blocked_since", since).Logger()
+	hours := sess.Config.Merge.CloseWhenBlockedAfterHours
+	if hours <= 0 {
+		hours = defaultCloseWhenBlockedAfterHours
+	}
+	closeAfter := time.Duration(hours) * time.Hour
+	if now.Sub(since) < closeAfter {
+		logger.Debug().Msg("pull request is blocked, but not long enough to close it")
+		if _, err := worker.CheckRunsKV.PutString(key, since.Format(time.RFC3339)); err != nil {
+			return false, errors.Wrap(err, "unable to store blocked since in kv bucket")
+		}
+		return false, nil
+	}
+
+	logger.Info().Str("skip_reason", string(reason)).Msg("closing pull request, it was blocked for too long")
+	if err := github.ClosePullRequest(ctx, worker.HTTPClient, sess.AccessToken, details.ID); err != nil {
+		return false, errors.WithStack(err)
+	}
+	if err := worker.clearBlockedSince(details.ID); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// clearBlockedSince removes the time the pull request got blocked from the check runs kv bucket.
+func (worker *Worker) clearBlockedSince(pullRequestNodeID string) error {
+	if err := worker.CheckRunsKV.Delete(common.BlockedSinceKVKey(pullRequestNodeID)); err != nil {
+		return errors.Wrap(err, "unable to delete blocked since from kv bucket")
+	}
+	return nil
+}
@@ -3,10 +3,17 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
@@ -33,30 +40,425 @@ const (
 	RebaseMergeStrategy MergeStrategy = "rebase"
 )
 
+type MergeOrder string
+
+const (
+	// OldestFirstOrder queues the pull request with the lowest number first, this is the default.
+	OldestFirstOrder MergeOrder = "oldest"
+	// NewestFirstOrder queues the pull request with the highest number first.
+	NewestFirstOrder MergeOrder = "newest"
+)
+
 type ConfigHeader struct {
+	// Version of the config file, must be 1.
 	Version int `yaml:"version"`
 }
 
 type ConfigV1 struct {
 	ConfigHeader
-	Merge  MergeConfigV1  `yaml:"merge"`
+	// ExtendsURL is an https url on raw.githubusercontent.com or api.github.com or a path (relative to the repository
+	// root) to a parent config.
+	// The parent config is used as base, values of this config take precedence.
+	ExtendsURL string `yaml:"extendsURL"`
+	// Merge configures when and how pull requests are merged.
+	Merge MergeConfigV1 `yaml:"merge"`
+	// Update configures when pull requests are updated with their base branch.
 	Update UpdateConfigV1 `yaml:"update"`
+	// LabelDeletedNotification controls how admins are notified when a configured label was deleted.
+	LabelDeletedNotification NotificationType `yaml:"labelDeletedNotification"`
+	// CreateMissingLabels creates the labels of merge.labels, merge.immediateLabels and update.labels that do not
+	// exist in the repository, instead of reporting them in a check run. Only names, not regexes, are created.
+	CreateMissingLabels bool `yaml:"createMissingLabels"`
+	// CheckRun configures the output of the check run the bot creates.
+	CheckRun CheckRunConfigV1 `yaml:"checkRun"`
+	// Matching controls how the regex patterns of this config are matched.
+	Matching MatchingMode `yaml:"matching"`
+	// CaseSensitive makes all patterns of this config case-sensitive, patterns are case-insensitive by default.
+	CaseSensitive bool `yaml:"caseSensitive"`
 }
 
+type MatchingMode string
+
+const (
+	// RegexMatching matches patterns anywhere in the value, this is the default.
+	RegexMatching MatchingMode = "regex"
+	// AnchoredMatching requires patterns to match the whole value.
+	AnchoredMatching MatchingMode = "anchored"
+)
+
+type CheckRunConfigV1 struct {
+	// ShowConfig appends the effective config and its source to the check run summary.
+	ShowConfig bool `yaml:"showConfig"`
+	// Announce creates a check run on pull requests that are opened or pushed to without a merge or update label,
+	// that explains the merge label and lists what currently blocks the merge.
+	Announce bool `yaml:"announce"`
+}
+
+type NotificationType string
+
+const (
+	// CheckRunNotification creates a check run on the head of the default branch, this is the default.
+	CheckRunNotification NotificationType = "checkRun"
+	// IssueNotification opens an issue in the repository.
+	IssueNotification NotificationType = "issue"
+	// NoNotification disables the notification.
+	NoNotification NotificationType = "none"
+)
+
 type MergeConfigV1 struct {
-	Labels               common.RegexSlice `yaml:"labels"`
-	Strategy             MergeStrategy     `yaml:"strategy"`
-	RequiredApprovals    int               `yaml:"requiredApprovals"`
-	RequireApprovalsFrom common.RegexSlice `yaml:"requireApprovalsFrom"`
-	RequiredChecks       common.RegexSlice `yaml:"requiredChecks"`
-	RequireLinearHistory bool              `yaml:"requireLinearHistory"`
-	DeleteBranch         bool              `yaml:"deleteBranch"`
-	IgnoreConfig
+	// Labels that mark a pull request for merging (regex), one of them must be present.
+	// Leave empty to disable merging.
+	Labels common.RegexSlice `yaml:"labels"`
+	// ImmediateLabels mark a pull request for merging right away (regex). Unlike labels the pull request is
+	// evaluated only once: if it cannot be merged, e.g. because checks are still running, the label is removed
+	// and the reason is reported in the check run. Delays of the bot itself, e.g. because the last commit is too
+	// recent, are retried once. labels take precedence if both are present.
+	ImmediateLabels common.RegexSlice `yaml:"immediateLabels"`
+	// Strategy to merge with.
+	Strategy MergeStrategy `yaml:"strategy"`
+	// RequiredApprovals is the amount of approvals that are required before merging.
+	RequiredApprovals int `yaml:"requiredApprovals"`
+	// RequireApprovalsFrom are requirements that all need to be met before merging.
+	// An entry is either a user that needs to approve (regex)
+	// or a group of users of which a minimum amount needs to approve.
+	RequireApprovalsFrom ApprovalRequirements `yaml:"requireApprovalsFrom"`
+	// IgnoreApprovalsFrom are users whose approvals do not count for requiredApprovals and requireApprovalsFrom
+	// (regex), defaults to all bots. Set it to an empty list to count approvals of bots.
+	IgnoreApprovalsFrom common.RegexSlice `yaml:"ignoreApprovalsFrom"`
+	// WaitForRequestedReviewers does not merge while a review is requested from a user or team that did not
+	// review yet, regardless of the approvals.
+	WaitForRequestedReviewers bool `yaml:"waitForRequestedReviewers"`
+	// RequireReviewDecisionApproved does not merge unless GitHub's review decision, which takes branch protection
+	// and code owners into account, is APPROVED. It is checked in addition to the approvals above.
+	RequireReviewDecisionApproved bool `yaml:"requireReviewDecisionApproved"`
+	// RequiredChecks are checks that all need to pass before merging (regex).
+	RequiredChecks common.RegexSlice `yaml:"requiredChecks"`
+	// ExceptChecks are checks that are never considered, even if they match requiredChecks (regex).
+	// They are also ignored by waitForAllCheckSuites.
+	ExceptChecks common.RegexSlice `yaml:"exceptChecks"`
+	// IgnoreChecksFromApps are GitHub Apps whose check suites and check runs are never considered (regex),
+	// e.g. coverage or preview deployments. Commit statuses are not affected.
+	IgnoreChecksFromApps common.RegexSlice `yaml:"ignoreChecksFromApps"`
+	// RequireLinearHistory requires a linear history.
+	RequireLinearHistory bool `yaml:"requireLinearHistory"`
+	// Cascade evaluates the remaining labeled pull requests right after a pull request was merged,
+	// instead of waiting for their next event. Defaults to true.
+	Cascade *bool `yaml:"cascade"`
+	// Order is the order in which pull requests are looked at after a push or status event, by their number.
+	// With requireLinearHistory only one pull request can be merged per update of the base branch,
+	// so this decides which one is merged first.
+	Order MergeOrder `yaml:"order"`
+	// DeleteBranch deletes the branch after merging.
+	DeleteBranch bool `yaml:"deleteBranch"`
+	// CheckRunName is the name of the check run the bot creates, defaults to the BotName setting.
+	CheckRunName string `yaml:"checkRunName"`
+	// MaxCommitCount is the maximum amount of commits a pull request is allowed to have, 0 disables the check.
+	MaxCommitCount int `yaml:"maxCommitCount"`
+	// WaitForAllCheckSuites waits for all checks to complete, not only the required ones. The check run of the
+	// bot itself is never waited for.
+	WaitForAllCheckSuites bool `yaml:"waitForAllCheckSuites"`
+	// CheckRunPollInterval is the interval to check again when checks are still running,
+	// defaults to the MessageRetryWait setting.
+	CheckRunPollInterval time.Duration `yaml:"checkRunPollInterval"`
+	// RecheckInterval evaluates the pull request again after this interval while required checks are still pending,
+	// instead of waiting for the next event. 0 disables rechecking.
+	RecheckInterval time.Duration `yaml:"recheckInterval"`
+	// MaxRechecks is the maximum amount of rechecks per commit, defaults to 20.
+	MaxRechecks int `yaml:"maxRechecks"`
+	// WaitForMissingChecks waits for required checks that do not exist yet, instead of reporting them as missing,
+	// until missingCheckTimeout passed since the last commit.
+	WaitForMissingChecks bool `yaml:"waitForMissingChecks"`
+	// MissingCheckTimeout is the time after the last commit after which missing checks are reported,
+	// defaults to 30m.
+	MissingCheckTimeout time.Duration `yaml:"missingCheckTimeout"`
+	// AllowFromUsers restricts merging to pull requests that were created by these users (regex),
+	// bots have the [bot] suffix, leave empty to allow all users.
+	AllowFromUsers common.RegexSlice `yaml:"allowFromUsers"`
+	// LabelAppliedBy are the users that may add the merge label (regex), a label added by another user is removed
+	// again, leave empty to allow all users. It is only checked for the labeled event.
+	LabelAppliedBy common.RegexSlice `yaml:"labelAppliedBy"`
+	// OnlyDefaultBranch only merges pull requests that target the default branch of the repository.
+	OnlyDefaultBranch bool `yaml:"onlyDefaultBranch"`
+	// IgnoreNativeAutoMerge neither merges nor updates pull requests that have GitHub's auto-merge enabled,
+	// so the bot does not compete with it. Defaults to true.
+	IgnoreNativeAutoMerge *bool `yaml:"ignoreNativeAutoMerge"`
+	// ForbiddenAuthors are never merged, but still updated (unlike ignoreFromUsers).
+	ForbiddenAuthors common.RegexSlice `yaml:"forbiddenAuthors"`
+	// StrategyByBranch selects the strategy by the name of the head branch, the first matching rule is used.
+	// If no rule matches Strategy is used.
+	StrategyByBranch []BranchStrategyRule `yaml:"strategyByBranch"`
+	// ReportAllBlockers evaluates all conditions and lists every reason that blocks the merge in the check run,
+	// instead of stopping at the first one.
+	ReportAllBlockers bool `yaml:"reportAllBlockers"`
+	// RequireSignedCommits requires the last commit to have a valid signature.
+	RequireSignedCommits bool `yaml:"requireSignedCommits"`
+	// RequirePRBodyNotEmpty requires the pull request to have a description.
+	RequirePRBodyNotEmpty bool `yaml:"requirePRBodyNotEmpty"`
+	// RequestReviewersOnMissingApproval are users and teams (team:org/slug) reviews are requested from when
+	// approvals are missing, reviewers are only requested once per pull request.
+	RequestReviewersOnMissingApproval []string `yaml:"requestReviewersOnMissingApproval"`
+	// PostMergedComment is a template for a comment that is posted after the pull request was merged,
+	// {{.Strategy}}, {{.HeadRef}}, {{.BaseRef}} and {{.Author}} can be used. Leave empty to post no comment.
+	PostMergedComment string `yaml:"postMergedComment"`
+	// CloseWhenBlocked closes pull requests that could not be merged for closeWhenBlockedAfterHours,
+	// e.g. because of failed checks or conflicts. Ignored pull requests are never closed.
+	CloseWhenBlocked bool `yaml:"closeWhenBlocked"`
+	// CloseWhenBlockedAfterHours is the amount of hours a pull request must be blocked before it gets closed,
+	// defaults to 72.
+	CloseWhenBlockedAfterHours int `yaml:"closeWhenBlockedAfterHours"`
+	// BlockedLabel is added to pull requests that can not be merged and removed once they can be merged, the merge
+	// label was removed or the pull request was closed, leave empty to disable.
+	// The label is never treated as a merge, update or ignore label.
+	BlockedLabel string `yaml:"blockedLabel"`
+	// Overrides override settings by the author of the pull request, the first matching rule is used.
+	Overrides []AuthorOverrideRule `yaml:"overrides"`
+	// OverridesByLabel overrides settings by the labels of the pull request, the first matching rule is used.
+	OverridesByLabel []LabelOverrideRule `yaml:"overridesByLabel"`
+	IgnoreConfig     `yaml:",inline"`
+}
+
+type AuthorOverrideRule struct {
+	// Authors are matched against the author of the pull request (regex), bots have the [bot] suffix.
+	Authors common.RegexSlice `yaml:"authors"`
+	// RequiredApprovals overrides requiredApprovals when set.
+	RequiredApprovals *int `yaml:"requiredApprovals"`
+	// RequiredChecks overrides requiredChecks when set (regex).
+	RequiredChecks common.RegexSlice `yaml:"requiredChecks"`
+	// Strategy overrides strategy and strategyByBranch when set.
+	Strategy MergeStrategy `yaml:"strategy"`
+}
+
+// applyTo returns a copy of cfg with the values of the rule that are set, unset values are inherited from cfg.
+func (r *AuthorOverrideRule) applyTo(cfg MergeConfigV1) MergeConfigV1 {
+	if r.RequiredApprovals != nil {
+		cfg.RequiredApprovals = *r.RequiredApprovals
+	}
+	if r.RequiredChecks != nil {
+		cfg.RequiredChecks = r.RequiredChecks
+	}
+	if r.Strategy != "" {
+		cfg.Strategy = r.Strategy
+		cfg.StrategyByBranch = nil
+	}
+	return cfg
+}
+
+// forAuthor returns a copy of the config with the first rule in Overrides that matches the author applied.
+// If no rule matches the config is returned unchanged and index is -1.
+func (c *ConfigV1) forAuthor(author string) (cfg *ConfigV1, index int) {
+	for i := range c.Merge.Overrides {
+		if c.Merge.Overrides[i].Authors.ContainsOneOf(author) == "" {
+			continue
+		}
+		result := *c
+		result.Merge = c.Merge.Overrides[i].applyTo(c.Merge)
+		return &result, i
+	}
+	return c, -1
+}
+
+type LabelOverrideRule struct {
+	// Label is matched against the labels of the pull request (regex).
+	Label common.RegexItem `yaml:"label"`
+	// RequireLinearHistory overrides requireLinearHistory when set.
+	RequireLinearHistory *bool `yaml:"requireLinearHistory"`
+}
+
+func (r *LabelOverrideRule) UnmarshalYAML(node *yaml.Node) error {
+	type plain LabelOverrideRule
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	if r.Label.Text == "" {
+		return errors.New("label must not be empty")
+	}
+	return nil
+}
+
+type ApprovalRequirement struct {
+	// AnyOf are the users that can approve (regex).
+	AnyOf common.RegexSlice `yaml:"anyOf"`
+	// Count is the amount of users in anyOf that need to approve, defaults to 1.
+	// It must not be greater than the amount of users in anyOf.
+	Count int `yaml:"count"`
+}
+
+// isSimple reports whether the requirement can be written as a single pattern.
+func (r *ApprovalRequirement) isSimple() bool {
+	return len(r.AnyOf) == 1 && r.Count <= 1
+}
+
+// requiredCount returns the amount of approvals that are required.
+func (r *ApprovalRequirement) requiredCount() int {
+	if r.Count <= 0 {
+		return 1
+	}
+	return r.Count
+}
+
+func (r ApprovalRequirement) MarshalYAML() (interface{}, error) {
+	if r.isSimple() {
+		return r.AnyOf[0].Text, nil
+	}
+	type plain ApprovalRequirement
+	return plain(r), nil
+}
+
+func (r *ApprovalRequirement) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var item common.RegexItem
+		if err := node.Decode(&item); err != nil {
+			return err
+		}
+		*r = ApprovalRequirement{AnyOf: common.RegexSlice{item}}
+		return nil
+	}
+	type plain ApprovalRequirement
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	if len(r.AnyOf) == 0 {
+		return errors.New("anyOf must not be empty")
+	}
+	if r.Count > len(r.AnyOf) {
+		return errors.Errorf("count %d is greater than the %d users in anyOf", r.Count, len(r.AnyOf))
+	}
+	return nil
+}
+
+// approvers returns the distinct users of approvedBy that are matched by AnyOf and not excluded by all.
+func (r *ApprovalRequirement) approvers(approvedBy []string, all ApprovalRequirements) []string {
+	var approvers []string
+	for _, name := range approvedBy {
+		if r.AnyOf.ContainsOneOf(name) == "" || all.excludes(name) || slices.Contains(approvers, name) {
+			continue
+		}
+		approvers = append(approvers, name)
+	}
+	return approvers
+}
+
+type ApprovalRequirements []ApprovalRequirement
+
+// excludes reports whether the approvals of name are excluded by a negated entry, e.g. `!bob`.
+func (rs ApprovalRequirements) excludes(name string) bool {
+	for i := range rs {
+		if rs[i].isSimple() && rs[i].AnyOf[0].Negated() && rs[i].AnyOf.Excludes(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultIgnoreApprovalsFrom is used for MergeConfigV1.IgnoreApprovalsFrom if it is not set.
+var DefaultIgnoreApprovalsFrom = common.RegexSlice{common.MustNewRegexItem(`.*\[bot\]$`)}
+
+// Approvals returns the users that approved the pull request, without the users in IgnoreApprovalsFrom.
+func (c *MergeConfigV1) Approvals(approvedBy []string) []string {
+	var approvals []string
+	for _, name := range approvedBy {
+		if c.IgnoreApprovalsFrom.ContainsOneOf(name) == "" {
+			approvals = append(approvals, name)
+		}
+	}
+	return approvals
+}
+
+type BranchStrategyRule struct {
+	// Pattern is matched against the name of the head branch (regex).
+	Pattern common.RegexItem `yaml:"pattern"`
+	// Strategy to merge with when the pattern matches.
+	Strategy MergeStrategy `yaml:"strategy"`
+}
+
+func (r *BranchStrategyRule) UnmarshalYAML(node *yaml.Node) error {
+	type plain BranchStrategyRule
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	if r.Pattern.Text == "" {
+		return errors.New("pattern must not be empty")
+	}
+	return nil
+}
+
+// StrategyForBranch returns the strategy of the first rule in StrategyByBranch that matches the branch,
+// or Strategy if no rule matches.
+func (c *MergeConfigV1) StrategyForBranch(branch string) MergeStrategy {
+	for i := range c.StrategyByBranch {
+		if c.StrategyByBranch[i].Pattern.Equal(branch) {
+			return c.StrategyByBranch[i].Strategy
+		}
+	}
+	return c.Strategy
+}
+
+// ignoresNativeAutoMerge reports whether pull requests with GitHub's auto-merge are skipped,
+// see IgnoreNativeAutoMerge.
+func (c *MergeConfigV1) ignoresNativeAutoMerge() bool {
+	return c.IgnoreNativeAutoMerge == nil || *c.IgnoreNativeAutoMerge
+}
+
+// usesStatusCheckRollup reports whether the checks can be decided by GitHub's status check rollup of the last
+// commit, which is the case for the default requiredChecks without exceptChecks and ignoreChecksFromApps.
+func (c *MergeConfigV1) usesStatusCheckRollup() bool {
+	return len(c.RequiredChecks) == 1 && c.RequiredChecks[0].Text == ".*" &&
+		len(c.ExceptChecks) == 0 && len(c.IgnoreChecksFromApps) == 0
+}
+
+// isFromIgnoredApp reports whether the check belongs to one of the IgnoreChecksFromApps.
+func (c *MergeConfigV1) isFromIgnoredApp(check *github.CheckState) bool {
+	return check.App != "" && c.IgnoreChecksFromApps.ContainsOneOf(check.App) != ""
+}
+
+// cascades reports whether the remaining pull requests are evaluated after a merge, see Cascade.
+func (c *MergeConfigV1) cascades() bool {
+	return c.Cascade == nil || *c.Cascade
+}
+
+// immediateLabelsOf returns the labels that match ImmediateLabels.
+func (c *MergeConfigV1) immediateLabelsOf(labels []string) []string {
+	var result []string
+	for _, label := range labels {
+		if c.ImmediateLabels.ContainsOneOf(label) != "" {
+			result = append(result, label)
+		}
+	}
+	return result
+}
+
+// wantsMerge reports whether one of the labels matches Labels or ImmediateLabels.
+func (c *MergeConfigV1) wantsMerge(labels []string) bool {
+	return c.Labels.ContainsOneOf(labels...) != "" || c.ImmediateLabels.ContainsOneOf(labels...) != ""
+}
+
+// RequireLinearHistoryForLabels returns RequireLinearHistory of the first rule in OverridesByLabel
+// that matches one of the labels and sets it, or RequireLinearHistory if no rule matches.
+func (c *MergeConfigV1) RequireLinearHistoryForLabels(labels []string) bool {
+	for i := range c.OverridesByLabel {
+		rule := &c.OverridesByLabel[i]
+		if rule.RequireLinearHistory == nil {
+			continue
+		}
+		for _, label := range labels {
+			if rule.Label.Equal(label) {
+				return *rule.RequireLinearHistory
+			}
+		}
+	}
+	return c.RequireLinearHistory
 }
 
 type UpdateConfigV1 struct {
+	// Labels that mark a pull request for updating (regex), one of them must be present.
+	// Leave empty to disable updating.
 	Labels common.RegexSlice `yaml:"labels"`
-	IgnoreConfig
+	// AllowFromUsers restricts updating to pull requests that were created by these users (regex),
+	// bots have the [bot] suffix, leave empty to allow all users.
+	AllowFromUsers common.RegexSlice `yaml:"allowFromUsers"`
+	// OnlyDefaultBranch only updates pull requests that target the default branch of the repository.
+	OnlyDefaultBranch bool `yaml:"onlyDefaultBranch"`
+	IgnoreConfig      `yaml:",inline"`
 }
 
 func defaultConfig() (*ConfigV1, error) {
@@ -78,11 +480,15 @@ update:
 	if err != nil {
 		return nil, err
 	}
+	cfg.Version = 1
+	cfg.applyDefaults()
 	return &cfg, nil
 }
 
 type IgnoreConfig struct {
-	IgnoreFromUsers  common.RegexSlice `yaml:"ignoreFromUsers"`
+	// IgnoreFromUsers ignores pull requests that were created by these users (regex).
+	IgnoreFromUsers common.RegexSlice `yaml:"ignoreFromUsers"`
+	// IgnoreWithTitles ignores pull requests that match one of these titles (regex).
 	IgnoreWithTitles common.RegexSlice `yaml:"ignoreWithTitles"`
 	ignoreWithLabels common.RegexSlice `yaml:"ignoreWithLabels"`
 }
@@ -101,7 +507,230 @@ func (c *IgnoreConfig) IsLabelIgnored(s string) string {
 
 type cachedConfig struct {
 	*ConfigV1
+	// SHA is the ref the config was read from, a commit sha or the name of the default branch.
 	SHA string
+	// Source describes where the config was loaded from.
+	Source string
+	// Problems are the parts of the config that were invalid and ignored.
+	Problems []ConfigProblem
+}
+
+// ConfigProblem describes a field of the config that is invalid and was ignored.
+type ConfigProblem struct {
+	// Field is the path of the field, e.g. `merge.requiredChecks[1]`.
+	Field string
+	// Message describes what is wrong with the field.
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	return "`" + p.Field + "` " + p.Message
+}
+
+// patternFields are the paths of all pattern lists in the config.
+var patternFields = [][]string{
+	{"merge", "labels"},
+	{"merge", "immediateLabels"},
+	{"merge", "requireApprovalsFrom"},
+	{"merge", "ignoreApprovalsFrom"},
+	{"merge", "requiredChecks"},
+	{"merge", "exceptChecks"},
+	{"merge", "ignoreChecksFromApps"},
+	{"merge", "allowFromUsers"},
+	{"merge", "labelAppliedBy"},
+	{"merge", "forbiddenAuthors"},
+	{"merge", "ignoreFromUsers"},
+	{"merge", "ignoreWithTitles"},
+	{"update", "labels"},
+	{"update", "allowFromUsers"},
+	{"update", "ignoreFromUsers"},
+	{"update", "ignoreWithTitles"},
+}
+
+// clampApprovalCount lowers the count of the requirement to the amount of users in anyOf, a higher count can
+// never be met. The requirement is kept, so all users in anyOf need to approve.
+func clampApprovalCount(requirement *yaml.Node, users, index int) []ConfigProblem {
+	node := mappingValue(requirement, "count")
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return nil
+	}
+	count, err := strconv.Atoi(node.Value)
+	if err != nil || count <= users {
+		return nil
+	}
+	node.Value = strconv.Itoa(users)
+	return []ConfigProblem{{
+		Field:   fmt.Sprintf("merge.requireApprovalsFrom[%d].count", index),
+		Message: fmt.Sprintf("%d is greater than the %d users in anyOf, all of them need to approve", count, users),
+	}}
+}
+
+// parseConfigLenient parses the config like parseConfig, but drops patterns that are not valid regexes
+// instead of failing. The dropped patterns are returned as problems.
+func parseConfigLenient(buf []byte) (*ConfigV1, []ConfigProblem, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to decode config")
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		cfg, err := parseConfig(buf)
+		return cfg, nil, err
+	}
+
+	problems := dropInvalidPatterns(doc.Content[0])
+	problems = append(problems, dropInvalidMergedComment(doc.Content[0])...)
+	if len(problems) == 0 {
+		cfg, err := parseConfig(buf)
+		return cfg, nil, err
+	}
+
+	buf, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to encode config")
+	}
+	cfg, err := parseConfig(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, problems, nil
+}
+
+// dropInvalidPatterns removes all patterns that are not valid regexes from the config node.
+func dropInvalidPatterns(root *yaml.Node) []ConfigProblem {
+	var problems []ConfigProblem
+	for _, path := range patternFields {
+		node := root
+		for _, key := range path {
+			node = mappingValue(node, key)
+		}
+		if node == nil || node.Kind != yaml.SequenceNode {
+			continue
+		}
+		problems = append(problems, dropInvalidItems(node, strings.Join(path, "."))...)
+	}
+
+	if requirements := mappingValue(mappingValue(root, "merge"), "requireApprovalsFrom"); requirements != nil &&
+		requirements.Kind == yaml.SequenceNode {
+		var valid []*yaml.Node
+		for i, requirement := range requirements.Content {
+			anyOf := mappingValue(requirement, "anyOf")
+			if anyOf != nil && anyOf.Kind == yaml.SequenceNode && len(anyOf.Content) != 0 {
+				problems = append(problems, dropInvalidItems(anyOf, fmt.Sprintf("merge.requireApprovalsFrom[%d].anyOf", i))...)
+				if len(anyOf.Content) == 0 {
+					// all patterns of the group were invalid
+					continue
+				}
+			}
+			if anyOf != nil && anyOf.Kind == yaml.SequenceNode {
+				problems = append(problems, clampApprovalCount(requirement, len(anyOf.Content), i)...)
+			}
+			valid = append(valid, requirement)
+		}
+		requirements.Content = valid
+	}
+
+	if overrides := mappingValue(mappingValue(root, "merge"), "overrides"); overrides != nil &&
+		overrides.Kind == yaml.SequenceNode {
+		for i, override := range overrides.Content {
+			for _, key := range []string{"authors", "requiredChecks"} {
+				if node := mappingValue(override, key); node != nil && node.Kind == yaml.SequenceNode {
+					problems = append(problems, dropInvalidItems(node, fmt.Sprintf("merge.overrides[%d].%s", i, key))...)
+				}
+			}
+			if node := mappingValue(override, "strategy"); node != nil && node.Kind == yaml.ScalarNode &&
+				node.Value != "" && MergeStrategy(node.Value).GithubString() == "" {
+				problems = append(problems, ConfigProblem{
+					Field:   fmt.Sprintf("merge.overrides[%d].strategy", i),
+					Message: fmt.Sprintf("unknown strategy `%s'", node.Value),
+				})
+				node.Value = ""
+			}
+		}
+	}
+
+	problems = append(problems, dropInvalidRules(root, "strategyByBranch", "pattern")...)
+	problems = append(problems, dropInvalidRules(root, "overridesByLabel", "label")...)
+	return problems
+}
+
+// dropInvalidMergedComment removes merge.postMergedComment if it is not a valid template.
+func dropInvalidMergedComment(root *yaml.Node) []ConfigProblem {
+	node := mappingValue(mappingValue(root, "merge"), "postMergedComment")
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return nil
+	}
+	_, err := parseMergedComment(node.Value)
+	if err == nil {
+		return nil
+	}
+	node.Value = ""
+	return []ConfigProblem{{Field: "merge.postMergedComment", Message: errors.Cause(err).Error()}}
+}
+
+// dropInvalidItems removes all scalars of the sequence node that are not valid regexes,
+// field is the path of the sequence.
+func dropInvalidItems(node *yaml.Node, field string) []ConfigProblem {
+	var problems []ConfigProblem
+	var valid []*yaml.Node
+	for i, item := range node.Content {
+		if item.Kind == yaml.ScalarNode {
+			if _, err := common.NewRegexItem(item.Value); err != nil {
+				problems = append(problems, ConfigProblem{
+					Field:   fmt.Sprintf("%s[%d]", field, i),
+					Message: err.Error(),
+				})
+				continue
+			}
+		}
+		valid = append(valid, item)
+	}
+	node.Content = valid
+	return problems
+}
+
+// dropInvalidRules removes all rules of the merge.<field> list whose patternKey is missing or not a valid regex.
+func dropInvalidRules(root *yaml.Node, field, patternKey string) []ConfigProblem {
+	rules := mappingValue(mappingValue(root, "merge"), field)
+	if rules == nil || rules.Kind != yaml.SequenceNode {
+		return nil
+	}
+	var problems []ConfigProblem
+	var valid []*yaml.Node
+	for i, rule := range rules.Content {
+		pattern := mappingValue(rule, patternKey)
+		if rule.Kind == yaml.MappingNode && (pattern == nil || (pattern.Kind == yaml.ScalarNode && pattern.Value == "")) {
+			problems = append(problems, ConfigProblem{
+				Field:   fmt.Sprintf("merge.%s[%d].%s", field, i, patternKey),
+				Message: "is required",
+			})
+			continue
+		}
+		if pattern != nil && pattern.Kind == yaml.ScalarNode {
+			if _, err := common.NewRegexItem(pattern.Value); err != nil {
+				problems = append(problems, ConfigProblem{
+					Field:   fmt.Sprintf("merge.%s[%d].%s", field, i, patternKey),
+					Message: err.Error(),
+				})
+				continue
+			}
+		}
+		valid = append(valid, rule)
+	}
+	rules.Content = valid
+	return problems
+}
+
+// mappingValue returns the value of key in the mapping node, or nil if node is not a mapping or has no such key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
 }
 
 func parseConfig(buf []byte) (*ConfigV1, error) {
@@ -117,26 +746,261 @@ func parseConfig(buf []byte) (*ConfigV1, error) {
 			return nil, errors.Wrap(err, "unable to decode config")
 		}
 		cfg.Version = hdr.Version
+		cfg.applyDefaults()
+		if err := cfg.applyMatching(); err != nil {
+			return nil, err
+		}
+		if cfg.Merge.PostMergedComment != "" {
+			if _, err := parseMergedComment(cfg.Merge.PostMergedComment); err != nil {
+				return nil, err
+			}
+		}
+		switch cfg.Merge.Order {
+		case "", OldestFirstOrder, NewestFirstOrder:
+		default:
+			return nil, errors.Errorf("unknown merge order `%s'", cfg.Merge.Order)
+		}
+		for i := range cfg.Merge.Overrides {
+			if strategy := cfg.Merge.Overrides[i].Strategy; strategy != "" && strategy.GithubString() == "" {
+				return nil, errors.Errorf("unknown strategy `%s' in merge.overrides[%d]", strategy, i)
+			}
+		}
 		return &cfg, nil
 	default:
 		return nil, errors.Errorf("unknown version `%d'", hdr.Version)
 	}
 }
 
-func (worker *Worker) getConfig(
+// applyDefaults sets the defaults of fields that were not set.
+func (c *ConfigV1) applyDefaults() {
+	if c.Merge.IgnoreApprovalsFrom == nil {
+		c.Merge.IgnoreApprovalsFrom = DefaultIgnoreApprovalsFrom
+	}
+	if c.Merge.Cascade == nil {
+		cascade := true
+		c.Merge.Cascade = &cascade
+	}
+	if c.Merge.IgnoreNativeAutoMerge == nil {
+		ignoreNativeAutoMerge := true
+		c.Merge.IgnoreNativeAutoMerge = &ignoreNativeAutoMerge
+	}
+}
+
+// applyMatching applies Matching and CaseSensitive to all patterns.
+// Patterns are stored as text only, so this must be called again after decoding a cached config.
+func (c *ConfigV1) applyMatching() error {
+	var options []func(common.RegexSlice) (common.RegexSlice, error)
+	switch c.Matching {
+	case "", RegexMatching:
+	case AnchoredMatching:
+		options = append(options, common.RegexSlice.Anchored)
+	default:
+		return errors.Errorf("unknown matching `%s'", c.Matching)
+	}
+	if c.CaseSensitive {
+		options = append(options, common.RegexSlice.CaseSensitive)
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	apply := func(sl common.RegexSlice) (common.RegexSlice, error) {
+		for _, option := range options {
+			var err error
+			if sl, err = option(sl); err != nil {
+				return nil, errors.Wrap(err, "unable to apply matching options")
+			}
+		}
+		return sl, nil
+	}
+
+	patterns := []*common.RegexSlice{
+		&c.Merge.Labels,
+		&c.Merge.ImmediateLabels,
+		&c.Merge.IgnoreApprovalsFrom,
+		&c.Merge.RequiredChecks,
+		&c.Merge.ExceptChecks,
+		&c.Merge.IgnoreChecksFromApps,
+		&c.Merge.AllowFromUsers,
+		&c.Merge.LabelAppliedBy,
+		&c.Merge.ForbiddenAuthors,
+		&c.Merge.IgnoreFromUsers,
+		&c.Merge.IgnoreWithTitles,
+		&c.Merge.ignoreWithLabels,
+		&c.Update.Labels,
+		&c.Update.AllowFromUsers,
+		&c.Update.IgnoreFromUsers,
+		&c.Update.IgnoreWithTitles,
+		&c.Update.ignoreWithLabels,
+	}
+	for _, sl := range patterns {
+		result, err := apply(*sl)
+		if err != nil {
+			return err
+		}
+		*sl = result
+	}
+	for i := range c.Merge.RequireApprovalsFrom {
+		result, err := apply(c.Merge.RequireApprovalsFrom[i].AnyOf)
+		if err != nil {
+			return err
+		}
+		c.Merge.RequireApprovalsFrom[i].AnyOf = result
+	}
+	for i := range c.Merge.Overrides {
+		for _, sl := range []*common.RegexSlice{&c.Merge.Overrides[i].Authors, &c.Merge.Overrides[i].RequiredChecks} {
+			result, err := apply(*sl)
+			if err != nil {
+				return err
+			}
+			*sl = result
+		}
+	}
+	for i := range c.Merge.StrategyByBranch {
+		result, err := apply(common.RegexSlice{c.Merge.StrategyByBranch[i].Pattern})
+		if err != nil {
+			return err
+		}
+		c.Merge.StrategyByBranch[i].Pattern = result[0]
+	}
+	for i := range c.Merge.OverridesByLabel {
+		result, err := apply(common.RegexSlice{c.Merge.OverridesByLabel[i].Label})
+		if err != nil {
+			return err
+		}
+		c.Merge.OverridesByLabel[i].Label = result[0]
+	}
+	return nil
+}
+
+// mergeConfigs deep merges child on top of parent, values in child take precedence.
+// Lists are not merged, they are replaced.
+func mergeConfigs(parent, child []byte) ([]byte, error) {
+	var parentMap map[string]any
+	if err := yaml.Unmarshal(parent, &parentMap); err != nil {
+		return nil, errors.Wrap(err, "unable to decode parent config")
+	}
+	var childMap map[string]any
+	if err := yaml.Unmarshal(child, &childMap); err != nil {
+		return nil, errors.Wrap(err, "unable to decode config")
+	}
+	buf, err := yaml.Marshal(mergeMaps(parentMap, childMap))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode merged config")
+	}
+	return buf, nil
+}
+
+func mergeMaps(parent, child map[string]any) map[string]any {
+	result := make(map[string]any, len(parent)+len(child))
+	for k, v := range parent {
+		result[k] = v
+	}
+	for k, v := range child {
+		childMap, childIsMap := v.(map[string]any)
+		parentMap, parentIsMap := result[k].(map[string]any)
+		if childIsMap && parentIsMap {
+			result[k] = mergeMaps(parentMap, childMap)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// resolveExtends fetches the parent config (if the config extends one) and merges the config on top of it.
+// Only one level of inheritance is supported, the extendsURL of the parent config is ignored.
+func (worker *Worker) resolveExtends(
 	ctx context.Context,
 	rootLogger *zerolog.Logger,
 	accessToken string,
 	repository *common.Repository,
 	sha string,
-) (*ConfigV1, error) {
-	if sha == "" {
+	buf []byte,
+) ([]byte, error) {
+	var extends struct {
+		ExtendsURL string `yaml:"extendsURL"`
+	}
+	if err := yaml.Unmarshal(buf, &extends); err != nil {
+		return nil, errors.Wrap(err, "unable to decode config")
+	}
+	if extends.ExtendsURL == "" {
+		return buf, nil
+	}
+
+	logger := rootLogger.With().Str("extends_url", extends.ExtendsURL).Logger()
+	logger.Debug().Msg("getting parent config")
+
+	rawURL, filePath, err := parseExtendsURL(extends.ExtendsURL)
+	if err != nil {
+		return nil, err
+	}
+	var parent []byte
+	if rawURL != "" {
+		parent, err = github.GetURL(ctx, worker.HTTPClient, accessToken, rawURL)
+	} else {
+		parent, err = github.GetFile(ctx, worker.HTTPClient, accessToken, repository, sha, filePath)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get parent config")
+	}
+	if parent == nil {
+		return nil, errors.Errorf("parent config `%s' does not exist", extends.ExtendsURL)
+	}
+
+	var parentExtends struct {
+		ExtendsURL string `yaml:"extendsURL"`
+	}
+	if err := yaml.Unmarshal(parent, &parentExtends); err == nil && parentExtends.ExtendsURL != "" {
+		logger.Debug().
+			Str("parent_extends_url", parentExtends.ExtendsURL).
+			Msg("ignoring extendsURL in parent config, only one level is supported")
+	}
+
+	return mergeConfigs(parent, buf)
+}
+
+// parseExtendsURL returns either the url of a parent config on a GitHub host or the cleaned path of a parent config
+// in the repository.
+// Other hosts are rejected, so a config cannot make the worker request hosts of its network.
+func parseExtendsURL(extendsURL string) (rawURL, filePath string, err error) {
+	if strings.Contains(extendsURL, "://") {
+		u, parseErr := url.Parse(extendsURL)
+		if parseErr != nil {
+			return "", "", errors.Wrapf(parseErr, "unable to parse extendsURL `%s'", extendsURL)
+		}
+		if u.Scheme != "https" || u.User != nil || !github.IsGitHubHost(u.Host) {
+			return "", "", errors.Errorf("extendsURL `%s' must be a https url to a GitHub host", extendsURL)
+		}
+		return u.String(), "", nil
+	}
+	for _, part := range strings.Split(extendsURL, "/") {
+		if part == ".." {
+			return "", "", errors.Errorf("extendsURL `%s' must not leave the repository", extendsURL)
+		}
+	}
+	filePath = strings.TrimPrefix(path.Clean("/"+extendsURL), "/")
+	if filePath == "" {
+		return "", "", errors.Errorf("extendsURL `%s' is not a file", extendsURL)
+	}
+	return "", filePath, nil
+}
+
+// getConfig returns the config of the repository at ref, ref is a commit sha or the name of the default branch.
+// A config that was read from the default branch stays cached until forgetConfig drops it or the kv entry expires.
+func (worker *Worker) getConfig(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	accessToken string,
+	repository *common.Repository,
+	ref string,
+) (*cachedConfig, error) {
+	if ref == "" {
 		return nil, nil
 	}
-	key := hashForKV(repository.FullName)
+	key := hashForKV(repository)
 	logger := rootLogger.With().
 		Str("hash_key", key).
-		Str("sha", sha).
+		Str("ref", ref).
 		Logger()
 
 	entry, err := worker.ConfigsKV.Get(key)
@@ -144,25 +1008,32 @@ func (worker *Worker) getConfig(
 		return nil, errors.Wrap(err, "unable to get config from kv bucket")
 	}
 	if entry == nil || len(entry.Value()) == 0 || errors.Is(err, nats.ErrKeyNotFound) {
+		countKVLookup(worker.ConfigsKV, false)
 		logger.Debug().
 			Str("reason", "not in cache").
 			Msg("getting latest config")
-		return worker.getLatestConfig(ctx, &logger, accessToken, repository, key, sha)
+		return worker.getLatestConfig(ctx, &logger, accessToken, repository, key, ref)
 	}
 
 	var config cachedConfig
 	if err := json.Unmarshal(entry.Value(), &config); err != nil {
 		return nil, errors.Wrap(err, "unable to decode config from kv bucket")
 	}
-	if config.SHA != sha {
+	if err := config.applyMatching(); err != nil {
+		return nil, errors.Wrap(err, "unable to decode config from kv bucket")
+	}
+	if config.SHA != ref {
+		countKVLookup(worker.ConfigsKV, false)
 		logger.Debug().
 			Str("reason", "possible old config").
 			Msg("getting latest config")
-		return worker.getLatestConfig(ctx, &logger, accessToken, repository, key, sha)
+		return worker.getLatestConfig(ctx, &logger, accessToken, repository, key, ref)
 	}
+	countKVLookup(worker.ConfigsKV, true)
 	logger.Debug().
 		Msg("got config from cache")
-	return config.ConfigV1, err
+	config.Source += " (cached)"
+	return &config, nil
 }
 
 func (worker *Worker) getLatestConfig(
@@ -172,7 +1043,7 @@ func (worker *Worker) getLatestConfig(
 	repository *common.Repository,
 	key,
 	sha string,
-) (*ConfigV1, error) {
+) (*cachedConfig, error) {
 	rootLogger.Debug().Msg("getting latest config from github")
 	buf, err := github.GetConfig(ctx, worker.HTTPClient, accessToken, repository, sha)
 	if err != nil {
@@ -180,18 +1051,44 @@ func (worker *Worker) getLatestConfig(
 	}
 	if buf == nil {
 		rootLogger.Debug().Msg("no config found, returning default config")
-		return defaultConfig()
+		cfg, err := defaultConfig()
+		if err != nil {
+			return nil, err
+		}
+		return &cachedConfig{
+			ConfigV1: cfg,
+			SHA:      sha,
+			Source:   "default config, no `" + github.ConfigFilePath + "` at " + sha,
+		}, nil
 	}
 
-	cfg, err := parseConfig(buf)
+	buf, err = worker.resolveExtends(ctx, rootLogger, accessToken, repository, sha, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve parent config")
+	}
+
+	cfg, problems, err := parseConfigLenient(buf)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to parse config")
 	}
+	for _, problem := range problems {
+		rootLogger.Warn().
+			Str("field", problem.Field).
+			Str("problem", problem.Message).
+			Msg("ignoring invalid config field")
+	}
 
-	buf, err = json.Marshal(&cachedConfig{
+	source := "`" + github.ConfigFilePath + "` at " + sha
+	if cfg.ExtendsURL != "" {
+		source += " extending `" + cfg.ExtendsURL + "`"
+	}
+	config := &cachedConfig{
 		ConfigV1: cfg,
 		SHA:      sha,
-	})
+		Source:   source,
+		Problems: problems,
+	}
+	buf, err = json.Marshal(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to encode config")
 	}
@@ -199,5 +1096,13 @@ func (worker *Worker) getLatestConfig(
 	if _, err := worker.ConfigsKV.Put(key, buf); err != nil {
 		return nil, errors.Wrap(err, "unable to store access token in kv bucket")
 	}
-	return cfg, nil
+	return config, nil
+}
+
+// forgetConfig drops the cached config of the repository, the next session reads it again.
+func (worker *Worker) forgetConfig(repository *common.Repository) error {
+	if err := worker.ConfigsKV.Delete(hashForKV(repository)); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return errors.Wrap(err, "unable to delete config from kv bucket")
+	}
+	return nil
 }
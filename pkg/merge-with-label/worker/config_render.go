@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// renderConfigDetails renders the config as yaml inside a collapsible markdown section,
+// source describes where the config was loaded from.
+func renderConfigDetails(cfg *ConfigV1, source string) (string, error) {
+	var sb strings.Builder
+	enc := yaml.NewEncoder(&sb)
+	enc.SetIndent(2) //nolint:gomnd // indent like the config examples
+	if err := enc.Encode(cfg); err != nil {
+		return "", errors.Wrap(err, "unable to encode config")
+	}
+	if err := enc.Close(); err != nil {
+		return "", errors.Wrap(err, "unable to encode config")
+	}
+
+	return "<details>\n" +
+		"<summary>Effective configuration</summary>\n\n" +
+		"Source: " + source + "\n\n" +
+		"```yaml\n" + sb.String() + "```\n" +
+		"</details>", nil
+}
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestRenderConfigDetails(t *testing.T) {
+	defaultCfg, err := defaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	customCfg, err := parseConfig([]byte(`
+version: 1
+extendsURL: "https://example.com/merge-with-label.yml"
+merge:
+  labels: ["merge", "automerge"]
+  strategy: "squash"
+  strategyByBranch:
+    - pattern: "^hotfix/"
+      strategy: "commit"
+  requiredApprovals: 2
+  requiredChecks:
+    - "test"
+  checkRunPollInterval: "30s"
+  deleteBranch: true
+update:
+  labels: ["update-branch"]
+checkRun:
+  showConfig: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cfg    *ConfigV1
+		source string
+	}{
+		{
+			name:   "default",
+			cfg:    defaultCfg,
+			source: "default config, no `.github/merge-with-label.yml` at abc",
+		},
+		{
+			name:   "custom",
+			cfg:    customCfg,
+			source: "`.github/merge-with-label.yml` at abc extending `https://example.com/merge-with-label.yml`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderConfigDetails(tt.cfg, tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "config_"+tt.name+".golden.md")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != strings.ReplaceAll(string(want), "\r\n", "\n") {
+				t.Errorf("renderConfigDetails() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateCheckRun_ShowConfig(t *testing.T) {
+	for _, showConfig := range []bool{false, true} {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		sess := newTestSession()
+		sess.Config.CheckRun.ShowConfig = showConfig
+		sess.ConfigSource = "`.github/merge-with-label.yml` at abc"
+
+		logger := log.Logger
+		if err := worker.CreateOrUpdateCheckRun(
+			context.Background(), &logger, sess, "PR_1", "abc", "COMPLETED", "title", "summary",
+		); err != nil {
+			t.Fatal(err)
+		}
+		body := gh.LastRequestBody("CreateCheckRun")
+		if got := strings.Contains(body, "Effective configuration"); got != showConfig {
+			t.Errorf("showConfig=%v: summary contains config = %v", showConfig, got)
+		}
+		if showConfig && !strings.Contains(body, "at abc") {
+			t.Errorf("summary does not contain the config source: %s", body)
+		}
+	}
+}
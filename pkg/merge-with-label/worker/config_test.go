@@ -0,0 +1,681 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func newFileServerClient(files map[string]string) *http.Client {
+	return &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			content, ok := files[r.URL.String()]
+			if !ok {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(content)), Request: r}, nil
+		}),
+	}
+}
+
+func Test_mergeConfigs(t *testing.T) {
+	parent := `
+version: 1
+merge:
+  labels: ["merge"]
+  strategy: "squash"
+  requiredApprovals: 2
+  requiredChecks: [".*"]
+update:
+  labels: ["update-branch"]
+`
+	child := `
+version: 1
+merge:
+  strategy: "rebase"
+  requiredChecks: ["ci"]
+`
+	buf, err := mergeConfigs([]byte(parent), []byte(child))
+	if err != nil {
+		t.Fatalf("mergeConfigs() error = %v", err)
+	}
+	cfg, err := parseConfig(buf)
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	if cfg.Merge.Strategy != RebaseMergeStrategy {
+		t.Errorf("Merge.Strategy = %q, want %q", cfg.Merge.Strategy, RebaseMergeStrategy)
+	}
+	if cfg.Merge.RequiredApprovals != 2 {
+		t.Errorf("Merge.RequiredApprovals = %d, want 2", cfg.Merge.RequiredApprovals)
+	}
+	if got := cfg.Merge.Labels.String(); got != "merge" {
+		t.Errorf("Merge.Labels = %q, want %q", got, "merge")
+	}
+	if got := cfg.Merge.RequiredChecks.String(); got != "ci" {
+		t.Errorf("Merge.RequiredChecks = %q, want %q", got, "ci")
+	}
+	if got := cfg.Update.Labels.String(); got != "update-branch" {
+		t.Errorf("Update.Labels = %q, want %q", got, "update-branch")
+	}
+}
+
+func TestWorker_resolveExtends(t *testing.T) {
+	repo := &common.Repository{FullName: "owner/repo"}
+	files := map[string]string{
+		"https://raw.githubusercontent.com/owner/repo/sha/.github/base.yml": `
+version: 1
+merge:
+  requiredApprovals: 1
+  strategy: "squash"
+`,
+		"https://raw.githubusercontent.com/owner/.github/main/merge-with-label.yml": `
+version: 1
+extendsURL: "https://raw.githubusercontent.com/owner/.github/main/other.yml"
+merge:
+  requiredApprovals: 3
+  strategy: "commit"
+`,
+	}
+	worker := Worker{HTTPClient: newFileServerClient(files)}
+
+	tests := []struct {
+		name                  string
+		config                string
+		wantErr               bool
+		wantRequiredApprovals int
+		wantStrategy          MergeStrategy
+	}{
+		{
+			name:                  "no extendsURL",
+			config:                "version: 1\nmerge:\n  requiredApprovals: 5\n",
+			wantRequiredApprovals: 5,
+		},
+		{
+			name:                  "relative path",
+			config:                "version: 1\nextendsURL: .github/base.yml\nmerge:\n  strategy: rebase\n",
+			wantRequiredApprovals: 1,
+			wantStrategy:          RebaseMergeStrategy,
+		},
+		{
+			name:                  "url with nested extendsURL",
+			config:                "version: 1\nextendsURL: https://raw.githubusercontent.com/owner/.github/main/merge-with-label.yml\nmerge:\n  requiredApprovals: 2\n",
+			wantRequiredApprovals: 2,
+			wantStrategy:          MergeCommitStrategy,
+		},
+		{
+			name:                  "relative path is cleaned",
+			config:                "version: 1\nextendsURL: /./.github//base.yml\n",
+			wantRequiredApprovals: 1,
+			wantStrategy:          SquashMergeStrategy,
+		},
+		{
+			name:    "parent does not exist",
+			config:  "version: 1\nextendsURL: .github/missing.yml\n",
+			wantErr: true,
+		},
+		{
+			name:    "path outside of the repository",
+			config:  "version: 1\nextendsURL: .github/../../base.yml\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := worker.resolveExtends(context.Background(), &log.Logger, "token", repo, "sha", []byte(tt.config))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveExtends() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			cfg, err := parseConfig(buf)
+			if err != nil {
+				t.Fatalf("parseConfig() error = %v", err)
+			}
+			if cfg.Merge.RequiredApprovals != tt.wantRequiredApprovals {
+				t.Errorf("Merge.RequiredApprovals = %d, want %d", cfg.Merge.RequiredApprovals, tt.wantRequiredApprovals)
+			}
+			if cfg.Merge.Strategy != tt.wantStrategy {
+				t.Errorf("Merge.Strategy = %q, want %q", cfg.Merge.Strategy, tt.wantStrategy)
+			}
+		})
+	}
+}
+
+func Test_parseExtendsURL(t *testing.T) {
+	tests := []struct {
+		extendsURL   string
+		wantURL      string
+		wantFilePath string
+		wantErr      bool
+	}{
+		{
+			extendsURL: "https://raw.githubusercontent.com/owner/.github/main/base.yml",
+			wantURL:    "https://raw.githubusercontent.com/owner/.github/main/base.yml",
+		},
+		{
+			extendsURL: "https://api.github.com/repos/owner/.github/contents/base.yml",
+			wantURL:    "https://api.github.com/repos/owner/.github/contents/base.yml",
+		},
+		{extendsURL: "http://raw.githubusercontent.com/owner/.github/main/base.yml", wantErr: true},
+		{extendsURL: "https://169.254.169.254/latest/meta-data", wantErr: true},
+		{extendsURL: "https://user@raw.githubusercontent.com/owner/.github/main/base.yml", wantErr: true},
+		{extendsURL: "file:///etc/passwd", wantErr: true},
+		{extendsURL: ".github/base.yml", wantFilePath: ".github/base.yml"},
+		{extendsURL: "/./.github//base.yml", wantFilePath: ".github/base.yml"},
+		{extendsURL: ".github/../base.yml", wantErr: true},
+		{extendsURL: "/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.extendsURL, func(t *testing.T) {
+			gotURL, gotFilePath, err := parseExtendsURL(tt.extendsURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExtendsURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotURL != tt.wantURL || gotFilePath != tt.wantFilePath {
+				t.Errorf("parseExtendsURL() = %q, %q, want %q, %q", gotURL, gotFilePath, tt.wantURL, tt.wantFilePath)
+			}
+		})
+	}
+}
+
+func TestMergeConfigV1_StrategyForBranch(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+version: 1
+merge:
+  strategy: "squash"
+  strategyByBranch:
+    - pattern: "^hotfix/"
+      strategy: "commit"
+    - pattern: "^dependabot/"
+      strategy: "rebase"
+    - pattern: "^dependabot/npm"
+      strategy: "commit"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		branch string
+		want   MergeStrategy
+	}{
+		{branch: "hotfix/crash", want: MergeCommitStrategy},
+		{branch: "dependabot/go_modules/x", want: RebaseMergeStrategy},
+		{branch: "dependabot/npm_and_yarn/y", want: RebaseMergeStrategy},
+		{branch: "feature/hotfix/z", want: SquashMergeStrategy},
+		{branch: "main", want: SquashMergeStrategy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			if got := cfg.Merge.StrategyForBranch(tt.branch); got != tt.want {
+				t.Errorf("StrategyForBranch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseConfig_Matching(t *testing.T) {
+	config := func(matching string) []byte {
+		return []byte(`
+version: 1
+matching: "` + matching + `"
+merge:
+  labels: ["merge"]
+  requiredChecks: ["test", "= lint"]
+  strategyByBranch:
+    - pattern: "hotfix"
+      strategy: "commit"
+update:
+  labels: ["update"]
+`)
+	}
+
+	tests := []struct {
+		matching         string
+		wantErr          bool
+		wantCheckMatches bool
+		wantLabelMatches bool
+		wantStrategy     MergeStrategy
+	}{
+		{matching: "", wantCheckMatches: true, wantLabelMatches: true, wantStrategy: MergeCommitStrategy},
+		{matching: "regex", wantCheckMatches: true, wantLabelMatches: true, wantStrategy: MergeCommitStrategy},
+		{matching: "anchored", wantCheckMatches: false, wantLabelMatches: false, wantStrategy: SquashMergeStrategy},
+		{matching: "exact", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.matching, func(t *testing.T) {
+			cfg, err := parseConfig(config(tt.matching))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Merge.Strategy = SquashMergeStrategy
+
+			// a config from the cache is decoded from json, the anchors must be applied again
+			buf, err := json.Marshal(&cachedConfig{ConfigV1: cfg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cached cachedConfig
+			if err := json.Unmarshal(buf, &cached); err != nil {
+				t.Fatal(err)
+			}
+			if err := cached.applyMatching(); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, c := range map[string]*ConfigV1{"parsed": cfg, "cached": cached.ConfigV1} {
+				if got := c.Merge.RequiredChecks.ContainsOneOf("integration-test-flaky") != ""; got != tt.wantCheckMatches {
+					t.Errorf("%s: check matches = %v, want %v", name, got, tt.wantCheckMatches)
+				}
+				if c.Merge.RequiredChecks.ContainsOneOf("lint") == "" || c.Merge.RequiredChecks.ContainsOneOf("lint-extra") != "" {
+					t.Errorf("%s: literal pattern should only match lint", name)
+				}
+				if got := c.Update.Labels.ContainsOneOf("do-not-update") != ""; got != tt.wantLabelMatches {
+					t.Errorf("%s: label matches = %v, want %v", name, got, tt.wantLabelMatches)
+				}
+				if got := c.Merge.StrategyForBranch("fix/hotfix-1"); got != tt.wantStrategy {
+					t.Errorf("%s: StrategyForBranch() = %v, want %v", name, got, tt.wantStrategy)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseConfigLenient(t *testing.T) {
+	cfg, problems, err := parseConfigLenient([]byte(`
+version: 1
+merge:
+  labels: ["merge"]
+  requiredChecks: ["test", "([a-z", "lint"]
+  strategyByBranch:
+    - pattern: "[hotfix"
+      strategy: "commit"
+    - pattern: "^release/"
+      strategy: "rebase"
+update:
+  labels: ["update", "(("]
+`))
+	if err != nil {
+		t.Fatalf("parseConfigLenient() error = %v", err)
+	}
+
+	wantFields := []string{"merge.requiredChecks[1]", "update.labels[1]", "merge.strategyByBranch[0].pattern"}
+	if len(problems) != len(wantFields) {
+		t.Fatalf("got %d problems, want %d: %v", len(problems), len(wantFields), problems)
+	}
+	for i, field := range wantFields {
+		if problems[i].Field != field {
+			t.Errorf("problems[%d].Field = %q, want %q", i, problems[i].Field, field)
+		}
+	}
+	if got := problems[0].String(); !strings.Contains(got, "`merge.requiredChecks[1]` `([a-z' is not a valid regex") {
+		t.Errorf("problems[0].String() = %q", got)
+	}
+
+	if got := cfg.Merge.RequiredChecks.String(); got != "test, lint" {
+		t.Errorf("Merge.RequiredChecks = %q, want %q", got, "test, lint")
+	}
+	if got := cfg.Update.Labels.String(); got != "update" {
+		t.Errorf("Update.Labels = %q, want %q", got, "update")
+	}
+	if got := cfg.Merge.StrategyForBranch("release/1.0"); got != RebaseMergeStrategy {
+		t.Errorf("StrategyForBranch() = %q, want %q", got, RebaseMergeStrategy)
+	}
+
+	t.Run("valid config has no problems", func(t *testing.T) {
+		_, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  labels: [\"merge\"]\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 0 {
+			t.Errorf("got problems %v, want none", problems)
+		}
+	})
+
+	t.Run("ignore lists are read from merge and update", func(t *testing.T) {
+		cfg, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  ignoreFromUsers: [\"renovate\", \"((\"]\n" +
+			"update:\n  ignoreWithTitles: [\"^WIP\"]\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || problems[0].Field != "merge.ignoreFromUsers[1]" {
+			t.Fatalf("problems = %v, want merge.ignoreFromUsers[1]", problems)
+		}
+		if got := cfg.Merge.IsUserIgnored("renovate"); got == "" {
+			t.Error("IsUserIgnored() did not match merge.ignoreFromUsers")
+		}
+		if got := cfg.Update.IsTitleIgnored("WIP: feature"); got == "" {
+			t.Error("IsTitleIgnored() did not match update.ignoreWithTitles")
+		}
+	})
+
+	t.Run("strategyByBranch rules without pattern are dropped", func(t *testing.T) {
+		cfg, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  strategy: squash\n  strategyByBranch:\n" +
+			"    - strategy: rebase\n    - pattern: \"\"\n      strategy: rebase\n    - pattern: \"^release/\"\n      strategy: commit\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantFields := []string{"merge.strategyByBranch[0].pattern", "merge.strategyByBranch[1].pattern"}
+		if len(problems) != len(wantFields) {
+			t.Fatalf("problems = %v, want %v", problems, wantFields)
+		}
+		for i, field := range wantFields {
+			if problems[i].Field != field || problems[i].Message != "is required" {
+				t.Errorf("problems[%d] = %v, want %s to be required", i, problems[i], field)
+			}
+		}
+		if got := cfg.Merge.StrategyForBranch("feature"); got != SquashMergeStrategy {
+			t.Errorf("StrategyForBranch() = %q, want %q", got, SquashMergeStrategy)
+		}
+		if got := cfg.Merge.StrategyForBranch("release/1.0"); got != MergeCommitStrategy {
+			t.Errorf("StrategyForBranch() = %q, want %q", got, MergeCommitStrategy)
+		}
+
+		if _, err := parseConfig([]byte("version: 1\nmerge:\n  strategyByBranch:\n    - strategy: rebase\n")); err == nil {
+			t.Error("parseConfig() expected an error")
+		}
+	})
+
+	t.Run("overridesByLabel rules without label are dropped", func(t *testing.T) {
+		cfg, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  overridesByLabel:\n" +
+			"    - requireLinearHistory: true\n    - label: \"linear\"\n      requireLinearHistory: true\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || problems[0].Field != "merge.overridesByLabel[0].label" || problems[0].Message != "is required" {
+			t.Fatalf("problems = %v, want merge.overridesByLabel[0].label to be required", problems)
+		}
+		if cfg.Merge.RequireLinearHistoryForLabels([]string{"other"}) {
+			t.Error("RequireLinearHistoryForLabels() = true for a label without rule")
+		}
+		if !cfg.Merge.RequireLinearHistoryForLabels([]string{"linear"}) {
+			t.Error("RequireLinearHistoryForLabels() = false for the label of the rule")
+		}
+
+		if _, err := parseConfig([]byte("version: 1\nmerge:\n  overridesByLabel:\n    - requireLinearHistory: true\n")); err == nil {
+			t.Error("parseConfig() expected an error")
+		}
+	})
+
+	t.Run("unknown override strategy is dropped", func(t *testing.T) {
+		cfg, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  strategy: squash\n  overrides:\n" +
+			"    - authors: [\"renovate\\\\[bot\\\\]\"]\n      strategy: fast-forward\n      requiredApprovals: 0\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || problems[0].Field != "merge.overrides[0].strategy" {
+			t.Fatalf("problems = %v, want merge.overrides[0].strategy", problems)
+		}
+		// the rest of the rule is still applied
+		got, index := cfg.forAuthor("renovate[bot]")
+		if index != 0 || got.Merge.Strategy != SquashMergeStrategy || got.Merge.RequiredApprovals != 0 {
+			t.Errorf("forAuthor() = %+v, %d, want the rule without strategy", got.Merge, index)
+		}
+
+		if _, err := parseConfig([]byte("version: 1\nmerge:\n  overrides:\n    - strategy: fast-forward\n")); err == nil {
+			t.Error("parseConfig() expected an error")
+		}
+	})
+
+	t.Run("invalid postMergedComment is dropped", func(t *testing.T) {
+		for _, text := range []string{"{{.Unknown", "{{range 1000000000}}{{end}}"} {
+			cfg, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  postMergedComment: \"" + text + "\"\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 1 || problems[0].Field != "merge.postMergedComment" {
+				t.Errorf("%s: problems = %v, want merge.postMergedComment", text, problems)
+			}
+			if cfg.Merge.PostMergedComment != "" {
+				t.Errorf("%s: PostMergedComment = %q, want it to be dropped", text, cfg.Merge.PostMergedComment)
+			}
+		}
+	})
+
+	t.Run("other errors still fail", func(t *testing.T) {
+		if _, _, err := parseConfigLenient([]byte("version: 1\nmerge:\n  requiredApprovals: many\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestCreateOrUpdateCheckRun_ConfigProblems(t *testing.T) {
+	gh := newFakeGitHub()
+	worker := newTestPullRequestWorker(gh)
+	sess := newTestSession()
+	sess.ConfigProblems = []ConfigProblem{{Field: "merge.requiredChecks[1]", Message: "`([a-z' is not a valid regex"}}
+
+	logger := log.Logger
+	if err := worker.CreateOrUpdateCheckRun(
+		context.Background(), &logger, sess, "PR_1", "abc", "COMPLETED", "title", "summary",
+	); err != nil {
+		t.Fatal(err)
+	}
+	body := gh.LastRequestBody("CreateCheckRun")
+	if !strings.Contains(body, "merge.requiredChecks[1]") || !strings.Contains(body, "summary") {
+		t.Errorf("summary does not contain the problems and the summary: %s", body)
+	}
+}
+
+func Test_parseConfig_IgnoreApprovalsFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "defaults to bots", config: "version: 1\n", want: DefaultIgnoreApprovalsFrom.String()},
+		{name: "empty list counts bots", config: "version: 1\nmerge:\n  ignoreApprovalsFrom: []\n", want: ""},
+		{name: "custom list", config: "version: 1\nmerge:\n  ignoreApprovalsFrom: [\"machine-user\"]\n", want: "machine-user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig([]byte(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the empty list must survive the cache
+			buf, err := json.Marshal(&cachedConfig{ConfigV1: cfg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cached cachedConfig
+			if err := json.Unmarshal(buf, &cached); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, c := range map[string]*ConfigV1{"parsed": cfg, "cached": cached.ConfigV1} {
+				if got := c.Merge.IgnoreApprovalsFrom.String(); got != tt.want {
+					t.Errorf("%s: IgnoreApprovalsFrom = %q, want %q", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseConfig_RequireApprovalsFrom(t *testing.T) {
+	buf := []byte(`
+version: 1
+merge:
+  requireApprovalsFrom:
+    - "owner"
+    - anyOf: ["alice", "bob", "carol"]
+      count: 2
+`)
+	cfg, err := parseConfig(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Merge.RequireApprovalsFrom) != 2 {
+		t.Fatalf("got %d requirements, want 2", len(cfg.Merge.RequireApprovalsFrom))
+	}
+	if simple := cfg.Merge.RequireApprovalsFrom[0]; !simple.isSimple() || simple.AnyOf.String() != "owner" {
+		t.Errorf("RequireApprovalsFrom[0] = %+v, want the simple requirement owner", simple)
+	}
+	if group := cfg.Merge.RequireApprovalsFrom[1]; group.Count != 2 || group.AnyOf.String() != "alice, bob, carol" {
+		t.Errorf("RequireApprovalsFrom[1] = %+v, want 2 of alice, bob, carol", group)
+	}
+
+	out, err := yaml.Marshal(cfg.Merge.RequireApprovalsFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "- owner\n- anyOf:\n    - alice\n    - bob\n    - carol\n  count: 2\n"; string(out) != want {
+		t.Errorf("yaml.Marshal() = %q, want %q", out, want)
+	}
+
+	t.Run("empty anyOf", func(t *testing.T) {
+		if _, err := parseConfig([]byte("version: 1\nmerge:\n  requireApprovalsFrom:\n    - count: 2\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("invalid patterns in a group are reported", func(t *testing.T) {
+		cfg, problems, err := parseConfigLenient([]byte("version: 1\nmerge:\n  requireApprovalsFrom:\n" +
+			"    - anyOf: [\"alice\", \"([a-z\"]\n      count: 1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || problems[0].Field != "merge.requireApprovalsFrom[0].anyOf[1]" {
+			t.Fatalf("problems = %v", problems)
+		}
+		if got := cfg.Merge.RequireApprovalsFrom[0].AnyOf.String(); got != "alice" {
+			t.Errorf("AnyOf = %q, want alice", got)
+		}
+	})
+
+	t.Run("count greater than anyOf", func(t *testing.T) {
+		buf := []byte("version: 1\nmerge:\n  requireApprovalsFrom:\n    - anyOf: [\"alice\", \"bob\", \"([a-z\"]\n      count: 3\n")
+		cfg, problems, err := parseConfigLenient(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantFields := []string{"merge.requireApprovalsFrom[0].anyOf[2]", "merge.requireApprovalsFrom[0].count"}
+		if len(problems) != len(wantFields) || problems[0].Field != wantFields[0] || problems[1].Field != wantFields[1] {
+			t.Fatalf("problems = %v, want %v", problems, wantFields)
+		}
+		// the requirement is kept, all users need to approve
+		if group := cfg.Merge.RequireApprovalsFrom[0]; group.Count != 2 || group.AnyOf.String() != "alice, bob" {
+			t.Errorf("RequireApprovalsFrom[0] = %+v, want 2 of alice, bob", group)
+		}
+
+		if _, err := parseConfig([]byte("version: 1\nmerge:\n  requireApprovalsFrom:\n    - anyOf: [\"alice\"]\n      count: 2\n")); err == nil {
+			t.Error("parseConfig() expected an error")
+		}
+	})
+}
+
+func TestConfigV1_forAuthor(t *testing.T) {
+	base, err := parseConfig([]byte(`
+version: 1
+merge:
+  labels: ["merge"]
+  strategy: "squash"
+  requiredApprovals: 2
+  requiredChecks: ["test", "lint"]
+  strategyByBranch:
+    - pattern: "^hotfix/"
+      strategy: "commit"
+  overrides:
+    - authors: ["renovate.*", "dependabot.*"]
+      requiredApprovals: 0
+    - authors: ["dependabot.*"]
+      requiredApprovals: 1
+      requiredChecks: []
+      strategy: "rebase"
+    - authors: ["= bob"]
+      requiredChecks: ["test"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		author            string
+		wantIndex         int
+		wantApprovals     int
+		wantChecks        string
+		wantHotfixMerging MergeStrategy
+	}{
+		{author: "alice", wantIndex: -1, wantApprovals: 2, wantChecks: "test, lint", wantHotfixMerging: MergeCommitStrategy},
+		{author: "renovate[bot]", wantIndex: 0, wantApprovals: 0, wantChecks: "test, lint", wantHotfixMerging: MergeCommitStrategy},
+		// the first matching rule wins, even if a later one matches too
+		{author: "dependabot[bot]", wantIndex: 0, wantApprovals: 0, wantChecks: "test, lint", wantHotfixMerging: MergeCommitStrategy},
+		{author: "bob", wantIndex: 2, wantApprovals: 2, wantChecks: "test", wantHotfixMerging: MergeCommitStrategy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.author, func(t *testing.T) {
+			cfg, index := base.forAuthor(tt.author)
+			if index != tt.wantIndex {
+				t.Errorf("index = %d, want %d", index, tt.wantIndex)
+			}
+			if cfg.Merge.RequiredApprovals != tt.wantApprovals {
+				t.Errorf("RequiredApprovals = %d, want %d", cfg.Merge.RequiredApprovals, tt.wantApprovals)
+			}
+			if got := cfg.Merge.RequiredChecks.String(); got != tt.wantChecks {
+				t.Errorf("RequiredChecks = %q, want %q", got, tt.wantChecks)
+			}
+			if got := cfg.Merge.StrategyForBranch("hotfix/1"); got != tt.wantHotfixMerging {
+				t.Errorf("StrategyForBranch() = %q, want %q", got, tt.wantHotfixMerging)
+			}
+			if got := cfg.Merge.Labels.String(); got != "merge" {
+				t.Errorf("Labels = %q, want them to be inherited", got)
+			}
+		})
+	}
+
+	t.Run("unset values are inherited, empty values override", func(t *testing.T) {
+		rule := base.Merge.Overrides[1]
+		cfg := rule.applyTo(base.Merge)
+		if cfg.RequiredApprovals != 1 || len(cfg.RequiredChecks) != 0 || cfg.RequiredChecks == nil {
+			t.Errorf("RequiredApprovals = %d, RequiredChecks = %#v", cfg.RequiredApprovals, cfg.RequiredChecks)
+		}
+		if got := cfg.StrategyForBranch("hotfix/1"); got != RebaseMergeStrategy {
+			t.Errorf("StrategyForBranch() = %q, want the strategy of the override", got)
+		}
+		if base.Merge.RequiredApprovals != 2 || base.Merge.RequiredChecks.String() != "test, lint" {
+			t.Error("the base config must not be modified")
+		}
+	})
+}
+
+func Test_parseConfig_Order(t *testing.T) {
+	tests := []struct {
+		order   string
+		want    MergeOrder
+		wantErr bool
+	}{
+		{order: "", want: ""},
+		{order: "oldest", want: OldestFirstOrder},
+		{order: "newest", want: NewestFirstOrder},
+		{order: "random", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			cfg, err := parseConfig([]byte("version: 1\nmerge:\n  order: \"" + tt.order + "\"\n"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Merge.Order != tt.want {
+				t.Errorf("order = %q, want %q", cfg.Merge.Order, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// deadLetter moves the message to the DeadLetterSubject if this was its last delivery, so it can be
+// inspected and requeued with the dlq command. It reports whether the message was moved.
+func (worker *Worker) deadLetter(logger *zerolog.Logger, msg *nats.Msg, reason string) bool {
+	maxDeliver := worker.maxDeliver(msg.Subject)
+	if worker.DeadLetterSubject == "" || maxDeliver <= 0 {
+		return false
+	}
+	meta, err := msg.Metadata()
+	if err != nil || meta.NumDelivered < uint64(maxDeliver) {
+		return false
+	}
+
+	dead := nats.NewMsg(worker.DeadLetterSubject + "." + uuid.NewString())
+	dead.Data = msg.Data
+	dead.Header.Set(common.DeadLetterSubjectHeader, msg.Subject)
+	dead.Header.Set(common.DeadLetterReasonHeader, reason)
+	dead.Header.Set(common.DeadLetterQueuedAtHeader, meta.Timestamp.UTC().Format(time.RFC3339))
+	if _, err := worker.JetStreamContext.PublishMsg(dead); err != nil {
+		logger.Error().Err(err).Msg("unable to publish message to dead letter subject")
+		return false
+	}
+	logger.Warn().
+		Str("subject", msg.Subject).
+		Str("reason", reason).
+		Msg("message was moved to the dead letter subject")
+	if err := msg.Term(); err != nil {
+		logger.Error().Err(err).Msg("unable to terminate message")
+	}
+	worker.notifyDeadLetter(msg, reason)
+	return true
+}
+
+// notifyDeadLetter queues a notification about the dead lettered message if notifications are enabled.
+func (worker *Worker) notifyDeadLetter(msg *nats.Msg, reason string) {
+	// every message carries the repository, only pull request messages carry the pull request
+	var m common.QueuePullRequestMessage
+	_ = json.Unmarshal(msg.Data, &m)
+	worker.notify(Notification{
+		Action:      NotificationDeadLettered,
+		Repository:  m.Repository.FullName,
+		PullRequest: m.PullRequest.Number,
+		Reason:      reason,
+		Actor:       m.Sender.Login,
+	})
+}
+
+// maxDeliver returns the number of deliveries after which nats drops a message of the subject.
+func (worker *Worker) maxDeliver(subject string) int {
+	for prefix, n := range worker.MaxDeliverBySubject {
+		if strings.HasPrefix(subject, prefix+".") {
+			return n
+		}
+	}
+	return worker.MaxDeliver
+}
+
+// maxDeliveriesBeforeAlert returns the number of deliveries after which a message of the subject is logged as a
+// warning.
+func (worker *Worker) maxDeliveriesBeforeAlert(subject string) int {
+	if worker.MaxDeliveriesBeforeAlert > 0 {
+		return worker.MaxDeliveriesBeforeAlert
+	}
+	return worker.maxDeliver(subject) / 2 //nolint:gomnd // alert after half of the attempts
+}
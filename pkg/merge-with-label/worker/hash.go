@@ -1,11 +1,10 @@
 package worker
 
 import (
-	"crypto/sha512"
-	"encoding/hex"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 )
 
-func hashForKV(name string) string {
-	h := sha512.Sum512([]byte(name))
-	return hex.EncodeToString(h[:])
+// hashForKV returns the key the repository is stored with in the kv buckets, it is derived from the CacheKey of the repository.
+func hashForKV(repository *common.Repository) string {
+	return common.HashForKV(repository.CacheKey())
 }
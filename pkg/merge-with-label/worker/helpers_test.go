@@ -0,0 +1,260 @@
+package worker
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func newTestPrivateKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeKV is an in memory nats.KeyValue, only the methods used by the worker are implemented.
+type fakeKV struct {
+	nats.KeyValue
+	mu       sync.Mutex
+	entries  map[string]*fakeKVEntry
+	revision uint64
+	// ttl expires entries like the ttl of a bucket, entries never expire if 0.
+	ttl time.Duration
+	// now returns the current time, defaults to time.Now.
+	now func() time.Time
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{entries: make(map[string]*fakeKVEntry)}
+}
+
+type fakeKVEntry struct {
+	key      string
+	value    []byte
+	revision uint64
+	created  time.Time
+}
+
+func (e *fakeKVEntry) Bucket() string             { return "fake" }
+func (e *fakeKVEntry) Key() string                { return e.key }
+func (e *fakeKVEntry) Value() []byte              { return e.value }
+func (e *fakeKVEntry) Revision() uint64           { return e.revision }
+func (e *fakeKVEntry) Created() time.Time         { return e.created }
+func (e *fakeKVEntry) Delta() uint64              { return 0 }
+func (e *fakeKVEntry) Operation() nats.KeyValueOp { return nats.KeyValuePut }
+
+func (kv *fakeKV) Bucket() string {
+	return "fake"
+}
+
+func (kv *fakeKV) timeNow() time.Time {
+	if kv.now != nil {
+		return kv.now()
+	}
+	return time.Now()
+}
+
+// get returns the entry of the key if it exists and did not expire, kv.mu must be held.
+func (kv *fakeKV) get(key string) (*fakeKVEntry, bool) {
+	entry, ok := kv.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if kv.ttl > 0 && !kv.timeNow().Before(entry.created.Add(kv.ttl)) {
+		delete(kv.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+// put stores the value, kv.mu must be held.
+func (kv *fakeKV) put(key string, value []byte) uint64 {
+	kv.revision++
+	kv.entries[key] = &fakeKVEntry{key: key, value: value, revision: kv.revision, created: kv.timeNow()}
+	return kv.revision
+}
+
+func (kv *fakeKV) Get(key string) (nats.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.get(key)
+	if !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+func (kv *fakeKV) Put(key string, value []byte) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.put(key, value), nil
+}
+
+func (kv *fakeKV) PutString(key, value string) (uint64, error) {
+	return kv.Put(key, []byte(value))
+}
+
+func (kv *fakeKV) Create(key string, value []byte) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if _, ok := kv.get(key); ok {
+		return 0, nats.ErrKeyExists
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *fakeKV) Update(key string, value []byte, last uint64) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.get(key)
+	if !ok || entry.revision != last {
+		return 0, nats.ErrKeyExists
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *fakeKV) Delete(key string, _ ...nats.DeleteOpt) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.entries, key)
+	return nil
+}
+
+func (kv *fakeKV) Purge(key string, opts ...nats.DeleteOpt) error {
+	return kv.Delete(key, opts...)
+}
+
+func (kv *fakeKV) Keys(...nats.WatchOpt) ([]string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var keys []string
+	for key := range kv.entries {
+		if _, ok := kv.get(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nats.ErrNoKeysFound
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+var graphQLOperationRegex = regexp.MustCompile(`(?:query|mutation)\s+(\w+)`)
+
+// fakeGitHub records the graphql operations that were called.
+// Responses can be set per operation, the default response is an empty data object.
+type fakeGitHub struct {
+	mu         sync.Mutex
+	operations []string
+	bodies     map[string]string
+	responses  map[string]string
+	onRequest  func(operation string)
+}
+
+func newFakeGitHub() *fakeGitHub {
+	return &fakeGitHub{responses: make(map[string]string), bodies: make(map[string]string)}
+}
+
+func (f *fakeGitHub) Client() *http.Client {
+	return &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			var req struct {
+				Query string `json:"query"`
+			}
+			var body []byte
+			if r.Body != nil {
+				body, _ = io.ReadAll(r.Body)
+				_ = json.Unmarshal(body, &req)
+			}
+			operation := r.URL.String()
+			if m := graphQLOperationRegex.FindStringSubmatch(req.Query); m != nil {
+				operation = m[1]
+			}
+			f.mu.Lock()
+			f.operations = append(f.operations, operation)
+			f.bodies[operation] = string(body)
+			response, ok := f.responses[operation]
+			onRequest := f.onRequest
+			f.mu.Unlock()
+			if onRequest != nil {
+				onRequest(operation)
+			}
+			if !ok {
+				response = `{"data":{}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(response)),
+				Request:    r,
+			}, nil
+		}),
+	}
+}
+
+// LastRequestBody returns the body of the last request for the operation.
+func (f *fakeGitHub) LastRequestBody(operation string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bodies[operation]
+}
+
+func (f *fakeGitHub) Operations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.operations...)
+}
+
+// checkStatesOf returns the check states for a map of check names to states.
+func checkStatesOf(states map[string]string) map[string]github.CheckState {
+	result := make(map[string]github.CheckState, len(states))
+	for name, state := range states {
+		result[name] = github.CheckState{Name: name, State: state}
+	}
+	return result
+}
+
+// newTestSessionWorker returns a worker that can create sessions for owner/repo (installation 1) with the config,
+// the default branch is main at sha def.
+func newTestSessionWorker(t *testing.T, gh *fakeGitHub, config string) *Worker {
+	t.Helper()
+	repository := &common.Repository{FullName: "owner/repo", OwnerName: "owner", Name: "repo", NodeID: "R_1"}
+	worker := newTestPullRequestWorker(gh).Worker
+	worker.AccessTokensKV = newFakeKV()
+	worker.ConfigsKV = newFakeKV()
+	token, err := json.Marshal(github.AccessToken{Token: "token", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worker.AccessTokensKV.Put(common.AccessTokenKVKey(1, repository), token); err != nil {
+		t.Fatal(err)
+	}
+	gh.responses["GetLatestBaseCommitSha"] = `{"data":{"repository":{"defaultBranchRef":{"name":"main","target":{"oid":"def"}}}}}`
+	gh.responses["https://raw.githubusercontent.com/owner/repo/def/"+github.ConfigFilePath] = config
+	gh.responses["https://raw.githubusercontent.com/owner/repo/main/"+github.ConfigFilePath] = config
+	return worker
+}
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// shouldGiveUpImmediateMerge reports whether a pull request with one of the merge.immediateLabels cannot be merged.
+// result and err are the outcome of shouldSkipMerge. Skip conditions are final, delays of the bot itself
+// (e.g. the last commit is too recent or not all check suites are completed) are retried once per commit.
+func (worker *pullRequestWorker) shouldGiveUpImmediateMerge(
+	logger *zerolog.Logger,
+	details *github.PullRequestDetails,
+	result *shouldSkipResult,
+	err error,
+) bool {
+	if result.SkipAction {
+		return true
+	}
+	if !errors.As(err, new(pushBackError)) {
+		return false
+	}
+	if result.SkipReason != "" {
+		// a skip condition fired, the bot would only wait for it to change, e.g. for pending checks
+		return true
+	}
+	if worker.CheckRunsKV == nil {
+		return true
+	}
+	if _, err := worker.CheckRunsKV.Create(common.ImmediateMergeRetryKVKey(details.ID, details.LastCommitSha), []byte(worker.ID)); err != nil {
+		if !errors.Is(err, nats.ErrKeyExists) {
+			logger.Warn().Err(err).Msg("unable to store the immediate merge retry in kv bucket")
+		}
+		return true
+	}
+	logger.Debug().Msg("retrying the immediate merge once")
+	return false
+}
+
+// giveUpImmediateMerge reports why a pull request with one of the merge.immediateLabels could not be merged
+// and removes the labels, so the pull request is not evaluated again until the label is added again.
+func (worker *pullRequestWorker) giveUpImmediateMerge(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+	labels []string,
+	result *shouldSkipResult,
+) error {
+	reason := result.checkRunTitle("")
+	if reason == "" {
+		reason = "pull request is not ready yet"
+	}
+	logger.Info().Str("skip_reason", string(result.SkipReason)).Strs("labels", labels).Msg("not merging immediately")
+
+	quoted := make([]string, len(labels))
+	for i := range labels {
+		quoted[i] = "`" + labels[i] + "`"
+	}
+	summary := fmt.Sprintf("Removed %s, add it again to retry.", strings.Join(quoted, ", "))
+	if result.Summary != "" {
+		summary = result.Summary + "\n\n" + summary
+	}
+	if err := worker.CreateOrUpdateCheckRun(
+		ctx,
+		logger,
+		sess,
+		details.ID,
+		details.LastCommitSha,
+		"COMPLETED",
+		"not merging immediately: "+reason,
+		summary,
+		result.Annotations...,
+	); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var labelIDs []string
+	for _, label := range labels {
+		if id, ok := details.LabelIDs[label]; ok {
+			labelIDs = append(labelIDs, id)
+		}
+	}
+	if len(labelIDs) == 0 {
+		return nil
+	}
+	if err := github.RemoveLabels(ctx, worker.HTTPClient, sess.AccessToken, details.ID, labelIDs); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
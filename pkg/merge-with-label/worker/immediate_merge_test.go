@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestPullRequestWorker_mergePullRequest_ImmediateLabels(t *testing.T) {
+	pendingChecks := func(details *github.PullRequestDetails) {
+		details.CheckStates = checkStatesOf(map[string]string{"ci": "IN_PROGRESS"})
+	}
+	conflicts := func(details *github.PullRequestDetails) {
+		details.HasConflicts = true
+		details.IsMergeable = false
+	}
+	tests := []struct {
+		name                  string
+		labels                []string
+		waitForAllCheckSuites bool
+		requiredChecks        common.RegexSlice
+		modify                func(*github.PullRequestDetails)
+		wantPushBack          bool
+		wantStop              bool
+		wantMerge             bool
+		wantRemove            bool
+		wantRemoveIDs         string
+	}{
+		{name: "merge label waits for checks", labels: []string{"merge"}, waitForAllCheckSuites: true, modify: pendingChecks, wantPushBack: true},
+		{
+			name:           "immediate label gives up on pending required checks",
+			labels:         []string{"ship-it"},
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem("ci")},
+			modify:         pendingChecks,
+			wantStop:       true,
+			wantRemove:     true,
+			wantRemoveIDs:  `"labelIds":["LA_2"]`,
+		},
+		{
+			name:                  "immediate label retries while check suites are running",
+			labels:                []string{"ship-it"},
+			waitForAllCheckSuites: true,
+			modify:                pendingChecks,
+			wantPushBack:          true,
+		},
+		{
+			name:          "immediate label gives up on conflicts",
+			labels:        []string{"ship-it"},
+			modify:        conflicts,
+			wantStop:      true,
+			wantRemove:    true,
+			wantRemoveIDs: `"labelIds":["LA_2"]`,
+		},
+		{name: "immediate label merges a ready pull request", labels: []string{"ship-it"}, wantMerge: true},
+		{
+			name:                  "merge label takes precedence",
+			labels:                []string{"merge", "ship-it"},
+			waitForAllCheckSuites: true,
+			modify:                pendingChecks,
+			wantPushBack:          true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			sess.Config.Merge.ImmediateLabels = common.RegexSlice{common.MustNewRegexItem("ship-it")}
+			sess.Config.Merge.WaitForAllCheckSuites = tt.waitForAllCheckSuites
+			sess.Config.Merge.RequiredChecks = tt.requiredChecks
+			details := newTestPullRequestDetails()
+			details.Labels = tt.labels
+			details.LabelIDs = map[string]string{"merge": "LA_1", "ship-it": "LA_2"}
+			if tt.modify != nil {
+				tt.modify(details)
+			}
+
+			logger := log.Logger
+			stop, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+			var pbErr pushBackError
+			if isPushBack := errors.As(err, &pbErr); isPushBack != tt.wantPushBack {
+				t.Fatalf("mergePullRequest() error = %v, want push back %v", err, tt.wantPushBack)
+			}
+			if !tt.wantPushBack && err != nil {
+				t.Fatalf("mergePullRequest() error = %v", err)
+			}
+			if stop != tt.wantStop || didMerge != tt.wantMerge {
+				t.Errorf("mergePullRequest() = %v, %v, want %v, %v", stop, didMerge, tt.wantStop, tt.wantMerge)
+			}
+			if got := countOperations(gh, "RemoveLabels") != 0; got != tt.wantRemove {
+				t.Fatalf("RemoveLabels called = %v, want %v", got, tt.wantRemove)
+			}
+			if tt.wantRemove {
+				body := gh.LastRequestBody("RemoveLabels")
+				if !strings.Contains(body, tt.wantRemoveIDs) || !strings.Contains(body, `"labelableId":"PR_1"`) {
+					t.Errorf("RemoveLabels body = %s, want %s", body, tt.wantRemoveIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_ImmediateLabelsRetryOnce(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	gh := newFakeGitHub()
+	worker := newTestPullRequestWorker(gh)
+	worker.DurationBeforeMergeAfterCheck = time.Minute
+	worker.now = func() time.Time { return now }
+	sess := newTestSession()
+	sess.Config.Merge.ImmediateLabels = common.RegexSlice{common.MustNewRegexItem("ship-it")}
+	newDetails := func() *github.PullRequestDetails {
+		details := newTestPullRequestDetails()
+		details.Labels = []string{"ship-it"}
+		details.LabelIDs = map[string]string{"ship-it": "LA_2"}
+		details.LastCommitTime = now.Add(-30 * time.Second)
+		return details
+	}
+
+	logger := log.Logger
+	// the commit is too recent, this is a delay of the bot, not a reason to give up
+	if _, _, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, newDetails()); !errors.As(err, new(pushBackError)) {
+		t.Fatalf("mergePullRequest() error = %v, want pushBackError", err)
+	}
+	if got := countOperations(gh, "RemoveLabels"); got != 0 {
+		t.Fatalf("RemoveLabels called %d times, want 0", got)
+	}
+
+	// the commit is still too recent on the retry, e.g. because DurationBeforeMergeAfterCheck was raised
+	stop, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, newDetails())
+	if err != nil {
+		t.Fatalf("mergePullRequest() error = %v", err)
+	}
+	if !stop || didMerge {
+		t.Errorf("mergePullRequest() = %v, %v, want true, false", stop, didMerge)
+	}
+	if got := countOperations(gh, "RemoveLabels"); got != 1 {
+		t.Errorf("RemoveLabels called %d times, want 1", got)
+	}
+}
+
+func TestMergeConfigV1_immediateLabelsOf(t *testing.T) {
+	cfg := MergeConfigV1{ImmediateLabels: common.RegexSlice{common.MustNewRegexItem("^ship-it$")}}
+	got := cfg.immediateLabelsOf([]string{"merge", "ship-it", "bug"})
+	if len(got) != 1 || got[0] != "ship-it" {
+		t.Errorf("immediateLabelsOf() = %v, want [ship-it]", got)
+	}
+}
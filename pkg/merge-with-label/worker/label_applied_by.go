@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// rejectLabelAppliedBy reports whether the merge label was just added by a user that is not in merge.labelAppliedBy.
+// The label is removed again, so later messages do not merge the pull request, and the reason is written to the
+// check run. Only labeled messages are checked, other messages do not know who added the label. Messages without a
+// sender or label (queued by older servers) are not rejected.
+func (worker *pullRequestWorker) rejectLabelAppliedBy(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	msg *common.QueuePullRequestMessage,
+	details *github.PullRequestDetails,
+) (bool, error) {
+	allowed := sess.Config.Merge.LabelAppliedBy
+	if len(allowed) == 0 || msg.Action != "labeled" || msg.Sender.Login == "" || msg.Label == "" {
+		return false, nil
+	}
+	// the blocked label is added by the bot itself
+	if strings.EqualFold(msg.Label, sess.Config.Merge.BlockedLabel) ||
+		(sess.Config.Merge.Labels.ContainsOneOf(msg.Label) == "" &&
+			sess.Config.Merge.ImmediateLabels.ContainsOneOf(msg.Label) == "") {
+		return false, nil
+	}
+	if allowed.ContainsOneOf(msg.Sender.Login) != "" {
+		return false, nil
+	}
+
+	logger.Info().
+		Str("sender", msg.Sender.Login).
+		Str("label", msg.Label).
+		Msg("label was added by a user that is not in merge.labelAppliedBy")
+	if id, ok := details.LabelIDs[msg.Label]; ok {
+		if err := github.RemoveLabels(ctx, worker.HTTPClient, sess.AccessToken, details.ID, []string{id}); err != nil {
+			return true, errors.WithStack(err)
+		}
+	}
+	if err := worker.CreateOrUpdateCheckRun(
+		ctx,
+		logger,
+		sess,
+		details.ID,
+		details.LastCommitSha,
+		"COMPLETED",
+		fmt.Sprintf("label added by %s, who is not allowed to merge", msg.Sender.Login),
+		fmt.Sprintf("Only users in `merge.labelAppliedBy` can add the merge label, `%s` was removed.", msg.Label),
+	); err != nil {
+		return true, errors.WithStack(err)
+	}
+	return true, nil
+}
@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestPullRequestWorker_rejectLabelAppliedBy(t *testing.T) {
+	tests := []struct {
+		name           string
+		labelAppliedBy common.RegexSlice
+		action         string
+		sender         string
+		label          string
+		labels         []string
+		want           bool
+	}{
+		{name: "not configured", action: "labeled", sender: "mallory", label: "merge", labels: []string{"merge"}},
+		{
+			name:           "allowed user",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "labeled",
+			sender:         "octocat",
+			label:          "merge",
+			labels:         []string{"merge"},
+		},
+		{
+			name:           "not allowed user",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "labeled",
+			sender:         "mallory",
+			label:          "merge",
+			labels:         []string{"merge"},
+			want:           true,
+		},
+		{
+			name:           "other actions bypass the check",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "synchronize",
+			sender:         "mallory",
+			label:          "merge",
+			labels:         []string{"merge"},
+		},
+		{
+			name:           "no sender",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "labeled",
+			label:          "merge",
+			labels:         []string{"merge"},
+		},
+		{
+			name:           "other label was added",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "labeled",
+			sender:         "mallory",
+			label:          "bug",
+			labels:         []string{"bug"},
+		},
+		{
+			name:           "other label was added to a pull request with the merge label",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "labeled",
+			sender:         "mallory",
+			label:          "merge-blocked",
+			labels:         []string{"merge", "merge-blocked"},
+		},
+		{
+			name:           "no label",
+			labelAppliedBy: common.RegexSlice{common.MustNewRegexItem("^octocat$")},
+			action:         "labeled",
+			sender:         "mallory",
+			labels:         []string{"merge"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			sess.Config.Merge.LabelAppliedBy = tt.labelAppliedBy
+			sess.Config.Merge.BlockedLabel = "merge-blocked"
+			details := newTestPullRequestDetails()
+			details.Labels = tt.labels
+			details.LabelIDs = map[string]string{}
+			for _, label := range tt.labels {
+				details.LabelIDs[label] = "L_" + label
+			}
+			msg := &common.QueuePullRequestMessage{Action: tt.action, Label: tt.label}
+			msg.Sender.Login = tt.sender
+
+			logger := log.Logger
+			got, err := worker.rejectLabelAppliedBy(context.Background(), &logger, sess, msg, details)
+			if err != nil {
+				t.Fatalf("rejectLabelAppliedBy() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rejectLabelAppliedBy() = %v, want %v", got, tt.want)
+			}
+			if wrote := countOperations(gh, "CreateCheckRun") != 0; wrote != tt.want {
+				t.Errorf("check run written = %v, want %v", wrote, tt.want)
+			}
+			// the label is removed, so later messages do not merge the pull request
+			if removed := countOperations(gh, "RemoveLabels") != 0; removed != tt.want {
+				t.Errorf("label removed = %v, want %v", removed, tt.want)
+			}
+		})
+	}
+}
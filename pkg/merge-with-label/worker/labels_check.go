@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// missingLabelColor is the color of labels created by createMissingLabels.
+const missingLabelColor = "0e8a16"
+
+// missingLabel is a configured label that does not exist in the repository.
+type missingLabel struct {
+	Name    string
+	Setting string
+}
+
+// labelName returns the label name the item refers to, ok is false if the item is a regular expression
+// or negated, those can not be checked against the labels of a repository.
+func labelName(item *common.RegexItem) (name string, ok bool) {
+	if item.Negated() {
+		return "", false
+	}
+	if literal, ok := item.Literal(); ok {
+		return literal, literal != ""
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(item.Text, "^"), "$")
+	return name, name != "" && regexp.QuoteMeta(name) == name
+}
+
+// missingLabels returns the labels of merge.labels, merge.immediateLabels, update.labels and merge.blockedLabel
+// that are not part of repositoryLabels.
+func missingLabels(cfg *ConfigV1, repositoryLabels []string) []missingLabel {
+	settings := []struct {
+		name   string
+		labels common.RegexSlice
+	}{
+		{name: "merge.labels", labels: cfg.Merge.Labels},
+		{name: "merge.immediateLabels", labels: cfg.Merge.ImmediateLabels},
+		{name: "update.labels", labels: cfg.Update.Labels},
+	}
+
+	var missing []missingLabel
+	seen := make(map[string]struct{})
+	for _, setting := range settings {
+		for i := range setting.labels {
+			name, ok := labelName(&setting.labels[i])
+			if !ok {
+				continue
+			}
+			if _, ok := seen[strings.ToLower(name)]; ok {
+				continue
+			}
+			seen[strings.ToLower(name)] = struct{}{}
+			if !containsFold(repositoryLabels, name) {
+				missing = append(missing, missingLabel{Name: name, Setting: setting.name})
+			}
+		}
+	}
+	if name := cfg.Merge.BlockedLabel; name != "" {
+		if _, ok := seen[strings.ToLower(name)]; !ok && !containsFold(repositoryLabels, name) {
+			missing = append(missing, missingLabel{Name: name, Setting: "merge.blockedLabel"})
+		}
+	}
+	return missing
+}
+
+func containsFold(sl []string, s string) bool {
+	for i := range sl {
+		if strings.EqualFold(sl[i], s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConfiguredLabels makes sure the labels used in the config exist in the repository.
+// Missing labels are created if createMissingLabels is enabled, otherwise a check run on the base commit reports them.
+// The check runs once per repository per day, this is tracked in the configs kv bucket.
+func (worker *Worker) checkConfiguredLabels(ctx context.Context, logger *zerolog.Logger, sess *session) error {
+	if worker.ConfigsKV == nil {
+		return nil
+	}
+
+	key := common.LabelCheckKVKey(sess.Repository)
+	if _, err := worker.ConfigsKV.Create(key, []byte(worker.ID)); err != nil {
+		if errors.Is(err, nats.ErrKeyExists) {
+			logger.Debug().Msg("labels were already checked")
+			return nil
+		}
+		return errors.Wrap(err, "unable to create label check in kv bucket")
+	}
+
+	err := worker.doCheckConfiguredLabels(ctx, logger, sess)
+	if err != nil {
+		// allow the next session to try again
+		if err := worker.ConfigsKV.Delete(key); err != nil {
+			logger.Error().Err(err).Msg("unable to delete label check from kv bucket")
+		}
+	}
+	return err
+}
+
+func (worker *Worker) doCheckConfiguredLabels(ctx context.Context, logger *zerolog.Logger, sess *session) error {
+	repositoryLabels, err := github.GetRepositoryLabels(ctx, worker.HTTPClient, sess.AccessToken, sess.Repository)
+	if err != nil {
+		return errors.Wrap(err, "unable to get repository labels")
+	}
+	missing := missingLabels(sess.Config, repositoryLabels)
+	if len(missing) == 0 {
+		logger.Debug().Msg("all configured labels exist")
+		return nil
+	}
+
+	if sess.Config.CreateMissingLabels {
+		for _, label := range missing {
+			logger.Info().Str("label", label.Name).Str("setting", label.Setting).Msg("creating missing label")
+			if err := github.CreateLabel(
+				ctx,
+				worker.HTTPClient,
+				sess.AccessToken,
+				sess.Repository.NodeID,
+				label.Name,
+				missingLabelColor,
+				fmt.Sprintf("used by %s (%s)", worker.BotName, label.Setting),
+			); err != nil {
+				return errors.Wrapf(err, "unable to create label %q", label.Name)
+			}
+		}
+		return nil
+	}
+
+	lines := make([]string, len(missing))
+	names := make([]string, len(missing))
+	for i, label := range missing {
+		names[i] = label.Name
+		lines[i] = fmt.Sprintf("* `%s` (`%s`)", label.Name, label.Setting)
+	}
+	logger.Info().Strs("labels", names).Msg("configured labels do not exist")
+
+	title := "configured labels do not exist"
+	if len(missing) == 1 {
+		title = fmt.Sprintf("label `%s` does not exist", missing[0].Name)
+	}
+	summary := fmt.Sprintf(
+		"The following labels are used in `%s`, but do not exist in this repository:\n%s\n\n"+
+			"Create them or set `createMissingLabels: true` to let %s create them.",
+		github.ConfigFilePath,
+		strings.Join(lines, "\n"),
+		worker.BotName,
+	)
+	sha, err := worker.baseSHA(ctx, sess)
+	if err != nil {
+		return err
+	}
+	if sha == "" {
+		logger.Debug().Msg("latest commit sha is empty")
+		return nil
+	}
+	if _, err := github.CreateCheckRun(
+		ctx,
+		worker.HTTPClient,
+		sess.AccessToken,
+		sess.Repository,
+		sha,
+		"COMPLETED",
+		worker.checkRunName(sess),
+		title,
+		checkRunSummaryWithFooter(summary),
+	); err != nil {
+		return errors.Wrap(err, "unable to create check run")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+const repositoryLabelsResponse = `{"data":{"repository":{"labels":{"nodes":[{"name":"Merge"}],"pageInfo":{"hasNextPage":false}}}}}`
+
+func TestMissingLabels(t *testing.T) {
+	cfg := &ConfigV1{
+		Merge: MergeConfigV1{
+			Labels:          common.RegexSlice{common.MustNewRegexItem("^merge$"), common.MustNewRegexItem("auto-.*")},
+			ImmediateLabels: common.RegexSlice{common.MustNewRegexItem("= ship.it"), common.MustNewRegexItem("!wip")},
+			BlockedLabel:    "merge-blocked",
+		},
+		Update: UpdateConfigV1{
+			Labels: common.RegexSlice{common.MustNewRegexItem("update"), common.MustNewRegexItem("ship.it")},
+		},
+	}
+	got := missingLabels(cfg, []string{"Merge"})
+	want := []missingLabel{
+		{Name: "ship.it", Setting: "merge.immediateLabels"},
+		{Name: "update", Setting: "update.labels"},
+		{Name: "merge-blocked", Setting: "merge.blockedLabel"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("missingLabels() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("missingLabels()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWorker_checkConfiguredLabels(t *testing.T) {
+	tests := []struct {
+		name            string
+		createMissing   bool
+		wantCreateLabel int
+		wantCheckRun    int
+	}{
+		{name: "reports missing labels", wantCheckRun: 1},
+		{name: "creates missing labels", createMissing: true, wantCreateLabel: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetRepositoryLabels"] = repositoryLabelsResponse
+			worker := newTestPullRequestWorker(gh).Worker
+			worker.ConfigsKV = newFakeKV()
+			sess := newTestSession()
+			sess.BaseSHA = "def"
+			sess.Config.Update.Labels = common.RegexSlice{common.MustNewRegexItem("update")}
+			sess.Config.CreateMissingLabels = tt.createMissing
+
+			logger := log.Logger
+			for i := 0; i < 2; i++ {
+				if err := worker.checkConfiguredLabels(context.Background(), &logger, sess); err != nil {
+					t.Fatalf("checkConfiguredLabels() error = %v", err)
+				}
+			}
+			if got := countOperations(gh, "GetRepositoryLabels"); got != 1 {
+				t.Errorf("GetRepositoryLabels called %d times, want 1", got)
+			}
+			if got := countOperations(gh, "CreateLabel"); got != tt.wantCreateLabel {
+				t.Errorf("CreateLabel called %d times, want %d", got, tt.wantCreateLabel)
+			}
+			if got := countOperations(gh, "CreateCheckRun"); got != tt.wantCheckRun {
+				t.Errorf("CreateCheckRun called %d times, want %d", got, tt.wantCheckRun)
+			}
+			if tt.wantCreateLabel > 0 {
+				body := gh.LastRequestBody("CreateLabel")
+				if !strings.Contains(body, `"name":"update"`) || !strings.Contains(body, `"repositoryId":"R_1"`) {
+					t.Errorf("CreateLabel body = %s", body)
+				}
+			}
+			if tt.wantCheckRun > 0 {
+				body := gh.LastRequestBody("CreateCheckRun")
+				if !strings.Contains(body, "label `update` does not exist") {
+					t.Errorf("CreateCheckRun body = %s", body)
+				}
+			}
+		})
+	}
+}
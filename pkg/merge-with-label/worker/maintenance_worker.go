@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+type maintenanceWorker struct {
+	*Worker
+}
+
+func (worker *maintenanceWorker) runLogic(rootLogger *zerolog.Logger, msg *common.QueueMaintenanceMessage) error {
+	logger := rootLogger.With().
+		Str("entry", "maintenance").
+		Str("repo", msg.Repository.FullName).
+		Str("deleted_label", msg.DeletedLabel).
+		Str("merge_group_head_sha", msg.MergeGroupHeadSHA).
+		Logger()
+	ctx, done := worker.withBudget(&logger, worker.MaxDurationForPushWorker)
+	defer done()
+	ctx = worker.withMutationThrottle(ctx, &logger, msg.InstallationID)
+
+	if msg.DeletedLabel == "" && msg.MergeGroupHeadSHA == "" {
+		return nil
+	}
+
+	sess, err := worker.getSession(ctx, &logger, &msg.BaseMessage)
+	if err != nil {
+		return errors.Wrap(err, "unable to get session")
+	}
+	if sess == nil {
+		return nil
+	}
+
+	if msg.MergeGroupHeadSHA != "" {
+		return worker.passMergeGroup(ctx, &logger, sess, msg.MergeGroupHeadSHA)
+	}
+	return worker.notifyAboutDeletedLabel(ctx, &logger, sess, msg.DeletedLabel)
+}
+
+// passMergeGroup creates a successful check run on the head of a merge group.
+// Merge groups are not evaluated, the check run only exists so merge queues that require it are not blocked.
+func (worker *Worker) passMergeGroup(ctx context.Context, logger *zerolog.Logger, sess *session, sha string) error {
+	logger.Debug().Msg("passing merge group")
+	if err := github.CreateSucceededCheckRun(
+		ctx,
+		worker.HTTPClient,
+		sess.AccessToken,
+		sess.Repository,
+		sha,
+		worker.checkRunName(sess),
+		"merge group",
+	); err != nil {
+		return errors.Wrap(err, "unable to create check run for merge group")
+	}
+	return nil
+}
+
+// settingsReferencingLabel returns the config settings that reference the label.
+func settingsReferencingLabel(cfg *ConfigV1, label string) []string {
+	var settings []string
+	if containsLabel(cfg.Merge.Labels, label) {
+		settings = append(settings, "merge.labels")
+	}
+	if containsLabel(cfg.Merge.ImmediateLabels, label) {
+		settings = append(settings, "merge.immediateLabels")
+	}
+	if containsLabel(cfg.Update.Labels, label) {
+		settings = append(settings, "update.labels")
+	}
+	return settings
+}
+
+// containsLabel reports whether the slice references the label by its name.
+// Regular expressions that happen to match the label are not considered a reference.
+func containsLabel(sl common.RegexSlice, label string) bool {
+	for _, item := range sl {
+		if strings.EqualFold(item.Text, label) {
+			return true
+		}
+	}
+	return false
+}
+
+func (worker *Worker) notifyAboutDeletedLabel(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	label string,
+) error {
+	settings := settingsReferencingLabel(sess.Config, label)
+	if len(settings) == 0 {
+		logger.Debug().Msg("deleted label is not referenced in config")
+		return nil
+	}
+
+	logger.Info().Strs("settings", settings).Msg("configured label was deleted")
+
+	title := fmt.Sprintf("label `%s` was deleted", label)
+	summary := fmt.Sprintf(
+		"The label `%s` was deleted from this repository, but it is still used in `%s` of `%s`.\n"+
+			"%s will not act on pull requests until the label is created again or the config is updated.",
+		label,
+		strings.Join(settings, "`, `"),
+		github.ConfigFilePath,
+		worker.BotName,
+	)
+
+	switch sess.Config.LabelDeletedNotification {
+	case NoNotification:
+		return nil
+	case IssueNotification:
+		if err := github.CreateIssue(ctx, worker.HTTPClient, sess.AccessToken, sess.Repository, title, summary); err != nil {
+			return errors.Wrap(err, "unable to create issue")
+		}
+		return nil
+	case CheckRunNotification, "":
+		sha, err := worker.baseSHA(ctx, sess)
+		if err != nil {
+			return err
+		}
+		if sha == "" {
+			logger.Debug().Msg("latest commit sha is empty")
+			return nil
+		}
+		if _, err := github.CreateCheckRun(
+			ctx,
+			worker.HTTPClient,
+			sess.AccessToken,
+			sess.Repository,
+			sha,
+			"COMPLETED",
+			worker.checkRunName(sess),
+			title,
+			checkRunSummaryWithFooter(summary),
+		); err != nil {
+			return errors.Wrap(err, "unable to create check run")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown notification type `%s'", sess.Config.LabelDeletedNotification)
+	}
+}
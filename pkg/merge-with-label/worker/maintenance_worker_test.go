@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func Test_settingsReferencingLabel(t *testing.T) {
+	cfg := &ConfigV1{
+		Merge: MergeConfigV1{
+			Labels: common.RegexSlice{common.MustNewRegexItem("merge"), common.MustNewRegexItem("automerge.*")},
+		},
+		Update: UpdateConfigV1{
+			Labels: common.RegexSlice{common.MustNewRegexItem("update-branch"), common.MustNewRegexItem("Merge")},
+		},
+	}
+	tests := []struct {
+		name  string
+		label string
+		want  []string
+	}{
+		{
+			name:  "label is referenced in merge and update",
+			label: "merge",
+			want:  []string{"merge.labels", "update.labels"},
+		},
+		{
+			name:  "label is referenced in update",
+			label: "update-branch",
+			want:  []string{"update.labels"},
+		},
+		{
+			name:  "label that only matches a regex is not referenced",
+			label: "automerge-please",
+			want:  nil,
+		},
+		{
+			name:  "label is not referenced",
+			label: "bug",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := settingsReferencingLabel(cfg, tt.label); !slices.Equal(got, tt.want) {
+				t.Errorf("settingsReferencingLabel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_notifyAboutDeletedLabel(t *testing.T) {
+	tests := []struct {
+		name           string
+		notification   NotificationType
+		label          string
+		baseSHA        string
+		wantOperations []string
+	}{
+		{
+			name:           "check run is created by default",
+			notification:   "",
+			label:          "merge",
+			wantOperations: []string{"GetLatestBaseCommitSha", "CreateCheckRun"},
+		},
+		{
+			name:           "check run uses the base sha of the session",
+			notification:   CheckRunNotification,
+			label:          "merge",
+			baseSHA:        "def",
+			wantOperations: []string{"CreateCheckRun"},
+		},
+		{
+			name:           "issue is created",
+			notification:   IssueNotification,
+			label:          "merge",
+			wantOperations: []string{"CreateIssue"},
+		},
+		{
+			name:           "notification is disabled",
+			notification:   NoNotification,
+			label:          "merge",
+			wantOperations: nil,
+		},
+		{
+			name:           "label is not referenced",
+			notification:   CheckRunNotification,
+			label:          "bug",
+			wantOperations: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetLatestBaseCommitSha"] = `{"data":{"repository":{"defaultBranchRef":{"target":{"oid":"abc"}}}}}`
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			sess.Config.LabelDeletedNotification = tt.notification
+			sess.BaseSHA = tt.baseSHA
+
+			logger := log.Logger
+			if err := worker.notifyAboutDeletedLabel(context.Background(), &logger, sess, tt.label); err != nil {
+				t.Fatalf("notifyAboutDeletedLabel() error = %v", err)
+			}
+			if got := gh.Operations(); !slices.Equal(got, tt.wantOperations) {
+				t.Errorf("operations = %v, want %v", got, tt.wantOperations)
+			}
+		})
+	}
+}
+
+func TestWorker_passMergeGroup(t *testing.T) {
+	gh := newFakeGitHub()
+	worker := newTestPullRequestWorker(gh)
+	worker.CheckRunNamePrefix = "ci/"
+	sess := newTestSession()
+
+	logger := log.Logger
+	if err := worker.passMergeGroup(context.Background(), &logger, sess, "def"); err != nil {
+		t.Fatalf("passMergeGroup() error = %v", err)
+	}
+	if got := gh.Operations(); !slices.Equal(got, []string{"CreateSucceededCheckRun"}) {
+		t.Fatalf("operations = %v", got)
+	}
+	body := gh.LastRequestBody("CreateSucceededCheckRun")
+	for _, want := range []string{`"sha":"def"`, `"name":"ci/merge-with-label"`, `"repositoryId":"R_1"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body = %s, want %s", body, want)
+		}
+	}
+}
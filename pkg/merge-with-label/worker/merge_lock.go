@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// acquireMergeLock locks the merge of a pull request so that only one worker merges it at a time.
+// If another worker holds the lock a pushBackError is returned.
+// The returned function releases the lock, it must be called once the merge is done or failed.
+func (worker *Worker) acquireMergeLock(logger *zerolog.Logger, pullRequestNodeID string) (func(), error) {
+	return worker.acquireLock(logger, common.MergeLockKVKey(pullRequestNodeID), "pull request is being merged by another worker")
+}
+
+// acquireRepositoryMergeLock locks the merges of a repository so that only one pull request of the repository is
+// merged at a time, this is used when a linear history is required.
+// If another pull request of the repository is being merged a pushBackError is returned.
+// The lock expires with the TTL of the merge locks bucket, so a crashed worker does not block the repository forever.
+func (worker *Worker) acquireRepositoryMergeLock(logger *zerolog.Logger, repository *common.Repository) (func(), error) {
+	return worker.acquireLock(
+		logger,
+		common.RepositoryMergeLockKVKey(repository),
+		"another pull request of the repository is being merged",
+	)
+}
+
+func (worker *Worker) acquireLock(logger *zerolog.Logger, key, heldMessage string) (func(), error) {
+	if worker.MergeLocksKV == nil {
+		return func() {}, nil
+	}
+
+	if _, err := worker.MergeLocksKV.Create(key, []byte(worker.ID)); err != nil {
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return nil, errors.Wrap(err, "unable to create merge lock in kv bucket")
+		}
+		var holder string
+		if entry, err := worker.MergeLocksKV.Get(key); err == nil && entry != nil {
+			holder = string(entry.Value())
+		}
+		logger.Info().Str("lock_holder", holder).Msg(heldMessage)
+		return nil, pushBackError{delay: worker.RetryWait}
+	}
+
+	return func() {
+		if err := worker.MergeLocksKV.Delete(key); err != nil {
+			logger.Error().Err(err).Msg("unable to release merge lock")
+		}
+	}, nil
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestWorker_acquireRepositoryMergeLock_Race(t *testing.T) {
+	kv := newFakeKV()
+	repository := &common.Repository{FullName: "owner/repo", NodeID: "R_1"}
+	logger := zerolog.Nop()
+
+	const workers = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var acquired, pushedBack int
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := &Worker{MergeLocksKV: kv, RetryWait: time.Second}
+			_, err := worker.acquireRepositoryMergeLock(&logger, repository)
+			mu.Lock()
+			defer mu.Unlock()
+			var pbErr pushBackError
+			switch {
+			case err == nil:
+				acquired++
+			case errors.As(err, &pbErr):
+				pushedBack++
+			default:
+				t.Errorf("acquireRepositoryMergeLock() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if acquired != 1 || pushedBack != workers-1 {
+		t.Errorf("%d workers acquired the lock and %d were pushed back, want 1 and %d", acquired, pushedBack, workers-1)
+	}
+}
+
+func TestWorker_acquireRepositoryMergeLock_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	kv := newFakeKV()
+	kv.ttl = 2 * time.Minute
+	kv.now = func() time.Time { return now }
+	repository := &common.Repository{FullName: "owner/repo", NodeID: "R_1"}
+	logger := zerolog.Nop()
+
+	// the holder crashed without releasing the lock
+	crashed := &Worker{MergeLocksKV: kv, ID: "crashed"}
+	if _, err := crashed.acquireRepositoryMergeLock(&logger, repository); err != nil {
+		t.Fatal(err)
+	}
+
+	worker := &Worker{MergeLocksKV: kv, ID: "worker-1"}
+	var pbErr pushBackError
+	if _, err := worker.acquireRepositoryMergeLock(&logger, repository); !errors.As(err, &pbErr) {
+		t.Fatalf("acquireRepositoryMergeLock() error = %v, want pushBackError while the lock is held", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	release, err := worker.acquireRepositoryMergeLock(&logger, repository)
+	if err != nil {
+		t.Fatalf("acquireRepositoryMergeLock() error = %v, want the expired lock to be taken over", err)
+	}
+	release()
+	if _, err := kv.Get(common.RepositoryMergeLockKVKey(repository)); err == nil {
+		t.Error("lock should be released")
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_RepositoryLock(t *testing.T) {
+	tests := []struct {
+		name          string
+		linearHistory bool
+		lockHeld      bool
+		wantMerge     bool
+		wantSweep     bool
+	}{
+		{name: "linear history merges and queues a sweep", linearHistory: true, wantMerge: true, wantSweep: true},
+		{name: "linear history waits for the repository lock", linearHistory: true, lockHeld: true},
+		{name: "without linear history the repository lock is ignored", lockHeld: true, wantMerge: true, wantSweep: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			kv := newFakeKV()
+			js := &fakePublisher{}
+			worker.MergeLocksKV = kv
+			worker.JetStreamContext = js
+			worker.RateLimitKV = newFakeKV()
+			worker.PushSubject = "push"
+			sess := newTestSession()
+			sess.InstallationID = 1
+			sess.Config.Merge.RequireLinearHistory = tt.linearHistory
+			if tt.lockHeld {
+				if _, err := kv.Create(common.RepositoryMergeLockKVKey(sess.Repository), []byte("worker-2")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := log.Logger
+			_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, newTestPullRequestDetails())
+			var pbErr pushBackError
+			if tt.wantMerge && err != nil || !tt.wantMerge && !errors.As(err, &pbErr) {
+				t.Fatalf("mergePullRequest() error = %v", err)
+			}
+			if didMerge != tt.wantMerge || slices.Contains(gh.Operations(), "MergePullRequest") != tt.wantMerge {
+				t.Errorf("merged = %v, want %v", didMerge, tt.wantMerge)
+			}
+			if tt.wantMerge && tt.linearHistory {
+				if _, err := kv.Get(common.RepositoryMergeLockKVKey(sess.Repository)); err == nil {
+					t.Error("repository lock should be released after the merge")
+				}
+			}
+
+			if !tt.wantSweep {
+				if len(js.published) != 0 {
+					t.Errorf("published %d messages, want none", len(js.published))
+				}
+				return
+			}
+			if len(js.published) != 1 {
+				t.Fatalf("published %d messages, want 1", len(js.published))
+			}
+			var msg common.QueuePushMessage
+			if err := json.Unmarshal(js.published[0].Data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Branch != "main" || msg.Repository.FullName != "owner/repo" {
+				t.Errorf("queued %+v, want a push message for main of owner/repo", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// mergedCommentData are the variables that can be used in postMergedComment.
+type mergedCommentData struct {
+	Strategy MergeStrategy
+	HeadRef  string
+	BaseRef  string
+	Author   string
+}
+
+// maxMergedCommentSize is the maximum size of the rendered postMergedComment.
+const maxMergedCommentSize = 64 << 10
+
+var errMergedCommentTooLong = errors.Errorf("postMergedComment is longer than %d bytes", maxMergedCommentSize)
+
+// parseMergedComment parses the postMergedComment template.
+// The template comes from the repository, so range and template actions are not allowed, they could keep the worker
+// busy forever without writing anything. None of the variables is a list, so they are not needed.
+func parseMergedComment(text string) (*template.Template, error) {
+	tmpl, err := template.New("postMergedComment").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse postMergedComment")
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkMergedCommentNode(t.Tree.Root); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+func checkMergedCommentNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkMergedCommentNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkMergedCommentBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkMergedCommentBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return errors.New("range is not allowed in postMergedComment")
+	case *parse.TemplateNode:
+		return errors.New("template is not allowed in postMergedComment")
+	}
+	return nil
+}
+
+func checkMergedCommentBranch(node *parse.BranchNode) error {
+	if err := checkMergedCommentNode(node.List); err != nil {
+		return err
+	}
+	return checkMergedCommentNode(node.ElseList)
+}
+
+// limitedWriter fails once more than remaining bytes are written.
+type limitedWriter struct {
+	sb        strings.Builder
+	remaining int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		return 0, errMergedCommentTooLong
+	}
+	w.remaining -= len(p)
+	return w.sb.Write(p)
+}
+
+// renderMergedComment renders the postMergedComment template of the config, the result is limited to
+// maxMergedCommentSize.
+func renderMergedComment(cfg *ConfigV1, details *github.PullRequestDetails) (string, error) {
+	tmpl, err := parseMergedComment(cfg.Merge.PostMergedComment)
+	if err != nil {
+		return "", err
+	}
+	w := limitedWriter{remaining: maxMergedCommentSize}
+	if err := tmpl.Execute(&w, mergedCommentData{
+		Strategy: cfg.Merge.StrategyForBranch(details.HeadRefName),
+		HeadRef:  details.HeadRefName,
+		BaseRef:  details.BaseRefName,
+		Author:   details.Author,
+	}); err != nil {
+		return "", errors.Wrap(err, "unable to render postMergedComment")
+	}
+	return w.sb.String(), nil
+}
+
+// postMergedComment posts the postMergedComment on the merged pull request, nothing is done if it is empty.
+func (worker *Worker) postMergedComment(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	cfg *ConfigV1,
+	details *github.PullRequestDetails,
+) error {
+	if cfg.Merge.PostMergedComment == "" {
+		return nil
+	}
+	body, err := renderMergedComment(cfg, details)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil
+	}
+	logger.Debug().Msg("posting merged comment")
+	if err := github.PostIssueComment(ctx, worker.HTTPClient, sess.AccessToken, details.ID, body); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
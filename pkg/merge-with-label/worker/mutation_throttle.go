@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// installationThrottle takes the tokens for the mutations of an installation from the MutationThrottle.
+type installationThrottle struct {
+	logger         *zerolog.Logger
+	bucket         *common.TokenBucket
+	installationID int64
+}
+
+func (t *installationThrottle) Take() (time.Duration, error) {
+	wait, err := t.bucket.Take(common.MutationThrottleKVKey(t.installationID))
+	if err != nil {
+		// do not stop working because of the throttle
+		t.logger.Error().Err(err).Msg("unable to take a token for a mutation")
+		return 0, nil
+	}
+	if wait > 0 {
+		t.logger.Debug().Dur("wait", wait).Msg("mutations of the installation are throttled")
+	}
+	return wait, nil
+}
+
+// withMutationThrottle returns a context whose github mutations are limited by the MutationThrottle of the
+// installation, ctx is returned unchanged if MutationThrottle is nil.
+func (worker *Worker) withMutationThrottle(ctx context.Context, logger *zerolog.Logger, installationID int64) context.Context {
+	if worker.MutationThrottle == nil {
+		return ctx
+	}
+	return github.WithThrottle(ctx, &installationThrottle{
+		logger:         logger,
+		bucket:         worker.MutationThrottle,
+		installationID: installationID,
+	})
+}
+
+// waitForThrottle calls fn until its mutation is not throttled anymore, in between it waits for the next token.
+// It is used for mutations that can not be retried by redelivering the message, e.g. deleting the branch after the
+// merge. It gives up once ctx is done.
+func waitForThrottle(ctx context.Context, logger *zerolog.Logger, fn func() error) error {
+	for {
+		err := fn()
+		var throttledErr *github.ErrThrottled
+		if !errors.As(err, &throttledErr) {
+			return err
+		}
+		logger.Debug().Dur("wait", throttledErr.Wait).Msg("waiting for the mutation throttle")
+		timer := time.NewTimer(throttledErr.Wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(err, "gave up waiting for the mutation throttle")
+		case <-timer.C:
+		}
+	}
+}
+
+// requeueThrottled queues the message again with a delay and acks the delivery, so waiting for the throttle does
+// not use up the deliveries of the message and a busy installation never moves it to the dead letter subject.
+// If the message can not be queued it is redelivered like any other failed message.
+func (worker *Worker) requeueThrottled(logger *zerolog.Logger, msg *nats.Msg, delay time.Duration) {
+	requeued := nats.NewMsg(msg.Subject)
+	requeued.Data = msg.Data
+	for key, values := range msg.Header {
+		// the copy must not be dropped as a duplicate of the original message
+		if key != nats.MsgIdHdr {
+			requeued.Header[key] = values
+		}
+	}
+	requeued.Header.Set(common.DelayUntilHeader, worker.timeNow().Add(delay).Format(time.RFC3339))
+	if _, err := worker.JetStreamContext.PublishMsg(requeued); err != nil {
+		logger.Error().Err(err).Msg("unable to requeue throttled message")
+		if worker.deadLetter(logger, msg, "throttled on every delivery") {
+			return
+		}
+		if err := msg.NakWithDelay(delay); err != nil {
+			logger.Error().Err(err).Msg("unable to nak message")
+		}
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		logger.Error().Err(err).Msg("unable to ack message")
+	}
+}
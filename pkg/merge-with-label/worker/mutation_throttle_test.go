@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestWorker_withMutationThrottle(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	kv := newFakeKV()
+	worker := &Worker{
+		MutationThrottle: &common.TokenBucket{
+			KV:             kv,
+			Size:           1,
+			RefillInterval: time.Minute,
+			Now:            func() time.Time { return now },
+		},
+	}
+	logger := zerolog.Nop()
+	gh := newFakeGitHub()
+	gh.responses["DeleteRef"] = `{"data":{}}`
+	deleteRef := func(installationID int64) error {
+		ctx := worker.withMutationThrottle(context.Background(), &logger, installationID)
+		return github.DeleteRef(ctx, gh.Client(), "token", "ref")
+	}
+
+	if err := deleteRef(1); err != nil {
+		t.Fatalf("DeleteRef() error = %v", err)
+	}
+	var throttledErr *github.ErrThrottled
+	if err := deleteRef(1); !errors.As(err, &throttledErr) || throttledErr.Wait != time.Minute {
+		t.Errorf("DeleteRef() error = %v, want to be throttled for 1m", err)
+	}
+	if _, err := kv.Get(common.MutationThrottleKVKey(1)); err != nil {
+		t.Errorf("bucket of the installation was not stored: %v", err)
+	}
+	// other installations have their own bucket
+	if err := deleteRef(2); err != nil {
+		t.Errorf("DeleteRef() of another installation error = %v", err)
+	}
+	if got := len(gh.Operations()); got != 2 {
+		t.Errorf("sent %d mutations, want 2", got)
+	}
+
+	worker.MutationThrottle = nil
+	if err := deleteRef(1); err != nil {
+		t.Errorf("DeleteRef() without a MutationThrottle error = %v", err)
+	}
+}
+
+func TestHandleMessage_Throttled(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	worker := newTestCircuitBreakerWorker(&now)
+	js := &fakePublisher{}
+	worker.JetStreamContext = js
+	worker.RetryWait = time.Minute
+	const repo = "owner/throttled"
+
+	// throttled mutations are retried later and are no hard failures
+	for i := 0; i < 3; i++ {
+		err := &github.ErrThrottled{Wait: time.Second}
+		if !deliverCircuitBreakerMessage(worker, repo, err) {
+			t.Fatal("message was not processed")
+		}
+	}
+	if keys, _ := worker.CircuitBreakerKV.Keys(); len(keys) != 0 {
+		t.Errorf("stored %d circuit states, want 0", len(keys))
+	}
+
+	// the message is queued again, so waiting does not use up its deliveries
+	if len(js.published) != 3 {
+		t.Fatalf("requeued %d messages, want 3", len(js.published))
+	}
+	requeued := js.published[0]
+	if requeued.Subject != "pull_request.1" {
+		t.Errorf("subject = %q, want pull_request.1", requeued.Subject)
+	}
+	// waits at least RetryWait
+	if got := requeued.Header.Get(common.DelayUntilHeader); got != "2023-07-22T04:01:00Z" {
+		t.Errorf("delay until = %q, want 2023-07-22T04:01:00Z", got)
+	}
+}
+
+func TestHandleMessage_ThrottledOnLastDelivery(t *testing.T) {
+	js := &fakePublisher{}
+	logger := zerolog.Nop()
+	worker := &Worker{
+		AllowedRepositories: common.RegexSlice{common.MustNewRegexItem(".*")},
+		JetStreamContext:    js,
+		MaxDeliver:          3,
+		DeadLetterSubject:   "dead_letter",
+	}
+	msg := &nats.Msg{
+		Sub:     &nats.Subscription{},
+		Subject: "pull_request.1",
+		Reply:   "$JS.ACK.stream.consumer.3.10.10.1690000000000000000.0",
+		Header:  nats.Header{nats.MsgIdHdr: []string{"abc"}},
+		Data:    []byte(`{"repository":{"full_name":"owner/repo"}}`),
+	}
+	handleMessage[common.QueuePullRequestMessage](worker, &logger, msg,
+		func(*zerolog.Logger, *common.QueuePullRequestMessage) error {
+			return &github.ErrThrottled{Wait: time.Second}
+		})
+
+	if len(js.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(js.published))
+	}
+	if got := js.published[0].Subject; got != msg.Subject {
+		t.Errorf("subject = %q, want the message to be requeued to %q", got, msg.Subject)
+	}
+	if got := js.published[0].Header.Get(nats.MsgIdHdr); got != "" {
+		t.Errorf("msg id = %q, want none", got)
+	}
+}
+
+func TestWaitForThrottle(t *testing.T) {
+	worker := &Worker{
+		MutationThrottle: &common.TokenBucket{
+			KV:             newFakeKV(),
+			Size:           1,
+			RefillInterval: 10 * time.Millisecond,
+			Now:            time.Now,
+		},
+	}
+	logger := zerolog.Nop()
+	gh := newFakeGitHub()
+	gh.responses["DeleteRef"] = `{"data":{}}`
+	deleteRef := func(ctx context.Context) func() error {
+		return func() error {
+			return github.DeleteRef(worker.withMutationThrottle(ctx, &logger, 1), gh.Client(), "token", "ref")
+		}
+	}
+
+	// e.g. the merge took the only token
+	if err := deleteRef(context.Background())(); err != nil {
+		t.Fatalf("DeleteRef() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := waitForThrottle(ctx, &logger, deleteRef(ctx)); err != nil {
+		t.Fatalf("waitForThrottle() error = %v", err)
+	}
+	if got := len(gh.Operations()); got != 2 {
+		t.Errorf("sent %d mutations, want 2", got)
+	}
+
+	// gives up once the budget is used up
+	worker.MutationThrottle.RefillInterval = time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var throttledErr *github.ErrThrottled
+	if err := waitForThrottle(ctx, &logger, deleteRef(ctx)); !errors.As(err, &throttledErr) {
+		t.Errorf("waitForThrottle() error = %v, want ErrThrottled", err)
+	}
+}
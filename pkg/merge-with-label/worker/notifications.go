@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// Notification actions.
+const (
+	NotificationMerged       = "merged"
+	NotificationUpdated      = "updated"
+	NotificationMergeFailed  = "merge_failed"
+	NotificationDeadLettered = "dead_lettered"
+)
+
+const (
+	// notificationBufferSize is the amount of notifications that can be queued, further notifications are dropped.
+	notificationBufferSize = 64
+	// notificationAttempts is the amount of times a notification is sent before it is dropped.
+	notificationAttempts = 3
+	// notificationRetryWait is the wait before the first retry, it doubles with every attempt.
+	notificationRetryWait = time.Second
+)
+
+// Notification is the payload that is posted to the notification url.
+type Notification struct {
+	Action      string    `json:"action"`
+	Repository  string    `json:"repository"`
+	PullRequest int64     `json:"pull_request,omitempty"`
+	BaseBranch  string    `json:"base_branch,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Actor       string    `json:"actor,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Notifier posts notifications to a webhook in the background, so sending never delays the handling of messages.
+type Notifier struct {
+	logger *zerolog.Logger
+	url    string
+	// template renders the body, the Notification is sent as json if it is nil.
+	template   *template.Template
+	timeout    time.Duration
+	httpClient *http.Client
+	retryWait  time.Duration
+
+	ch   chan Notification
+	done chan struct{}
+}
+
+// NewNotifier returns a Notifier that posts to rawURL and starts its sender, it must be stopped with Close.
+// If tmpl is not empty it is used to render the body, otherwise the Notification is sent as json.
+func NewNotifier(logger *zerolog.Logger, httpClient *http.Client, rawURL, tmpl string, timeout time.Duration) (*Notifier, error) {
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return nil, errors.Errorf("invalid notification url %s", redactURL(rawURL))
+	}
+	n := &Notifier{
+		logger:     logger,
+		url:        rawURL,
+		timeout:    timeout,
+		httpClient: httpClient,
+		retryWait:  notificationRetryWait,
+		ch:         make(chan Notification, notificationBufferSize),
+		done:       make(chan struct{}),
+	}
+	if tmpl != "" {
+		t, err := ParseNotificationTemplate(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		n.template = t
+	}
+	go n.run()
+	return n, nil
+}
+
+// ParseNotificationTemplate parses the template that renders the body of notifications.
+func ParseNotificationTemplate(tmpl string) (*template.Template, error) {
+	t, err := template.New("notification").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse notification template")
+	}
+	return t, nil
+}
+
+// Send queues the notification, it is dropped if the queue is full.
+func (n *Notifier) Send(notification Notification) {
+	select {
+	case n.ch <- notification:
+	default:
+		n.logger.Warn().Str("action", notification.Action).Msg("notification queue is full, dropping notification")
+	}
+}
+
+// Close stops accepting notifications and waits until the queued ones were sent or ctx is done.
+func (n *Notifier) Close(ctx context.Context) error {
+	close(n.ch)
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for notification := range n.ch {
+		logger := n.logger.With().
+			Str("action", notification.Action).
+			Str("repo", notification.Repository).
+			Str("url", redactURL(n.url)).
+			Logger()
+		if err := n.send(notification); err != nil {
+			logger.Error().Err(err).Msg("unable to send notification")
+			continue
+		}
+		logger.Debug().Msg("notification sent")
+	}
+}
+
+func (n *Notifier) send(notification Notification) error {
+	body, err := n.render(notification)
+	if err != nil {
+		return err
+	}
+	wait := n.retryWait
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= notificationAttempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (n *Notifier) render(notification Notification) ([]byte, error) {
+	if n.template == nil {
+		buf, err := json.Marshal(notification)
+		return buf, errors.WithStack(err)
+	}
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, notification); err != nil {
+		return nil, errors.Wrap(err, "unable to render notification template")
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends the body once, retry reports whether sending it again might succeed.
+func (n *Notifier) post(body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.New("unable to create notification request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// the url might contain secrets, e.g. the token of a slack webhook
+			urlErr.URL = redactURL(n.url)
+		}
+		return true, errors.Wrap(err, "unable to send notification")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	return retry, errors.Errorf("notification was rejected with status %d", resp.StatusCode)
+}
+
+// redactURL returns the url without its user info, path and query, those might contain secrets.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "redacted"
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		redacted += "/redacted"
+	}
+	return redacted
+}
+
+// notify queues the notification if notifications are enabled.
+func (worker *Worker) notify(notification Notification) {
+	if worker.Notifications == nil {
+		return
+	}
+	notification.Time = worker.timeNow().UTC()
+	worker.Notifications.Send(notification)
+}
+
+// notifyPullRequest queues a notification about the pull request if notifications are enabled.
+func (worker *Worker) notifyPullRequest(
+	action string,
+	sess *session,
+	number int64,
+	details *github.PullRequestDetails,
+	reason string,
+) {
+	worker.notify(Notification{
+		Action:      action,
+		Repository:  sess.Repository.FullName,
+		PullRequest: number,
+		BaseBranch:  details.BaseRefName,
+		Reason:      reason,
+		Actor:       sess.Sender.Login,
+		URL:         details.URL,
+	})
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// DefaultPRDetailsCacheInvalidationEvents are pull_request actions that change the details without changing the
+// head commit, so cached details cannot be used for them.
+var DefaultPRDetailsCacheInvalidationEvents = []string{
+	"labeled", "unlabeled", "edited", "ready_for_review", "converted_to_draft", "auto_merge_enabled", "auto_merge_disabled",
+}
+
+// getPullRequestDetails returns the details of the pull request.
+// Cached details are only used when the message contains the head sha, the sha matches the cached details and the
+// action of the message is not one of PRDetailsCacheInvalidationEvents. Closed pull requests and pull requests with
+// a merge label are always fetched, approvals and checks can change without a new head commit and a merge must not
+// be decided on stale details.
+func (worker *Worker) getPullRequestDetails(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	msg *common.QueuePullRequestMessage,
+) (*github.PullRequestDetails, error) {
+	if worker.PRDetailsKV == nil {
+		return worker.fetchPullRequestDetails(ctx, sess, msg)
+	}
+
+	key := common.PullRequestDetailsKVKey(&msg.Repository, msg.PullRequest.Number)
+	logger := rootLogger.With().
+		Str("action", msg.Action).
+		Str("head_sha", msg.HeadSHA).
+		Logger()
+
+	if !msg.Closed && msg.HeadSHA != "" && slices.Index(worker.PRDetailsCacheInvalidationEvents, msg.Action) == -1 {
+		details, err := worker.getCachedPullRequestDetails(key)
+		if err != nil {
+			return nil, err
+		}
+		hit := details != nil && details.LastCommitSha == msg.HeadSHA
+		if hit && sess.Config.Merge.wantsMerge(details.Labels) {
+			logger.Debug().Msg("pull request has a merge label, bypassing the cache")
+			hit = false
+		}
+		countKVLookup(worker.PRDetailsKV, hit)
+		if hit {
+			logger.Debug().Msg("got pull request details from cache")
+			return details, nil
+		}
+	}
+
+	logger.Debug().Msg("getting pull request details from github")
+	details, err := worker.fetchPullRequestDetails(ctx, sess, msg)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(details)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode pull request details")
+	}
+	if _, err := worker.PRDetailsKV.Put(key, buf); err != nil {
+		return nil, errors.Wrap(err, "unable to store pull request details in kv bucket")
+	}
+	return details, nil
+}
+
+// fetchPullRequestDetails gets the details of the pull request from github.
+// The check states are only fetched when the status check rollup cannot decide the checks on its own:
+// for custom requiredChecks or exceptChecks, and for the summary of a rollup that did not succeed.
+func (worker *Worker) fetchPullRequestDetails(
+	ctx context.Context,
+	sess *session,
+	msg *common.QueuePullRequestMessage,
+) (*github.PullRequestDetails, error) {
+	details, err := github.GetPullRequestDetails(ctx, worker.HTTPClient, sess.AccessToken, &msg.Repository, msg.PullRequest.Number)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if msg.Closed {
+		// closed pull requests are only cleaned up
+		return details, nil
+	}
+	cfg, _ := sess.Config.forAuthor(details.Author)
+	if cfg.Merge.usesStatusCheckRollup() && details.StatusCheckRollup == "SUCCESS" {
+		return details, nil
+	}
+	details.CheckStates, err = github.GetPullRequestCheckStates(ctx, worker.HTTPClient, sess.AccessToken, &msg.Repository, msg.PullRequest.Number)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return details, nil
+}
+
+func (worker *Worker) getCachedPullRequestDetails(key string) (*github.PullRequestDetails, error) {
+	entry, err := worker.PRDetailsKV.Get(key)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "unable to get pull request details from kv bucket")
+	}
+	if entry == nil || len(entry.Value()) == 0 {
+		return nil, nil
+	}
+	var details github.PullRequestDetails
+	if err := json.Unmarshal(entry.Value(), &details); err != nil {
+		return nil, errors.Wrap(err, "unable to decode pull request details from kv bucket")
+	}
+	return &details, nil
+}
@@ -0,0 +1,286 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestWorker_getPullRequestDetails(t *testing.T) {
+	const freshDetails = `{"data":{"repository":{"pullRequest":{"id":"PR_1","state":"OPEN","title":"fresh",` +
+		`"commits":{"nodes":[{"commit":{"oid":"abc","committedDate":"2024-01-01T00:00:00Z"}}]}}}}}`
+
+	tests := []struct {
+		name         string
+		msg          common.QueuePullRequestMessage
+		cachedSHA    string
+		cachedLabels []string
+		wantTitle    string
+	}{
+		{
+			name:      "cached details are used when the sha matches",
+			msg:       common.QueuePullRequestMessage{Action: "synchronize", HeadSHA: "abc"},
+			cachedSHA: "abc",
+			wantTitle: "cached",
+		},
+		{
+			name:         "pull requests with a merge label bypass the cache",
+			msg:          common.QueuePullRequestMessage{Action: "synchronize", HeadSHA: "abc"},
+			cachedSHA:    "abc",
+			cachedLabels: []string{"merge"},
+			wantTitle:    "fresh",
+		},
+		{
+			name:         "pull requests with an update label use the cache",
+			msg:          common.QueuePullRequestMessage{Action: "synchronize", HeadSHA: "abc"},
+			cachedSHA:    "abc",
+			cachedLabels: []string{"update"},
+			wantTitle:    "cached",
+		},
+		{
+			name:      "labeled events bypass the cache",
+			msg:       common.QueuePullRequestMessage{Action: "labeled", HeadSHA: "abc"},
+			cachedSHA: "abc",
+			wantTitle: "fresh",
+		},
+		{
+			name:      "unlabeled events bypass the cache",
+			msg:       common.QueuePullRequestMessage{Action: "unlabeled", HeadSHA: "abc"},
+			cachedSHA: "abc",
+			wantTitle: "fresh",
+		},
+		{
+			name:      "different sha",
+			msg:       common.QueuePullRequestMessage{Action: "synchronize", HeadSHA: "abc"},
+			cachedSHA: "old",
+			wantTitle: "fresh",
+		},
+		{
+			name:      "unknown sha",
+			msg:       common.QueuePullRequestMessage{},
+			cachedSHA: "abc",
+			wantTitle: "fresh",
+		},
+		{
+			name:      "closed pull requests bypass the cache",
+			msg:       common.QueuePullRequestMessage{Action: "closed", HeadSHA: "abc", Closed: true},
+			cachedSHA: "abc",
+			wantTitle: "fresh",
+		},
+		{
+			name:      "nothing cached",
+			msg:       common.QueuePullRequestMessage{Action: "synchronize", HeadSHA: "abc"},
+			wantTitle: "fresh",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetPullRequestDetails"] = freshDetails
+			worker := newTestPullRequestWorker(gh)
+			worker.PRDetailsKV = newFakeKV()
+			worker.PRDetailsCacheInvalidationEvents = []string{"labeled", "unlabeled"}
+
+			sess := newTestSession()
+			tt.msg.Repository = *sess.Repository
+			tt.msg.PullRequest.Number = 1
+			key := common.PullRequestDetailsKVKey(&tt.msg.Repository, 1)
+			if tt.cachedSHA != "" {
+				buf, err := json.Marshal(&github.PullRequestDetails{Title: "cached", LastCommitSha: tt.cachedSHA, Labels: tt.cachedLabels})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := worker.PRDetailsKV.Put(key, buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := log.Logger
+			details, err := worker.getPullRequestDetails(context.Background(), &logger, sess, &tt.msg)
+			if err != nil {
+				t.Fatalf("getPullRequestDetails() error = %v", err)
+			}
+			if details.Title != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", details.Title, tt.wantTitle)
+			}
+			fetched := slices.Contains(gh.Operations(), "GetPullRequestDetails")
+			if fetched != (tt.wantTitle == "fresh") {
+				t.Errorf("fetched = %v, operations = %v", fetched, gh.Operations())
+			}
+			if fetched {
+				cached, err := worker.getCachedPullRequestDetails(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cached == nil || cached.Title != "fresh" {
+					t.Errorf("fresh details were not cached: %+v", cached)
+				}
+			}
+		})
+	}
+}
+
+func TestWorker_getPullRequestDetails_CheckStates(t *testing.T) {
+	tests := []struct {
+		name           string
+		rollup         string
+		requiredChecks common.RegexSlice
+		exceptChecks   common.RegexSlice
+		ignoreApps     common.RegexSlice
+		closed         bool
+		wantFetched    bool
+	}{
+		{
+			name:           "succeeded rollup decides the default required checks",
+			rollup:         "SUCCESS",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+			wantFetched:    false,
+		},
+		{
+			name:           "failed rollup needs the check states for the summary",
+			rollup:         "FAILURE",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+			wantFetched:    true,
+		},
+		{
+			name:           "missing rollup needs the check states",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+			wantFetched:    true,
+		},
+		{
+			name:           "custom required checks need the check states",
+			rollup:         "SUCCESS",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem("build")},
+			wantFetched:    true,
+		},
+		{
+			name:           "except checks need the check states",
+			rollup:         "SUCCESS",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+			exceptChecks:   common.RegexSlice{common.MustNewRegexItem("coverage")},
+			wantFetched:    true,
+		},
+		{
+			name:           "ignored apps need the check states",
+			rollup:         "SUCCESS",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+			ignoreApps:     common.RegexSlice{common.MustNewRegexItem("Codecov")},
+			wantFetched:    true,
+		},
+		{
+			name:           "closed pull requests do not need the check states",
+			rollup:         "FAILURE",
+			requiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+			closed:         true,
+			wantFetched:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			rollup := "null"
+			if tt.rollup != "" {
+				rollup = `{"state":"` + tt.rollup + `"}`
+			}
+			gh.responses["GetPullRequestDetails"] = `{"data":{"repository":{"pullRequest":{"id":"PR_1","state":"OPEN",` +
+				`"commits":{"nodes":[{"commit":{"oid":"abc","committedDate":"2024-01-01T00:00:00Z","statusCheckRollup":` + rollup + `}}]}}}}}`
+			gh.responses["GetPullRequestCheckStates"] = `{"data":{"repository":{"pullRequest":{"commits":{"nodes":[{"commit":{` +
+				`"status":{"contexts":[{"context":"build","state":"FAILURE"}]}}}]}}}}}`
+			worker := newTestPullRequestWorker(gh)
+
+			sess := newTestSession()
+			sess.Config.Merge.RequiredChecks = tt.requiredChecks
+			sess.Config.Merge.ExceptChecks = tt.exceptChecks
+			sess.Config.Merge.IgnoreChecksFromApps = tt.ignoreApps
+			msg := common.QueuePullRequestMessage{Closed: tt.closed}
+			msg.Repository = *sess.Repository
+			msg.PullRequest.Number = 1
+
+			logger := log.Logger
+			details, err := worker.getPullRequestDetails(context.Background(), &logger, sess, &msg)
+			if err != nil {
+				t.Fatalf("getPullRequestDetails() error = %v", err)
+			}
+			if details.StatusCheckRollup != tt.rollup {
+				t.Errorf("StatusCheckRollup = %q, want %q", details.StatusCheckRollup, tt.rollup)
+			}
+			fetches := 0
+			for _, operation := range gh.Operations() {
+				if operation == "GetPullRequestCheckStates" {
+					fetches++
+				}
+			}
+			want := 0
+			if tt.wantFetched {
+				want = 1
+			}
+			if fetches != want {
+				t.Errorf("fetched the check states %d times, want %d, operations = %v", fetches, want, gh.Operations())
+			}
+			if tt.wantFetched && details.CheckStates["build"].State != "FAILURE" {
+				t.Errorf("CheckStates = %+v", details.CheckStates)
+			}
+		})
+	}
+}
+
+// a dismissed approval must not be taken from the cache, neither for review events, which are queued without the
+// head sha, nor for events with the head sha, e.g. a check run that finished after the approval was dismissed.
+func TestPullRequestWorker_DismissedApprovalIsRefetched(t *testing.T) {
+	const detailsWithoutApproval = `{"data":{"repository":{"pullRequest":{"id":"PR_1","state":"OPEN","title":"change",` +
+		`"mergeable":"MERGEABLE","labels":{"nodes":[{"id":"LA_1","name":"merge"}]},` +
+		`"commits":{"nodes":[{"commit":{"oid":"abc","committedDate":"2024-01-01T00:00:00Z"}}]},` +
+		`"reviews":{"nodes":[]}}}}}`
+
+	tests := []struct {
+		name    string
+		headSHA string
+	}{
+		{name: "without head sha"},
+		{name: "with head sha", headSHA: "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetPullRequestDetails"] = detailsWithoutApproval
+			worker := newTestPullRequestWorker(gh)
+			worker.PRDetailsKV = newFakeKV()
+			sess := newTestSession()
+			sess.Config.Merge.RequiredApprovals = 1
+
+			msg := common.QueuePullRequestMessage{BaseMessage: common.BaseMessage{Repository: *sess.Repository}, HeadSHA: tt.headSHA}
+			msg.PullRequest.Number = 1
+			cached := newTestPullRequestDetails()
+			cached.ApprovedBy = []string{"alice"}
+			buf, err := json.Marshal(cached)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worker.PRDetailsKV.Put(common.PullRequestDetailsKVKey(&msg.Repository, 1), buf); err != nil {
+				t.Fatal(err)
+			}
+
+			logger := log.Logger
+			details, err := worker.getPullRequestDetails(context.Background(), &logger, sess, &msg)
+			if err != nil {
+				t.Fatalf("getPullRequestDetails() error = %v", err)
+			}
+			if len(details.ApprovedBy) != 0 {
+				t.Fatalf("ApprovedBy = %v, want no approvals", details.ApprovedBy)
+			}
+			_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+			if err != nil {
+				t.Fatalf("mergePullRequest() error = %v", err)
+			}
+			if didMerge || slices.Contains(gh.Operations(), "MergePullRequest") {
+				t.Error("pull request was merged without the required approval")
+			}
+		})
+	}
+}
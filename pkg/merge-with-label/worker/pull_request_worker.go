@@ -3,6 +3,7 @@ package worker
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -16,13 +17,19 @@ type pullRequestWorker struct {
 }
 
 func (worker *pullRequestWorker) runLogic(rootLogger *zerolog.Logger, msg *common.QueuePullRequestMessage) error {
-	ctx, cancel := context.WithTimeout(context.Background(), worker.MaxDurationForPullRequestWorker)
-	defer cancel()
 	logger := rootLogger.With().
 		Str("entry", "pull_request").
 		Int64("number", msg.PullRequest.Number).
 		Str("repo", msg.Repository.FullName).
 		Logger()
+	ctx, done := worker.withBudget(&logger, worker.MaxDurationForPullRequestWorker)
+	defer done()
+	ctx = worker.withMutationThrottle(ctx, &logger, msg.InstallationID)
+
+	if worker.isRecentlyMerged(&logger, msg) {
+		logger.Debug().Msg("pull request was merged recently")
+		return nil
+	}
 
 	sess, err := worker.getSession(ctx, &logger, &msg.BaseMessage)
 	if err != nil {
@@ -32,10 +39,15 @@ func (worker *pullRequestWorker) runLogic(rootLogger *zerolog.Logger, msg *commo
 		return nil
 	}
 
-	details, err := github.GetPullRequestDetails(ctx, worker.HTTPClient, sess.AccessToken, &msg.Repository, msg.PullRequest.Number)
+	details, err := worker.getPullRequestDetails(ctx, &logger, sess, msg)
 	if err != nil {
 		return errors.Wrap(err, "error getting pull request details")
 	}
+	withoutBlockedLabel(sess.Config, details)
+
+	if msg.Closed {
+		return worker.cleanupClosedPullRequest(ctx, &logger, sess, details)
+	}
 
 	if details.State != "OPEN" {
 		logger.Debug().Msg("pull request is not open anymore")
@@ -47,6 +59,27 @@ func (worker *pullRequestWorker) runLogic(rootLogger *zerolog.Logger, msg *commo
 		return nil
 	}
 
+	if len(sess.ConfigProblems) != 0 {
+		// report the broken config, even if the pull request is not labeled
+		logger.Warn().Int("problems", len(sess.ConfigProblems)).Msg("config contains invalid fields")
+		if err := worker.CreateOrUpdateCheckRun(
+			ctx,
+			&logger,
+			sess,
+			details.ID,
+			details.LastCommitSha,
+			"COMPLETED",
+			configProblemsTitle(sess.ConfigProblems),
+			"",
+		); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if announced, err := worker.announce(ctx, &logger, sess, msg.Action, details); err != nil || announced {
+		return errors.WithStack(err)
+	}
+
 	// update logic
 	stopLogic, didUpdatePullRequest, err := worker.updatePullRequest(ctx, &logger, sess, details)
 	if err != nil {
@@ -55,10 +88,16 @@ func (worker *pullRequestWorker) runLogic(rootLogger *zerolog.Logger, msg *commo
 	if stopLogic {
 		return nil
 	}
+	if didUpdatePullRequest {
+		worker.notifyPullRequest(NotificationUpdated, sess, msg.PullRequest.Number, details, "")
+	}
 
 	if didUpdatePullRequest && sess.Config.Merge.Labels.ContainsOneOf(details.Labels...) != "" {
-		logger.Debug().Msg("not merging, because pull request was just updated")
-		return pushBackError{delay: worker.DurationToWaitAfterUpdateBranch}
+		return worker.waitAfterUpdate(ctx, &logger, sess, details)
+	}
+
+	if rejected, err := worker.rejectLabelAppliedBy(ctx, &logger, sess, msg, details); err != nil || rejected {
+		return errors.WithStack(err)
 	}
 
 	stopLogic, didMergePullRequest, err := worker.mergePullRequest(
@@ -76,10 +115,70 @@ func (worker *pullRequestWorker) runLogic(rootLogger *zerolog.Logger, msg *commo
 		return nil
 	}
 
+	if didMergePullRequest {
+		if err := worker.markRecentlyMerged(&msg.Repository, msg.PullRequest.Number); err != nil {
+			logger.Error().Err(err).Msg("unable to mark pull request as merged")
+		}
+	}
+
 	if didMergePullRequest && sess.Config.Merge.DeleteBranch {
 		logger.Info().Str("branch", details.HeadRefName).Msg("deleting branch")
-		if err := github.DeleteRef(ctx, worker.HTTPClient, sess.AccessToken, details.HeadRefID); err != nil {
-			return errors.New("unable to delete branch")
+		// a redelivery would stop at isRecentlyMerged, so wait for the throttle instead of failing the message
+		if err := waitForThrottle(ctx, &logger, func() error {
+			return github.DeleteRef(ctx, worker.HTTPClient, sess.AccessToken, details.HeadRefID)
+		}); err != nil {
+			return errors.Wrap(err, "unable to delete branch")
+		}
+	}
+	return nil
+}
+
+// waitAfterUpdate postpones the merge of a pull request that was just updated by DurationToWaitAfterUpdateBranch,
+// so the checks of the new commit can start.
+func (worker *pullRequestWorker) waitAfterUpdate(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+) error {
+	rootLogger.Debug().Msg("not merging, because pull request was just updated")
+	if err := worker.CreateOrUpdateCheckRun(
+		ctx,
+		rootLogger,
+		sess,
+		details.ID,
+		details.LastCommitSha,
+		"COMPLETED",
+		"not merging: pull request was just updated",
+		fmt.Sprintf("waiting %s (DurationToWaitAfterUpdateBranch) before merging", worker.DurationToWaitAfterUpdateBranch),
+	); err != nil {
+		return errors.WithStack(err)
+	}
+	return pushBackError{delay: worker.DurationToWaitAfterUpdateBranch}
+}
+
+// cleanupClosedPullRequest removes the state of a pull request that was closed without merging.
+// It never merges or updates the pull request.
+func (worker *pullRequestWorker) cleanupClosedPullRequest(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+) error {
+	if details.State != "CLOSED" {
+		rootLogger.Debug().Str("state", details.State).Msg("pull request is not closed anymore")
+		return nil
+	}
+	rootLogger.Info().Msg("pull request was closed, cleaning up")
+	if err := worker.CloseCheckRun(ctx, rootLogger, sess, details.ID, details.LastCommitSha, "pull request closed"); err != nil {
+		return errors.Wrap(err, "unable to close check run")
+	}
+	if err := worker.removeBlockedLabel(ctx, rootLogger, sess, details); err != nil {
+		rootLogger.Warn().Err(err).Msg("unable to remove blocked label")
+	}
+	if sess.Config.Merge.CloseWhenBlocked {
+		if err := worker.clearBlockedSince(details.ID); err != nil {
+			return errors.WithStack(err)
 		}
 	}
 	return nil
@@ -104,7 +203,7 @@ func (worker *pullRequestWorker) updatePullRequest(
 	}
 
 	if details.HasConflicts {
-		rootLogger.Info().Msg("not updating: pull request has conflicts")
+		rootLogger.Info().Str("skip_reason", string(SkipReasonConflicts)).Msg("not updating: pull request has conflicts")
 		if err := worker.CreateOrUpdateCheckRun(
 			ctx,
 			rootLogger,
@@ -120,11 +219,12 @@ func (worker *pullRequestWorker) updatePullRequest(
 		return true, false, nil
 	}
 
-	result, err := worker.shouldSkipUpdate(ctx, rootLogger, sess.Config, details)
+	result, err := worker.shouldSkipUpdate(ctx, rootLogger, sess.Config, sess.Repository.DefaultBranch, details)
 	if err != nil {
 		return false, false, errors.WithStack(err)
 	}
 	if result.SkipAction {
+		rootLogger.Info().Str("skip_reason", string(result.SkipReason)).Msg("not updating")
 		if err := worker.CreateOrUpdateCheckRun(
 			ctx,
 			rootLogger,
@@ -134,6 +234,7 @@ func (worker *pullRequestWorker) updatePullRequest(
 			"COMPLETED",
 			result.Title,
 			result.Summary,
+			result.Annotations...,
 		); err != nil {
 			return false, false, errors.WithStack(err)
 		}
@@ -194,15 +295,42 @@ func (worker *pullRequestWorker) mergePullRequest(
 	number int64,
 	details *github.PullRequestDetails,
 ) (stopLogic, didMerge bool, err error) {
+	// merge.labels take precedence, the pull request keeps waiting until it can be merged
+	var immediateLabels []string
 	if sess.Config.Merge.Labels.ContainsOneOf(details.Labels...) == "" {
-		return false, false, nil
+		immediateLabels = sess.Config.Merge.immediateLabelsOf(details.Labels)
+		if len(immediateLabels) == 0 {
+			// the merge label was removed, the pull request is not blocked anymore
+			if err := worker.removeBlockedLabel(ctx, rootLogger, sess, details); err != nil {
+				rootLogger.Warn().Err(err).Msg("unable to remove blocked label")
+			}
+			return false, false, nil
+		}
+	}
+
+	cfg, override := sess.Config.forAuthor(details.Author)
+	if override != -1 {
+		sess.MergeOverride = fmt.Sprintf("`merge.overrides[%d]` (authors: %s)", override, cfg.Merge.Overrides[override].Authors)
+		rootLogger.Debug().Int("override", override).Str("author", details.Author).Msg("applying merge override")
 	}
 
-	result, err := worker.shouldSkipMerge(ctx, rootLogger, sess.Config, details)
+	result, err := worker.shouldSkipMerge(ctx, rootLogger, cfg, sess.Repository.DefaultBranch, details)
+	if len(immediateLabels) != 0 && worker.shouldGiveUpImmediateMerge(rootLogger, details, &result, err) {
+		// do not wait, let the author add the label again once the pull request is ready
+		return true, false, worker.giveUpImmediateMerge(ctx, rootLogger, sess, details, immediateLabels, &result)
+	}
 	if err != nil {
+		var pbErr pushBackError
+		if errors.As(err, &pbErr) {
+			// document why the merge is delayed
+			if err := worker.reportRetry(ctx, rootLogger, sess, details, result.Title, result.Summary, pbErr.delay); err != nil {
+				return false, false, errors.WithStack(err)
+			}
+		}
 		return false, false, errors.WithStack(err)
 	}
 	if result.SkipAction {
+		rootLogger.Info().Str("skip_reason", string(result.SkipReason)).Msg("not merging")
 		if err := worker.CreateOrUpdateCheckRun(
 			ctx,
 			rootLogger,
@@ -212,12 +340,59 @@ func (worker *pullRequestWorker) mergePullRequest(
 			"COMPLETED",
 			result.Title,
 			result.Summary,
+			result.Annotations...,
 		); err != nil {
 			return false, false, errors.WithStack(err)
 		}
+		if result.SkipReason == SkipReasonMissingApprovals {
+			if err := worker.requestReviewers(ctx, rootLogger, sess, details); err != nil {
+				rootLogger.Warn().Err(err).Msg("unable to request reviewers")
+			}
+		}
+		if err := worker.addBlockedLabel(ctx, rootLogger, sess, details); err != nil {
+			rootLogger.Warn().Err(err).Msg("unable to add blocked label")
+		}
+		if _, err := worker.closeIfBlockedTooLong(ctx, rootLogger, sess, details, result.SkipReason); err != nil {
+			return false, false, errors.WithStack(err)
+		}
 		return true, false, nil
 	}
 
+	if sess.Config.Merge.CloseWhenBlocked {
+		if err := worker.clearBlockedSince(details.ID); err != nil {
+			return false, false, errors.WithStack(err)
+		}
+	}
+	if err := worker.removeBlockedLabel(ctx, rootLogger, sess, details); err != nil {
+		rootLogger.Warn().Err(err).Msg("unable to remove blocked label")
+	}
+
+	if worker.PreMergeHook != nil {
+		if err := worker.PreMergeHook(ctx, sess.Repository, number, details); err != nil {
+			rootLogger.Warn().Err(err).Msg("pre merge hook failed, retrying later")
+			if err := worker.reportRetry(ctx, rootLogger, sess, details, "waiting for the pre merge hook", "", worker.RetryWait); err != nil {
+				return false, false, errors.WithStack(err)
+			}
+			return false, false, pushBackError{delay: worker.RetryWait}
+		}
+	}
+
+	releaseMergeLock, err := worker.acquireMergeLock(rootLogger, details.ID)
+	if err != nil {
+		return false, false, worker.reportMergeLockRetry(ctx, rootLogger, sess, details, err)
+	}
+	defer releaseMergeLock()
+
+	// with a linear history every merge outdates all other pull requests, merge them one at a time
+	linearHistory := cfg.Merge.RequireLinearHistoryForLabels(details.Labels)
+	if linearHistory {
+		releaseRepositoryMergeLock, err := worker.acquireRepositoryMergeLock(rootLogger, sess.Repository)
+		if err != nil {
+			return false, false, worker.reportMergeLockRetry(ctx, rootLogger, sess, details, err)
+		}
+		defer releaseRepositoryMergeLock()
+	}
+
 	rootLogger.Info().Msg("merging pull request")
 	if err := worker.CreateOrUpdateCheckRun(
 		ctx,
@@ -238,9 +413,14 @@ func (worker *pullRequestWorker) mergePullRequest(
 		sess.AccessToken,
 		details.ID,
 		details.LastCommitSha,
-		sess.Config.Merge.Strategy.GithubString(),
+		cfg.Merge.StrategyForBranch(details.HeadRefName).GithubString(),
 		fmt.Sprintf("%s (#%d)", details.Title, number),
 	); err != nil {
+		var throttledErr *github.ErrThrottled
+		if errors.As(err, &throttledErr) {
+			// nothing was sent, the merge is retried once a token is available
+			return false, false, errors.WithStack(err)
+		}
 		var graphQLErrors github.GraphQLErrors
 		if errors.As(err, &graphQLErrors) {
 			if err := worker.CreateOrUpdateCheckRun(
@@ -255,8 +435,82 @@ func (worker *pullRequestWorker) mergePullRequest(
 			); err != nil {
 				return false, false, errors.WithStack(err)
 			}
+			worker.notifyPullRequest(NotificationMergeFailed, sess, number, details, graphQLErrors.GetMessages())
+		} else {
+			worker.notifyPullRequest(NotificationMergeFailed, sess, number, details, err.Error())
 		}
 		return false, false, errors.Wrap(err, "unable to merge pull request")
 	}
+	worker.notifyPullRequest(NotificationMerged, sess, number, details, "")
+
+	if worker.PostMergeHook != nil {
+		if err := worker.PostMergeHook(ctx, sess.Repository, number, details); err != nil {
+			rootLogger.Error().Err(err).Msg("post merge hook failed")
+		}
+	}
+
+	if err := worker.postMergedComment(ctx, rootLogger, sess, cfg, details); err != nil {
+		rootLogger.Error().Err(err).Msg("unable to post merged comment")
+	}
+
+	if cfg.Merge.cascades() {
+		if err := worker.queueSweepAfterMerge(rootLogger, sess, details); err != nil {
+			rootLogger.Error().Err(err).Msg("unable to queue the next pull requests")
+		}
+	}
 	return false, true, nil
 }
+
+// reportRetry updates the check run with the reason the merge is delayed and when the pull request is evaluated
+// again, so it is visible that the bot is still working on it.
+// The check run is IN_PROGRESS while waiting, shouldSkipBecauseOfChecks ignores it (see isOwnCheckRun), so it does not
+// hold back requiredChecks like `.*` or waitForAllCheckSuites.
+func (worker *pullRequestWorker) reportRetry(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+	title,
+	summary string,
+	delay time.Duration,
+) error {
+	if title == "" {
+		title = "waiting"
+	}
+	retry := fmt.Sprintf(
+		"waiting — will re-evaluate at %s UTC (in %s)",
+		worker.timeNow().Add(delay).UTC().Format(time.TimeOnly),
+		delay.Round(time.Second),
+	)
+	if summary != "" {
+		retry += "\n\n" + summary
+	}
+	return worker.CreateOrUpdateCheckRun(
+		ctx,
+		rootLogger,
+		sess,
+		details.ID,
+		details.LastCommitSha,
+		"IN_PROGRESS",
+		title,
+		retry,
+	)
+}
+
+// reportMergeLockRetry reports the retry if err is the pushBackError of a merge lock, err is returned in any case.
+func (worker *pullRequestWorker) reportMergeLockRetry(
+	ctx context.Context,
+	rootLogger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+	err error,
+) error {
+	var pbErr pushBackError
+	if !errors.As(err, &pbErr) {
+		return errors.WithStack(err)
+	}
+	if err := worker.reportRetry(ctx, rootLogger, sess, details, "waiting for another merge to finish", "", pbErr.delay); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(err)
+}
@@ -0,0 +1,642 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func newTestPullRequestWorker(gh *fakeGitHub) *pullRequestWorker {
+	logger := log.Logger
+	return &pullRequestWorker{
+		Worker: &Worker{
+			Logger:      &logger,
+			BotName:     "merge-with-label",
+			CheckRunsKV: newFakeKV(),
+			HTTPClient:  gh.Client(),
+			RetryWait:   time.Second,
+		},
+	}
+}
+
+func newTestSession() *session {
+	return &session{
+		Repository: &common.Repository{FullName: "owner/repo", NodeID: "R_1"},
+		Config: &ConfigV1{
+			Merge: MergeConfigV1{
+				Labels:   common.RegexSlice{common.MustNewRegexItem("merge")},
+				Strategy: SquashMergeStrategy,
+			},
+		},
+	}
+}
+
+func newTestPullRequestDetails() *github.PullRequestDetails {
+	return &github.PullRequestDetails{
+		ID:            "PR_1",
+		Title:         "some change",
+		Labels:        []string{"merge"},
+		IsMergeable:   true,
+		LastCommitSha: "abc",
+		HeadRefName:   "feature",
+		BaseRefName:   "main",
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_Hooks(t *testing.T) {
+	t.Run("hooks are called around the merge", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+
+		var mu sync.Mutex
+		var calls []string
+		record := func(s string) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, s)
+		}
+		gh.onRequest = func(operation string) {
+			if operation == "MergePullRequest" {
+				record(operation)
+			}
+		}
+		worker.PreMergeHook = func(_ context.Context, repo *common.Repository, prNumber int64, details *github.PullRequestDetails) error {
+			if repo.FullName != "owner/repo" || prNumber != 1 || details.ID != "PR_1" {
+				t.Errorf("unexpected hook arguments: %s %d %s", repo.FullName, prNumber, details.ID)
+			}
+			record("pre")
+			return nil
+		}
+		worker.PostMergeHook = func(context.Context, *common.Repository, int64, *github.PullRequestDetails) error {
+			record("post")
+			return nil
+		}
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, newTestPullRequestDetails())
+		if err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+		if !didMerge {
+			t.Fatal("expected pull request to be merged")
+		}
+		if want := []string{"pre", "MergePullRequest", "post"}; !slices.Equal(calls, want) {
+			t.Errorf("calls = %v, want %v", calls, want)
+		}
+	})
+
+	t.Run("failing pre merge hook pushes back", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+
+		postCalled := false
+		worker.PreMergeHook = func(context.Context, *common.Repository, int64, *github.PullRequestDetails) error {
+			return errors.New("not ready")
+		}
+		worker.PostMergeHook = func(context.Context, *common.Repository, int64, *github.PullRequestDetails) error {
+			postCalled = true
+			return nil
+		}
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, newTestPullRequestDetails())
+		var pbErr pushBackError
+		if !errors.As(err, &pbErr) {
+			t.Fatalf("expected pushBackError, got %v", err)
+		}
+		if didMerge {
+			t.Error("expected pull request not to be merged")
+		}
+		if postCalled {
+			t.Error("post merge hook should not be called")
+		}
+		if slices.Contains(gh.Operations(), "MergePullRequest") {
+			t.Error("MergePullRequest should not be called")
+		}
+	})
+
+	t.Run("failing post merge hook does not fail the merge", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		worker.PostMergeHook = func(context.Context, *common.Repository, int64, *github.PullRequestDetails) error {
+			return errors.New("notification failed")
+		}
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, newTestPullRequestDetails())
+		if err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+		if !didMerge {
+			t.Fatal("expected pull request to be merged")
+		}
+	})
+}
+
+func TestPullRequestWorker_cleanupClosedPullRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		state          string
+		checkRunID     string
+		wantOperations []string
+		wantKeyDeleted bool
+	}{
+		{
+			name:           "check run is closed and removed",
+			state:          "CLOSED",
+			checkRunID:     "CR_1",
+			wantOperations: []string{"UpdateCheckRun"},
+			wantKeyDeleted: true,
+		},
+		{
+			name:           "empty check run id is removed",
+			state:          "CLOSED",
+			checkRunID:     "",
+			wantOperations: nil,
+			wantKeyDeleted: true,
+		},
+		{
+			name:           "reopened pull request is not cleaned up",
+			state:          "OPEN",
+			checkRunID:     "CR_1",
+			wantOperations: nil,
+			wantKeyDeleted: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			details := newTestPullRequestDetails()
+			details.State = tt.state
+
+			key := checkRunKey(worker.checkRunName(sess), details.ID, details.LastCommitSha)
+			if _, err := worker.CheckRunsKV.PutString(key, tt.checkRunID); err != nil {
+				t.Fatal(err)
+			}
+
+			logger := log.Logger
+			if err := worker.cleanupClosedPullRequest(context.Background(), &logger, sess, details); err != nil {
+				t.Fatalf("cleanupClosedPullRequest() error = %v", err)
+			}
+			if got := gh.Operations(); !slices.Equal(got, tt.wantOperations) {
+				t.Errorf("operations = %v, want %v", got, tt.wantOperations)
+			}
+			_, err := worker.CheckRunsKV.Get(key)
+			if deleted := errors.Is(err, nats.ErrKeyNotFound); deleted != tt.wantKeyDeleted {
+				t.Errorf("key deleted = %v, want %v", deleted, tt.wantKeyDeleted)
+			}
+		})
+	}
+}
+
+func TestPullRequestWorker_waitAfterUpdate(t *testing.T) {
+	gh := newFakeGitHub()
+	worker := newTestPullRequestWorker(gh)
+	worker.DurationBeforeMergeAfterCheck = time.Hour
+	worker.DurationToWaitAfterUpdateBranch = time.Minute
+
+	logger := log.Logger
+	err := worker.waitAfterUpdate(context.Background(), &logger, newTestSession(), newTestPullRequestDetails())
+	var pbErr pushBackError
+	if !errors.As(err, &pbErr) {
+		t.Fatalf("waitAfterUpdate() error = %v, want pushBackError", err)
+	}
+	if pbErr.delay != time.Minute {
+		t.Errorf("delay = %v, want DurationToWaitAfterUpdateBranch (%v)", pbErr.delay, time.Minute)
+	}
+	if want := []string{"CreateCheckRun"}; !slices.Equal(gh.Operations(), want) {
+		t.Errorf("operations = %v, want %v", gh.Operations(), want)
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_RecentCommit(t *testing.T) {
+	gh := newFakeGitHub()
+	worker := newTestPullRequestWorker(gh)
+	worker.DurationBeforeMergeAfterCheck = time.Hour
+	worker.DurationToWaitAfterUpdateBranch = time.Minute
+
+	details := newTestPullRequestDetails()
+	details.IsMergeable = false
+	details.LastCommitTime = time.Now()
+
+	logger := log.Logger
+	_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, details)
+	var pbErr pushBackError
+	if !errors.As(err, &pbErr) {
+		t.Fatalf("mergePullRequest() error = %v, want pushBackError", err)
+	}
+	if pbErr.delay <= time.Minute {
+		t.Errorf("delay = %v, want DurationBeforeMergeAfterCheck", pbErr.delay)
+	}
+	if didMerge {
+		t.Error("expected pull request not to be merged")
+	}
+	if want := []string{"CreateCheckRun"}; !slices.Equal(gh.Operations(), want) {
+		t.Errorf("operations = %v, want %v", gh.Operations(), want)
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_ReportRetry(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		setup     func(worker *pullRequestWorker, details *github.PullRequestDetails)
+		wantDelay time.Duration
+		wantBody  []string
+	}{
+		{
+			name: "commit too recent",
+			setup: func(worker *pullRequestWorker, details *github.PullRequestDetails) {
+				worker.DurationBeforeMergeAfterCheck = time.Hour
+				details.LastCommitTime = now.Add(-30 * time.Minute)
+			},
+			wantDelay: 30 * time.Minute,
+			wantBody: []string{
+				"waiting — will re-evaluate at 12:30:00 UTC (in 30m0s)",
+				"the last commit is younger than 1h0m0s",
+			},
+		},
+		{
+			name: "merge lock held by another worker",
+			setup: func(worker *pullRequestWorker, _ *github.PullRequestDetails) {
+				kv := newFakeKV()
+				worker.MergeLocksKV = kv
+				if _, err := kv.Create(common.MergeLockKVKey("PR_1"), []byte("worker-2")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDelay: 30 * time.Second,
+			wantBody: []string{
+				"waiting for another merge to finish",
+				"waiting — will re-evaluate at 12:00:30 UTC (in 30s)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			worker.RetryWait = 30 * time.Second
+			worker.now = func() time.Time { return now }
+			details := newTestPullRequestDetails()
+			tt.setup(worker, details)
+
+			logger := log.Logger
+			_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, details)
+			var pbErr pushBackError
+			if !errors.As(err, &pbErr) {
+				t.Fatalf("mergePullRequest() error = %v, want pushBackError", err)
+			}
+			if pbErr.delay != tt.wantDelay {
+				t.Errorf("delay = %v, want %v", pbErr.delay, tt.wantDelay)
+			}
+			if didMerge {
+				t.Error("expected pull request not to be merged")
+			}
+			body := gh.LastRequestBody("CreateCheckRun")
+			for _, want := range append(tt.wantBody, `"status":"IN_PROGRESS"`) {
+				if !strings.Contains(body, want) {
+					t.Errorf("CreateCheckRun body = %s, want it to contain %s", body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_RetryThenMerge(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	gh := newFakeGitHub()
+	worker := newTestPullRequestWorker(gh)
+	worker.DurationBeforeMergeAfterCheck = time.Minute
+	worker.now = func() time.Time { return now }
+	sess := newTestSession()
+	sess.Config.Merge.RequiredChecks = common.RegexSlice{common.MustNewRegexItem(".*")}
+
+	// ownCheckState returns the check state github reports for the check run the bot created last
+	ownCheckState := func() github.CheckState {
+		state := "PENDING"
+		if strings.Contains(gh.LastRequestBody("CreateCheckRun"), `"status":"COMPLETED"`) {
+			state = "NEUTRAL"
+		}
+		return github.CheckState{Name: "app/merge-with-label", State: state, Source: github.CheckStateSourceCheckRun, App: "app"}
+	}
+
+	details := newTestPullRequestDetails()
+	details.LastCommitTime = now.Add(-30 * time.Second)
+	details.CheckStates = checkStatesOf(map[string]string{"ci": "SUCCESS"})
+	logger := log.Logger
+	if _, _, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details); !errors.As(err, new(pushBackError)) {
+		t.Fatalf("mergePullRequest() error = %v, want pushBackError", err)
+	}
+
+	// the retry sees the check run of the bot
+	now = now.Add(time.Minute)
+	details = newTestPullRequestDetails()
+	details.LastCommitTime = now.Add(-90 * time.Second)
+	details.CheckStates = checkStatesOf(map[string]string{"ci": "SUCCESS"})
+	details.CheckStates["app/merge-with-label"] = ownCheckState()
+	_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+	if err != nil {
+		t.Fatalf("mergePullRequest() error = %v", err)
+	}
+	if !didMerge {
+		t.Error("expected pull request to be merged after the retry")
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_Lock(t *testing.T) {
+	t.Run("lock held by another worker pushes back", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		kv := newFakeKV()
+		worker.MergeLocksKV = kv
+		worker.ID = "worker-1"
+		if _, err := kv.Create(common.MergeLockKVKey("PR_1"), []byte("worker-2")); err != nil {
+			t.Fatal(err)
+		}
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, newTestPullRequestDetails())
+		var pbErr pushBackError
+		if !errors.As(err, &pbErr) {
+			t.Fatalf("expected pushBackError, got %v", err)
+		}
+		if didMerge {
+			t.Error("expected pull request not to be merged")
+		}
+		if slices.Contains(gh.Operations(), "MergePullRequest") {
+			t.Error("MergePullRequest should not be called")
+		}
+		entry, err := kv.Get(common.MergeLockKVKey("PR_1"))
+		if err != nil || string(entry.Value()) != "worker-2" {
+			t.Errorf("lock of the other worker should be kept, got %v, %v", entry, err)
+		}
+	})
+
+	t.Run("lock is held during the merge and released afterwards", func(t *testing.T) {
+		gh := newFakeGitHub()
+		worker := newTestPullRequestWorker(gh)
+		kv := newFakeKV()
+		worker.MergeLocksKV = kv
+		worker.ID = "worker-1"
+		var holder string
+		gh.onRequest = func(operation string) {
+			if operation != "MergePullRequest" {
+				return
+			}
+			if entry, err := kv.Get(common.MergeLockKVKey("PR_1")); err == nil {
+				holder = string(entry.Value())
+			}
+		}
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, newTestPullRequestDetails())
+		if err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+		if !didMerge {
+			t.Fatal("expected pull request to be merged")
+		}
+		if holder != "worker-1" {
+			t.Errorf("lock holder during merge = %q, want worker-1", holder)
+		}
+		if _, err := kv.Get(common.MergeLockKVKey("PR_1")); !errors.Is(err, nats.ErrKeyNotFound) {
+			t.Errorf("lock should be released, got %v", err)
+		}
+	})
+
+	t.Run("lock is released when the merge fails", func(t *testing.T) {
+		gh := newFakeGitHub()
+		gh.responses["MergePullRequest"] = `{"errors":[{"message":"Base branch was modified"}]}`
+		worker := newTestPullRequestWorker(gh)
+		kv := newFakeKV()
+		worker.MergeLocksKV = kv
+
+		logger := log.Logger
+		_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, newTestSession(), 1, newTestPullRequestDetails())
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if didMerge {
+			t.Error("expected pull request not to be merged")
+		}
+		if _, err := kv.Get(common.MergeLockKVKey("PR_1")); !errors.Is(err, nats.ErrKeyNotFound) {
+			t.Errorf("lock should be released, got %v", err)
+		}
+	})
+}
+
+func TestPullRequestWorker_mergePullRequest_CloseWhenBlocked(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		closeWhenBlocked bool
+		config           func(*MergeConfigV1)
+		blockedSince     string
+		details          func(*github.PullRequestDetails)
+		wantClosed       bool
+		wantBlockedSince string
+	}{
+		{
+			name:             "first block stores the start",
+			closeWhenBlocked: true,
+			details:          func(d *github.PullRequestDetails) { d.HasConflicts, d.IsMergeable = true, false },
+			wantClosed:       false,
+			wantBlockedSince: now.Format(time.RFC3339),
+		},
+		{
+			name:             "blocked not long enough keeps the start",
+			closeWhenBlocked: true,
+			blockedSince:     now.Add(-time.Hour).Format(time.RFC3339),
+			details:          func(d *github.PullRequestDetails) { d.HasConflicts, d.IsMergeable = true, false },
+			wantClosed:       false,
+			wantBlockedSince: now.Add(-time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:             "blocked for too long closes the pull request",
+			closeWhenBlocked: true,
+			blockedSince:     now.Add(-25 * time.Hour).Format(time.RFC3339),
+			details:          func(d *github.PullRequestDetails) { d.HasConflicts, d.IsMergeable = true, false },
+			wantClosed:       true,
+		},
+		{
+			name:             "unset hours default to 72",
+			closeWhenBlocked: true,
+			config:           func(c *MergeConfigV1) { c.CloseWhenBlockedAfterHours = 0 },
+			blockedSince:     now.Add(-25 * time.Hour).Format(time.RFC3339),
+			details:          func(d *github.PullRequestDetails) { d.HasConflicts, d.IsMergeable = true, false },
+			wantClosed:       false,
+			wantBlockedSince: now.Add(-25 * time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:             "unset hours close after 72 hours",
+			closeWhenBlocked: true,
+			config:           func(c *MergeConfigV1) { c.CloseWhenBlockedAfterHours = 0 },
+			blockedSince:     now.Add(-73 * time.Hour).Format(time.RFC3339),
+			details:          func(d *github.PullRequestDetails) { d.HasConflicts, d.IsMergeable = true, false },
+			wantClosed:       true,
+		},
+		{
+			name:             "missing checks never close",
+			closeWhenBlocked: true,
+			config:           func(c *MergeConfigV1) { c.RequiredChecks = common.RegexSlice{common.MustNewRegexItem("^ci$")} },
+			blockedSince:     now.Add(-25 * time.Hour).Format(time.RFC3339),
+			details: func(d *github.PullRequestDetails) {
+				d.CheckStates = checkStatesOf(map[string]string{"lint": "SUCCESS"})
+			},
+			wantClosed:       false,
+			wantBlockedSince: now.Add(-25 * time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:             "disabled never closes",
+			closeWhenBlocked: false,
+			blockedSince:     now.Add(-25 * time.Hour).Format(time.RFC3339),
+			details:          func(d *github.PullRequestDetails) { d.HasConflicts, d.IsMergeable = true, false },
+			wantClosed:       false,
+			wantBlockedSince: now.Add(-25 * time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:             "ignored pull requests are never closed",
+			closeWhenBlocked: true,
+			blockedSince:     now.Add(-25 * time.Hour).Format(time.RFC3339),
+			details:          func(d *github.PullRequestDetails) { d.Title = "WIP: some change" },
+			wantClosed:       false,
+			wantBlockedSince: now.Add(-25 * time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:             "mergeable pull request clears the start",
+			closeWhenBlocked: true,
+			blockedSince:     now.Add(-25 * time.Hour).Format(time.RFC3339),
+			details:          func(*github.PullRequestDetails) {},
+			wantClosed:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			worker.now = func() time.Time { return now }
+			sess := newTestSession()
+			sess.Config.Merge.CloseWhenBlocked = tt.closeWhenBlocked
+			sess.Config.Merge.CloseWhenBlockedAfterHours = 24
+			if tt.config != nil {
+				tt.config(&sess.Config.Merge)
+			}
+			sess.Config.Merge.IgnoreWithTitles = common.RegexSlice{common.MustNewRegexItem("^WIP")}
+			details := newTestPullRequestDetails()
+			tt.details(details)
+
+			key := common.BlockedSinceKVKey(details.ID)
+			if tt.blockedSince != "" {
+				if _, err := worker.CheckRunsKV.PutString(key, tt.blockedSince); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := log.Logger
+			if _, _, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details); err != nil {
+				t.Fatalf("mergePullRequest() error = %v", err)
+			}
+			if got := slices.Contains(gh.Operations(), "ClosePullRequest"); got != tt.wantClosed {
+				t.Errorf("closed = %v, want %v", got, tt.wantClosed)
+			}
+			var blockedSince string
+			if entry, err := worker.CheckRunsKV.Get(key); err == nil {
+				blockedSince = string(entry.Value())
+			}
+			if blockedSince != tt.wantBlockedSince {
+				t.Errorf("blocked since = %q, want %q", blockedSince, tt.wantBlockedSince)
+			}
+		})
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_Overrides(t *testing.T) {
+	noApprovals := 0
+	for _, author := range []string{"renovate[bot]", "alice"} {
+		t.Run(author, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			sess.Config.Merge.RequiredApprovals = 1
+			sess.Config.Merge.Overrides = []AuthorOverrideRule{
+				{Authors: common.RegexSlice{common.MustNewRegexItem("renovate")}, RequiredApprovals: &noApprovals},
+			}
+			details := newTestPullRequestDetails()
+			details.Author = author
+
+			logger := log.Logger
+			_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+			if err != nil {
+				t.Fatal(err)
+			}
+			override := author == "renovate[bot]"
+			if didMerge != override {
+				t.Errorf("didMerge = %v, want %v", didMerge, override)
+			}
+			if got := strings.Contains(gh.LastRequestBody("CreateCheckRun"), "merge.overrides[0]"); got != override {
+				t.Errorf("footer contains the override = %v, want %v", got, override)
+			}
+		})
+	}
+}
+
+func TestPullRequestWorker_mergePullRequest_PostMergedComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "disabled", template: ""},
+		{
+			name:     "rendered",
+			template: "Merged {{.HeadRef}} into {{.BaseRef}} of {{.Author}} using {{.Strategy}}",
+			want:     "Merged feature into main of alice using squash",
+		},
+		{name: "invalid template", template: "{{.Unknown"},
+		{name: "range", template: "{{range 1000000000}}{{end}}"},
+		{name: "recursive template", template: `{{define "a"}}{{template "a" .}}{{end}}{{template "a" .}}`},
+		{name: "too long", template: strings.Repeat("x", maxMergedCommentSize+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			sess := newTestSession()
+			sess.Config.Merge.PostMergedComment = tt.template
+			details := newTestPullRequestDetails()
+			details.Author = "alice"
+
+			logger := log.Logger
+			_, didMerge, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !didMerge {
+				t.Fatal("pull request was not merged")
+			}
+			body := gh.LastRequestBody("AddComment")
+			if tt.want == "" {
+				if body != "" {
+					t.Errorf("expected no comment, got %s", body)
+				}
+				return
+			}
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("comment %s does not contain %q", body, tt.want)
+			}
+		})
+	}
+}
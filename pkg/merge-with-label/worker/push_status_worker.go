@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// sharedPushStatusWorker handles push and status messages, both work on all pull requests of the repository.
+type sharedPushStatusWorker struct {
+	*Worker
+	// eventType is the type of the handled messages ("push" or "status").
+	eventType string
+}
+
+// runLogic looks at all pull requests of the repository, if baseBranch is not empty only at the ones based on it.
+// Pushes are not filtered by the default branch here, a push to any branch (e.g. release/1.x) looks at the pull
+// requests that are based on it.
+func (worker *sharedPushStatusWorker) runLogic(rootLogger *zerolog.Logger, msg *common.BaseMessage, baseBranch string) error {
+	logger := rootLogger.With().Str("entry", worker.eventType).Str("repo", msg.Repository.FullName).Logger()
+	ctx, done := worker.withBudget(&logger, worker.MaxDurationForPushWorker)
+	defer done()
+	ctx = worker.withMutationThrottle(ctx, &logger, msg.InstallationID)
+
+	if worker.eventType == "push" && baseBranch != "" && baseBranch == msg.Repository.DefaultBranch {
+		// the config is cached by the name of the default branch, the push might have changed it
+		if err := worker.forgetConfig(&msg.Repository); err != nil {
+			logger.Warn().Err(err).Msg("unable to forget config")
+		}
+	}
+
+	sess, err := worker.getSession(ctx, &logger, msg)
+	if err != nil {
+		return errors.Wrap(err, "unable to get session")
+	}
+	if sess == nil {
+		return nil
+	}
+
+	return worker.workOnAllPullRequests(ctx, &logger, sess, baseBranch)
+}
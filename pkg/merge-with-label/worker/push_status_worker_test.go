@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestSharedPushStatusWorker_runLogic_OtherBranch(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["GetOpenPullRequests"] = `{"data":{"repository":{"pullRequests":{"nodes":[` +
+		`{"number":1,"baseRefName":"main","labels":{"nodes":[{"name":"merge"}]}},` +
+		`{"number":2,"baseRefName":"release/1.x","labels":{"nodes":[{"name":"merge"}]}}],` +
+		`"pageInfo":{"hasNextPage":false}}}}}`
+	js := &fakePublisher{}
+	worker := &sharedPushStatusWorker{Worker: newTestSessionWorker(t, gh, "version: 1\nmerge:\n  labels: [merge]\n"), eventType: "push"}
+	worker.JetStreamContext = js
+	worker.RateLimitKV = newFakeKV()
+	worker.PullRequestSubject = "pull_request"
+	msg := &common.BaseMessage{
+		InstallationID: 1,
+		Repository:     common.Repository{FullName: "owner/repo", OwnerName: "owner", Name: "repo", NodeID: "R_1", DefaultBranch: "main"},
+	}
+
+	// e.g. the sweep after a merge into a release branch
+	logger := log.Logger
+	if err := worker.runLogic(&logger, msg, "release/1.x"); err != nil {
+		t.Fatalf("runLogic() error = %v", err)
+	}
+	var got []int64
+	for _, published := range js.published {
+		var m common.QueuePullRequestMessage
+		if err := json.Unmarshal(published.Data, &m); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m.PullRequest.Number)
+	}
+	if want := []int64{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued pull requests = %v, want %v", got, want)
+	}
+}
+
+func TestSharedPushStatusWorker_runLogic_ForgetsConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		branch    string
+		wantFetch int
+	}{
+		{name: "push to the default branch", branch: "main", wantFetch: 1},
+		{name: "push to another branch", branch: "release/1.x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetRepositoryLabels"] = repositoryLabelsResponse
+			worker := &sharedPushStatusWorker{Worker: newTestSessionWorker(t, gh, "version: 1\nmerge:\n  labels: [merge]\n"), eventType: "push"}
+			worker.JetStreamContext = &fakePublisher{}
+			worker.RateLimitKV = newFakeKV()
+			worker.PullRequestSubject = "pull_request"
+			msg := &common.BaseMessage{
+				InstallationID: 1,
+				Repository:     common.Repository{FullName: "owner/repo", OwnerName: "owner", Name: "repo", NodeID: "R_1", DefaultBranch: "main"},
+			}
+			cfg, err := defaultConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Merge.Labels = common.RegexSlice{common.MustNewRegexItem("merge")}
+			buf, err := json.Marshal(cachedConfig{ConfigV1: cfg, SHA: "main"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worker.ConfigsKV.Put(hashForKV(&msg.Repository), buf); err != nil {
+				t.Fatal(err)
+			}
+
+			logger := log.Logger
+			if err := worker.runLogic(&logger, msg, tt.branch); err != nil {
+				t.Fatalf("runLogic() error = %v", err)
+			}
+			configURL := "https://raw.githubusercontent.com/owner/repo/main/" + github.ConfigFilePath
+			if got := countOperations(gh, configURL); got != tt.wantFetch {
+				t.Errorf("config fetched %d times, want %d", got, tt.wantFetch)
+			}
+		})
+	}
+}
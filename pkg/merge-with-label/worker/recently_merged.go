@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// isRecentlyMerged reports whether the pull request of the message is known to be merged, either from the state
+// carried by the webhook or because this bot merged it recently. No request to GitHub is made.
+func (worker *Worker) isRecentlyMerged(logger *zerolog.Logger, msg *common.QueuePullRequestMessage) bool {
+	if msg.PullRequest.MergedAt != nil {
+		return true
+	}
+	if worker.RecentlyMergedKV == nil {
+		return false
+	}
+	_, err := worker.RecentlyMergedKV.Get(common.RecentlyMergedKVKey(&msg.Repository, msg.PullRequest.Number))
+	if err != nil {
+		if !errors.Is(err, nats.ErrKeyNotFound) {
+			logger.Warn().Err(err).Msg("unable to get recently merged pull request from kv bucket")
+		}
+		countKVLookup(worker.RecentlyMergedKV, false)
+		return false
+	}
+	countKVLookup(worker.RecentlyMergedKV, true)
+	return true
+}
+
+// markRecentlyMerged remembers that the pull request was merged, so messages that are still queued for it
+// are dropped without asking GitHub.
+func (worker *Worker) markRecentlyMerged(repository *common.Repository, number int64) error {
+	if worker.RecentlyMergedKV == nil {
+		return nil
+	}
+	key := common.RecentlyMergedKVKey(repository, number)
+	if _, err := worker.RecentlyMergedKV.PutString(key, worker.timeNow().Format(time.RFC3339)); err != nil {
+		return errors.Wrap(err, "unable to store recently merged pull request in kv bucket")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestPullRequestWorker_runLogic_RecentlyMerged(t *testing.T) {
+	mergedAt := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		mark     bool
+		mergedAt *time.Time
+	}{
+		{name: "merged by this bot", mark: true},
+		{name: "merged according to the webhook", mergedAt: &mergedAt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			worker := newTestPullRequestWorker(gh)
+			worker.RecentlyMergedKV = newFakeKV()
+			worker.MaxDurationForPullRequestWorker = time.Minute
+			msg := &common.QueuePullRequestMessage{
+				BaseMessage: common.BaseMessage{
+					InstallationID: 1,
+					Repository:     common.Repository{FullName: "owner/repo", NodeID: "R_1"},
+				},
+				PullRequest: common.PullRequest{Number: 1, MergedAt: tt.mergedAt},
+			}
+			if tt.mark {
+				if err := worker.markRecentlyMerged(&msg.Repository, msg.PullRequest.Number); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := log.Logger
+			if err := worker.runLogic(&logger, msg); err != nil {
+				t.Fatalf("runLogic() error = %v", err)
+			}
+			if ops := gh.Operations(); len(ops) != 0 {
+				t.Errorf("expected no calls to GitHub, got %v", ops)
+			}
+		})
+	}
+}
+
+func TestWorker_isRecentlyMerged(t *testing.T) {
+	worker := newTestPullRequestWorker(newFakeGitHub())
+	logger := log.Logger
+	msg := &common.QueuePullRequestMessage{
+		BaseMessage: common.BaseMessage{Repository: common.Repository{NodeID: "R_1"}},
+		PullRequest: common.PullRequest{Number: 1},
+	}
+
+	// disabled without a bucket
+	if err := worker.markRecentlyMerged(&msg.Repository, 1); err != nil {
+		t.Fatal(err)
+	}
+	if worker.isRecentlyMerged(&logger, msg) {
+		t.Error("isRecentlyMerged() = true without a bucket")
+	}
+
+	worker.RecentlyMergedKV = newFakeKV()
+	if worker.isRecentlyMerged(&logger, msg) {
+		t.Error("isRecentlyMerged() = true before the pull request was merged")
+	}
+	if err := worker.markRecentlyMerged(&msg.Repository, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !worker.isRecentlyMerged(&logger, msg) {
+		t.Error("isRecentlyMerged() = false after the pull request was merged")
+	}
+	other := *msg
+	other.PullRequest.Number = 2
+	if worker.isRecentlyMerged(&logger, &other) {
+		t.Error("isRecentlyMerged() = true for another pull request")
+	}
+}
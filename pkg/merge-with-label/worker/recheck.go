@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// defaultMaxRechecks is used if recheckInterval is set and no maxRechecks is set.
+const defaultMaxRechecks = 20
+
+// shouldRecheck reports whether the pull request should be evaluated again after recheckInterval,
+// because its checks are still pending. Every recheck is counted per commit in the check runs kv bucket,
+// once maxRechecks is reached the pull request waits for the next event again.
+func (worker *Worker) shouldRecheck(logger *zerolog.Logger, cfg *MergeConfigV1, details *github.PullRequestDetails) bool {
+	if cfg.RecheckInterval <= 0 || worker.CheckRunsKV == nil {
+		return false
+	}
+	maxRechecks := cfg.MaxRechecks
+	if maxRechecks <= 0 {
+		maxRechecks = defaultMaxRechecks
+	}
+
+	rechecks, err := worker.countRecheck(common.RecheckKVKey(details.ID, details.LastCommitSha), maxRechecks)
+	if err != nil {
+		logger.Warn().Err(err).Msg("unable to count rechecks")
+		return false
+	}
+	if rechecks > maxRechecks {
+		logger.Debug().Int("max_rechecks", maxRechecks).Msg("checks are still pending, but rechecks are exhausted")
+		return false
+	}
+	return true
+}
+
+// maxRecheckCountAttempts is how often a recheck is counted if other workers changed the counter in the meantime.
+const maxRecheckCountAttempts = 32
+
+// countRecheck increments the recheck counter stored under key and returns the new value,
+// the counter is not incremented beyond maxRechecks+1. The counter is stored with its revision, so rechecks of
+// other workers are not lost.
+func (worker *Worker) countRecheck(key string, maxRechecks int) (int, error) {
+	for attempt := 0; attempt < maxRecheckCountAttempts; attempt++ {
+		var rechecks int
+		var revision uint64
+		entry, err := worker.CheckRunsKV.Get(key)
+		if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+			return 0, errors.Wrap(err, "unable to get rechecks from kv bucket")
+		}
+		if err == nil && entry != nil {
+			revision = entry.Revision()
+			if n, err := strconv.Atoi(string(entry.Value())); err == nil {
+				rechecks = n
+			}
+		}
+		if rechecks > maxRechecks {
+			return rechecks, nil
+		}
+		rechecks++
+		value := []byte(strconv.Itoa(rechecks))
+		if revision == 0 {
+			_, err = worker.CheckRunsKV.Create(key, value)
+		} else {
+			_, err = worker.CheckRunsKV.Update(key, value, revision)
+		}
+		if err == nil {
+			return rechecks, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return 0, errors.Wrap(err, "unable to store rechecks in kv bucket")
+		}
+		// another worker counted a recheck in the meantime
+	}
+	return 0, errors.New("unable to count the recheck, the counter was changed too often")
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestWorker_shouldSkipBecauseOfChecks_Recheck(t *testing.T) {
+	cfg := &MergeConfigV1{
+		RequiredChecks:  common.RegexSlice{common.MustNewRegexItem("build"), common.MustNewRegexItem("lint")},
+		RecheckInterval: 45 * time.Second,
+		MaxRechecks:     2,
+	}
+	worker := Worker{CheckRunsKV: newFakeKV()}
+	details := &github.PullRequestDetails{
+		ID:            "PR_1",
+		LastCommitSha: "abc",
+		CheckStates:   checkStatesOf(map[string]string{"build": "PENDING", "lint": "SUCCESS"}),
+	}
+
+	for i := 0; i < cfg.MaxRechecks; i++ {
+		got, err := worker.shouldSkipBecauseOfChecks(cfg)(context.Background(), &log.Logger, details)
+		var pbErr pushBackError
+		if !errors.As(err, &pbErr) {
+			t.Fatalf("recheck %d: error = %v, want pushBackError", i, err)
+		}
+		if pbErr.delay != cfg.RecheckInterval {
+			t.Errorf("recheck %d: delay = %v, want %v", i, pbErr.delay, cfg.RecheckInterval)
+		}
+		if got.SkipAction || got.Title != "waiting for pending check(s)" {
+			t.Errorf("recheck %d: got = %+v, want to wait for pending checks", i, got)
+		}
+	}
+
+	got, err := worker.shouldSkipBecauseOfChecks(cfg)(context.Background(), &log.Logger, details)
+	if err != nil {
+		t.Fatalf("error = %v, want no push back once the rechecks are exhausted", err)
+	}
+	if !got.SkipAction || got.SkipReason != SkipReasonPendingChecks {
+		t.Errorf("got = %+v, want skip with reason %s", got, SkipReasonPendingChecks)
+	}
+
+	// a new commit starts counting again
+	details.LastCommitSha = "def"
+	if _, err := worker.shouldSkipBecauseOfChecks(cfg)(context.Background(), &log.Logger, details); !errors.As(err, new(pushBackError)) {
+		t.Errorf("error = %v, want pushBackError for a new commit", err)
+	}
+}
+
+func TestWorker_shouldSkipBecauseOfChecks_RecheckOnlyPending(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         *MergeConfigV1
+		checkStates map[string]string
+	}{
+		{
+			name:        "failed check",
+			cfg:         &MergeConfigV1{RecheckInterval: time.Minute},
+			checkStates: map[string]string{"build": "PENDING", "lint": "FAILURE"},
+		},
+		{
+			name:        "missing check",
+			cfg:         &MergeConfigV1{RecheckInterval: time.Minute},
+			checkStates: map[string]string{"build": "PENDING"},
+		},
+		{
+			name:        "disabled",
+			cfg:         &MergeConfigV1{},
+			checkStates: map[string]string{"build": "PENDING", "lint": "PENDING"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.RequiredChecks = common.RegexSlice{common.MustNewRegexItem("build"), common.MustNewRegexItem("lint")}
+			worker := Worker{CheckRunsKV: newFakeKV()}
+			details := &github.PullRequestDetails{ID: "PR_1", LastCommitSha: "abc", CheckStates: checkStatesOf(tt.checkStates)}
+			got, err := worker.shouldSkipBecauseOfChecks(tt.cfg)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("error = %v, want no push back", err)
+			}
+			if !got.SkipAction {
+				t.Errorf("got = %+v, want skip", got)
+			}
+		})
+	}
+}
+
+func TestWorker_countRecheck_Concurrent(t *testing.T) {
+	kv := &concurrentKV{fakeKV: newFakeKV()}
+	worker := Worker{CheckRunsKV: kv}
+	key := common.RecheckKVKey("PR_1", "abc")
+
+	// another worker counts a recheck between the read and the write
+	for i := 0; i < 2; i++ {
+		kv.beforeWrite = func() {
+			if _, err := worker.countRecheck(key, 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := worker.countRecheck(key, 10); err != nil {
+			t.Fatalf("countRecheck() error = %v", err)
+		}
+	}
+	entry, err := kv.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(entry.Value()); got != "4" {
+		t.Errorf("rechecks = %s, want 4", got)
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+// Reconcile queues a push message for the default branch of every allowed repository the app is installed on,
+// so pull requests whose events were delivered while the worker was down are evaluated.
+// The messages are published ReconcileThrottle apart, so the worker is not flooded after startup.
+func (worker *Worker) Reconcile(ctx context.Context) error {
+	if worker.PushSubject == "" {
+		return nil
+	}
+	installationIDs, err := github.GetInstallationIDs(ctx, worker.HTTPClient, worker.AppID, worker.PrivateKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to get installations")
+	}
+	worker.Logger.Info().Int("installations", len(installationIDs)).Msg("reconciling installations")
+
+	var result error
+	queued := 0
+	for _, installationID := range installationIDs {
+		n, err := worker.reconcileInstallation(ctx, installationID)
+		queued += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.WithStack(ctx.Err())
+			}
+			worker.Logger.Error().Err(err).Int64("installation_id", installationID).Msg("unable to reconcile installation")
+			result = multierror.Append(result, err)
+		}
+	}
+	worker.Logger.Info().Int("repositories", queued).Msg("reconciled installations")
+	return result
+}
+
+// reconcileInstallation queues a push message for every allowed repository of the installation, it returns the
+// number of queued messages.
+func (worker *Worker) reconcileInstallation(ctx context.Context, installationID int64) (int, error) {
+	token, err := github.GetInstallationAccessToken(ctx, worker.HTTPClient, worker.AppID, worker.PrivateKey, installationID)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get access token")
+	}
+	repositories, err := github.GetInstallationRepositories(ctx, worker.HTTPClient, token.Token)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get repositories")
+	}
+
+	n := 0
+	for i := range repositories {
+		repository := repositories[i].Repository
+		logger := worker.Logger.With().Str("repo", repository.FullName).Logger()
+		if !worker.isReconcilable(&repositories[i]) {
+			logger.Debug().Msg("repository is not reconciled")
+			continue
+		}
+		if worker.ReconcileThrottle > 0 {
+			select {
+			case <-ctx.Done():
+				return n, errors.WithStack(ctx.Err())
+			case <-time.After(worker.ReconcileThrottle):
+			}
+		}
+		err := common.QueueMessage(
+			&logger,
+			worker.JetStreamContext,
+			worker.RateLimitKV,
+			worker.RateLimitInterval,
+			worker.PushSubject+"."+uuid.NewString(),
+			// same id as push events, so they are deduplicated
+			fmt.Sprintf("push.%d.%s", installationID, repository.NodeID),
+			"",
+			&common.QueuePushMessage{
+				BaseMessage: common.BaseMessage{
+					InstallationID: installationID,
+					Repository:     repository,
+				},
+				Branch: repository.DefaultBranch,
+			})
+		if err != nil {
+			return n, errors.Wrapf(err, "unable to publish push message for %s", repository.FullName)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// isReconcilable reports whether a push message should be queued for the repository.
+func (worker *Worker) isReconcilable(repository *github.InstallationRepository) bool {
+	if repository.Archived || repository.DefaultBranch == "" {
+		return false
+	}
+	if worker.AllowOnlyPublicRepositories && repository.Private {
+		return false
+	}
+	return worker.AllowedRepositories.ContainsOneOf(repository.FullName) != ""
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// fakeInstallationsAPI answers the REST requests Reconcile makes, repositories are keyed by the installation token.
+func fakeInstallationsAPI(t *testing.T, installations string, repositories map[string]string) *http.Client {
+	t.Helper()
+	respond := func(r *http.Request, status int, body string) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	}
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		switch {
+		case r.URL.Path == "/app/installations":
+			return respond(r, http.StatusOK, installations)
+		case strings.HasPrefix(r.URL.Path, "/app/installations/") && strings.HasSuffix(r.URL.Path, "/access_tokens"):
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/app/installations/"), "/access_tokens")
+			return respond(r, http.StatusCreated, `{"token":"token`+id+`","expires_at":"2024-01-02T13:00:00Z"}`)
+		case r.URL.Path == "/installation/repositories":
+			body, ok := repositories[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+			if !ok {
+				return respond(r, http.StatusUnauthorized, `{"message":"Bad credentials"}`)
+			}
+			return respond(r, http.StatusOK, body)
+		}
+		t.Errorf("unexpected request %s", r.URL)
+		return respond(r, http.StatusNotFound, "")
+	})}
+}
+
+func TestWorker_Reconcile(t *testing.T) {
+	js := &fakePublisher{}
+	logger := zerolog.Nop()
+	worker := &Worker{
+		Logger:     &logger,
+		AppID:      1,
+		PrivateKey: newTestPrivateKey(t),
+		HTTPClient: fakeInstallationsAPI(t, `[{"id":1},{"id":2}]`, map[string]string{
+			"token1": `{"repositories":[` +
+				`{"full_name":"owner/repo","node_id":"R_1","default_branch":"main"},` +
+				`{"full_name":"owner/archived","node_id":"R_2","default_branch":"main","archived":true},` +
+				`{"full_name":"owner/empty","node_id":"R_3"},` +
+				`{"full_name":"owner/private","node_id":"R_4","default_branch":"main","private":true}]}`,
+			"token2": `{"repositories":[` +
+				`{"full_name":"other/repo","node_id":"R_5","default_branch":"develop"},` +
+				`{"full_name":"forbidden/repo","node_id":"R_6","default_branch":"main"}]}`,
+		}),
+		AllowedRepositories:         common.RegexSlice{common.MustNewRegexItem("^(owner|other)/")},
+		AllowOnlyPublicRepositories: true,
+		JetStreamContext:            js,
+		RateLimitKV:                 newFakeKV(),
+		PushSubject:                 "push",
+	}
+
+	if err := worker.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := decodePushMessages(t, js.published)
+	var got []string
+	for _, m := range messages {
+		got = append(got, m.Repository.FullName+"@"+m.Branch)
+		if m.Repository.FullName == "other/repo" && m.InstallationID != 2 {
+			t.Errorf("installation of other/repo = %d, want 2", m.InstallationID)
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"other/repo@develop", "owner/repo@main"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+}
+
+func TestWorker_Reconcile_InstallationError(t *testing.T) {
+	js := &fakePublisher{}
+	logger := zerolog.Nop()
+	worker := &Worker{
+		Logger:     &logger,
+		AppID:      1,
+		PrivateKey: newTestPrivateKey(t),
+		// the repositories of installation 1 can not be listed
+		HTTPClient: fakeInstallationsAPI(t, `[{"id":1},{"id":2}]`, map[string]string{
+			"token2": `{"repositories":[{"full_name":"owner/repo","node_id":"R_1","default_branch":"main"}]}`,
+		}),
+		AllowedRepositories: common.RegexSlice{common.MustNewRegexItem(".*")},
+		JetStreamContext:    js,
+		RateLimitKV:         newFakeKV(),
+		PushSubject:         "push",
+	}
+
+	if err := worker.Reconcile(context.Background()); err == nil {
+		t.Error("Reconcile() error = nil, want the error of installation 1")
+	}
+	if len(js.published) != 1 {
+		t.Errorf("published %d messages, want the repository of installation 2", len(js.published))
+	}
+}
+
+func TestWorker_Reconcile_Canceled(t *testing.T) {
+	js := &fakePublisher{}
+	logger := zerolog.Nop()
+	worker := &Worker{
+		Logger:     &logger,
+		AppID:      1,
+		PrivateKey: newTestPrivateKey(t),
+		HTTPClient: fakeInstallationsAPI(t, `[{"id":1}]`, map[string]string{
+			"token1": `{"repositories":[{"full_name":"owner/repo","node_id":"R_1","default_branch":"main"}]}`,
+		}),
+		AllowedRepositories: common.RegexSlice{common.MustNewRegexItem(".*")},
+		JetStreamContext:    js,
+		RateLimitKV:         newFakeKV(),
+		PushSubject:         "push",
+		ReconcileThrottle:   time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	worker.HTTPClient.Transport = cancelAfter(worker.HTTPClient.Transport, "/installation/repositories", cancel)
+	if err := worker.Reconcile(ctx); err == nil {
+		t.Error("Reconcile() error = nil, want the context to be canceled")
+	}
+	if len(js.published) != 0 {
+		t.Errorf("published %d messages, want 0", len(js.published))
+	}
+}
+
+// cancelAfter calls cancel after the request to path was answered.
+func cancelAfter(next http.RoundTripper, path string, cancel func()) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if r.URL.Path == path {
+			cancel()
+		}
+		return resp, err
+	})
+}
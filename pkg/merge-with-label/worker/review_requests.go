@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+const teamReviewerPrefix = "team:"
+
+// reviewerIDCache caches the node ids of users and teams, node ids never change so they are kept forever.
+type reviewerIDCache struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func (c *reviewerIDCache) get(reviewer string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[reviewer]
+	return id, ok
+}
+
+func (c *reviewerIDCache) set(reviewer, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[string]string)
+	}
+	c.ids[reviewer] = id
+}
+
+// resolveReviewerID returns the node id of a reviewer, reviewer is either a user login or a team in the
+// form team:org/slug.
+func (worker *Worker) resolveReviewerID(ctx context.Context, token, reviewer string) (id string, isTeam bool, err error) {
+	name, isTeam := strings.CutPrefix(reviewer, teamReviewerPrefix)
+	if id, ok := worker.reviewerIDs.get(reviewer); ok {
+		return id, isTeam, nil
+	}
+
+	if isTeam {
+		org, slug, ok := strings.Cut(name, "/")
+		if !ok || org == "" || slug == "" {
+			return "", true, errors.Errorf("invalid team %q, expected team:org/slug", reviewer)
+		}
+		id, err = github.GetTeamID(ctx, worker.HTTPClient, token, org, slug)
+	} else {
+		id, err = github.GetUserID(ctx, worker.HTTPClient, token, name)
+	}
+	if err != nil {
+		return "", isTeam, errors.WithStack(err)
+	}
+	worker.reviewerIDs.set(reviewer, id)
+	return id, isTeam, nil
+}
+
+// requestReviewers requests reviews from requestReviewersOnMissingApproval.
+// Reviewers are requested once per pull request, this is tracked in the review requests kv bucket.
+func (worker *Worker) requestReviewers(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	sess *session,
+	details *github.PullRequestDetails,
+) error {
+	reviewers := sess.Config.Merge.RequestReviewersOnMissingApproval
+	if worker.ReviewRequestsKV == nil || len(reviewers) == 0 {
+		return nil
+	}
+
+	key := common.ReviewRequestKVKey(details.ID)
+	if _, err := worker.ReviewRequestsKV.Create(key, []byte(worker.ID)); err != nil {
+		if errors.Is(err, nats.ErrKeyExists) {
+			logger.Debug().Msg("reviewers were already requested")
+			return nil
+		}
+		return errors.Wrap(err, "unable to create review request in kv bucket")
+	}
+
+	err := worker.doRequestReviewers(ctx, logger, sess.AccessToken, details, reviewers)
+	if err != nil {
+		// allow the next evaluation to try again
+		if err := worker.ReviewRequestsKV.Delete(key); err != nil {
+			logger.Error().Err(err).Msg("unable to delete review request from kv bucket")
+		}
+	}
+	return err
+}
+
+func (worker *Worker) doRequestReviewers(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	token string,
+	details *github.PullRequestDetails,
+	reviewers []string,
+) error {
+	var userIDs, teamIDs []string
+	for _, reviewer := range reviewers {
+		// github does not allow the author to review their own pull request
+		if strings.EqualFold(reviewer, details.Author) {
+			continue
+		}
+		id, isTeam, err := worker.resolveReviewerID(ctx, token, reviewer)
+		if err != nil {
+			return err
+		}
+		if isTeam {
+			teamIDs = append(teamIDs, id)
+		} else {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 && len(teamIDs) == 0 {
+		return nil
+	}
+
+	logger.Info().Strs("reviewers", reviewers).Msg("requesting reviewers")
+	if err := github.RequestReviews(ctx, worker.HTTPClient, token, details.ID, userIDs, teamIDs); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
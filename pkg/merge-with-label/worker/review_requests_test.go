@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func countOperations(gh *fakeGitHub, operation string) int {
+	var n int
+	for _, op := range gh.Operations() {
+		if op == operation {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPullRequestWorker_mergePullRequest_RequestReviewers(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["GetUserID"] = `{"data":{"user":{"id":"U_alice"}}}`
+	gh.responses["GetTeamID"] = `{"data":{"organization":{"team":{"id":"T_reviewers"}}}}`
+	worker := newTestPullRequestWorker(gh)
+	worker.ReviewRequestsKV = newFakeKV()
+	sess := newTestSession()
+	sess.Config.Merge.RequiredApprovals = 1
+	sess.Config.Merge.RequestReviewersOnMissingApproval = []string{"team:org/reviewers", "alice", "author"}
+
+	logger := log.Logger
+	for _, id := range []string{"PR_1", "PR_1", "PR_2"} {
+		details := newTestPullRequestDetails()
+		details.ID = id
+		details.Author = "author"
+		if _, _, err := worker.mergePullRequest(context.Background(), &logger, sess, 1, details); err != nil {
+			t.Fatalf("mergePullRequest() error = %v", err)
+		}
+	}
+
+	if got := countOperations(gh, "RequestReviews"); got != 2 {
+		t.Errorf("RequestReviews called %d times, want 2 (once per pull request)", got)
+	}
+	if got := countOperations(gh, "GetUserID") + countOperations(gh, "GetTeamID"); got != 2 {
+		t.Errorf("reviewer ids resolved %d times, want 2 (cached after the first pull request)", got)
+	}
+	body := gh.LastRequestBody("RequestReviews")
+	for _, want := range []string{`"userIds":["U_alice"]`, `"teamIds":["T_reviewers"]`, `"pullRequestId":"PR_2"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("RequestReviews body %s does not contain %s", body, want)
+		}
+	}
+}
+
+func TestPullRequestWorker_requestReviewers_Failure(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["GetUserID"] = `{"data":{"user":null}}`
+	worker := newTestPullRequestWorker(gh)
+	worker.ReviewRequestsKV = newFakeKV()
+	sess := newTestSession()
+	sess.Config.Merge.RequestReviewersOnMissingApproval = []string{"unknown"}
+	details := newTestPullRequestDetails()
+
+	logger := log.Logger
+	if err := worker.requestReviewers(context.Background(), &logger, sess, details); err == nil {
+		t.Fatal("requestReviewers() expected an error for an unknown user")
+	}
+	if _, err := worker.ReviewRequestsKV.Get(common.ReviewRequestKVKey(details.ID)); err == nil {
+		t.Error("review request flag was kept after a failure, the next evaluation would not retry")
+	}
+	if got := countOperations(gh, "RequestReviews"); got != 0 {
+		t.Errorf("RequestReviews called %d times, want 0", got)
+	}
+}
+
+func TestWorker_resolveReviewerID(t *testing.T) {
+	tests := []struct {
+		reviewer   string
+		wantID     string
+		wantIsTeam bool
+		wantErr    bool
+	}{
+		{reviewer: "alice", wantID: "U_1"},
+		{reviewer: "team:org/reviewers", wantID: "T_1", wantIsTeam: true},
+		{reviewer: "team:reviewers", wantIsTeam: true, wantErr: true},
+		{reviewer: "team:org/", wantIsTeam: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reviewer, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetUserID"] = `{"data":{"user":{"id":"U_1"}}}`
+			gh.responses["GetTeamID"] = `{"data":{"organization":{"team":{"id":"T_1"}}}}`
+			worker := newTestPullRequestWorker(gh)
+
+			id, isTeam, err := worker.resolveReviewerID(context.Background(), "token", tt.reviewer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveReviewerID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.wantID || isTeam != tt.wantIsTeam {
+				t.Errorf("resolveReviewerID() = %q, %v, want %q, %v", id, isTeam, tt.wantID, tt.wantIsTeam)
+			}
+		})
+	}
+}
@@ -15,7 +15,17 @@ type session struct {
 	Repository     *common.Repository
 	InstallationID int64
 	AccessToken    string
-	Config         *ConfigV1
+	// Sender triggered the message, it is empty for messages that were not created by a webhook.
+	Sender common.Sender
+	Config *ConfigV1
+	// ConfigSource describes where Config was loaded from.
+	ConfigSource string
+	// ConfigProblems are the parts of Config that were invalid and ignored.
+	ConfigProblems []ConfigProblem
+	// MergeOverride describes the rule of merge.overrides that was applied, it is shown in the check run footer.
+	MergeOverride string
+	// BaseSHA is the latest commit sha of the default branch, it is empty until it was looked up, see baseSHA.
+	BaseSHA string
 }
 
 func (worker *Worker) getSession(ctx context.Context, rootLogger *zerolog.Logger, message *common.BaseMessage) (*session, error) {
@@ -24,16 +34,25 @@ func (worker *Worker) getSession(ctx context.Context, rootLogger *zerolog.Logger
 		return nil, errors.Wrap(err, "unable to get access token")
 	}
 
-	sha, err := github.GetLatestBaseCommitSha(ctx, worker.HTTPClient, accessToken, &message.Repository)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get latest base commit sha")
-	}
-	if sha == "" {
-		rootLogger.Debug().Msg("latest commit sha is empty")
-		return nil, nil
+	// the config is read from the default branch, messages queued by older servers do not carry it,
+	// look it up for them.
+	ref := message.Repository.DefaultBranch
+	var baseSHA string
+	if ref == "" {
+		defaultBranch, sha, err := github.GetDefaultBranch(ctx, worker.HTTPClient, accessToken, &message.Repository)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get latest base commit sha")
+		}
+		if sha == "" {
+			rootLogger.Debug().Msg("latest commit sha is empty")
+			return nil, nil
+		}
+		message.Repository.DefaultBranch = defaultBranch
+		ref = sha
+		baseSHA = sha
 	}
 
-	cfg, err := worker.getConfig(ctx, rootLogger, accessToken, &message.Repository, sha)
+	cfg, err := worker.getConfig(ctx, rootLogger, accessToken, &message.Repository, ref)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get config")
 	}
@@ -42,14 +61,39 @@ func (worker *Worker) getSession(ctx context.Context, rootLogger *zerolog.Logger
 		return nil, nil
 	}
 
-	if len(cfg.Merge.Labels) == 0 && len(cfg.Update.Labels) == 0 {
+	if len(cfg.Merge.Labels) == 0 && len(cfg.Merge.ImmediateLabels) == 0 && len(cfg.Update.Labels) == 0 {
 		rootLogger.Debug().Msg("merge and update are disabled")
 		return nil, nil
 	}
-	return &session{
+	sess := &session{
 		Repository:     &message.Repository,
 		InstallationID: message.InstallationID,
+		Sender:         message.Sender,
 		AccessToken:    accessToken,
-		Config:         cfg,
-	}, nil
+		Config:         cfg.ConfigV1,
+		ConfigSource:   cfg.Source,
+		ConfigProblems: cfg.Problems,
+		BaseSHA:        baseSHA,
+	}
+	if err := worker.checkConfiguredLabels(ctx, rootLogger, sess); err != nil {
+		rootLogger.Warn().Err(err).Msg("unable to check configured labels")
+	}
+	if err := worker.rememberRepository(message); err != nil {
+		rootLogger.Warn().Err(err).Msg("unable to remember repository for the sweep")
+	}
+	return sess, nil
+}
+
+// baseSHA returns the latest commit sha of the default branch.
+// It is only looked up if getSession did not already get it, and only once per session.
+func (worker *Worker) baseSHA(ctx context.Context, sess *session) (string, error) {
+	if sess.BaseSHA != "" {
+		return sess.BaseSHA, nil
+	}
+	sha, err := github.GetLatestBaseCommitSha(ctx, worker.HTTPClient, sess.AccessToken, sess.Repository)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get latest base commit sha")
+	}
+	sess.BaseSHA = sha
+	return sha, nil
 }
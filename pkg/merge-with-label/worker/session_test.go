@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func TestWorker_getSession_DefaultBranch(t *testing.T) {
+	tests := []struct {
+		name              string
+		defaultBranch     string
+		wantBaseQueries   int
+		wantDefaultBranch string
+		wantBaseSHA       string
+		wantSource        string
+	}{
+		{
+			name:              "carried default branch",
+			defaultBranch:     "main",
+			wantDefaultBranch: "main",
+			wantSource:        "at main",
+		},
+		{
+			name:              "message of an older server",
+			wantBaseQueries:   1,
+			wantDefaultBranch: "main",
+			wantBaseSHA:       "def",
+			wantSource:        "at def",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub()
+			gh.responses["GetRepositoryLabels"] = repositoryLabelsResponse
+			worker := newTestSessionWorker(t, gh, "version: 1\nmerge:\n  labels: [merge]\n")
+			msg := &common.BaseMessage{
+				InstallationID: 1,
+				Repository: common.Repository{
+					FullName: "owner/repo", OwnerName: "owner", Name: "repo", NodeID: "R_1", DefaultBranch: tt.defaultBranch,
+				},
+			}
+
+			logger := log.Logger
+			sess, err := worker.getSession(context.Background(), &logger, msg)
+			if err != nil {
+				t.Fatalf("getSession() error = %v", err)
+			}
+			if sess == nil {
+				t.Fatal("getSession() returned no session")
+			}
+			if got := countOperations(gh, "GetLatestBaseCommitSha"); got != tt.wantBaseQueries {
+				t.Errorf("GetLatestBaseCommitSha called %d times, want %d", got, tt.wantBaseQueries)
+			}
+			if sess.Repository.DefaultBranch != tt.wantDefaultBranch {
+				t.Errorf("DefaultBranch = %q, want %q", sess.Repository.DefaultBranch, tt.wantDefaultBranch)
+			}
+			if sess.BaseSHA != tt.wantBaseSHA {
+				t.Errorf("BaseSHA = %q, want %q", sess.BaseSHA, tt.wantBaseSHA)
+			}
+			if !strings.Contains(sess.ConfigSource, tt.wantSource) {
+				t.Errorf("ConfigSource = %q, want it to contain %q", sess.ConfigSource, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestWorker_baseSHA(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["GetLatestBaseCommitSha"] = `{"data":{"repository":{"defaultBranchRef":{"name":"main","target":{"oid":"def"}}}}}`
+	worker := newTestPullRequestWorker(gh).Worker
+	sess := newTestSession()
+
+	for i := 0; i < 2; i++ {
+		sha, err := worker.baseSHA(context.Background(), sess)
+		if err != nil {
+			t.Fatalf("baseSHA() error = %v", err)
+		}
+		if sha != "def" {
+			t.Errorf("baseSHA() = %q, want def", sha)
+		}
+	}
+	if got := countOperations(gh, "GetLatestBaseCommitSha"); got != 1 {
+		t.Errorf("GetLatestBaseCommitSha called %d times, want 1", got)
+	}
+}
@@ -11,16 +11,63 @@ import (
 	"github.com/rs/zerolog"
 	"golang.org/x/exp/slices"
 
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
 )
 
+// SkipReason is a machine-readable code describing why an action was skipped.
+type SkipReason string
+
+const (
+	SkipReasonIgnoredTitle     SkipReason = "ignored_title"
+	SkipReasonIgnoredLabel     SkipReason = "ignored_label"
+	SkipReasonIgnoredAuthor    SkipReason = "ignored_author"
+	SkipReasonForbiddenAuthor  SkipReason = "forbidden_author"
+	SkipReasonAuthorNotAllowed SkipReason = "author_not_allowed"
+	SkipReasonDraft            SkipReason = "draft"
+	SkipReasonConflicts        SkipReason = "conflicts"
+	SkipReasonNotMergeable     SkipReason = "not_mergeable"
+	SkipReasonLinearHistory    SkipReason = "linear_history_required"
+	SkipReasonTooManyCommits   SkipReason = "too_many_commits"
+	SkipReasonMissingApprovals SkipReason = "missing_approvals"
+	SkipReasonMissingChecks    SkipReason = "missing_checks"
+	SkipReasonPendingChecks    SkipReason = "pending_checks"
+	SkipReasonFailedChecks     SkipReason = "failed_checks"
+	SkipReasonUnsignedCommit   SkipReason = "unsigned_commit"
+	SkipReasonEmptyBody        SkipReason = "empty_body"
+	SkipReasonNotDefaultBranch SkipReason = "not_default_branch"
+	SkipReasonNativeAutoMerge  SkipReason = "native_auto_merge"
+	SkipReasonPendingReviewers SkipReason = "pending_reviewers"
+	SkipReasonReviewDecision   SkipReason = "review_decision"
+)
+
 type shouldSkipResult struct {
 	SkipAction bool
+	SkipReason SkipReason
 	Title      string
 	Summary    string
+	// Annotations are attached to the check run to highlight specific files, no condition populates them yet.
+	Annotations []github.CheckRunAnnotation
+}
+
+// checkRunTitle returns the title for the check run, prefixed with the action that was skipped.
+// If no title is set the SkipReason is used.
+func (r *shouldSkipResult) checkRunTitle(prefix string) string {
+	title := r.Title
+	if title == "" {
+		title = strings.ReplaceAll(string(r.SkipReason), "_", " ")
+	}
+	if title == "" {
+		return ""
+	}
+	return prefix + title
 }
 
-var statesThatAreSuccess = []string{"NEUTRAL", "SUCCESS", ""}
+// statesThatAreSuccess are the check states that allow a merge. SKIPPED is one of them, because GitHub's
+// status check rollup (see usesStatusCheckRollup) and branch protection count skipped checks as succeeded.
+var statesThatAreSuccess = []string{"NEUTRAL", "SKIPPED", "SUCCESS", ""}
+
+var statesThatAreRunning = []string{"IN_PROGRESS", "QUEUED", "PENDING"}
 
 type shouldSkipFunc func(ctx context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error)
 
@@ -28,48 +75,141 @@ func (worker *Worker) shouldSkipMerge(
 	ctx context.Context,
 	logger *zerolog.Logger,
 	cfg *ConfigV1,
+	defaultBranch string,
 	details *github.PullRequestDetails,
 ) (shouldSkipResult, error) {
-	conditions := []shouldSkipFunc{
+	conditions := worker.mergeConditions(cfg, defaultBranch)
+
+	if cfg.Merge.ReportAllBlockers {
+		return evaluateAllConditions(ctx, logger, details, conditions, "not merging: ")
+	}
+
+	for i := range conditions {
+		result, err := conditions[i](ctx, logger, details)
+		if err != nil || result.SkipAction {
+			if result.SkipAction {
+				result.Title = result.checkRunTitle("not merging: ")
+			}
+			return result, errors.WithStack(err)
+		}
+	}
+	return shouldSkipResult{SkipAction: false}, nil
+}
+
+// mergeConditions returns the conditions that are checked before merging, in the order they are checked.
+// defaultBranch is the default branch of the repository, it is empty if it is not known.
+func (worker *Worker) mergeConditions(cfg *ConfigV1, defaultBranch string) []shouldSkipFunc {
+	return []shouldSkipFunc{
 		worker.shouldSkipBecauseOfTitle(&cfg.Merge.IgnoreConfig),
 		worker.shouldSkipBecauseOfLabel(&cfg.Merge.IgnoreConfig),
 		worker.shouldSkipBecauseOfAuthorName(&cfg.Merge.IgnoreConfig),
+		worker.shouldSkipBecauseOfAuthorNotAllowed(cfg.Merge.AllowFromUsers),
+		worker.shouldSkipBecauseOfForbiddenAuthor(&cfg.Merge),
+		worker.shouldSkipBecauseOfBaseBranch(cfg.Merge.OnlyDefaultBranch, defaultBranch),
+		worker.shouldSkipBecauseOfNativeAutoMerge(&cfg.Merge),
 		worker.shouldSkipBecauseOfHistory(&cfg.Merge),
+		worker.shouldSkipBecauseOfCommitCount(&cfg.Merge),
+		worker.shouldSkipBecauseOfCommitSignature(&cfg.Merge),
+		worker.shouldSkipBecauseOfEmptyBody(&cfg.Merge),
 		worker.shouldSkipBecauseOfReviews(&cfg.Merge),
+		worker.shouldSkipBecauseOfReviewDecision(&cfg.Merge),
+		worker.shouldSkipBecauseOfPendingReviewers(&cfg.Merge),
 		worker.shouldSkipBecauseOfChecks(&cfg.Merge),
+		worker.shouldSkipBecauseCommitTooRecent(&cfg.Merge),
 		worker.shouldSkipBecauseIsNotMergeable(&cfg.Merge),
 	}
+}
 
+// evaluateAllConditions runs all conditions and combines every blocker into one result.
+// The decision is the same as when stopping at the first blocker: the first condition that skips or pushes back wins.
+func evaluateAllConditions(
+	ctx context.Context,
+	logger *zerolog.Logger,
+	details *github.PullRequestDetails,
+	conditions []shouldSkipFunc,
+	prefix string,
+) (shouldSkipResult, error) {
+	var blockers shouldSkipResults
+	decided := false
+	var decision error
 	for i := range conditions {
 		result, err := conditions[i](ctx, logger, details)
-		if err != nil || result.SkipAction {
-			if result.Title != "" {
-				result.Title = "not merging: " + result.Title
+		if err != nil {
+			var pbErr pushBackError
+			if !errors.As(err, &pbErr) {
+				return result, errors.WithStack(err)
 			}
-			return result, errors.WithStack(err)
+		}
+		if err == nil && !result.SkipAction {
+			continue
+		}
+		if !decided {
+			decided = true
+			decision = err
+		}
+		if result.SkipAction || result.Title != "" {
+			blockers = append(blockers, result)
 		}
 	}
-	return shouldSkipResult{SkipAction: false}, nil
+	if !decided {
+		return shouldSkipResult{SkipAction: false}, nil
+	}
+	result := blockers.combine(prefix)
+	result.SkipAction = decision == nil
+	return result, errors.WithStack(decision)
+}
+
+type shouldSkipResults []shouldSkipResult
+
+// combine merges the results into one result, that lists every blocker as a markdown checklist.
+func (r shouldSkipResults) combine(prefix string) shouldSkipResult {
+	switch len(r) {
+	case 0:
+		return shouldSkipResult{}
+	case 1:
+		result := r[0]
+		result.Title = result.checkRunTitle(prefix)
+		return result
+	}
+
+	result := shouldSkipResult{
+		SkipReason: r[0].SkipReason,
+		Title:      fmt.Sprintf("%s%d blockers", prefix, len(r)),
+	}
+	var lines []string
+	for i := range r {
+		lines = append(lines, "- [ ] "+r[i].checkRunTitle(""))
+		if summary := strings.TrimSpace(r[i].Summary); summary != "" {
+			for _, line := range strings.Split(summary, "\n") {
+				lines = append(lines, "  "+line)
+			}
+		}
+		result.Annotations = append(result.Annotations, r[i].Annotations...)
+	}
+	result.Summary = strings.Join(lines, "\n")
+	return result
 }
 
 func (worker *Worker) shouldSkipUpdate(
 	ctx context.Context,
 	logger *zerolog.Logger,
 	cfg *ConfigV1,
+	defaultBranch string,
 	details *github.PullRequestDetails,
 ) (shouldSkipResult, error) {
 	conditions := []shouldSkipFunc{
 		worker.shouldSkipBecauseOfTitle(&cfg.Update.IgnoreConfig),
 		worker.shouldSkipBecauseOfLabel(&cfg.Update.IgnoreConfig),
 		worker.shouldSkipBecauseOfAuthorName(&cfg.Update.IgnoreConfig),
+		worker.shouldSkipBecauseOfAuthorNotAllowed(cfg.Update.AllowFromUsers),
+		worker.shouldSkipBecauseOfBaseBranch(cfg.Update.OnlyDefaultBranch, defaultBranch),
+		worker.shouldSkipBecauseOfNativeAutoMerge(&cfg.Merge),
 	}
 
 	for _, condition := range conditions {
 		result, err := condition(ctx, logger, details)
 		if result.SkipAction || err != nil {
-			if result.Title != "" {
-				result.Title = "not updating: " + result.Title
-			}
+			result.Title = result.checkRunTitle("not updating: ")
 			return result, errors.WithStack(err)
 		}
 	}
@@ -84,6 +224,7 @@ func (worker *Worker) shouldSkipBecauseOfTitle(cfg *IgnoreConfig) shouldSkipFunc
 				Msg("title is in ignore list")
 			return shouldSkipResult{
 				SkipAction: true,
+				SkipReason: SkipReasonIgnoredTitle,
 				Title:      "title is in ignore list",
 				Summary:    fmt.Sprintf("`%s` is in the ignore list (`%s`, matched by `%s`)", details.Title, cfg.IgnoreWithTitles.String(), ignoredBy),
 			}, nil
@@ -101,6 +242,7 @@ func (worker *Worker) shouldSkipBecauseOfLabel(cfg *IgnoreConfig) shouldSkipFunc
 					Msg("label is in ignore list")
 				return shouldSkipResult{
 					SkipAction: true,
+					SkipReason: SkipReasonIgnoredLabel,
 					Title:      "label is in ignore list",
 					Summary:    fmt.Sprintf("`%s` is in the ignore list (`%s`)", label, cfg.ignoreWithLabels.String()),
 				}, nil
@@ -121,15 +263,60 @@ func (worker *Worker) shouldSkipBecauseOfAuthorName(cfg *IgnoreConfig) shouldSki
 			Msg("author is in ignore list")
 		return shouldSkipResult{
 			SkipAction: true,
+			SkipReason: SkipReasonIgnoredAuthor,
 			Title:      "author is in ignore list",
 			Summary:    fmt.Sprintf("`%s` is in the ignore list (`%s`, matched by `%s`)", details.Author, cfg.IgnoreFromUsers.String(), ignoredBy),
 		}, nil
 	}
 }
 
+// shouldSkipBecauseOfAuthorNotAllowed skips pull requests whose author matches none of the patterns in
+// allowFromUsers, an empty list allows all authors.
+func (worker *Worker) shouldSkipBecauseOfAuthorNotAllowed(allowFromUsers common.RegexSlice) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if len(allowFromUsers) == 0 || allowFromUsers.ContainsOneOf(details.Author) != "" {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Str("author", details.Author).
+			Msg("author is not in allow list")
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonAuthorNotAllowed,
+			Title:      "author not in allow list",
+			Summary:    fmt.Sprintf("`%s` is not in the allow list (`%s`)", details.Author, allowFromUsers.String()),
+		}, nil
+	}
+}
+
+// shouldSkipBecauseOfForbiddenAuthor blocks merging for authors in merge.forbiddenAuthors,
+// in contrast to ignoreFromUsers updating is still allowed.
+func (worker *Worker) shouldSkipBecauseOfForbiddenAuthor(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		forbiddenBy := cfg.ForbiddenAuthors.ContainsOneOf(details.Author)
+		if forbiddenBy == "" {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Str("author", details.Author).
+			Msg("author is not allowed to be merged automatically")
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonForbiddenAuthor,
+			Title:      "author is forbidden",
+			Summary: fmt.Sprintf(
+				"pull requests from `%s` need to be merged manually (`%s`, matched by `%s`)",
+				details.Author,
+				cfg.ForbiddenAuthors.String(),
+				forbiddenBy,
+			),
+		}, nil
+	}
+}
+
 func (worker *Worker) shouldSkipBecauseOfHistory(cfg *MergeConfigV1) shouldSkipFunc {
 	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
-		if !cfg.RequireLinearHistory {
+		if !cfg.RequireLinearHistoryForLabels(details.Labels) {
 			return shouldSkipResult{
 				SkipAction: false,
 				Title:      "",
@@ -147,34 +334,195 @@ func (worker *Worker) shouldSkipBecauseOfHistory(cfg *MergeConfigV1) shouldSkipF
 			Msg("a linear history is required")
 		return shouldSkipResult{
 			SkipAction: true,
+			SkipReason: SkipReasonLinearHistory,
 			Title:      "a linear history is required",
 			Summary:    fmt.Sprintf("the branch is not upto date with the latest changes from `%s` branch", details.BaseRefName),
 		}, nil
 	}
 }
 
-func (worker *Worker) buildAvailableChecksList(details *github.PullRequestDetails) string {
+func (worker *Worker) shouldSkipBecauseOfCommitCount(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if cfg.MaxCommitCount <= 0 || details.CommitCount <= cfg.MaxCommitCount {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Int("max_commit_count", cfg.MaxCommitCount).
+			Int("commit_count", details.CommitCount).
+			Msg("too many commits")
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonTooManyCommits,
+			Title:      "too many commits",
+			Summary: fmt.Sprintf(
+				"the pull request has %d commits, at most %d are allowed\nplease squash your commits",
+				details.CommitCount,
+				cfg.MaxCommitCount,
+			),
+		}, nil
+	}
+}
+
+func (worker *Worker) shouldSkipBecauseOfCommitSignature(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if !cfg.RequireSignedCommits || details.IsLastCommitSigned {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Str("sha", details.LastCommitSha).
+			Msg("last commit is not signed")
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonUnsignedCommit,
+			Title:      "last commit is not signed",
+			Summary:    fmt.Sprintf("commit `%s` has no valid signature, signed commits are required", details.LastCommitSha),
+		}, nil
+	}
+}
+
+func (worker *Worker) shouldSkipBecauseOfEmptyBody(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if !cfg.RequirePRBodyNotEmpty || strings.TrimSpace(details.Body) != "" {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().Msg("pull request has no description")
+		summary := "a description is required, add one to the pull request"
+		if details.URL != "" {
+			summary = fmt.Sprintf("a description is required, [add one to the pull request](%s)", details.URL)
+		}
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonEmptyBody,
+			Title:      "pull request has no description",
+			Summary:    summary,
+		}, nil
+	}
+}
+
+// shouldSkipBecauseOfBaseBranch skips pull requests that do not target defaultBranch if onlyDefaultBranch is set.
+// Nothing is skipped if the default branch is not known.
+func (worker *Worker) shouldSkipBecauseOfBaseBranch(onlyDefaultBranch bool, defaultBranch string) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if !onlyDefaultBranch || defaultBranch == "" || details.BaseRefName == defaultBranch {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Str("base_branch", details.BaseRefName).
+			Str("default_branch", defaultBranch).
+			Msg("pull request does not target the default branch")
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonNotDefaultBranch,
+			Title:      "pull request does not target the default branch",
+			Summary: fmt.Sprintf(
+				"the pull request targets `%s`, but only pull requests targeting the default branch `%s` are handled",
+				details.BaseRefName,
+				defaultBranch,
+			),
+		}, nil
+	}
+}
+
+// shouldSkipBecauseOfNativeAutoMerge skips pull requests that have GitHub's auto-merge enabled,
+// unless merge.ignoreNativeAutoMerge is disabled.
+func (worker *Worker) shouldSkipBecauseOfNativeAutoMerge(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if !cfg.ignoresNativeAutoMerge() || details.AutoMerge == nil {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Str("enabled_by", details.AutoMerge.EnabledBy).
+			Msg("native auto-merge is enabled")
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonNativeAutoMerge,
+			Title:      "native auto-merge already enabled by @" + details.AutoMerge.EnabledBy,
+			Summary: "GitHub merges the pull request itself, disable auto-merge or set " +
+				"`merge.ignoreNativeAutoMerge: false` to let the bot handle it",
+		}, nil
+	}
+}
+
+// shouldSkipBecauseOfPendingReviewers skips pull requests that still wait for a requested review,
+// if merge.waitForRequestedReviewers is set.
+func (worker *Worker) shouldSkipBecauseOfPendingReviewers(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if !cfg.WaitForRequestedReviewers || len(details.PendingReviewers) == 0 {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Strs("pending_reviewers", details.PendingReviewers).
+			Msg("waiting for requested reviewers")
+		lines := make([]string, len(details.PendingReviewers))
+		for i, reviewer := range details.PendingReviewers {
+			lines[i] = "- @" + strings.TrimPrefix(reviewer, "team:")
+		}
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonPendingReviewers,
+			Title:      "waiting for requested reviewers",
+			Summary:    "a review is still requested from:\n" + strings.Join(lines, "\n"),
+		}, nil
+	}
+}
+
+// shouldSkipBecauseOfReviewDecision skips pull requests whose review decision is not APPROVED if
+// requireReviewDecisionApproved is set. Pull requests without a review decision are skipped as well.
+func (worker *Worker) shouldSkipBecauseOfReviewDecision(cfg *MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if !cfg.RequireReviewDecisionApproved || details.ReviewDecision == "APPROVED" {
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		logger.Info().
+			Str("review_decision", details.ReviewDecision).
+			Msg("review decision is not approved")
+		decision := details.ReviewDecision
+		if decision == "" {
+			// github has no decision if the branch does not require reviews
+			decision = "none"
+		}
+		return shouldSkipResult{
+			SkipAction: true,
+			SkipReason: SkipReasonReviewDecision,
+			Title:      "review decision is not approved",
+			Summary:    "the review decision of the pull request is " + decision + ", it needs to be APPROVED",
+		}, nil
+	}
+}
+
+// buildAvailableChecksList renders all checks of the pull request as table, checks that match exceptChecks
+// are marked as excluded and checks of ignoreChecksFromApps as ignored app.
+func (worker *Worker) buildAvailableChecksList(details *github.PullRequestDetails, cfg *MergeConfigV1) string {
 	if len(details.CheckStates) == 0 {
 		return ""
 	}
 
 	type check struct {
 		name   string
+		url    string
 		state  string
 		passed string
 	}
 
 	checks := make([]check, 0, len(details.CheckStates))
-	for name, state := range details.CheckStates {
+	for name, checkState := range details.CheckStates {
+		state := checkState.State
 		passed := "✅"
 		if slices.Index(statesThatAreSuccess, state) == -1 {
 			passed = "❌"
 		}
+		if cfg.ExceptChecks.ContainsOneOf(name) != "" {
+			passed = "(excluded)"
+		}
+		if cfg.isFromIgnoredApp(&checkState) {
+			passed = "(ignored app)"
+		}
 		if state == "" {
 			state = "\u200e" // empty char, do not delete
 		}
 		checks = append(checks, check{
 			name:   name,
+			url:    checkState.URL,
 			state:  state,
 			passed: passed,
 		})
@@ -190,7 +538,11 @@ func (worker *Worker) buildAvailableChecksList(details *github.PullRequestDetail
 	sb.WriteString("| ---- | ----- | ---------------------- |\n")
 
 	for _, item := range checks {
-		fmt.Fprintf(&sb, "| `%s` | `%s` | %s |\n", item.name, item.state, item.passed)
+		name := "`" + item.name + "`"
+		if item.url != "" {
+			name = fmt.Sprintf("[%s](%s)", name, item.url)
+		}
+		fmt.Fprintf(&sb, "| %s | `%s` | %s |\n", name, item.state, item.passed)
 	}
 
 	return sb.String()
@@ -198,6 +550,35 @@ func (worker *Worker) buildAvailableChecksList(details *github.PullRequestDetail
 
 func (worker *Worker) shouldSkipBecauseOfChecks(cfg *MergeConfigV1) shouldSkipFunc {
 	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if cfg.usesStatusCheckRollup() && details.StatusCheckRollup == "SUCCESS" {
+			// all checks succeeded, the check states are only needed to describe the checks that did not
+			return shouldSkipResult{SkipAction: false}, nil
+		}
+		// the own check run is not finished while the bot decides, waiting for it would push back forever
+		checkStates := make(map[string]github.CheckState, len(details.CheckStates))
+		for name, state := range details.CheckStates {
+			if cfg.ExceptChecks.ContainsOneOf(name) == "" && !cfg.isFromIgnoredApp(&state) && !worker.isOwnCheckRun(cfg, &state) {
+				checkStates[name] = state
+			}
+		}
+
+		if cfg.WaitForAllCheckSuites {
+			for name, check := range checkStates {
+				if slices.Index(statesThatAreRunning, check.State) == -1 {
+					continue
+				}
+				delay := cfg.CheckRunPollInterval
+				if delay <= 0 {
+					delay = worker.RetryWait
+				}
+				logger.Debug().
+					Str("name", name).
+					Str("state", check.State).
+					Msg("delaying merge, because not all checks are completed")
+				return shouldSkipResult{SkipAction: false}, pushBackError{delay: delay}
+			}
+		}
+
 		if len(cfg.RequiredChecks) == 0 {
 			return shouldSkipResult{
 				SkipAction: false,
@@ -208,17 +589,19 @@ func (worker *Worker) shouldSkipBecauseOfChecks(cfg *MergeConfigV1) shouldSkipFu
 
 		type checkInfo struct {
 			name  string
+			state string
 			check string
 		}
 		var checksNotSucceeded []checkInfo
 		var checksMissing []string
-		for _, re := range cfg.RequiredChecks {
+		for _, re := range cfg.RequiredChecks.Positives() {
 			foundCheck := false
-			for name, state := range details.CheckStates {
-				if !re.Equal(name) {
+			for name, check := range checkStates {
+				if !re.Equal(name) || cfg.RequiredChecks.Excludes(name) {
 					continue
 				}
 				foundCheck = true
+				state := check.State
 				if slices.Index(statesThatAreSuccess, state) == -1 {
 					logger.Info().
 						Str("name", name).
@@ -227,6 +610,7 @@ func (worker *Worker) shouldSkipBecauseOfChecks(cfg *MergeConfigV1) shouldSkipFu
 						Msg("check did not succeed")
 					checksNotSucceeded = append(checksNotSucceeded, checkInfo{
 						name:  name,
+						state: state,
 						check: re.Text,
 					})
 				}
@@ -240,80 +624,181 @@ func (worker *Worker) shouldSkipBecauseOfChecks(cfg *MergeConfigV1) shouldSkipFu
 		}
 
 		if len(checksMissing) > 0 {
+			if result, wait := worker.waitForMissingChecks(cfg, details, checksMissing, len(checksNotSucceeded) != 0); wait > 0 {
+				logger.Debug().
+					Strs("checks", checksMissing).
+					Msg("delaying merge, because checks are missing")
+				return result, pushBackError{delay: wait}
+			}
 			lines := make([]string, len(checksMissing))
 			for i := range checksMissing {
 				lines[i] = fmt.Sprintf("no check matches `%s`", checksMissing[i])
 			}
-			lines = append(lines, "", worker.buildAvailableChecksList(details))
+			lines = append(lines, "", worker.buildAvailableChecksList(details, cfg))
 			return shouldSkipResult{
 				SkipAction: true,
+				SkipReason: SkipReasonMissingChecks,
 				Title:      "check(s) missing",
 				Summary:    strings.Join(lines, "\n"),
 			}, nil
 		}
 
 		if len(checksNotSucceeded) > 0 {
+			reason := SkipReasonPendingChecks
+			running := true
 			lines := make([]string, len(checksNotSucceeded))
 			for i := range checksNotSucceeded {
 				lines[i] = fmt.Sprintf("check `%s` did not succeed (matched by `%s`)", checksNotSucceeded[i].name, checksNotSucceeded[i].check)
+				if slices.Index(statesThatAreRunning, checksNotSucceeded[i].state) == -1 {
+					reason = SkipReasonFailedChecks
+					running = false
+				}
 			}
-			lines = append(lines, "", worker.buildAvailableChecksList(details))
-			return shouldSkipResult{
+			lines = append(lines, "", worker.buildAvailableChecksList(details, cfg))
+			result := shouldSkipResult{
 				SkipAction: true,
+				SkipReason: reason,
 				Title:      "check(s) did not succeeded",
 				Summary:    strings.Join(lines, "\n"),
-			}, nil
+			}
+			if running && worker.shouldRecheck(logger, cfg, details) {
+				// do not depend on the check_run event of the pending checks, it might get lost
+				logger.Debug().Msg("delaying merge, because checks are still pending")
+				result.SkipAction = false
+				result.Title = "waiting for pending check(s)"
+				return result, pushBackError{delay: cfg.RecheckInterval}
+			}
+			return result, nil
 		}
 
-		if diff := time.Until(details.LastCommitTime.Add(worker.DurationBeforeMergeAfterCheck)); diff > 0 {
+		return shouldSkipResult{SkipAction: false}, nil
+	}
+}
+
+// defaultMissingCheckTimeout is used if waitForMissingChecks is enabled and no missingCheckTimeout is set.
+const defaultMissingCheckTimeout = 30 * time.Minute
+
+// waitForMissingChecks returns how long to wait for missing checks to appear, 0 means the missing checks should
+// be reported right away. Missing checks are only awaited with waitForMissingChecks, while no other check
+// did not succeed and until missingCheckTimeout passed since the last commit.
+func (worker *Worker) waitForMissingChecks(
+	cfg *MergeConfigV1,
+	details *github.PullRequestDetails,
+	checksMissing []string,
+	otherChecksNotSucceeded bool,
+) (shouldSkipResult, time.Duration) {
+	if !cfg.WaitForMissingChecks || otherChecksNotSucceeded || details.LastCommitTime.IsZero() {
+		return shouldSkipResult{SkipAction: false}, 0
+	}
+	timeout := cfg.MissingCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultMissingCheckTimeout
+	}
+	deadline := details.LastCommitTime.Add(timeout)
+	remaining := deadline.Sub(worker.timeNow())
+	if remaining <= 0 {
+		return shouldSkipResult{SkipAction: false}, 0
+	}
+	wait := cfg.CheckRunPollInterval
+	if wait <= 0 {
+		wait = worker.RetryWait
+	}
+	if wait <= 0 || wait > remaining {
+		wait = remaining
+	}
+
+	lines := make([]string, len(checksMissing))
+	for i := range checksMissing {
+		lines[i] = fmt.Sprintf("no check matches `%s` yet", checksMissing[i])
+	}
+	lines = append(lines, "", fmt.Sprintf("waiting until %s", deadline.UTC().Format(time.RFC3339)))
+	return shouldSkipResult{
+		SkipAction: false,
+		SkipReason: SkipReasonMissingChecks,
+		Title:      "waiting for missing check(s)",
+		Summary:    strings.Join(lines, "\n"),
+	}, wait
+}
+
+func (worker *Worker) shouldSkipBecauseCommitTooRecent(*MergeConfigV1) shouldSkipFunc {
+	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
+		if result, diff := worker.waitAfterLastCommit(details); diff > 0 {
 			// it's a bit too early. block merging, push back onto the queue
 			logger.Debug().Msg("delaying merge, because commit was too recent")
-			return shouldSkipResult{SkipAction: false}, pushBackError{delay: diff}
+			return result, pushBackError{delay: diff}
 		}
 		return shouldSkipResult{SkipAction: false}, nil
 	}
 }
 
+// waitAfterLastCommit returns how long to wait until DurationBeforeMergeAfterCheck passed since the last commit,
+// and a result that describes the delay.
+func (worker *Worker) waitAfterLastCommit(details *github.PullRequestDetails) (shouldSkipResult, time.Duration) {
+	diff := details.LastCommitTime.Add(worker.DurationBeforeMergeAfterCheck).Sub(worker.timeNow())
+	if diff <= 0 {
+		return shouldSkipResult{SkipAction: false}, 0
+	}
+	return shouldSkipResult{
+		SkipAction: false,
+		Title:      fmt.Sprintf("waiting %s after the last commit before merging", worker.DurationBeforeMergeAfterCheck),
+		Summary: fmt.Sprintf("the last commit is younger than %s (DurationBeforeMergeAfterCheck), trying again in %s",
+			worker.DurationBeforeMergeAfterCheck, diff.Round(time.Second)),
+	}, diff
+}
+
 func (worker *Worker) shouldSkipBecauseOfReviews(cfg *MergeConfigV1) shouldSkipFunc {
 	return func(_ context.Context, logger *zerolog.Logger, details *github.PullRequestDetails) (shouldSkipResult, error) {
-		if cfg.RequiredApprovals > 0 && cfg.RequiredApprovals > len(details.ApprovedBy) {
+		approvedBy := cfg.Approvals(details.ApprovedBy)
+		if cfg.RequiredApprovals > 0 && cfg.RequiredApprovals > len(approvedBy) {
 			logger.Info().
 				Int("required_approvals", cfg.RequiredApprovals).
-				Int("current_approvals", len(details.ApprovedBy)).
+				Int("current_approvals", len(approvedBy)).
+				Int("ignored_approvals", len(details.ApprovedBy)-len(approvedBy)).
 				Msg("missing required approvals")
 
 			return shouldSkipResult{
 				SkipAction: true,
+				SkipReason: SkipReasonMissingApprovals,
 				Title:      "missing required approvals",
-				Summary:    fmt.Sprintf("%d approvals are required, got %d", cfg.RequiredApprovals, len(details.ApprovedBy)),
+				Summary:    fmt.Sprintf("%d approvals are required, got %d", cfg.RequiredApprovals, len(approvedBy)),
 			}, nil
 		}
 
 		if len(cfg.RequireApprovalsFrom) > 0 {
-			var authorsMissing []string
-			for _, re := range cfg.RequireApprovalsFrom {
-				foundAuthor := false
-				for _, name := range details.ApprovedBy {
-					if re.Equal(name) {
-						foundAuthor = true
-						break
-					}
+			var lines []string
+			for i := range cfg.RequireApprovalsFrom {
+				requirement := &cfg.RequireApprovalsFrom[i]
+				if len(requirement.AnyOf.Positives()) == 0 {
+					continue
+				}
+				approvers := requirement.approvers(approvedBy, cfg.RequireApprovalsFrom)
+				if len(approvers) >= requirement.requiredCount() {
+					continue
 				}
-				if !foundAuthor {
+				if requirement.isSimple() {
 					logger.Info().
-						Str("author", re.Text).
+						Str("author", requirement.AnyOf[0].Text).
 						Msg("author did not approve")
-					authorsMissing = append(authorsMissing, re.Text)
+					lines = append(lines, fmt.Sprintf("`%s` didnt approved yet", requirement.AnyOf[0].Text))
+					continue
+				}
+				logger.Info().
+					Strs("authors", requirement.AnyOf.Strings()).
+					Int("required_approvals", requirement.requiredCount()).
+					Strs("approved_by", approvers).
+					Msg("group did not approve")
+				line := fmt.Sprintf("%d approvals required from {%s}, got %d",
+					requirement.requiredCount(), requirement.AnyOf.String(), len(approvers))
+				if len(approvers) > 0 {
+					line += " from " + strings.Join(approvers, ", ")
 				}
+				lines = append(lines, line)
 			}
 
-			if len(authorsMissing) > 0 {
-				lines := make([]string, len(authorsMissing))
-				for i := range authorsMissing {
-					lines[i] = fmt.Sprintf("`%s` didnt approved yet", authorsMissing[i])
-				}
+			if len(lines) > 0 {
 				return shouldSkipResult{
 					SkipAction: true,
+					SkipReason: SkipReasonMissingApprovals,
 					Title:      "approval(s) missing",
 					Summary:    strings.Join(lines, "\n"),
 				}, nil
@@ -329,16 +814,23 @@ func (worker *Worker) shouldSkipBecauseIsNotMergeable(*MergeConfigV1) shouldSkip
 			return shouldSkipResult{SkipAction: false}, nil
 		}
 
-		if diff := time.Until(details.LastCommitTime.Add(worker.DurationBeforeMergeAfterCheck)); diff > 0 {
+		if result, diff := worker.waitAfterLastCommit(details); diff > 0 {
 			// it's a bit too early. block merging, push back onto the queue
 			logger.Debug().Str("merge_state_status", details.MergeStateStatus).
 				Msg("pull request is not mergeable, but the the last commit is too recent, retrying")
-			return shouldSkipResult{SkipAction: false}, pushBackError{delay: diff}
+			return result, pushBackError{delay: diff}
 		}
 		logger.Debug().Str("merge_state_status", details.MergeStateStatus).Msg("pull request not mergeable")
+		reason := SkipReasonNotMergeable
+		switch {
+		case details.MergeStateStatus == "DRAFT":
+			reason = SkipReasonDraft
+		case details.HasConflicts:
+			reason = SkipReasonConflicts
+		}
 		return shouldSkipResult{
 			SkipAction: true,
-			Title:      "not merging",
+			SkipReason: reason,
 			Summary:    fmt.Sprintf("pull request is not mergeable, state is %s", details.MergeStateStatus),
 		}, nil
 	}
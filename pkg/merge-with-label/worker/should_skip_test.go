@@ -2,14 +2,123 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
 )
 
+// approvalsFrom returns a simple requirement for every pattern.
+func approvalsFrom(patterns ...string) ApprovalRequirements {
+	requirements := make(ApprovalRequirements, len(patterns))
+	for i, pattern := range patterns {
+		requirements[i] = ApprovalRequirement{AnyOf: common.RegexSlice{common.MustNewRegexItem(pattern)}}
+	}
+	return requirements
+}
+
+// approvalGroup returns a requirement that count users of the patterns need to approve.
+func approvalGroup(count int, patterns ...string) ApprovalRequirement {
+	requirement := ApprovalRequirement{Count: count}
+	for _, pattern := range patterns {
+		requirement.AnyOf = append(requirement.AnyOf, common.MustNewRegexItem(pattern))
+	}
+	return requirement
+}
+
+func Test_shouldSkipBecauseOfReviews_Groups(t *testing.T) {
+	tests := []struct {
+		name           string
+		requirements   ApprovalRequirements
+		approvedBy     []string
+		wantSkipAction bool
+		wantSummary    string
+	}{
+		{
+			name:           "skip action when the group has too few approvals",
+			requirements:   ApprovalRequirements{approvalGroup(2, "alice", "bob", "carol")},
+			approvedBy:     []string{"alice", "dave"},
+			wantSkipAction: true,
+			wantSummary:    "2 approvals required from {alice, bob, carol}, got 1 from alice",
+		},
+		{
+			name:           "skip action when nobody of the group approved",
+			requirements:   ApprovalRequirements{approvalGroup(1, "alice", "bob")},
+			approvedBy:     []string{"dave"},
+			wantSkipAction: true,
+			wantSummary:    "1 approvals required from {alice, bob}, got 0",
+		},
+		{
+			name:           "dont skip action when the group has enough approvals",
+			requirements:   ApprovalRequirements{approvalGroup(2, "alice", "bob", "carol")},
+			approvedBy:     []string{"carol", "alice"},
+			wantSkipAction: false,
+		},
+		{
+			name:           "duplicate approvals count once",
+			requirements:   ApprovalRequirements{approvalGroup(2, "alice", "bob")},
+			approvedBy:     []string{"alice", "alice"},
+			wantSkipAction: true,
+			wantSummary:    "2 approvals required from {alice, bob}, got 1 from alice",
+		},
+		{
+			name:           "a pattern in a group can match multiple users",
+			requirements:   ApprovalRequirements{approvalGroup(2, "team-.*", "!team-bot")},
+			approvedBy:     []string{"team-bot", "team-alice", "team-bob"},
+			wantSkipAction: false,
+		},
+		{
+			name:           "skip action when the simple requirement of a mixed list is missing",
+			requirements:   append(approvalsFrom("owner"), approvalGroup(2, "alice", "bob", "carol")),
+			approvedBy:     []string{"alice", "bob"},
+			wantSkipAction: true,
+			wantSummary:    "`owner` didnt approved yet",
+		},
+		{
+			name:           "skip action and list all missing requirements of a mixed list",
+			requirements:   append(approvalsFrom("owner"), approvalGroup(2, "alice", "bob", "carol")),
+			approvedBy:     []string{"bob"},
+			wantSkipAction: true,
+			wantSummary:    "`owner` didnt approved yet\n2 approvals required from {alice, bob, carol}, got 1 from bob",
+		},
+		{
+			name:           "dont skip action when all requirements of a mixed list are met",
+			requirements:   append(approvalsFrom("owner"), approvalGroup(2, "alice", "bob", "carol")),
+			approvedBy:     []string{"owner", "bob", "carol"},
+			wantSkipAction: false,
+		},
+		{
+			name:           "negated entries exclude users from groups",
+			requirements:   append(approvalsFrom("!bob"), approvalGroup(2, "alice", "bob", "carol")),
+			approvedBy:     []string{"alice", "bob"},
+			wantSkipAction: true,
+			wantSummary:    "2 approvals required from {alice, bob, carol}, got 1 from alice",
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &MergeConfigV1{RequireApprovalsFrom: tt.requirements}
+			got, err := worker.shouldSkipBecauseOfReviews(cfg)(context.Background(), &log.Logger, &github.PullRequestDetails{ApprovedBy: tt.approvedBy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfReviews() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.Summary != tt.wantSummary {
+				t.Errorf("Summary = %q, want %q", got.Summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
 func Test_shouldSkipBecauseOfReviews(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -34,28 +143,28 @@ func Test_shouldSkipBecauseOfReviews(t *testing.T) {
 		},
 		{
 			name:           "skip action when no review is present and 1 is required by a specific reviewer",
-			cfg:            &MergeConfigV1{RequireApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("owner")}},
+			cfg:            &MergeConfigV1{RequireApprovalsFrom: approvalsFrom("owner")},
 			details:        &github.PullRequestDetails{},
 			wantSkipAction: true,
 			wantErr:        false,
 		},
 		{
 			name:           "skip action when one review is present, but its not from the required reviewer",
-			cfg:            &MergeConfigV1{RequireApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("owner")}},
+			cfg:            &MergeConfigV1{RequireApprovalsFrom: approvalsFrom("owner")},
 			details:        &github.PullRequestDetails{ApprovedBy: []string{"user"}},
 			wantSkipAction: true,
 			wantErr:        false,
 		},
 		{
 			name:           "skip action when one review is present and required, but its not from the required reviewer",
-			cfg:            &MergeConfigV1{RequiredApprovals: 1, RequireApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("owner")}},
+			cfg:            &MergeConfigV1{RequiredApprovals: 1, RequireApprovalsFrom: approvalsFrom("owner")},
 			details:        &github.PullRequestDetails{ApprovedBy: []string{"user"}},
 			wantSkipAction: true,
 			wantErr:        false,
 		},
 		{
 			name:           "skip action when one review is present, but two are required by specific users",
-			cfg:            &MergeConfigV1{RequireApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("contributor"), common.MustNewRegexItem("owner")}},
+			cfg:            &MergeConfigV1{RequireApprovalsFrom: approvalsFrom("contributor", "owner")},
 			details:        &github.PullRequestDetails{ApprovedBy: []string{"contributor"}},
 			wantSkipAction: true,
 			wantErr:        false,
@@ -77,18 +186,53 @@ func Test_shouldSkipBecauseOfReviews(t *testing.T) {
 		},
 		{
 			name:           "dont skip action when all of the required reviewers reviewed",
-			cfg:            &MergeConfigV1{RequireApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("contributor"), common.MustNewRegexItem("owner")}},
+			cfg:            &MergeConfigV1{RequireApprovalsFrom: approvalsFrom("contributor", "owner")},
 			details:        &github.PullRequestDetails{ApprovedBy: []string{"owner", "contributor"}},
 			wantSkipAction: false,
 			wantErr:        false,
 		},
 		{
 			name:           "dont skip action when all of the required reviewers reviewed and the amount of reviewers is also met",
-			cfg:            &MergeConfigV1{RequiredApprovals: 2, RequireApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("owner")}},
+			cfg:            &MergeConfigV1{RequiredApprovals: 2, RequireApprovalsFrom: approvalsFrom("owner")},
 			details:        &github.PullRequestDetails{ApprovedBy: []string{"owner", "contributor"}},
 			wantSkipAction: false,
 			wantErr:        false,
 		},
+		{
+			name:           "skip action when the only approval is from an ignored bot",
+			cfg:            &MergeConfigV1{RequiredApprovals: 1, IgnoreApprovalsFrom: DefaultIgnoreApprovalsFrom},
+			details:        &github.PullRequestDetails{ApprovedBy: []string{"github-actions[bot]"}},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "skip action when the required reviewer is an ignored bot",
+			cfg:            &MergeConfigV1{RequireApprovalsFrom: approvalsFrom("approver"), IgnoreApprovalsFrom: DefaultIgnoreApprovalsFrom},
+			details:        &github.PullRequestDetails{ApprovedBy: []string{"auto-approver[bot]"}},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "skip action when approvals from ignored users are not enough",
+			cfg:            &MergeConfigV1{RequiredApprovals: 2, IgnoreApprovalsFrom: common.RegexSlice{common.MustNewRegexItem("= machine-user")}},
+			details:        &github.PullRequestDetails{ApprovedBy: []string{"machine-user", "user"}},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when bot approvals are not ignored",
+			cfg:            &MergeConfigV1{RequiredApprovals: 1, IgnoreApprovalsFrom: common.RegexSlice{}},
+			details:        &github.PullRequestDetails{ApprovedBy: []string{"github-actions[bot]"}},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when enough humans approved besides a bot",
+			cfg:            &MergeConfigV1{RequiredApprovals: 1, IgnoreApprovalsFrom: DefaultIgnoreApprovalsFrom},
+			details:        &github.PullRequestDetails{ApprovedBy: []string{"github-actions[bot]", "user"}},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
 	}
 	worker := Worker{}
 	for _, tt := range tests {
@@ -116,31 +260,127 @@ func Test_shouldSkipBecauseOfChecks(t *testing.T) {
 		{
 			name:           "skip action when no check is present and 1 is required by a specific reviewer",
 			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check1")}},
-			details:        &github.PullRequestDetails{CheckStates: map[string]string{}},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{})},
 			wantSkipAction: true,
 			wantErr:        false,
 		},
 		{
 			name:           "skip action when one check is present, but it is not SUCCESS",
 			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check1")}},
-			details:        &github.PullRequestDetails{CheckStates: map[string]string{"check1": "FAILED"}},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "FAILED"})},
 			wantSkipAction: true,
 			wantErr:        false,
 		},
 		{
 			name:           "dont skip action when no required checks are defined",
 			cfg:            &MergeConfigV1{},
-			details:        &github.PullRequestDetails{CheckStates: map[string]string{"check1": "SUCCESS"}},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "SUCCESS"})},
 			wantSkipAction: false,
 			wantErr:        false,
 		},
 		{
 			name:           "dont skip action when all checks are present and they are either SUCCESS, NEUTRAL or (empty)",
 			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check1"), common.MustNewRegexItem("check2"), common.MustNewRegexItem("check3")}},
-			details:        &github.PullRequestDetails{CheckStates: map[string]string{"check1": "SUCCESS", "check2": "NEUTRAL", "check3": ""}},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "SUCCESS", "check2": "NEUTRAL", "check3": ""})},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name: "dont skip action when a failed check is excepted",
+			cfg: &MergeConfigV1{
+				RequiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+				ExceptChecks:   common.RegexSlice{common.MustNewRegexItem("license"), common.MustNewRegexItem("coverage")},
+			},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"build": "SUCCESS", "license/cla": "FAILURE", "coverage-delta": "FAILURE"})},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name: "skip action when a failed check is not excepted",
+			cfg: &MergeConfigV1{
+				RequiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+				ExceptChecks:   common.RegexSlice{common.MustNewRegexItem("coverage")},
+			},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"build": "FAILURE", "coverage-delta": "FAILURE"})},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name: "skip action when the only check matching a required check is excepted",
+			cfg: &MergeConfigV1{
+				RequiredChecks: common.RegexSlice{common.MustNewRegexItem("build"), common.MustNewRegexItem("coverage")},
+				ExceptChecks:   common.RegexSlice{common.MustNewRegexItem("coverage")},
+			},
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"build": "SUCCESS", "coverage-delta": "SUCCESS"})},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name: "dont skip action when the failed checks belong to an ignored app",
+			cfg: &MergeConfigV1{
+				RequiredChecks:       common.RegexSlice{common.MustNewRegexItem(".*")},
+				IgnoreChecksFromApps: common.RegexSlice{common.MustNewRegexItem("Codecov")},
+			},
+			details: &github.PullRequestDetails{
+				StatusCheckRollup: "FAILURE",
+				CheckStates: map[string]github.CheckState{
+					"build":         {Name: "build", State: "SUCCESS"},
+					"Codecov":       {Name: "Codecov", State: "FAILURE", App: "Codecov"},
+					"Codecov/patch": {Name: "Codecov/patch", State: "FAILURE", App: "Codecov"},
+				},
+			},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name: "skip action when a failed status has the name of an ignored app",
+			cfg: &MergeConfigV1{
+				RequiredChecks:       common.RegexSlice{common.MustNewRegexItem(".*")},
+				IgnoreChecksFromApps: common.RegexSlice{common.MustNewRegexItem("Codecov")},
+			},
+			details: &github.PullRequestDetails{
+				CheckStates: map[string]github.CheckState{
+					"Codecov": {Name: "Codecov", State: "FAILURE"},
+				},
+			},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name: "skip action when the only check matching a required check belongs to an ignored app",
+			cfg: &MergeConfigV1{
+				RequiredChecks:       common.RegexSlice{common.MustNewRegexItem("Preview/deploy")},
+				IgnoreChecksFromApps: common.RegexSlice{common.MustNewRegexItem("Preview")},
+			},
+			details: &github.PullRequestDetails{
+				CheckStates: map[string]github.CheckState{
+					"Preview/deploy": {Name: "Preview/deploy", State: "SUCCESS", App: "Preview"},
+				},
+			},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when the status check rollup succeeded for the default required checks",
+			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")}},
+			details:        &github.PullRequestDetails{StatusCheckRollup: "SUCCESS"},
 			wantSkipAction: false,
 			wantErr:        false,
 		},
+		{
+			name:           "skip action when the status check rollup failed for the default required checks",
+			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")}},
+			details:        &github.PullRequestDetails{StatusCheckRollup: "FAILURE", CheckStates: checkStatesOf(map[string]string{"build": "FAILURE"})},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "skip action when the status check rollup succeeded but a custom required check is missing",
+			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("build")}},
+			details:        &github.PullRequestDetails{StatusCheckRollup: "SUCCESS", CheckStates: checkStatesOf(map[string]string{"test": "SUCCESS"})},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
 	}
 	worker := Worker{}
 	for _, tt := range tests {
@@ -157,6 +397,349 @@ func Test_shouldSkipBecauseOfChecks(t *testing.T) {
 	}
 }
 
+func Test_shouldSkipBecauseOfChecks_RollupAgreesWithCheckStates(t *testing.T) {
+	tests := []struct {
+		name   string
+		rollup string
+		checks map[string]string
+	}{
+		{name: "succeeded", rollup: "SUCCESS", checks: map[string]string{"build": "SUCCESS", "lint": "NEUTRAL"}},
+		{name: "skipped", rollup: "SUCCESS", checks: map[string]string{"build": "SUCCESS", "lint": "SKIPPED"}},
+		{name: "failed", rollup: "FAILURE", checks: map[string]string{"build": "SUCCESS", "lint": "FAILURE"}},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the rollup is only used for the default required checks, the same checks listed by name are decided
+			// by their check states
+			rollupCfg := &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")}}
+			detailedCfg := &MergeConfigV1{RequiredChecks: common.RegexSlice{
+				common.MustNewRegexItem("build"),
+				common.MustNewRegexItem("lint"),
+			}}
+			details := &github.PullRequestDetails{StatusCheckRollup: tt.rollup, CheckStates: checkStatesOf(tt.checks)}
+
+			fromRollup, err := worker.shouldSkipBecauseOfChecks(rollupCfg)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfChecks() error = %v", err)
+			}
+			fromCheckStates, err := worker.shouldSkipBecauseOfChecks(detailedCfg)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfChecks() error = %v", err)
+			}
+			if want := tt.rollup != "SUCCESS"; fromRollup.SkipAction != want || fromCheckStates.SkipAction != want {
+				t.Errorf("SkipAction from rollup = %v, from check states = %v, want %v",
+					fromRollup.SkipAction, fromCheckStates.SkipAction, want)
+			}
+		})
+	}
+}
+
+// ownCheckRun returns the state of a check run with the name of the merge-with-label app.
+func ownCheckRun(name, state string) github.CheckState {
+	return github.CheckState{
+		Name:   "merge-with-label/" + name,
+		State:  state,
+		Source: github.CheckStateSourceCheckRun,
+		App:    "merge-with-label",
+	}
+}
+
+func Test_shouldSkipBecauseOfChecks_WaitForAllCheckSuites(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *MergeConfigV1
+		details   *github.PullRequestDetails
+		wantDelay time.Duration
+	}{
+		{
+			name: "push back when a not required check is still running",
+			cfg: &MergeConfigV1{
+				RequiredChecks:        common.RegexSlice{common.MustNewRegexItem("check1")},
+				WaitForAllCheckSuites: true,
+				CheckRunPollInterval:  time.Minute,
+			},
+			details:   &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "SUCCESS", "check2": "IN_PROGRESS"})},
+			wantDelay: time.Minute,
+		},
+		{
+			name: "push back with the retry wait when no poll interval is set",
+			cfg: &MergeConfigV1{
+				WaitForAllCheckSuites: true,
+			},
+			details:   &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "QUEUED"})},
+			wantDelay: time.Second,
+		},
+		{
+			name: "dont push back when all checks are completed",
+			cfg: &MergeConfigV1{
+				WaitForAllCheckSuites: true,
+			},
+			details:   &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "SUCCESS", "check2": "FAILURE"})},
+			wantDelay: 0,
+		},
+		{
+			name: "dont push back when the running check is excepted",
+			cfg: &MergeConfigV1{
+				WaitForAllCheckSuites: true,
+				ExceptChecks:          common.RegexSlice{common.MustNewRegexItem("coverage")},
+			},
+			details:   &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "SUCCESS", "coverage": "IN_PROGRESS"})},
+			wantDelay: 0,
+		},
+		{
+			name: "dont push back when running checks are not awaited",
+			cfg: &MergeConfigV1{
+				RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check1")},
+			},
+			details:   &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "SUCCESS", "check2": "PENDING"})},
+			wantDelay: 0,
+		},
+		{
+			name: "dont push back for the own check run",
+			cfg: &MergeConfigV1{
+				RequiredChecks:        common.RegexSlice{common.MustNewRegexItem(".*")},
+				WaitForAllCheckSuites: true,
+			},
+			details: &github.PullRequestDetails{CheckStates: map[string]github.CheckState{
+				"check1":                            {Name: "check1", State: "SUCCESS"},
+				"merge-with-label/merge-with-label": ownCheckRun("merge-with-label", "PENDING"),
+			}},
+			wantDelay: 0,
+		},
+		{
+			name: "dont push back for the own check run with merge.checkRunName",
+			cfg: &MergeConfigV1{
+				RequiredChecks:        common.RegexSlice{common.MustNewRegexItem(".*")},
+				WaitForAllCheckSuites: true,
+				CheckRunName:          "staging",
+			},
+			details: &github.PullRequestDetails{CheckStates: map[string]github.CheckState{
+				"check1":                   {Name: "check1", State: "SUCCESS"},
+				"merge-with-label/staging": ownCheckRun("staging", "PENDING"),
+			}},
+			wantDelay: 0,
+		},
+		{
+			name: "push back for the check run of another instance",
+			cfg: &MergeConfigV1{
+				WaitForAllCheckSuites: true,
+				CheckRunName:          "staging",
+			},
+			details: &github.PullRequestDetails{CheckStates: map[string]github.CheckState{
+				"merge-with-label/merge-with-label": ownCheckRun("merge-with-label", "PENDING"),
+			}},
+			wantDelay: time.Second,
+		},
+	}
+	worker := Worker{RetryWait: time.Second, BotName: "merge-with-label"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := worker.shouldSkipBecauseOfChecks(tt.cfg)(context.Background(), &log.Logger, tt.details)
+			if got.SkipAction {
+				t.Errorf("shouldSkipBecauseOfChecks() got = %v, want no skip action", got)
+			}
+			var pbErr pushBackError
+			if tt.wantDelay == 0 {
+				if err != nil {
+					t.Errorf("shouldSkipBecauseOfChecks() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.As(err, &pbErr) {
+				t.Fatalf("shouldSkipBecauseOfChecks() error = %v, want pushBackError", err)
+			}
+			if pbErr.delay != tt.wantDelay {
+				t.Errorf("shouldSkipBecauseOfChecks() delay = %v, want %v", pbErr.delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfChecks_WaitForMissingChecks(t *testing.T) {
+	lastCommit := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cfg := &MergeConfigV1{
+		RequiredChecks:       common.RegexSlice{common.MustNewRegexItem("build")},
+		WaitForMissingChecks: true,
+		MissingCheckTimeout:  10 * time.Minute,
+		CheckRunPollInterval: time.Minute,
+	}
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		since          time.Duration
+		checkStates    map[string]string
+		wantDelay      time.Duration
+		wantSkipReason SkipReason
+	}{
+		{name: "push back right after the commit", cfg: cfg, since: 0, wantDelay: time.Minute},
+		{name: "push back until the timeout", cfg: cfg, since: 9*time.Minute + 30*time.Second, wantDelay: 30 * time.Second},
+		{name: "skip after the timeout", cfg: cfg, since: 10 * time.Minute, wantSkipReason: SkipReasonMissingChecks},
+		{
+			name:           "skip when another check failed",
+			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("build"), common.MustNewRegexItem("lint")}, WaitForMissingChecks: true},
+			checkStates:    map[string]string{"lint": "FAILURE"},
+			wantSkipReason: SkipReasonMissingChecks,
+		},
+		{
+			name:           "skip when disabled",
+			cfg:            &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("build")}},
+			wantSkipReason: SkipReasonMissingChecks,
+		},
+		{
+			name:      "default timeout",
+			cfg:       &MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("build")}, WaitForMissingChecks: true},
+			since:     29 * time.Minute,
+			wantDelay: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := lastCommit.Add(tt.since)
+			worker := Worker{RetryWait: time.Second, now: func() time.Time { return now }}
+			details := &github.PullRequestDetails{LastCommitTime: lastCommit, CheckStates: checkStatesOf(tt.checkStates)}
+			got, err := worker.shouldSkipBecauseOfChecks(tt.cfg)(context.Background(), &log.Logger, details)
+			if tt.wantDelay == 0 {
+				if err != nil {
+					t.Fatalf("shouldSkipBecauseOfChecks() error = %v, want nil", err)
+				}
+				if !got.SkipAction || got.SkipReason != tt.wantSkipReason {
+					t.Errorf("shouldSkipBecauseOfChecks() = %+v, want skip with reason %s", got, tt.wantSkipReason)
+				}
+				return
+			}
+			var pbErr pushBackError
+			if !errors.As(err, &pbErr) {
+				t.Fatalf("shouldSkipBecauseOfChecks() error = %v, want pushBackError", err)
+			}
+			if pbErr.delay != tt.wantDelay {
+				t.Errorf("shouldSkipBecauseOfChecks() delay = %v, want %v", pbErr.delay, tt.wantDelay)
+			}
+			if got.SkipAction || !strings.Contains(got.Summary, "no check matches `build` yet") {
+				t.Errorf("shouldSkipBecauseOfChecks() = %+v, want the missing check in the summary", got)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfChecks_ExceptChecksSummary(t *testing.T) {
+	cfg := &MergeConfigV1{
+		RequiredChecks: common.RegexSlice{common.MustNewRegexItem(".*")},
+		ExceptChecks:   common.RegexSlice{common.MustNewRegexItem("coverage")},
+	}
+	details := &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"build": "FAILURE", "coverage": "FAILURE"})}
+	worker := Worker{}
+	got, err := worker.shouldSkipBecauseOfChecks(cfg)(context.Background(), &log.Logger, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.Summary, "| `coverage` | `FAILURE` | (excluded) |") {
+		t.Errorf("summary = %q, want coverage to be marked as excluded", got.Summary)
+	}
+	if !strings.Contains(got.Summary, "| `build` | `FAILURE` | ❌ |") {
+		t.Errorf("summary = %q, want build to be listed as failed", got.Summary)
+	}
+	if strings.Contains(got.Summary, "check `coverage` did not succeed") {
+		t.Errorf("summary = %q, excepted check should not block", got.Summary)
+	}
+}
+
+// Test_shouldSkipBecauseCommitTooRecent pins that the delay after the last commit is controlled by
+// DurationBeforeMergeAfterCheck, DurationToWaitAfterUpdateBranch is only used after updating a pull request.
+func Test_shouldSkipBecauseCommitTooRecent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	worker := Worker{
+		DurationBeforeMergeAfterCheck:   time.Minute,
+		DurationToWaitAfterUpdateBranch: time.Hour,
+		now:                             func() time.Time { return now },
+	}
+	tests := []struct {
+		name      string
+		fn        shouldSkipFunc
+		details   *github.PullRequestDetails
+		wantDelay time.Duration
+	}{
+		{
+			name:      "commit is too recent",
+			fn:        worker.shouldSkipBecauseCommitTooRecent(&MergeConfigV1{}),
+			details:   &github.PullRequestDetails{LastCommitTime: now.Add(-time.Second * 20)},
+			wantDelay: time.Second * 40,
+		},
+		{
+			name:      "commit is exactly old enough",
+			fn:        worker.shouldSkipBecauseCommitTooRecent(&MergeConfigV1{}),
+			details:   &github.PullRequestDetails{LastCommitTime: now.Add(-time.Minute)},
+			wantDelay: 0,
+		},
+		{
+			name:      "commit is old enough",
+			fn:        worker.shouldSkipBecauseCommitTooRecent(&MergeConfigV1{}),
+			details:   &github.PullRequestDetails{LastCommitTime: now.Add(-time.Hour)},
+			wantDelay: 0,
+		},
+		{
+			name: "checks do not wait for recent commits",
+			fn:   worker.shouldSkipBecauseOfChecks(&MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check1")}}),
+			details: &github.PullRequestDetails{
+				CheckStates:    checkStatesOf(map[string]string{"check1": "SUCCESS"}),
+				LastCommitTime: now,
+			},
+			wantDelay: 0,
+		},
+		{
+			name:      "not mergeable but commit is too recent",
+			fn:        worker.shouldSkipBecauseIsNotMergeable(&MergeConfigV1{}),
+			details:   &github.PullRequestDetails{LastCommitTime: now.Add(-time.Second * 50)},
+			wantDelay: time.Second * 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn(context.Background(), &log.Logger, tt.details)
+			if got.SkipAction {
+				t.Errorf("got = %v, want no skip action", got)
+			}
+			if tt.wantDelay == 0 {
+				if err != nil {
+					t.Errorf("error = %v, want nil", err)
+				}
+				return
+			}
+			var pbErr pushBackError
+			if !errors.As(err, &pbErr) {
+				t.Fatalf("error = %v, want pushBackError", err)
+			}
+			if pbErr.delay != tt.wantDelay {
+				t.Errorf("delay = %v, want %v", pbErr.delay, tt.wantDelay)
+			}
+			if want := "waiting 1m0s after the last commit before merging"; got.Title != want {
+				t.Errorf("title = %q, want %q", got.Title, want)
+			}
+			if !strings.Contains(got.Summary, "DurationBeforeMergeAfterCheck") {
+				t.Errorf("summary = %q, want it to mention DurationBeforeMergeAfterCheck", got.Summary)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipMerge_CommitTooRecentWithoutRequiredChecks(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	worker := Worker{
+		DurationBeforeMergeAfterCheck: time.Minute,
+		now:                           func() time.Time { return now },
+	}
+	cfg := &ConfigV1{}
+	details := &github.PullRequestDetails{IsMergeable: true, LastCommitTime: now}
+	_, err := worker.shouldSkipMerge(context.Background(), &log.Logger, cfg, "", details)
+	var pbErr pushBackError
+	if !errors.As(err, &pbErr) {
+		t.Fatalf("shouldSkipMerge() error = %v, want pushBackError", err)
+	}
+	if pbErr.delay != time.Minute {
+		t.Errorf("delay = %v, want %v", pbErr.delay, time.Minute)
+	}
+}
+
 func Test_shouldSkipBecauseOfLabel(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -186,6 +769,20 @@ func Test_shouldSkipBecauseOfLabel(t *testing.T) {
 			wantSkipAction: true,
 			wantErr:        false,
 		},
+		{
+			name:           "skip action when no-merge label is present and configured using regex, but it is uppercase",
+			cfg:            &IgnoreConfig{ignoreWithLabels: common.RegexSlice{common.MustNewRegexItem("no-merge-.+")}},
+			details:        &github.PullRequestDetails{Labels: []string{"NO-MERGE-YET"}},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when uppercase label is present, but the regex is case-sensitive",
+			cfg:            &IgnoreConfig{ignoreWithLabels: common.RegexSlice{common.MustNewRegexItem("(?-i)no-merge-.+")}},
+			details:        &github.PullRequestDetails{Labels: []string{"NO-MERGE-YET"}},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
 		{
 			name:           "skip action when no-merge label is present and a slice is configured",
 			cfg:            &IgnoreConfig{ignoreWithLabels: common.RegexSlice{common.MustNewRegexItem("never-merge"), common.MustNewRegexItem("no-merge")}},
@@ -231,6 +828,7 @@ func Test_shouldSkipBecauseOfLabel(t *testing.T) {
 }
 
 func Test_shouldSkipBecauseOfHistory(t *testing.T) {
+	linearHistoryRequired, linearHistoryNotRequired := true, false
 	tests := []struct {
 		name           string
 		cfg            *MergeConfigV1
@@ -266,6 +864,43 @@ func Test_shouldSkipBecauseOfHistory(t *testing.T) {
 			wantSkipAction: false,
 			wantErr:        false,
 		},
+		{
+			name: "dont skip action when a label override does not require linear history",
+			cfg: &MergeConfigV1{
+				RequireLinearHistory: true,
+				OverridesByLabel: []LabelOverrideRule{
+					{Label: common.MustNewRegexItem("hotfix"), RequireLinearHistory: &linearHistoryNotRequired},
+				},
+			},
+			details:        &github.PullRequestDetails{AheadBy: 1, Labels: []string{"merge", "hotfix"}},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name: "skip action when a label override requires linear history",
+			cfg: &MergeConfigV1{
+				RequireLinearHistory: false,
+				OverridesByLabel: []LabelOverrideRule{
+					{Label: common.MustNewRegexItem("hotfix"), RequireLinearHistory: &linearHistoryNotRequired},
+					{Label: common.MustNewRegexItem("merge"), RequireLinearHistory: &linearHistoryRequired},
+				},
+			},
+			details:        &github.PullRequestDetails{AheadBy: 1, Labels: []string{"merge"}},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name: "skip action when the matching label override does not set linear history",
+			cfg: &MergeConfigV1{
+				RequireLinearHistory: true,
+				OverridesByLabel: []LabelOverrideRule{
+					{Label: common.MustNewRegexItem("hotfix")},
+				},
+			},
+			details:        &github.PullRequestDetails{AheadBy: 1, Labels: []string{"hotfix"}},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
 	}
 	worker := Worker{}
 	for _, tt := range tests {
@@ -311,6 +946,13 @@ func Test_shouldSkipBecauseOfTitle(t *testing.T) {
 			wantSkipAction: true,
 			wantErr:        false,
 		},
+		{
+			name:           "skip action when no-merge title is present and configured using regex, but it is uppercase",
+			cfg:            &IgnoreConfig{IgnoreWithTitles: common.RegexSlice{common.MustNewRegexItem("no-merge-.+")}},
+			details:        &github.PullRequestDetails{Title: "NO-MERGE-YET"},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
 		{
 			name:           "skip action when no-merge title is present and a slice is configured",
 			cfg:            &IgnoreConfig{IgnoreWithTitles: common.RegexSlice{common.MustNewRegexItem("never-merge"), common.MustNewRegexItem("no-merge")}},
@@ -413,3 +1055,965 @@ func Test_shouldSkipBecauseOfAuthorName(t *testing.T) {
 		})
 	}
 }
+
+func Test_shouldSkipBecauseOfCommitCount(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		details        *github.PullRequestDetails
+		wantSkipAction bool
+		wantErr        bool
+	}{
+		{
+			name:           "skip action when pull request has more commits than allowed",
+			cfg:            &MergeConfigV1{MaxCommitCount: 2},
+			details:        &github.PullRequestDetails{CommitCount: 3},
+			wantSkipAction: true,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when pull request has exactly the allowed commits",
+			cfg:            &MergeConfigV1{MaxCommitCount: 2},
+			details:        &github.PullRequestDetails{CommitCount: 2},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when pull request has less commits than allowed",
+			cfg:            &MergeConfigV1{MaxCommitCount: 2},
+			details:        &github.PullRequestDetails{CommitCount: 1},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+		{
+			name:           "dont skip action when max commit count is not configured",
+			cfg:            &MergeConfigV1{},
+			details:        &github.PullRequestDetails{CommitCount: 100},
+			wantSkipAction: false,
+			wantErr:        false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := worker.shouldSkipBecauseOfCommitCount(tt.cfg)(context.Background(), &log.Logger, tt.details)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("shouldSkipBecauseOfCommitCount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfCommitCount() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfEmptyBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		details        *github.PullRequestDetails
+		wantSkipAction bool
+	}{
+		{
+			name:           "dont skip action when the pull request has a description",
+			cfg:            &MergeConfigV1{RequirePRBodyNotEmpty: true},
+			details:        &github.PullRequestDetails{Body: "fixes #1"},
+			wantSkipAction: false,
+		},
+		{
+			name:           "skip action when the description is empty",
+			cfg:            &MergeConfigV1{RequirePRBodyNotEmpty: true},
+			details:        &github.PullRequestDetails{Body: ""},
+			wantSkipAction: true,
+		},
+		{
+			name:           "skip action when the description only contains whitespace",
+			cfg:            &MergeConfigV1{RequirePRBodyNotEmpty: true},
+			details:        &github.PullRequestDetails{Body: " \r\n\t"},
+			wantSkipAction: true,
+		},
+		{
+			name:           "dont skip action when a description is not required",
+			cfg:            &MergeConfigV1{},
+			details:        &github.PullRequestDetails{Body: ""},
+			wantSkipAction: false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := worker.shouldSkipBecauseOfEmptyBody(tt.cfg)(context.Background(), &log.Logger, tt.details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfEmptyBody() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfEmptyBody() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.SkipAction && got.SkipReason != SkipReasonEmptyBody {
+				t.Errorf("SkipReason = %v, want %v", got.SkipReason, SkipReasonEmptyBody)
+			}
+		})
+	}
+
+	t.Run("summary links to the pull request", func(t *testing.T) {
+		got, err := worker.shouldSkipBecauseOfEmptyBody(&MergeConfigV1{RequirePRBodyNotEmpty: true})(
+			context.Background(), &log.Logger, &github.PullRequestDetails{URL: "https://github.com/owner/repo/pull/1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got.Summary, "(https://github.com/owner/repo/pull/1)") {
+			t.Errorf("Summary = %q, want a link to the pull request", got.Summary)
+		}
+	})
+}
+
+func TestWorker_buildAvailableChecksList(t *testing.T) {
+	details := &github.PullRequestDetails{
+		CheckStates: map[string]github.CheckState{
+			"build": {Name: "build", State: "FAILURE", URL: "https://ci.example.com/1"},
+			"lint":  {Name: "lint", State: "SUCCESS"},
+		},
+	}
+	worker := Worker{}
+	got := worker.buildAvailableChecksList(details, &MergeConfigV1{})
+	for _, want := range []string{
+		"| [`build`](https://ci.example.com/1) | `FAILURE` | ❌ |",
+		"| `lint` | `SUCCESS` | ✅ |",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("buildAvailableChecksList() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestWorker_buildAvailableChecksList_IgnoredApps(t *testing.T) {
+	details := &github.PullRequestDetails{
+		CheckStates: map[string]github.CheckState{
+			"Codecov":         {Name: "Codecov", State: "FAILURE", App: "Codecov"},
+			"Codecov/patch":   {Name: "Codecov/patch", State: "FAILURE", App: "Codecov"},
+			"GitHub Actions":  {Name: "GitHub Actions", State: "SUCCESS", App: "GitHub Actions"},
+			"codecov/project": {Name: "codecov/project", State: "FAILURE"},
+		},
+	}
+	worker := Worker{}
+	got := worker.buildAvailableChecksList(details, &MergeConfigV1{
+		IgnoreChecksFromApps: common.RegexSlice{common.MustNewRegexItem("(?i)codecov")},
+	})
+	for _, want := range []string{
+		"| `Codecov` | `FAILURE` | (ignored app) |",
+		"| `Codecov/patch` | `FAILURE` | (ignored app) |",
+		"| `GitHub Actions` | `SUCCESS` | ✅ |",
+		// statuses do not belong to an app
+		"| `codecov/project` | `FAILURE` | ❌ |",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("buildAvailableChecksList() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func Test_shouldSkipBecauseOfBaseBranch(t *testing.T) {
+	tests := []struct {
+		name              string
+		onlyDefaultBranch bool
+		defaultBranch     string
+		baseBranch        string
+		wantSkipAction    bool
+	}{
+		{
+			name:              "dont skip action when the pull request targets the default branch",
+			onlyDefaultBranch: true,
+			defaultBranch:     "main",
+			baseBranch:        "main",
+			wantSkipAction:    false,
+		},
+		{
+			name:              "skip action when the pull request targets another branch",
+			onlyDefaultBranch: true,
+			defaultBranch:     "main",
+			baseBranch:        "release/1.x",
+			wantSkipAction:    true,
+		},
+		{
+			name:              "dont skip action when the default branch is not known",
+			onlyDefaultBranch: true,
+			baseBranch:        "release/1.x",
+			wantSkipAction:    false,
+		},
+		{
+			name:           "dont skip action when it is disabled",
+			defaultBranch:  "main",
+			baseBranch:     "release/1.x",
+			wantSkipAction: false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := &github.PullRequestDetails{BaseRefName: tt.baseBranch}
+			got, err := worker.shouldSkipBecauseOfBaseBranch(tt.onlyDefaultBranch, tt.defaultBranch)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfBaseBranch() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfBaseBranch() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if !got.SkipAction {
+				return
+			}
+			if got.SkipReason != SkipReasonNotDefaultBranch {
+				t.Errorf("SkipReason = %v, want %v", got.SkipReason, SkipReasonNotDefaultBranch)
+			}
+			if !strings.Contains(got.Summary, "`release/1.x`") || !strings.Contains(got.Summary, "`main`") {
+				t.Errorf("Summary = %q, want both branches", got.Summary)
+			}
+		})
+	}
+}
+
+func Test_shouldSkip_OnlyDefaultBranch(t *testing.T) {
+	cfg := &ConfigV1{
+		Merge:  MergeConfigV1{OnlyDefaultBranch: true},
+		Update: UpdateConfigV1{OnlyDefaultBranch: true},
+	}
+	details := &github.PullRequestDetails{BaseRefName: "release/1.x"}
+	worker := Worker{}
+
+	mergeResult, err := worker.shouldSkipMerge(context.Background(), &log.Logger, cfg, "main", details)
+	if err != nil {
+		t.Fatalf("shouldSkipMerge() error = %v", err)
+	}
+	if !mergeResult.SkipAction || mergeResult.Title != "not merging: pull request does not target the default branch" {
+		t.Errorf("shouldSkipMerge() = %+v, want skip with reason %s", mergeResult, SkipReasonNotDefaultBranch)
+	}
+
+	updateResult, err := worker.shouldSkipUpdate(context.Background(), &log.Logger, cfg, "main", details)
+	if err != nil {
+		t.Fatalf("shouldSkipUpdate() error = %v", err)
+	}
+	if !updateResult.SkipAction || updateResult.Title != "not updating: pull request does not target the default branch" {
+		t.Errorf("shouldSkipUpdate() = %+v, want skip with reason %s", updateResult, SkipReasonNotDefaultBranch)
+	}
+}
+
+func Test_shouldSkipBecauseOfNativeAutoMerge(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		autoMerge      *github.AutoMerge
+		wantSkipAction bool
+	}{
+		{
+			name:           "dont skip action when auto-merge is not enabled",
+			cfg:            &MergeConfigV1{},
+			wantSkipAction: false,
+		},
+		{
+			name:           "skip action when auto-merge is enabled",
+			cfg:            &MergeConfigV1{},
+			autoMerge:      &github.AutoMerge{EnabledBy: "octocat", MergeMethod: "SQUASH"},
+			wantSkipAction: true,
+		},
+		{
+			name:           "dont skip action when ignoreNativeAutoMerge is disabled",
+			cfg:            &MergeConfigV1{IgnoreNativeAutoMerge: &disabled},
+			autoMerge:      &github.AutoMerge{EnabledBy: "octocat", MergeMethod: "SQUASH"},
+			wantSkipAction: false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := &github.PullRequestDetails{AutoMerge: tt.autoMerge}
+			got, err := worker.shouldSkipBecauseOfNativeAutoMerge(tt.cfg)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfNativeAutoMerge() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfNativeAutoMerge() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.SkipAction && got.SkipReason != SkipReasonNativeAutoMerge {
+				t.Errorf("shouldSkipBecauseOfNativeAutoMerge() reason = %s, want %s", got.SkipReason, SkipReasonNativeAutoMerge)
+			}
+		})
+	}
+}
+
+func Test_shouldSkip_NativeAutoMerge(t *testing.T) {
+	cfg := &ConfigV1{}
+	cfg.applyDefaults()
+	details := &github.PullRequestDetails{AutoMerge: &github.AutoMerge{EnabledBy: "octocat", MergeMethod: "MERGE"}}
+	worker := Worker{}
+
+	mergeResult, err := worker.shouldSkipMerge(context.Background(), &log.Logger, cfg, "main", details)
+	if err != nil {
+		t.Fatalf("shouldSkipMerge() error = %v", err)
+	}
+	if !mergeResult.SkipAction || mergeResult.Title != "not merging: native auto-merge already enabled by @octocat" {
+		t.Errorf("shouldSkipMerge() = %+v, want skip with reason %s", mergeResult, SkipReasonNativeAutoMerge)
+	}
+
+	updateResult, err := worker.shouldSkipUpdate(context.Background(), &log.Logger, cfg, "main", details)
+	if err != nil {
+		t.Fatalf("shouldSkipUpdate() error = %v", err)
+	}
+	if !updateResult.SkipAction || updateResult.Title != "not updating: native auto-merge already enabled by @octocat" {
+		t.Errorf("shouldSkipUpdate() = %+v, want skip with reason %s", updateResult, SkipReasonNativeAutoMerge)
+	}
+}
+
+func Test_shouldSkipBecauseOfReviewDecision(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		reviewDecision string
+		wantSkipAction bool
+		wantSummary    string
+	}{
+		{
+			name:           "dont skip action when approved",
+			cfg:            &MergeConfigV1{RequireReviewDecisionApproved: true},
+			reviewDecision: "APPROVED",
+			wantSkipAction: false,
+		},
+		{
+			name:           "skip action when changes are requested",
+			cfg:            &MergeConfigV1{RequireReviewDecisionApproved: true},
+			reviewDecision: "CHANGES_REQUESTED",
+			wantSkipAction: true,
+			wantSummary:    "the review decision of the pull request is CHANGES_REQUESTED, it needs to be APPROVED",
+		},
+		{
+			name:           "skip action when there is no decision",
+			cfg:            &MergeConfigV1{RequireReviewDecisionApproved: true},
+			wantSkipAction: true,
+			wantSummary:    "the review decision of the pull request is none, it needs to be APPROVED",
+		},
+		{
+			name:           "dont skip action when it is disabled",
+			cfg:            &MergeConfigV1{},
+			reviewDecision: "REVIEW_REQUIRED",
+			wantSkipAction: false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := &github.PullRequestDetails{ReviewDecision: tt.reviewDecision}
+			got, err := worker.shouldSkipBecauseOfReviewDecision(tt.cfg)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfReviewDecision() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfReviewDecision() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.Summary != tt.wantSummary {
+				t.Errorf("shouldSkipBecauseOfReviewDecision() summary = %q, want %q", got.Summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfPendingReviewers(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              *MergeConfigV1
+		pendingReviewers []string
+		wantSkipAction   bool
+		wantSummary      string
+	}{
+		{
+			name:           "dont skip action when no review is requested",
+			cfg:            &MergeConfigV1{WaitForRequestedReviewers: true},
+			wantSkipAction: false,
+		},
+		{
+			name:             "skip action when reviews are requested",
+			cfg:              &MergeConfigV1{WaitForRequestedReviewers: true},
+			pendingReviewers: []string{"octocat", "team:org/reviewers"},
+			wantSkipAction:   true,
+			wantSummary:      "a review is still requested from:\n- @octocat\n- @org/reviewers",
+		},
+		{
+			name:             "dont skip action when it is disabled",
+			cfg:              &MergeConfigV1{},
+			pendingReviewers: []string{"octocat"},
+			wantSkipAction:   false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := &github.PullRequestDetails{PendingReviewers: tt.pendingReviewers}
+			got, err := worker.shouldSkipBecauseOfPendingReviewers(tt.cfg)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfPendingReviewers() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfPendingReviewers() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.Summary != tt.wantSummary {
+				t.Errorf("shouldSkipBecauseOfPendingReviewers() summary = %q, want %q", got.Summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfCommitSignature(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		details        *github.PullRequestDetails
+		wantSkipAction bool
+	}{
+		{
+			name:           "dont skip action when last commit is signed",
+			cfg:            &MergeConfigV1{RequireSignedCommits: true},
+			details:        &github.PullRequestDetails{LastCommitSha: "abc", IsLastCommitSigned: true},
+			wantSkipAction: false,
+		},
+		{
+			name:           "skip action when last commit is not signed",
+			cfg:            &MergeConfigV1{RequireSignedCommits: true},
+			details:        &github.PullRequestDetails{LastCommitSha: "abc", IsLastCommitSigned: false},
+			wantSkipAction: true,
+		},
+		{
+			name:           "skip action when there is no signature info",
+			cfg:            &MergeConfigV1{RequireSignedCommits: true},
+			details:        &github.PullRequestDetails{},
+			wantSkipAction: true,
+		},
+		{
+			name:           "dont skip action when signed commits are not required",
+			cfg:            &MergeConfigV1{},
+			details:        &github.PullRequestDetails{LastCommitSha: "abc", IsLastCommitSigned: false},
+			wantSkipAction: false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := worker.shouldSkipBecauseOfCommitSignature(tt.cfg)(context.Background(), &log.Logger, tt.details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfCommitSignature() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfCommitSignature() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.SkipAction && got.SkipReason != SkipReasonUnsignedCommit {
+				t.Errorf("SkipReason = %v, want %v", got.SkipReason, SkipReasonUnsignedCommit)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipResult_SkipReason(t *testing.T) {
+	tests := []struct {
+		name           string
+		fn             shouldSkipFunc
+		details        *github.PullRequestDetails
+		wantSkipReason SkipReason
+	}{
+		{
+			name:           "draft pull request",
+			fn:             (&Worker{}).shouldSkipBecauseIsNotMergeable(&MergeConfigV1{}),
+			details:        &github.PullRequestDetails{MergeStateStatus: "DRAFT"},
+			wantSkipReason: SkipReasonDraft,
+		},
+		{
+			name:           "pull request with conflicts",
+			fn:             (&Worker{}).shouldSkipBecauseIsNotMergeable(&MergeConfigV1{}),
+			details:        &github.PullRequestDetails{MergeStateStatus: "DIRTY", HasConflicts: true},
+			wantSkipReason: SkipReasonConflicts,
+		},
+		{
+			name:           "pull request not mergeable",
+			fn:             (&Worker{}).shouldSkipBecauseIsNotMergeable(&MergeConfigV1{}),
+			details:        &github.PullRequestDetails{MergeStateStatus: "BLOCKED"},
+			wantSkipReason: SkipReasonNotMergeable,
+		},
+		{
+			name:           "missing approvals",
+			fn:             (&Worker{}).shouldSkipBecauseOfReviews(&MergeConfigV1{RequiredApprovals: 1}),
+			details:        &github.PullRequestDetails{},
+			wantSkipReason: SkipReasonMissingApprovals,
+		},
+		{
+			name:           "missing checks",
+			fn:             (&Worker{}).shouldSkipBecauseOfChecks(&MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check1")}}),
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{})},
+			wantSkipReason: SkipReasonMissingChecks,
+		},
+		{
+			name:           "pending checks",
+			fn:             (&Worker{}).shouldSkipBecauseOfChecks(&MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check.*")}}),
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "PENDING", "check2": "SUCCESS"})},
+			wantSkipReason: SkipReasonPendingChecks,
+		},
+		{
+			name:           "queued and in progress checks",
+			fn:             (&Worker{}).shouldSkipBecauseOfChecks(&MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check.*")}}),
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "QUEUED", "check2": "IN_PROGRESS"})},
+			wantSkipReason: SkipReasonPendingChecks,
+		},
+		{
+			name:           "failed checks",
+			fn:             (&Worker{}).shouldSkipBecauseOfChecks(&MergeConfigV1{RequiredChecks: common.RegexSlice{common.MustNewRegexItem("check.*")}}),
+			details:        &github.PullRequestDetails{CheckStates: checkStatesOf(map[string]string{"check1": "PENDING", "check2": "FAILURE"})},
+			wantSkipReason: SkipReasonFailedChecks,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn(context.Background(), &log.Logger, tt.details)
+			if err != nil {
+				t.Errorf("shouldSkipFunc() error = %v", err)
+				return
+			}
+			if got.SkipReason != tt.wantSkipReason {
+				t.Errorf("shouldSkipFunc() got = %v, wantSkipReason %v", got.SkipReason, tt.wantSkipReason)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipResult_checkRunTitle(t *testing.T) {
+	r := shouldSkipResult{SkipAction: true, SkipReason: SkipReasonNotMergeable}
+	if got := r.checkRunTitle("not merging: "); got != "not merging: not mergeable" {
+		t.Errorf("checkRunTitle() = %q", got)
+	}
+	r.Title = "custom"
+	if got := r.checkRunTitle("not merging: "); got != "not merging: custom" {
+		t.Errorf("checkRunTitle() = %q", got)
+	}
+	if got := (&shouldSkipResult{}).checkRunTitle("not merging: "); got != "" {
+		t.Errorf("checkRunTitle() = %q", got)
+	}
+}
+
+func Test_shouldSkipBecauseOfForbiddenAuthor(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            *MergeConfigV1
+		details        *github.PullRequestDetails
+		wantSkipAction bool
+	}{
+		{
+			name:           "skip action when author is forbidden",
+			cfg:            &MergeConfigV1{ForbiddenAuthors: common.RegexSlice{common.MustNewRegexItem("renovate")}},
+			details:        &github.PullRequestDetails{Author: "renovate"},
+			wantSkipAction: true,
+		},
+		{
+			name:           "skip action when author is forbidden using regex",
+			cfg:            &MergeConfigV1{ForbiddenAuthors: common.RegexSlice{common.MustNewRegexItem("bot-.+")}},
+			details:        &github.PullRequestDetails{Author: "bot-deps"},
+			wantSkipAction: true,
+		},
+		{
+			name:           "dont skip action when author is not forbidden",
+			cfg:            &MergeConfigV1{ForbiddenAuthors: common.RegexSlice{common.MustNewRegexItem("renovate")}},
+			details:        &github.PullRequestDetails{Author: "user"},
+			wantSkipAction: false,
+		},
+		{
+			name:           "dont skip action when nothing is configured",
+			cfg:            &MergeConfigV1{},
+			details:        &github.PullRequestDetails{Author: "renovate"},
+			wantSkipAction: false,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := worker.shouldSkipBecauseOfForbiddenAuthor(tt.cfg)(context.Background(), &log.Logger, tt.details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfForbiddenAuthor() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfForbiddenAuthor() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.SkipAction && got.SkipReason != SkipReasonForbiddenAuthor {
+				t.Errorf("shouldSkipBecauseOfForbiddenAuthor() reason = %v, want %v", got.SkipReason, SkipReasonForbiddenAuthor)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfAuthorNotAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowFromUsers common.RegexSlice
+		author         string
+		wantSkipAction bool
+	}{
+		{
+			name:           "dont skip action when nothing is configured",
+			allowFromUsers: nil,
+			author:         "someone",
+			wantSkipAction: false,
+		},
+		{
+			name:           "dont skip action when author is allowed",
+			allowFromUsers: common.RegexSlice{common.MustNewRegexItem("renovate"), common.MustNewRegexItem("dependabot")},
+			author:         "dependabot[bot]",
+			wantSkipAction: false,
+		},
+		{
+			name:           "skip action when author is not allowed",
+			allowFromUsers: common.RegexSlice{common.MustNewRegexItem("renovate"), common.MustNewRegexItem("dependabot")},
+			author:         "someone",
+			wantSkipAction: true,
+		},
+		{
+			name:           "skip action when author is excluded",
+			allowFromUsers: common.RegexSlice{common.MustNewRegexItem(".*"), common.MustNewRegexItem("!someone")},
+			author:         "someone",
+			wantSkipAction: true,
+		},
+	}
+	worker := Worker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := &github.PullRequestDetails{Author: tt.author}
+			got, err := worker.shouldSkipBecauseOfAuthorNotAllowed(tt.allowFromUsers)(context.Background(), &log.Logger, details)
+			if err != nil {
+				t.Fatalf("shouldSkipBecauseOfAuthorNotAllowed() error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkipAction {
+				t.Errorf("shouldSkipBecauseOfAuthorNotAllowed() got = %v, wantSkipAction %v", got, tt.wantSkipAction)
+			}
+			if got.SkipAction && (got.SkipReason != SkipReasonAuthorNotAllowed || got.Title != "author not in allow list") {
+				t.Errorf("shouldSkipBecauseOfAuthorNotAllowed() = %+v, want reason %v", got, SkipReasonAuthorNotAllowed)
+			}
+		})
+	}
+}
+
+func Test_shouldSkipBecauseOfAuthorNotAllowed_Bot(t *testing.T) {
+	// graphql returns the login of the app without the [bot] suffix
+	gh := newFakeGitHub()
+	gh.responses["GetPullRequestDetails"] = `{"data":{"repository":{"pullRequest":{"id":"PR_1","state":"OPEN",` +
+		`"author":{"login":"renovate","__typename":"Bot"}}}}}`
+	details, err := github.GetPullRequestDetails(context.Background(), gh.Client(), "token", newTestSession().Repository, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pattern of the README example
+	allowFromUsers := common.RegexSlice{common.MustNewRegexItem(`renovate\[bot\]`)}
+	worker := Worker{}
+	got, err := worker.shouldSkipBecauseOfAuthorNotAllowed(allowFromUsers)(context.Background(), &log.Logger, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SkipAction {
+		t.Errorf("shouldSkipBecauseOfAuthorNotAllowed() = %+v, want the bot to be allowed", got)
+	}
+}
+
+func Test_allowFromUsers_MergeAndUpdate(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+version: 1
+merge:
+  labels: ["merge"]
+  allowFromUsers: ["renovate"]
+update:
+  labels: ["update"]
+  allowFromUsers: ["renovate", "alice"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := &github.PullRequestDetails{Author: "alice", IsMergeable: true}
+	worker := Worker{}
+
+	mergeResult, err := worker.shouldSkipMerge(context.Background(), &log.Logger, cfg, "", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mergeResult.SkipAction || mergeResult.SkipReason != SkipReasonAuthorNotAllowed {
+		t.Errorf("shouldSkipMerge() = %+v, want skip with reason %s", mergeResult, SkipReasonAuthorNotAllowed)
+	}
+
+	updateResult, err := worker.shouldSkipUpdate(context.Background(), &log.Logger, cfg, "", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updateResult.SkipAction {
+		t.Errorf("shouldSkipUpdate() = %+v, want no skip", updateResult)
+	}
+}
+
+func Test_forbiddenAuthors_onlyBlockMerge(t *testing.T) {
+	cfg := &ConfigV1{
+		Merge: MergeConfigV1{
+			ForbiddenAuthors: common.RegexSlice{common.MustNewRegexItem("renovate")},
+		},
+	}
+	details := &github.PullRequestDetails{Author: "renovate", IsMergeable: true}
+	worker := Worker{}
+
+	mergeResult, err := worker.shouldSkipMerge(context.Background(), &log.Logger, cfg, "", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mergeResult.SkipAction || mergeResult.SkipReason != SkipReasonForbiddenAuthor {
+		t.Errorf("shouldSkipMerge() = %+v, want skip with reason %s", mergeResult, SkipReasonForbiddenAuthor)
+	}
+
+	updateResult, err := worker.shouldSkipUpdate(context.Background(), &log.Logger, cfg, "", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updateResult.SkipAction {
+		t.Errorf("shouldSkipUpdate() = %+v, want no skip", updateResult)
+	}
+
+	// ignoreFromUsers blocks both
+	cfg.Merge.IgnoreFromUsers = common.RegexSlice{common.MustNewRegexItem("renovate")}
+	cfg.Update.IgnoreFromUsers = common.RegexSlice{common.MustNewRegexItem("renovate")}
+	updateResult, err = worker.shouldSkipUpdate(context.Background(), &log.Logger, cfg, "", details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updateResult.SkipAction || updateResult.SkipReason != SkipReasonIgnoredAuthor {
+		t.Errorf("shouldSkipUpdate() = %+v, want skip with reason %s", updateResult, SkipReasonIgnoredAuthor)
+	}
+}
+
+func Test_shouldSkipMerge_ReportAllBlockers(t *testing.T) {
+	cfg := &ConfigV1{
+		Merge: MergeConfigV1{
+			RequiredApprovals: 1,
+			RequiredChecks:    common.RegexSlice{common.MustNewRegexItem("check1")},
+		},
+	}
+	details := &github.PullRequestDetails{
+		IsMergeable: true,
+		CheckStates: checkStatesOf(map[string]string{"check1": "FAILURE"}),
+	}
+	worker := Worker{}
+
+	t.Run("stops at the first blocker by default", func(t *testing.T) {
+		got, err := worker.shouldSkipMerge(context.Background(), &log.Logger, cfg, "", details)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.SkipAction || got.SkipReason != SkipReasonMissingApprovals {
+			t.Errorf("got = %+v, want missing approvals", got)
+		}
+		if strings.Contains(got.Summary, "check1") {
+			t.Errorf("summary = %q, should not contain the failed check", got.Summary)
+		}
+	})
+
+	t.Run("reports all blockers", func(t *testing.T) {
+		cfg := *cfg
+		cfg.Merge.ReportAllBlockers = true
+		got, err := worker.shouldSkipMerge(context.Background(), &log.Logger, &cfg, "", details)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.SkipAction || got.SkipReason != SkipReasonMissingApprovals {
+			t.Errorf("got = %+v, want missing approvals", got)
+		}
+		if got.Title != "not merging: 2 blockers" {
+			t.Errorf("title = %q", got.Title)
+		}
+		for _, s := range []string{
+			"- [ ] missing required approvals",
+			"  1 approvals are required, got 0",
+			"- [ ] check(s) did not succeeded",
+			"check `check1` did not succeed",
+		} {
+			if !strings.Contains(got.Summary, s) {
+				t.Errorf("summary does not contain %q:\n%s", s, got.Summary)
+			}
+		}
+	})
+}
+
+func Test_evaluateAllConditions(t *testing.T) {
+	pushBack := func(delay time.Duration, title string) shouldSkipFunc {
+		return func(context.Context, *zerolog.Logger, *github.PullRequestDetails) (shouldSkipResult, error) {
+			return shouldSkipResult{Title: title}, pushBackError{delay: delay}
+		}
+	}
+	skip := func(reason SkipReason) shouldSkipFunc {
+		return func(context.Context, *zerolog.Logger, *github.PullRequestDetails) (shouldSkipResult, error) {
+			return shouldSkipResult{SkipAction: true, SkipReason: reason}, nil
+		}
+	}
+	pass := func(context.Context, *zerolog.Logger, *github.PullRequestDetails) (shouldSkipResult, error) {
+		return shouldSkipResult{}, nil
+	}
+	fail := func(context.Context, *zerolog.Logger, *github.PullRequestDetails) (shouldSkipResult, error) {
+		return shouldSkipResult{}, errors.New("api error")
+	}
+
+	tests := []struct {
+		name         string
+		conditions   []shouldSkipFunc
+		wantSkip     bool
+		wantDelay    time.Duration
+		wantErr      bool
+		wantBlockers []string
+	}{
+		{
+			name:       "no blockers",
+			conditions: []shouldSkipFunc{pass, pass},
+		},
+		{
+			name:         "first push back wins",
+			conditions:   []shouldSkipFunc{pass, pushBack(time.Minute, "waiting"), skip(SkipReasonDraft), pushBack(time.Hour, "waiting longer")},
+			wantDelay:    time.Minute,
+			wantBlockers: []string{"- [ ] waiting", "- [ ] draft", "- [ ] waiting longer"},
+		},
+		{
+			name:         "first skip wins",
+			conditions:   []shouldSkipFunc{skip(SkipReasonDraft), pushBack(time.Minute, "waiting"), skip(SkipReasonConflicts)},
+			wantSkip:     true,
+			wantBlockers: []string{"- [ ] draft", "- [ ] waiting", "- [ ] conflicts"},
+		},
+		{
+			name:       "errors are returned",
+			conditions: []shouldSkipFunc{skip(SkipReasonDraft), fail},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluateAllConditions(context.Background(), &log.Logger, &github.PullRequestDetails{}, tt.conditions, "not merging: ")
+			var pbErr pushBackError
+			isPushBack := errors.As(err, &pbErr)
+			if tt.wantErr {
+				if err == nil || isPushBack {
+					t.Fatalf("error = %v, want a non push back error", err)
+				}
+				return
+			}
+			if tt.wantDelay > 0 {
+				if !isPushBack || pbErr.delay != tt.wantDelay {
+					t.Fatalf("error = %v, want push back with %v", err, tt.wantDelay)
+				}
+			} else if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got.SkipAction != tt.wantSkip {
+				t.Errorf("SkipAction = %v, want %v", got.SkipAction, tt.wantSkip)
+			}
+			for _, s := range tt.wantBlockers {
+				if !strings.Contains(got.Summary, s) {
+					t.Errorf("summary does not contain %q:\n%s", s, got.Summary)
+				}
+			}
+		})
+	}
+}
+
+func Test_negativePatterns(t *testing.T) {
+	patterns := func(s ...string) common.RegexSlice {
+		sl := make(common.RegexSlice, len(s))
+		for i := range s {
+			sl[i] = common.MustNewRegexItem(s[i])
+		}
+		return sl
+	}
+	worker := Worker{}
+
+	t.Run("requiredChecks", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			checks         common.RegexSlice
+			states         map[string]string
+			wantSkipAction bool
+		}{
+			{
+				name:           "excluded check may fail",
+				checks:         patterns(".*", "!nightly"),
+				states:         map[string]string{"build": "SUCCESS", "nightly": "FAILURE"},
+				wantSkipAction: false,
+			},
+			{
+				name:           "other checks must still pass",
+				checks:         patterns(".*", "!nightly"),
+				states:         map[string]string{"build": "FAILURE", "nightly": "SUCCESS"},
+				wantSkipAction: true,
+			},
+			{
+				name:           "positive pattern that only matches excluded checks is missing",
+				checks:         patterns("ci/.*", "!ci/nightly"),
+				states:         map[string]string{"build": "SUCCESS", "ci/nightly": "SUCCESS"},
+				wantSkipAction: true,
+			},
+			{
+				name:           "only negatives require nothing",
+				checks:         patterns("!nightly"),
+				states:         map[string]string{"nightly": "FAILURE"},
+				wantSkipAction: false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cfg := &MergeConfigV1{RequiredChecks: tt.checks}
+				got, err := worker.shouldSkipBecauseOfChecks(cfg)(context.Background(), &log.Logger, &github.PullRequestDetails{CheckStates: checkStatesOf(tt.states)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.SkipAction != tt.wantSkipAction {
+					t.Errorf("got = %+v, wantSkipAction %v", got, tt.wantSkipAction)
+				}
+			})
+		}
+	})
+
+	t.Run("requireApprovalsFrom", func(t *testing.T) {
+		cfg := &MergeConfigV1{RequireApprovalsFrom: approvalsFrom("team-.*", "!team-bot")}
+		got, err := worker.shouldSkipBecauseOfReviews(cfg)(context.Background(), &log.Logger, &github.PullRequestDetails{ApprovedBy: []string{"team-bot"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.SkipAction {
+			t.Error("approval of an excluded user should not count")
+		}
+		got, err = worker.shouldSkipBecauseOfReviews(cfg)(context.Background(), &log.Logger, &github.PullRequestDetails{ApprovedBy: []string{"team-bot", "team-alice"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.SkipAction {
+			t.Errorf("got = %+v, want no skip", got)
+		}
+	})
+
+	t.Run("labels", func(t *testing.T) {
+		labels := patterns("merge.*", "!merge-later")
+		if labels.ContainsOneOf("merge-later") != "" {
+			t.Error("merge-later should not match")
+		}
+		if labels.ContainsOneOf("merge-later", "merge-now") != "merge.*" {
+			t.Error("merge-now should match")
+		}
+	})
+
+	t.Run("ignoreFromUsers", func(t *testing.T) {
+		cfg := &IgnoreConfig{IgnoreFromUsers: patterns(".*\\[bot\\]", "!renovate\\[bot\\]")}
+		got, err := worker.shouldSkipBecauseOfAuthorName(cfg)(context.Background(), &log.Logger, &github.PullRequestDetails{Author: "renovate[bot]"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.SkipAction {
+			t.Error("excluded author should not be ignored")
+		}
+		got, err = worker.shouldSkipBecauseOfAuthorName(cfg)(context.Background(), &log.Logger, &github.PullRequestDetails{Author: "dependabot[bot]"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.SkipAction {
+			t.Error("author should be ignored")
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+// rememberRepository stores the repository of the message in RepositoriesKV, so the sweep looks at it until the
+// ttl of the bucket expires. Only messages of webhooks, which have a sender, are remembered. Messages the worker
+// queued itself, e.g. by the sweep, would otherwise keep the repository from ever being forgotten.
+func (worker *Worker) rememberRepository(message *common.BaseMessage) error {
+	if worker.RepositoriesKV == nil || message.Sender.Login == "" {
+		return nil
+	}
+	buf, err := json.Marshal(common.BaseMessage{
+		InstallationID: message.InstallationID,
+		Repository:     message.Repository,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to encode repository")
+	}
+	key := common.RecentRepositoryKVKey(message.InstallationID, &message.Repository)
+	if _, err := worker.RepositoriesKV.Put(key, buf); err != nil {
+		return errors.Wrap(err, "unable to store repository in kv bucket")
+	}
+	return nil
+}
+
+// startSweeper calls sweep every SweepInterval until the returned function is called, it waits for a running sweep.
+func (worker *Worker) startSweeper() (stop func()) {
+	if worker.SweepInterval <= 0 || worker.RepositoriesKV == nil || worker.PushSubject == "" {
+		return func() {}
+	}
+	newTicker := worker.newTicker
+	if newTicker == nil {
+		newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+			ticker := time.NewTicker(d)
+			return ticker.C, ticker.Stop
+		}
+	}
+	ticks, stopTicker := newTicker(worker.SweepInterval)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticks:
+				if err := worker.sweep(worker.Logger); err != nil {
+					worker.Logger.Error().Err(err).Msg("sweep failed")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		stopTicker()
+		close(done)
+		<-stopped
+	}
+}
+
+// sweep queues a push message for the default branch of every repository in RepositoriesKV.
+// The messages are rate limited like the messages of real push events, so a sweep right after a push is dropped.
+func (worker *Worker) sweep(logger *zerolog.Logger) error {
+	keys, err := worker.RepositoriesKV.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return nil
+		}
+		return errors.Wrap(err, "unable to list repositories in kv bucket")
+	}
+	logger.Debug().Int("repositories", len(keys)).Msg("sweeping repositories")
+
+	var result error
+	for _, key := range keys {
+		entry, err := worker.RepositoriesKV.Get(key)
+		if err != nil {
+			if errors.Is(err, nats.ErrKeyNotFound) {
+				// expired in the meantime
+				continue
+			}
+			result = multierror.Append(result, errors.Wrap(err, "unable to get repository from kv bucket"))
+			continue
+		}
+		var msg common.BaseMessage
+		if err := json.Unmarshal(entry.Value(), &msg); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "unable to decode repository"))
+			continue
+		}
+		err = common.QueueMessage(
+			logger,
+			worker.JetStreamContext,
+			worker.RateLimitKV,
+			worker.RateLimitInterval,
+			worker.PushSubject+"."+uuid.NewString(),
+			// same id as push events, so they are deduplicated
+			fmt.Sprintf("push.%d.%s", msg.InstallationID, msg.Repository.NodeID),
+			"",
+			&common.QueuePushMessage{
+				BaseMessage: msg,
+				Branch:      msg.Repository.DefaultBranch,
+			})
+		if err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "unable to publish push message for %s", msg.Repository.FullName))
+			continue
+		}
+	}
+	return result
+}
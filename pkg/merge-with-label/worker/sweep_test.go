@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+)
+
+func newTestSweepWorker(js nats.JetStreamContext, now func() time.Time) *Worker {
+	logger := zerolog.Nop()
+	repositoriesKV := newFakeKV()
+	repositoriesKV.ttl = time.Hour
+	repositoriesKV.now = now
+	return &Worker{
+		Logger:            &logger,
+		JetStreamContext:  js,
+		RateLimitKV:       newFakeKV(),
+		RateLimitInterval: time.Minute,
+		PushSubject:       "push",
+		RepositoriesKV:    repositoriesKV,
+		SweepInterval:     time.Minute,
+	}
+}
+
+func decodePushMessages(t *testing.T, published []*nats.Msg) []common.QueuePushMessage {
+	t.Helper()
+	messages := make([]common.QueuePushMessage, len(published))
+	for i, msg := range published {
+		if !strings.HasPrefix(msg.Subject, "push.") {
+			t.Errorf("subject = %q, want a push subject", msg.Subject)
+		}
+		if err := json.Unmarshal(msg.Data, &messages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return messages
+}
+
+func TestWorker_sweep(t *testing.T) {
+	now := time.Date(2023, 7, 22, 4, 0, 0, 0, time.UTC)
+	js := &fakePublisher{}
+	worker := newTestSweepWorker(js, func() time.Time { return now })
+	logger := zerolog.Nop()
+
+	stale := &common.BaseMessage{
+		InstallationID: 1,
+		Repository:     common.Repository{FullName: "owner/stale", NodeID: "R_0", DefaultBranch: "main"},
+		Sender:         common.Sender{Login: "octocat"},
+	}
+	if err := worker.rememberRepository(stale); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Minute)
+	recent := &common.BaseMessage{
+		InstallationID: 1,
+		Repository:     common.Repository{FullName: "owner/repo", NodeID: "R_1", DefaultBranch: "develop"},
+		Sender:         common.Sender{Login: "octocat"},
+	}
+	if err := worker.rememberRepository(recent); err != nil {
+		t.Fatal(err)
+	}
+	// messages queued by the worker itself, e.g. the sweep, do not keep a repository alive
+	if err := worker.rememberRepository(&common.BaseMessage{InstallationID: stale.InstallationID, Repository: stale.Repository}); err != nil {
+		t.Fatal(err)
+	}
+	// owner/stale was not seen for longer than the ttl of the bucket
+	now = now.Add(45 * time.Minute)
+
+	if err := worker.sweep(&logger); err != nil {
+		t.Fatal(err)
+	}
+	messages := decodePushMessages(t, js.published)
+	if len(messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(messages))
+	}
+	got := messages[0]
+	if got.Repository.FullName != "owner/repo" || got.InstallationID != 1 || got.Branch != "develop" {
+		t.Errorf("message = %+v", got)
+	}
+	if got.Sender.Login != "" {
+		t.Errorf("sender = %q, a sweep is not triggered by a user", got.Sender.Login)
+	}
+
+	// the second sweep is rate limited like a push event
+	if err := worker.sweep(&logger); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.published) != 2 {
+		t.Fatalf("published %d messages, want 2", len(js.published))
+	}
+	if js.published[1].Header.Get(nats.MsgIdHdr) == "" || js.published[1].Header.Get(common.DelayUntilHeader) == "" {
+		t.Errorf("headers = %v, want the message to be deduplicated", js.published[1].Header)
+	}
+}
+
+func TestWorker_sweep_Empty(t *testing.T) {
+	js := &fakePublisher{}
+	worker := newTestSweepWorker(js, nil)
+	logger := zerolog.Nop()
+	if err := worker.sweep(&logger); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.published) != 0 {
+		t.Errorf("published %d messages, want 0", len(js.published))
+	}
+}
+
+func TestWorker_startSweeper(t *testing.T) {
+	t.Run("sweeps on every tick until stopped", func(t *testing.T) {
+		js := &fakePublisher{}
+		worker := newTestSweepWorker(js, nil)
+		if err := worker.rememberRepository(&common.BaseMessage{
+			InstallationID: 1,
+			Repository:     common.Repository{FullName: "owner/repo", NodeID: "R_1", DefaultBranch: "main"},
+			Sender:         common.Sender{Login: "octocat"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		ticks := make(chan time.Time)
+		var interval time.Duration
+		tickerStopped := false
+		worker.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+			interval = d
+			return ticks, func() { tickerStopped = true }
+		}
+
+		stop := worker.startSweeper()
+		ticks <- time.Now()
+		ticks <- time.Now()
+		stop()
+
+		if interval != time.Minute {
+			t.Errorf("interval = %s, want 1m", interval)
+		}
+		if !tickerStopped {
+			t.Error("ticker was not stopped")
+		}
+		if len(js.published) != 2 {
+			t.Errorf("published %d messages, want 2", len(js.published))
+		}
+	})
+
+	tests := []struct {
+		name   string
+		modify func(worker *Worker)
+	}{
+		{name: "without interval", modify: func(worker *Worker) { worker.SweepInterval = 0 }},
+		{name: "without bucket", modify: func(worker *Worker) { worker.RepositoriesKV = nil }},
+		{name: "without push subject", modify: func(worker *Worker) { worker.PushSubject = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := newTestSweepWorker(&fakePublisher{}, nil)
+			tt.modify(worker)
+			worker.newTicker = func(time.Duration) (<-chan time.Time, func()) {
+				t.Fatal("sweeper must not be started")
+				return nil, nil
+			}
+			worker.startSweeper()()
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,6 +15,7 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/metrics"
 
 	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
 )
@@ -21,6 +23,9 @@ import (
 type Worker struct {
 	Logger  *zerolog.Logger
 	BotName string
+	// CheckRunNamePrefix is prepended to the name of every check run, it separates the check runs of multiple
+	// instances that act on the same repositories.
+	CheckRunNamePrefix string
 
 	AllowedRepositories         common.RegexSlice
 	AllowOnlyPublicRepositories bool
@@ -28,14 +33,53 @@ type Worker struct {
 	PushSubscription        *nats.Subscription
 	StatusSubscription      *nats.Subscription
 	PullRequestSubscription *nats.Subscription
+	MaintenanceSubscription *nats.Subscription
 
 	AccessTokensKV nats.KeyValue
 	ConfigsKV      nats.KeyValue
 	CheckRunsKV    nats.KeyValue
+	// AccessTokenExpirySkew is the margin before their expiry at which cached access tokens are replaced,
+	// it covers clock skew between the worker and GitHub.
+	AccessTokenExpirySkew time.Duration
+	// PRDetailsKV caches pull request details by their head sha, caching is disabled if nil.
+	PRDetailsKV nats.KeyValue
+	// PRDetailsCacheInvalidationEvents are pull_request actions that always fetch fresh pull request details.
+	PRDetailsCacheInvalidationEvents []string
+	// MergeLocksKV holds a lock for every pull request that is being merged, locking is disabled if nil.
+	MergeLocksKV nats.KeyValue
+	// ReviewRequestsKV remembers the pull requests reviewers were requested for, requesting reviewers is
+	// disabled if nil.
+	ReviewRequestsKV nats.KeyValue
+	// RecentlyMergedKV remembers the pull requests that were merged recently, messages for them are dropped
+	// without asking GitHub. Disabled if nil.
+	RecentlyMergedKV nats.KeyValue
+	// RepositoriesKV remembers the repositories that were seen recently, the sweep looks at them. The sweep is
+	// disabled if nil.
+	RepositoriesKV nats.KeyValue
+	// SweepInterval is the interval a push message is queued for every repository in RepositoriesKV, so pull
+	// requests whose webhooks were lost are evaluated eventually. The sweep is disabled if 0.
+	SweepInterval time.Duration
+	// ReconcileThrottle is the wait before each push message Reconcile queues.
+	ReconcileThrottle time.Duration
+	// CircuitBreakerKV holds the consecutive hard failures of repositories, the circuit breaker is disabled if nil.
+	CircuitBreakerKV nats.KeyValue
+	// CircuitBreakerThreshold is the number of consecutive hard failures after which the messages of a repository
+	// are dropped for CircuitBreakerCooldown. The circuit breaker is disabled if 0.
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is how long messages are dropped before a trial message is processed.
+	CircuitBreakerCooldown time.Duration
+	// MutationThrottle limits the mutations that are sent to github per installation, so bursts do not trigger the
+	// secondary rate limits. Mutations are not limited if nil.
+	MutationThrottle *common.TokenBucket
+	// ID identifies this worker instance, it is stored as the holder of merge locks.
+	ID string
 
 	JetStreamContext   nats.JetStreamContext
 	PullRequestSubject string
 	RetryWait          time.Duration
+	// PushSubject is the subject a push message is queued to after a pull request was merged, so the remaining
+	// pull requests are evaluated (see merge.cascade). Disabled if empty.
+	PushSubject string
 
 	MaxDurationForPushWorker        time.Duration
 	MaxDurationForPullRequestWorker time.Duration
@@ -43,18 +87,60 @@ type Worker struct {
 	RateLimitKV       nats.KeyValue
 	RateLimitInterval time.Duration
 
+	// MaxPullRequestsPerSweep is the maximum number of open pull requests with an update or merge label a push or
+	// status message looks at, 0 looks at all of them.
+	MaxPullRequestsPerSweep int
+
 	DurationBeforeMergeAfterCheck       time.Duration
 	DurationToWaitAfterUpdateBranch     time.Duration
 	MessageChannelSizePerSubjectSetting int
 
 	HTTPClient *http.Client
 
+	// MaxDeliveriesBeforeAlert is the number of deliveries after which a message is logged as a warning,
+	// this surfaces stuck messages before they reach the max deliver limit.
+	// Defaults to half of the max deliveries of the subject of the message.
+	MaxDeliveriesBeforeAlert int
+	// MaxDeliver is the number of deliveries after which nats drops a message.
+	MaxDeliver int
+	// MaxDeliverBySubject overrides MaxDeliver for the messages of a subject, keyed by the subject without
+	// the trailing wildcard, e.g. "push" for the messages on "push.*".
+	MaxDeliverBySubject map[string]int
+	// DeadLetterSubject is the subject messages are moved to after their last failed delivery,
+	// dead lettering is disabled if empty.
+	DeadLetterSubject string
+
 	AppID      int64
 	PrivateKey []byte
 
+	// PreMergeHook is called right before a pull request gets merged.
+	// If it returns an error the merge is postponed and retried later.
+	PreMergeHook MergeHook
+	// PostMergeHook is called after a pull request was merged successfully.
+	// Errors are logged but do not affect the merge.
+	PostMergeHook MergeHook
+
+	// Notifications posts merges, updates and failures to a webhook, notifications are disabled if nil.
+	Notifications *Notifier
+
 	closeCh chan struct{}
+	// reviewerIDs caches the node ids of the users and teams reviews are requested from.
+	reviewerIDs reviewerIDCache
+	// now returns the current time, defaults to time.Now.
+	now func() time.Time
+	// newTicker returns the ticks of the sweep and a function that stops them, defaults to time.NewTicker.
+	newTicker func(d time.Duration) (<-chan time.Time, func())
+}
+
+func (worker *Worker) timeNow() time.Time {
+	if worker.now != nil {
+		return worker.now()
+	}
+	return time.Now()
 }
 
+type MergeHook func(ctx context.Context, repo *common.Repository, prNumber int64, details *github.PullRequestDetails) error
+
 type pushBackError struct {
 	delay time.Duration
 }
@@ -64,9 +150,15 @@ func (e pushBackError) Error() string {
 }
 
 func (worker *Worker) Consume() error {
+	if worker.HTTPClient == nil {
+		return errors.New("HTTPClient must not be nil")
+	}
 	worker.closeCh = make(chan struct{})
 	errChan := make(chan error)
 
+	stopSweeper := worker.startSweeper()
+	defer stopSweeper()
+
 	pushChan := make(chan *nats.Msg, worker.MessageChannelSizePerSubjectSetting)
 	go func() {
 		for {
@@ -103,33 +195,59 @@ func (worker *Worker) Consume() error {
 		}
 	}()
 
-	pushMsgWorker := pushWorker{
-		Worker: worker,
+	maintenanceChan := make(chan *nats.Msg, worker.MessageChannelSizePerSubjectSetting)
+	go func() {
+		for {
+			msg, err := worker.MaintenanceSubscription.NextMsgWithContext(context.Background())
+			if err != nil {
+				errChan <- err
+				return
+			}
+			maintenanceChan <- msg
+		}
+	}()
+
+	pushMsgWorker := sharedPushStatusWorker{
+		Worker:    worker,
+		eventType: "push",
 	}
 
-	statusMsgWorker := statusWorker{
-		Worker: worker,
+	statusMsgWorker := sharedPushStatusWorker{
+		Worker:    worker,
+		eventType: "status",
 	}
 
 	pullRequestMsgWorker := pullRequestWorker{
 		Worker: worker,
 	}
 
+	maintenanceMsgWorker := maintenanceWorker{
+		Worker: worker,
+	}
+
 	for {
 		select {
 		case msg := <-pushChan:
 			worker.Logger.Debug().
 				Msg("push message received")
-			handleMessage[common.QueuePushMessage](worker, worker.Logger, msg, pushMsgWorker.runLogic)
+			handleMessage(worker, worker.Logger, msg, func(logger *zerolog.Logger, m *common.QueuePushMessage) error {
+				return pushMsgWorker.runLogic(logger, &m.BaseMessage, m.Branch)
+			})
 		case msg := <-statusChan:
 			worker.Logger.Debug().
 				Msg("status message received")
-			handleMessage[common.QueueStatusMessage](worker, worker.Logger, msg, statusMsgWorker.runLogic)
+			handleMessage(worker, worker.Logger, msg, func(logger *zerolog.Logger, m *common.QueueStatusMessage) error {
+				return statusMsgWorker.runLogic(logger, &m.BaseMessage, "")
+			})
 		case msg := <-pullRequestChan:
 			worker.Logger.Debug().
 				Str("id", msg.Header.Get(nats.MsgIdHdr)).
 				Msg("pull_request message received")
 			handleMessage[common.QueuePullRequestMessage](worker, worker.Logger, msg, pullRequestMsgWorker.runLogic)
+		case msg := <-maintenanceChan:
+			worker.Logger.Debug().
+				Msg("maintenance message received")
+			handleMessage[common.QueueMaintenanceMessage](worker, worker.Logger, msg, maintenanceMsgWorker.runLogic)
 		case err := <-errChan:
 			return errors.Wrap(err, "error received")
 		case <-worker.closeCh:
@@ -139,12 +257,28 @@ func (worker *Worker) Consume() error {
 	}
 }
 
-func (worker *Worker) Shutdown(context.Context) error {
+func (worker *Worker) Shutdown(ctx context.Context) error {
 	worker.closeCh <- struct{}{}
+	if worker.Notifications != nil {
+		if err := worker.Notifications.Close(ctx); err != nil {
+			return errors.Wrap(err, "unable to send pending notifications")
+		}
+	}
 	return nil
 }
 
 func handleMessage[T common.Message](worker *Worker, logger *zerolog.Logger, msg *nats.Msg, fn func(logger *zerolog.Logger, m *T) error) {
+	if meta, err := msg.Metadata(); err == nil {
+		l := logger.With().Uint64("delivery_count", meta.NumDelivered).Logger()
+		logger = &l
+		if alertAfter := worker.maxDeliveriesBeforeAlert(msg.Subject); alertAfter > 0 && meta.NumDelivered > uint64(alertAfter) {
+			logger.Warn().
+				Str("subject", msg.Subject).
+				Str("payload", string(msg.Data)).
+				Msg("message was delivered too many times")
+		}
+	}
+
 	if common.DelayMessageIfNeeded(logger, msg) {
 		return
 	}
@@ -152,6 +286,9 @@ func handleMessage[T common.Message](worker *Worker, logger *zerolog.Logger, msg
 	var m T
 	if err := json.Unmarshal(msg.Data, &m); err != nil {
 		logger.Error().Err(err).Msg("unable to decode queue message")
+		if worker.deadLetter(logger, msg, err.Error()) {
+			return
+		}
 		if err := msg.NakWithDelay(worker.RetryWait); err != nil {
 			logger.Error().Err(err).Msg("unable to nak message")
 		}
@@ -174,38 +311,109 @@ func handleMessage[T common.Message](worker *Worker, logger *zerolog.Logger, msg
 		return
 	}
 
+	repository := m.GetRepository()
+	allowed, recorded := worker.circuitAllows(logger, &repository)
+	if !allowed {
+		logger.Warn().Str("repo", repository.FullName).Msg("circuit of the repository is open, dropping message")
+		metrics.CircuitBreakerDrops.Inc(repository.FullName)
+		if err := msg.Ack(); err != nil {
+			logger.Error().Err(err).Msg("unable to ack message")
+		}
+		return
+	}
+
 	err := fn(logger, &m)
 	if err != nil {
 		var pbErr pushBackError
 		delay := worker.RetryWait
+		reason := err.Error()
+		var throttledErr *github.ErrThrottled
 		if errors.As(err, &pbErr) {
 			delay = pbErr.delay
+			reason = "pushed back on every delivery"
+		} else if errors.As(err, &throttledErr) {
+			delay = max(throttledErr.Wait, worker.RetryWait)
+			logger.Info().Dur("wait", delay).Msg("mutations of the installation are throttled, retrying later")
+			worker.requeueThrottled(logger, msg, delay)
+			return
 		} else {
 			logger.Error().Err(err).Msg("error")
+			worker.recordCircuitResult(logger, &repository, true, recorded)
+		}
+		if worker.deadLetter(logger, msg, reason) {
+			return
 		}
 		if err := msg.NakWithDelay(delay); err != nil {
 			logger.Error().Err(err).Msg("unable to nak message")
 		}
 		return
 	}
+	worker.recordCircuitResult(logger, &repository, false, recorded)
 	if err := msg.Ack(); err != nil {
 		logger.Error().Err(err).Msg("unable to ack message")
 	}
 }
 
+// needsAction reports whether the pull request has one of the update or merge labels and, if baseBranch is not
+// empty, is based on it.
+func needsAction(cfg *ConfigV1, pr *github.OpenPullRequest, baseBranch string) bool {
+	if baseBranch != "" && pr.BaseRefName != baseBranch {
+		return false
+	}
+	return cfg.Update.Labels.ContainsOneOf(pr.Labels...) != "" || cfg.Merge.Labels.ContainsOneOf(pr.Labels...) != ""
+}
+
+// pullRequestsThatNeedAction returns the pull requests that have one of the update or merge labels,
+// sorted by merge.order. If baseBranch is not empty only pull requests based on it are returned.
+func pullRequestsThatNeedAction(
+	cfg *ConfigV1,
+	openPullRequests []github.OpenPullRequest,
+	baseBranch string,
+) []common.PullRequest {
+	var pullRequests []common.PullRequest
+	for i := range openPullRequests {
+		if !needsAction(cfg, &openPullRequests[i], baseBranch) {
+			continue
+		}
+		pullRequests = append(pullRequests, common.PullRequest{Number: openPullRequests[i].Number})
+	}
+	sort.Slice(pullRequests, func(i, j int) bool {
+		if cfg.Merge.Order == NewestFirstOrder {
+			return pullRequests[i].Number > pullRequests[j].Number
+		}
+		return pullRequests[i].Number < pullRequests[j].Number
+	})
+	return pullRequests
+}
+
+// workOnAllPullRequests queues a pull_request message for every pull request that needs action,
+// if baseBranch is not empty only for pull requests based on it.
 func (worker *Worker) workOnAllPullRequests(ctx context.Context,
 	rootLogger *zerolog.Logger,
-	sess *session) error {
-	pullRequests, err := github.GetPullRequestsThatAreOpenAndHaveOneOfTheseLabels(
+	sess *session,
+	baseBranch string) error {
+	// only pull requests that need action count towards MaxPullRequestsPerSweep
+	openPullRequests, truncated, err := github.GetOpenPullRequests(
 		ctx,
 		worker.HTTPClient,
 		sess.AccessToken,
 		sess.Repository,
-		append(sess.Config.Update.Labels.Strings(), sess.Config.Merge.Labels.Strings()...),
+		baseBranch,
+		worker.MaxPullRequestsPerSweep,
+		func(pr *github.OpenPullRequest) bool {
+			return needsAction(sess.Config, pr, baseBranch)
+		},
 	)
 	if err != nil {
 		return errors.Wrap(err, "error getting pull requests")
 	}
+	if truncated {
+		rootLogger.Warn().
+			Int("max_pull_requests_per_sweep", worker.MaxPullRequestsPerSweep).
+			Msg("repository has more pull requests that need action than MaxPullRequestsPerSweep, " +
+				"only the first ones are processed")
+	}
+	pullRequests := pullRequestsThatNeedAction(sess.Config, openPullRequests, baseBranch)
 	if len(pullRequests) == 0 {
 		rootLogger.Debug().Msg("no pull requests available that need action")
 		return nil
@@ -220,6 +428,7 @@ func (worker *Worker) workOnAllPullRequests(ctx context.Context,
 			worker.RateLimitInterval,
 			worker.PullRequestSubject+"."+uuid.NewString(),
 			fmt.Sprintf("pull_request.%d.%s.%d", sess.InstallationID, sess.Repository.NodeID, pullRequests[i].Number),
+			"",
 			&common.QueuePullRequestMessage{
 				BaseMessage: common.BaseMessage{
 					InstallationID: sess.InstallationID,
@@ -0,0 +1,303 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/common"
+	"github.com/Eun/merge-with-label/pkg/merge-with-label/github"
+)
+
+func TestHandleMessage_DeliveryCount(t *testing.T) {
+	tests := []struct {
+		name      string
+		delivered string
+		wantAlert bool
+	}{
+		{name: "first delivery", delivered: "1", wantAlert: false},
+		{name: "at limit", delivered: "2", wantAlert: false},
+		{name: "above limit", delivered: "3", wantAlert: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := zerolog.New(&buf)
+			worker := &Worker{
+				MaxDeliveriesBeforeAlert: 2,
+			}
+			msg := &nats.Msg{
+				Sub:     &nats.Subscription{},
+				Subject: "push.1",
+				Reply:   "$JS.ACK.stream.consumer." + tt.delivered + ".10.10.1690000000000000000.0",
+				Data:    []byte(`{"repository":{"full_name":"owner/repo"}}`),
+			}
+			handleMessage[common.QueuePushMessage](worker, &logger, msg, func(*zerolog.Logger, *common.QueuePushMessage) error {
+				return nil
+			})
+
+			out := buf.String()
+			if !strings.Contains(out, `"delivery_count":`+tt.delivered) {
+				t.Errorf("log does not contain the delivery_count:\n%s", out)
+			}
+			gotAlert := strings.Contains(out, "message was delivered too many times")
+			if gotAlert != tt.wantAlert {
+				t.Errorf("alert = %v, want %v:\n%s", gotAlert, tt.wantAlert, out)
+			}
+			if tt.wantAlert && !strings.Contains(out, `owner/repo`) {
+				t.Errorf("alert does not contain the payload:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestWorker_maxDeliveriesBeforeAlert(t *testing.T) {
+	worker := &Worker{
+		MaxDeliver:          10,
+		MaxDeliverBySubject: map[string]int{"push": 6},
+	}
+	if got := worker.maxDeliveriesBeforeAlert("push.1"); got != 3 {
+		t.Errorf("maxDeliveriesBeforeAlert(push.1) = %d, want 3", got)
+	}
+	if got := worker.maxDeliveriesBeforeAlert("pull_request.1"); got != 5 {
+		t.Errorf("maxDeliveriesBeforeAlert(pull_request.1) = %d, want 5", got)
+	}
+	worker.MaxDeliveriesBeforeAlert = 2
+	if got := worker.maxDeliveriesBeforeAlert("push.1"); got != 2 {
+		t.Errorf("maxDeliveriesBeforeAlert(push.1) = %d, want 2", got)
+	}
+}
+
+type fakePublisher struct {
+	nats.JetStreamContext
+	published []*nats.Msg
+}
+
+func (f *fakePublisher) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	f.published = append(f.published, m)
+	return &nats.PubAck{}, nil
+}
+
+func TestHandleMessage_DeadLetter(t *testing.T) {
+	tests := []struct {
+		name      string
+		delivered string
+		err       error
+		want      bool
+	}{
+		{name: "failed before the last delivery", delivered: "2", err: errors.New("boom"), want: false},
+		{name: "failed on the last delivery", delivered: "3", err: errors.New("boom"), want: true},
+		{name: "succeeded on the last delivery", delivered: "3", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakePublisher{}
+			logger := zerolog.Nop()
+			worker := &Worker{
+				AllowedRepositories: common.RegexSlice{common.MustNewRegexItem(".*")},
+				JetStreamContext:    js,
+				MaxDeliver:          3,
+				DeadLetterSubject:   "dead_letter",
+			}
+			msg := &nats.Msg{
+				Sub:     &nats.Subscription{},
+				Subject: "pull_request.1",
+				Reply:   "$JS.ACK.stream.consumer." + tt.delivered + ".10.10.1690000000000000000.0",
+				Data:    []byte(`{"repository":{"full_name":"owner/repo"}}`),
+			}
+			handleMessage[common.QueuePullRequestMessage](worker, &logger, msg,
+				func(*zerolog.Logger, *common.QueuePullRequestMessage) error {
+					return tt.err
+				})
+
+			if got := len(js.published) == 1; got != tt.want {
+				t.Fatalf("dead lettered = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			dead := js.published[0]
+			if !strings.HasPrefix(dead.Subject, "dead_letter.") {
+				t.Errorf("subject = %q, want a dead_letter subject", dead.Subject)
+			}
+			if got := dead.Header.Get(common.DeadLetterSubjectHeader); got != msg.Subject {
+				t.Errorf("original subject = %q, want %q", got, msg.Subject)
+			}
+			if got := dead.Header.Get(common.DeadLetterReasonHeader); got != "boom" {
+				t.Errorf("reason = %q, want boom", got)
+			}
+			if got := dead.Header.Get(common.DeadLetterQueuedAtHeader); got != "2023-07-22T04:26:40Z" {
+				t.Errorf("queued at = %q, want 2023-07-22T04:26:40Z", got)
+			}
+			if string(dead.Data) != string(msg.Data) {
+				t.Errorf("data = %s, want %s", dead.Data, msg.Data)
+			}
+		})
+	}
+}
+
+func TestConsume_RequiresHTTPClient(t *testing.T) {
+	worker := &Worker{}
+	if err := worker.Consume(); err == nil {
+		t.Error("Consume() expected an error for a nil HTTPClient")
+	}
+}
+
+func TestPullRequestsThatNeedAction(t *testing.T) {
+	cfg := &ConfigV1{
+		Update: UpdateConfigV1{Labels: common.RegexSlice{common.MustNewRegexItem("update")}},
+		Merge: MergeConfigV1{Labels: common.RegexSlice{
+			common.MustNewRegexItem("merge.*"),
+			common.MustNewRegexItem("!merge-later"),
+		}},
+	}
+	openPullRequests := []github.OpenPullRequest{
+		{Number: 1, Labels: []string{"merge-when-green"}},
+		{Number: 2, Labels: []string{"bug"}},
+		{Number: 3, Labels: []string{"bug", "update"}},
+		{Number: 4},
+		{Number: 5, Labels: []string{"merge-later"}},
+		{Number: 6, Labels: []string{"merge"}},
+	}
+
+	var got []int64
+	for _, pr := range pullRequestsThatNeedAction(cfg, openPullRequests, "") {
+		got = append(got, pr.Number)
+	}
+	if want := []int64{1, 3, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pullRequestsThatNeedAction() = %v, want %v", got, want)
+	}
+}
+
+func TestPullRequestsThatNeedAction_Order(t *testing.T) {
+	openPullRequests := []github.OpenPullRequest{
+		{Number: 12, Labels: []string{"merge"}},
+		{Number: 3, Labels: []string{"merge"}},
+		{Number: 7, Labels: []string{"merge"}},
+	}
+	tests := []struct {
+		order MergeOrder
+		want  []int64
+	}{
+		{order: "", want: []int64{3, 7, 12}},
+		{order: OldestFirstOrder, want: []int64{3, 7, 12}},
+		{order: NewestFirstOrder, want: []int64{12, 7, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.order), func(t *testing.T) {
+			cfg := &ConfigV1{Merge: MergeConfigV1{Labels: common.RegexSlice{common.MustNewRegexItem("merge")}, Order: tt.order}}
+			var got []int64
+			for _, pr := range pullRequestsThatNeedAction(cfg, openPullRequests, "") {
+				got = append(got, pr.Number)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pullRequestsThatNeedAction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_workOnAllPullRequests_Truncated(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["GetOpenPullRequests"] = `{"data":{"repository":{"pullRequests":{"nodes":[` +
+		`{"number":1,"labels":{"nodes":[{"name":"bug"}]}},{"number":2,"labels":{"nodes":[{"name":"merge"}]}}],` +
+		`"pageInfo":{"endCursor":"c","hasNextPage":true}}}}}`
+	js := &fakePublisher{}
+	worker := &Worker{
+		HTTPClient:              gh.Client(),
+		JetStreamContext:        js,
+		RateLimitKV:             newFakeKV(),
+		PullRequestSubject:      "pull_request",
+		MaxPullRequestsPerSweep: 1,
+	}
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	if err := worker.workOnAllPullRequests(context.Background(), &logger, newTestSession(), ""); err != nil {
+		t.Fatalf("workOnAllPullRequests() error = %v", err)
+	}
+	if got := countOperations(gh, "GetOpenPullRequests"); got != 1 {
+		t.Errorf("GetOpenPullRequests called %d times, want 1", got)
+	}
+	if !strings.Contains(buf.String(), "more pull requests that need action than MaxPullRequestsPerSweep") {
+		t.Errorf("log %s does not contain the truncation warning", buf.String())
+	}
+	// the pull request without label does not count towards MaxPullRequestsPerSweep
+	if len(js.published) != 1 {
+		t.Errorf("queued %d pull requests, want 1", len(js.published))
+	}
+}
+
+func TestWorker_workOnAllPullRequests_BaseBranch(t *testing.T) {
+	gh := newFakeGitHub()
+	gh.responses["GetOpenPullRequests"] = `{"data":{"repository":{"pullRequests":{"nodes":[` +
+		`{"number":1,"baseRefName":"main","labels":{"nodes":[{"name":"merge"}]}},` +
+		`{"number":2,"baseRefName":"release/1.x","labels":{"nodes":[{"name":"merge"}]}},` +
+		`{"number":3,"baseRefName":"main","labels":{"nodes":[{"name":"bug"}]}}],` +
+		`"pageInfo":{"hasNextPage":false}}}}}`
+
+	tests := []struct {
+		name       string
+		baseBranch string
+		want       []int64
+	}{
+		{name: "pushed branch", baseBranch: "release/1.x", want: []int64{2}},
+		{name: "other branch", baseBranch: "main", want: []int64{1}},
+		{name: "unknown branch", want: []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakePublisher{}
+			worker := &Worker{
+				HTTPClient:         gh.Client(),
+				JetStreamContext:   js,
+				RateLimitKV:        newFakeKV(),
+				PullRequestSubject: "pull_request",
+			}
+			logger := zerolog.Nop()
+			if err := worker.workOnAllPullRequests(context.Background(), &logger, newTestSession(), tt.baseBranch); err != nil {
+				t.Fatalf("workOnAllPullRequests() error = %v", err)
+			}
+
+			var got []int64
+			for _, msg := range js.published {
+				var m common.QueuePullRequestMessage
+				if err := json.Unmarshal(msg.Data, &m); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, m.PullRequest.Number)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued pull requests = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_maxDeliver(t *testing.T) {
+	worker := &Worker{
+		MaxDeliver:          5,
+		MaxDeliverBySubject: map[string]int{"push": 20, "pull_request": 2},
+	}
+	tests := []struct {
+		subject string
+		want    int
+	}{
+		{subject: "push.1", want: 20},
+		{subject: "pull_request.1", want: 2},
+		{subject: "status.1", want: 5},
+		{subject: "pushed.1", want: 5},
+	}
+	for _, tt := range tests {
+		if got := worker.maxDeliver(tt.subject); got != tt.want {
+			t.Errorf("maxDeliver(%q) = %d, want %d", tt.subject, got, tt.want)
+		}
+	}
+}